@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/viper"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/modules/sqliteclients"
+	"github.com/archaron/go-yubiserv/modules/sqlitecounters"
+	"github.com/archaron/go-yubiserv/modules/sqlitestorage"
+	"github.com/archaron/go-yubiserv/sqlmigrate"
+)
+
+var ErrNotSQLiteStore = errors.New("command requires the SQLite key store")
+
+func dbCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "db",
+		Usage: "maintain the SQLite key store",
+		Subcommands: cli.Commands{
+			{
+				Name:   "status",
+				Usage:  "show the schema versions and applied and pending migrations of the SQLite stores",
+				Action: dbStatus,
+			},
+			{
+				Name:   "migrate",
+				Usage:  "apply pending schema migrations of the SQLite stores, as done on start",
+				Action: dbMigrate,
+			},
+			{
+				Name:   "encrypt",
+				Usage:  "seal secrets of plaintext keys with the KEK from --sqlite-kek",
+				Action: dbEncrypt,
+			},
+			{
+				Name:   "rotate-kek",
+				Usage:  "re-wrap data keys of all sealed keys with a new KEK, update --sqlite-kek afterwards",
+				Action: dbRotateKEK,
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "new-kek", Required: true, Usage: "New KEK: file:<path>, env:<name> or transit:<key>"},
+				},
+			},
+		},
+	}
+}
+
+// withSQLiteKeys runs fn against the SQLite key store regardless of --keystore.
+func withSQLiteKeys(c *cli.Context, fn func(svc *sqlitestorage.Service, v *viper.Viper) error) error {
+	return withKeyStore(c, sqlitestorage.Module, func(ka common.KeyAdmin, v *viper.Viper) error {
+		svc, ok := ka.(*sqlitestorage.Service)
+		if !ok {
+			return ErrNotSQLiteStore
+		}
+
+		return fn(svc, v)
+	})
+}
+
+// schemaComponent is a versioned schema of one SQLite store, nil db if the store is not selected.
+type schemaComponent struct {
+	name string
+	// store is the flag selecting the store, reported if it is not SQLite
+	store string
+	db    schemaMigrator
+}
+
+// schemaMigrator shows and applies migrations of a schema component.
+type schemaMigrator interface {
+	Migrate(ctx context.Context) ([]sqlmigrate.Migration, error)
+	MigrationStatus(ctx context.Context) ([]sqlmigrate.Status, int, error)
+}
+
+// withSQLiteSchemas opens the databases of the key store and of the SQLite counters and clients stores,
+// each may be a different file, without migrating them and runs fn against their schemas.
+func withSQLiteSchemas(c *cli.Context, fn func(components []schemaComponent) error) error {
+	h, err := newKeyStoreApp(c, sqlitestorage.Module)
+	if err != nil {
+		return err
+	}
+
+	return h.Invoke(func(ka common.KeyAdmin, v *viper.Viper, log *zap.Logger) error {
+		svc, ok := ka.(*sqlitestorage.Service)
+		if !ok {
+			return ErrNotSQLiteStore
+		}
+
+		if err := svc.Open(c.Context); err != nil {
+			return fmt.Errorf("cannot open database: %w", err)
+		}
+
+		defer func() { _ = svc.Close() }()
+
+		components := []schemaComponent{
+			{name: "keys", db: svc},
+			{name: "counters", store: "counterstore"},
+			{name: "clients", store: "clientstore"},
+		}
+
+		if c.String("counterstore") == "sqlite" {
+			counters, err := sqlitecounters.OpenDB(c.Context, log, v.GetString("counters.dbpath"))
+			if err != nil {
+				return fmt.Errorf("cannot open counters database: %w", err)
+			}
+
+			defer func() { _ = counters.Close() }()
+
+			components[1].db = counters
+		}
+
+		if c.String("clientstore") == "sqlite" {
+			clients, err := sqliteclients.OpenDB(c.Context, log, v.GetString("clients.dbpath"))
+			if err != nil {
+				return fmt.Errorf("cannot open clients database: %w", err)
+			}
+
+			defer func() { _ = clients.Close() }()
+
+			components[2].db = clients
+		}
+
+		return fn(components)
+	})
+}
+
+func dbStatus(c *cli.Context) error {
+	return withSQLiteSchemas(c, func(components []schemaComponent) error {
+		for i, component := range components {
+			if i > 0 {
+				//nolint:forbidigo
+				fmt.Println()
+			}
+
+			if component.db == nil {
+				//nolint:forbidigo
+				fmt.Printf("%s: not stored in SQLite (--%s=%s)\n", component.name, component.store, c.String(component.store))
+
+				continue
+			}
+
+			statuses, version, err := component.db.MigrationStatus(c.Context)
+			if err != nil {
+				return fmt.Errorf("%s: %w", component.name, err)
+			}
+
+			if err = printMigrations(os.Stdout, component.name, statuses, version); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func printMigrations(out io.Writer, name string, statuses []sqlmigrate.Status, version int) error {
+	latest := 0
+	if len(statuses) > 0 {
+		latest = statuses[len(statuses)-1].Version
+	}
+
+	fmt.Fprintf(out, "%s: schema version %d, latest %d\n", name, version, latest)
+
+	if version > latest {
+		fmt.Fprintln(out, sqlmigrate.ErrSchemaTooNew)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0) //nolint:mnd
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+
+	for _, status := range statuses {
+		applied := status.AppliedAt
+		if applied == "" {
+			applied = "pending"
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+	}
+
+	return tw.Flush()
+}
+
+func dbMigrate(c *cli.Context) error {
+	return withSQLiteSchemas(c, func(components []schemaComponent) error {
+		for _, component := range components {
+			if component.db == nil {
+				continue
+			}
+
+			applied, err := component.db.Migrate(c.Context)
+			for _, m := range applied {
+				//nolint:forbidigo
+				fmt.Printf("%s: %d_%s applied\n", component.name, m.Version, m.Name)
+			}
+
+			if err != nil {
+				return fmt.Errorf("%s: %w", component.name, err)
+			}
+
+			//nolint:forbidigo
+			fmt.Printf("%s: %d migrations applied\n", component.name, len(applied))
+		}
+
+		return nil
+	})
+}
+
+func dbEncrypt(c *cli.Context) error {
+	return withSQLiteKeys(c, func(svc *sqlitestorage.Service, _ *viper.Viper) error {
+		count, err := svc.EncryptKeys(c.Context)
+		if err != nil {
+			return err
+		}
+
+		//nolint:forbidigo
+		fmt.Printf("%d keys sealed\n", count)
+
+		return nil
+	})
+}
+
+func dbRotateKEK(c *cli.Context) error {
+	return withSQLiteKeys(c, func(svc *sqlitestorage.Service, v *viper.Viper) error {
+		kek, err := sqlitestorage.LoadKEK(v, c.String("new-kek"))
+		if err != nil {
+			return err
+		}
+
+		count, err := svc.RewrapKeys(c.Context, kek)
+		if err != nil {
+			return err
+		}
+
+		//nolint:forbidigo
+		fmt.Printf("%d keys re-wrapped with %s\n", count, kek.ID())
+
+		return nil
+	})
+}
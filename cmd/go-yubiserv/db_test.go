@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/archaron/go-yubiserv/sqlmigrate"
+)
+
+func Test_printMigrations(t *testing.T) {
+	t.Parallel()
+
+	statuses := []sqlmigrate.Status{
+		{Migration: sqlmigrate.Migration{Version: 1, Name: "create_keys"}, AppliedAt: "baseline"},
+		{Migration: sqlmigrate.Migration{Version: 2, Name: "sealable_keys"}},
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, printMigrations(buf, "keys", statuses, 1))
+	require.Equal(t, "keys: schema version 1, latest 2\n"+
+		"VERSION  NAME           APPLIED\n"+
+		"1        create_keys    baseline\n"+
+		"2        sealable_keys  pending\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, printMigrations(buf, "keys", statuses, 3))
+	require.Contains(t, buf.String(), sqlmigrate.ErrSchemaTooNew.Error())
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/misc"
+)
+
+var (
+	ErrInvalidKeyRange    = errors.New("start must not be negative and count must be positive")
+	ErrUnknownProgramTool = errors.New("unknown programming tool specified")
+	ErrInvalidProgramSlot = errors.New("slot must be 1 or 2")
+)
+
+func generator() cli.ActionFunc {
+	return func(c *cli.Context) error {
+		start := c.Int("start")
+		count := c.Int("count")
+		slot := c.Int("slot")
+		tool := c.String("program-tool")
+
+		if start < 0 || count < 1 {
+			return ErrInvalidKeyRange
+		}
+
+		if slot != 1 && slot != 2 {
+			return ErrInvalidProgramSlot
+		}
+
+		if tool != "ykman" && tool != "ykpersonalize" {
+			return fmt.Errorf("%s: %w", tool, ErrUnknownProgramTool)
+		}
+
+		keys, err := generateKeys(start, count, time.Now())
+		if err != nil {
+			return err
+		}
+
+		if c.Bool("save") {
+			if err = saveKeys(c, keys); err != nil {
+				return err
+			}
+		}
+
+		if path := c.String("program-file"); path != "" {
+			if err = writeFile(path, func(w io.Writer) error {
+				return writeProgramScript(w, tool, slot, keys)
+			}); err != nil {
+				return err
+			}
+		}
+
+		return writeYKKSM(os.Stdout, start, keys, c.String("progflags"))
+	}
+}
+
+// saveKeys stores generated keys in the key store selected by --keystore in one transaction.
+func saveKeys(c *cli.Context, keys []*common.Key) error {
+	return withKeyAdmin(c, func(ka common.KeyAdmin) error {
+		if err := ka.StoreKeys(keys); err != nil {
+			return fmt.Errorf("cannot save generated keys: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// generateKeys creates count keys with random secrets, numbered from start.
+// Public ID of every key is its number in modhex.
+func generateKeys(start, count int, now time.Time) ([]*common.Key, error) {
+	keys := make([]*common.Key, 0, count)
+	created := now.UTC().Format(time.RFC3339)
+
+	for i := start; i < start+count; i++ {
+		key := &common.Key{
+			ID:       uint64(i), //nolint:gosec // start is checked to be non-negative
+			PublicID: misc.HexToModHex(fmt.Sprintf("%012x", i)),
+			Created:  created,
+			Active:   true,
+		}
+
+		if err := key.GenerateSecrets(); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// writeYKKSM writes keys in ykksm-gen-keys format, accepted by ykksm-import.
+//
+//nolint:forbidigo
+func writeYKKSM(w io.Writer, start int, keys []*common.Key, progflags string) error {
+	fmt.Fprintln(w, "# ykksm 1")
+	fmt.Fprintf(w, "# start %d end %d\n", start, start+len(keys)-1)
+	fmt.Fprintln(w, "# serialnr,identity,internaluid,aeskey,lockpw,created,accessed[,progflags]")
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "%d,%s,%s,%s,%s,%s,,%s\n",
+			key.ID,
+			key.PublicID,
+			key.PrivateID,
+			key.AESKey,
+			key.LockCode,
+			key.Created,
+			progflags,
+		)
+	}
+
+	_, err := fmt.Fprintln(w, "# the end")
+
+	return err
+}
+
+// writeProgramScript writes a shell script programming every key into its own YubiKey
+// with ykman or ykpersonalize, lock code is set as the slot access code.
+//
+//nolint:forbidigo
+func writeProgramScript(w io.Writer, tool string, slot int, keys []*common.Key) error {
+	fmt.Fprintln(w, "#!/bin/sh")
+	fmt.Fprintln(w, "set -e")
+
+	for _, key := range keys {
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "printf 'Insert YubiKey for %s (serial %d) and press Enter'; read -r _\n", key.PublicID, key.ID)
+
+		switch tool {
+		case "ykman":
+			fmt.Fprintf(w, "ykman otp yubiotp --force --public-id %s --private-id %s --key %s %d\n",
+				key.PublicID, key.PrivateID, key.AESKey, slot)
+			fmt.Fprintf(w, "ykman otp settings --force --new-access-code %s %d\n", key.LockCode, slot)
+		case "ykpersonalize":
+			fmt.Fprintf(w, "ykpersonalize -%d -y -ofixed=%s -ouid=%s -a%s -oaccess=%s\n",
+				slot, key.PublicID, key.PrivateID, key.AESKey, key.LockCode)
+		default:
+			return fmt.Errorf("%s: %w", tool, ErrUnknownProgramTool)
+		}
+	}
+
+	return nil
+}
+
+// writeFile creates file at path and writes its content with fn.
+func writeFile(path string, fn func(w io.Writer) error) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600) //nolint:mnd
+	if err != nil {
+		return fmt.Errorf("cannot create %s: %w", path, err)
+	}
+
+	if err = fn(f); err != nil {
+		_ = f.Close()
+
+		return fmt.Errorf("cannot write %s: %w", path, err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("cannot write %s: %w", path, err)
+	}
+
+	return nil
+}
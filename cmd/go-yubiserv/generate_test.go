@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+func Test_generateKeys(t *testing.T) {
+	t.Parallel()
+
+	keys, err := generateKeys(5, 3, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, keys, 3)
+
+	for i, key := range keys {
+		require.Equal(t, uint64(5+i), key.ID)
+		require.Equal(t, "2024-01-01T00:00:00Z", key.Created)
+		require.True(t, key.Active)
+		require.NoError(t, key.Validate())
+	}
+
+	require.Equal(t, "cccccccccccg", keys[0].PublicID)
+	require.Equal(t, "ccccccccccci", keys[2].PublicID)
+}
+
+func Test_writeYKKSM(t *testing.T) {
+	t.Parallel()
+
+	key := testKey()
+	buf := new(bytes.Buffer)
+	require.NoError(t, writeYKKSM(buf, 1, []*common.Key{key}, "-ofixed=h:0000"))
+	require.Equal(t, strings.Join([]string{
+		"# ykksm 1",
+		"# start 1 end 1",
+		"# serialnr,identity,internaluid,aeskey,lockpw,created,accessed[,progflags]",
+		"1,vvcccccccccc,0102030405ab,0102030405060708090a0b0c0d0e0f10,010203040506,2024-01-01T00:00:00Z,,-ofixed=h:0000",
+		"# the end",
+		"",
+	}, "\n"), buf.String())
+}
+
+func Test_writeProgramScript(t *testing.T) {
+	t.Parallel()
+
+	keys := []*common.Key{testKey()}
+
+	t.Run("ykman", func(t *testing.T) {
+		t.Parallel()
+
+		buf := new(bytes.Buffer)
+		require.NoError(t, writeProgramScript(buf, "ykman", 2, keys))
+		require.Contains(t, buf.String(),
+			"ykman otp yubiotp --force --public-id vvcccccccccc --private-id 0102030405ab --key 0102030405060708090a0b0c0d0e0f10 2\n")
+		require.Contains(t, buf.String(), "ykman otp settings --force --new-access-code 010203040506 2\n")
+	})
+
+	t.Run("ykpersonalize", func(t *testing.T) {
+		t.Parallel()
+
+		buf := new(bytes.Buffer)
+		require.NoError(t, writeProgramScript(buf, "ykpersonalize", 1, keys))
+		require.Contains(t, buf.String(),
+			"ykpersonalize -1 -y -ofixed=vvcccccccccc -ouid=0102030405ab -a0102030405060708090a0b0c0d0e0f10 -oaccess=010203040506\n")
+	})
+
+	t.Run("unknown tool", func(t *testing.T) {
+		t.Parallel()
+
+		require.ErrorIs(t, writeProgramScript(new(bytes.Buffer), "yknone", 1, keys), ErrUnknownProgramTool)
+	})
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/im-kulikov/helium/module"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/dig"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/ykimport"
+)
+
+var (
+	ErrNothingToImport   = errors.New("nothing to import, use --ykksm-keys, --ykval-counters or --ykval-clients")
+	ErrCountersNotStored = errors.New("counters cannot be imported into --counterstore=memory")
+	ErrClientsNotStored  = errors.New("API clients can be imported into --clientstore=sqlite only")
+	ErrClientConflict    = errors.New("another API client with the same ID exists, use --overwrite to replace it")
+)
+
+type (
+	// importParams are the stores selected for the imported data, only the needed ones are provided.
+	importParams struct {
+		dig.In
+
+		Keys     common.KeyAdmin       `optional:"true"`
+		Counters common.CounterStorage `optional:"true"`
+		Clients  common.ClientAdmin    `optional:"true"`
+	}
+
+	importOptions struct {
+		// dryRun only reports what would be imported
+		dryRun bool
+		// overwrite replaces different keys and clients with the same ID
+		overwrite bool
+	}
+
+	importStats struct {
+		added, replaced, present int
+	}
+)
+
+func importCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "import",
+		Usage: "import keys, counters and API clients of a Yubico ykksm and ykval installation",
+		Description: "Tables are read from SQL dumps of mysqldump or pg_dump, or from CSV files (*.csv) of one table.\n" +
+			"Keys go to --keystore, counters to --counterstore and API clients to --clientstore.",
+		Action: importYubico,
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "ykksm-keys", Usage: "ykksm database dump or yubikeys table CSV"},
+			&cli.StringFlag{Name: "ykval-counters", Usage: "ykval database dump or yubikeys table CSV"},
+			&cli.StringFlag{Name: "ykval-clients", Usage: "ykval database dump or clients table CSV"},
+			&cli.BoolFlag{Name: "dry-run", Usage: "Only report the data to be imported"},
+			&cli.BoolFlag{Name: "overwrite", Usage: "Replace different keys and API clients with the same ID"},
+		},
+	}
+}
+
+//nolint:forbidigo
+func importYubico(c *cli.Context) error {
+	keys, counters, clients, err := readYubico(c)
+	if err != nil {
+		return err
+	}
+
+	mods := module.Module{}
+
+	// bolt counters are kept in the database of the bolt key store
+	if keys != nil || (counters != nil && c.String("counterstore") == "bolt") {
+		store, err := keystoreModule(c)
+		if err != nil {
+			return err
+		}
+
+		mods = mods.Append(store)
+	}
+
+	if counters != nil {
+		store, err := counterstoreModule(c)
+		if err != nil {
+			return err
+		} else if len(store) == 0 {
+			return ErrCountersNotStored
+		}
+
+		mods = mods.Append(store)
+	}
+
+	if clients != nil {
+		store, err := clientstoreModule(c)
+		if err != nil {
+			return err
+		} else if len(store) == 0 {
+			return ErrClientsNotStored
+		}
+
+		mods = mods.Append(store)
+	}
+
+	h, err := newKeyStoreApp(c, mods)
+	if err != nil {
+		return err
+	}
+
+	opts := importOptions{dryRun: c.Bool("dry-run"), overwrite: c.Bool("overwrite")}
+
+	return h.Invoke(func(p importParams) error {
+		if keys != nil {
+			if err := p.Keys.Connect(c.Context); err != nil {
+				return fmt.Errorf("cannot connect to key store: %w", err)
+			}
+
+			defer func() { _ = p.Keys.Close() }()
+
+			stats, err := importKeys(c.Context, p.Keys, keys, opts, os.Stdout)
+			fmt.Printf("keys: %d added, %d replaced, %d already present\n", stats.added, stats.replaced, stats.present)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if counters != nil {
+			stats, err := importCounters(p.Counters, counters, opts, os.Stdout)
+			fmt.Printf("counters: %d added, %d advanced, %d already ahead\n", stats.added, stats.replaced, stats.present)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if clients != nil {
+			stats, err := importClients(p.Clients, clients, opts, os.Stdout)
+			fmt.Printf("API clients: %d added, %d replaced, %d already present\n", stats.added, stats.replaced, stats.present)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// readYubico reads and converts all given tables before any store is changed. Keys deactivated in ykval
+// are imported inactive.
+func readYubico(c *cli.Context) ([]*common.Key, []ykimport.Counter, []*common.Client, error) {
+	var (
+		keys     []*common.Key
+		counters []ykimport.Counter
+		clients  []*common.Client
+	)
+
+	if path := c.String("ykksm-keys"); path != "" {
+		rows, err := ykimport.ReadFile(path, ykimport.KSMKeys)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if keys, err = ykimport.Keys(rows); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if path := c.String("ykval-counters"); path != "" {
+		rows, err := ykimport.ReadFile(path, ykimport.ValKeys)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if counters, err = ykimport.Counters(rows); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if path := c.String("ykval-clients"); path != "" {
+		rows, err := ykimport.ReadFile(path, ykimport.ValClients)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if clients, err = ykimport.Clients(rows); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if keys == nil && counters == nil && clients == nil {
+		return nil, nil, nil, ErrNothingToImport
+	}
+
+	deactivateKeys(keys, counters)
+
+	return keys, counters, clients, nil
+}
+
+// deactivateKeys marks keys inactive in ykval as inactive.
+func deactivateKeys(keys []*common.Key, counters []ykimport.Counter) {
+	inactive := make(map[string]bool)
+
+	for _, counter := range counters {
+		if !counter.Active {
+			inactive[counter.PublicID] = true
+		}
+	}
+
+	for _, key := range keys {
+		if inactive[key.PublicID] {
+			key.Active = false
+		}
+	}
+}
+
+// importKeys adds new keys in one batch and replaces different keys with --overwrite. Added and replaced
+// keys are verified with a test OTP when the key store decrypts them.
+func importKeys(ctx context.Context, dst common.KeyAdmin, keys []*common.Key, opts importOptions, out io.Writer) (importStats, error) {
+	var (
+		stats    importStats
+		added    []*common.Key
+		replaced []*common.Key
+	)
+
+	for _, key := range keys {
+		present, err := dst.GetKey(ctx, key.PublicID)
+
+		switch {
+		case errors.Is(err, common.ErrStorageNoKey):
+			added = append(added, key)
+		case err != nil:
+			return stats, fmt.Errorf("cannot read key %s: %w", key.PublicID, err)
+		case sameKey(present, key, false):
+			stats.present++
+		case !opts.overwrite:
+			return stats, fmt.Errorf("%s: %w", key.PublicID, ErrKeyConflict)
+		default:
+			replaced = append(replaced, key)
+		}
+	}
+
+	if opts.dryRun {
+		for _, key := range added {
+			fmt.Fprintf(out, "key %s would be added\n", key.PublicID)
+		}
+
+		for _, key := range replaced {
+			fmt.Fprintf(out, "key %s would be replaced\n", key.PublicID)
+		}
+
+		stats.added, stats.replaced = len(added), len(replaced)
+
+		return stats, nil
+	}
+
+	if len(added) > 0 {
+		if err := dst.StoreKeys(added); err != nil {
+			return stats, fmt.Errorf("cannot store keys: %w", err)
+		}
+
+		stats.added = len(added)
+	}
+
+	for _, key := range replaced {
+		if err := dst.StoreKey(key); err != nil {
+			return stats, fmt.Errorf("cannot store key %s: %w", key.PublicID, err)
+		}
+
+		stats.replaced++
+
+		fmt.Fprintf(out, "key %s replaced\n", key.PublicID)
+	}
+
+	if target, ok := dst.(migrateTarget); ok {
+		for _, key := range slices.Concat(added, replaced) {
+			if err := verifyKey(ctx, target, key, false); err != nil {
+				return stats, fmt.Errorf("cannot verify key %s: %w", key.PublicID, err)
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// importCounters stores counters of keys with accepted OTPs, counters already ahead of the imported ones
+// are kept, so the import never makes a used OTP acceptable again.
+func importCounters(dst common.CounterStorage, counters []ykimport.Counter, opts importOptions, out io.Writer) (importStats, error) {
+	var stats importStats
+
+	current, err := dst.LoadCounters()
+	if err != nil {
+		return stats, fmt.Errorf("cannot load counters: %w", err)
+	}
+
+	for _, counter := range counters {
+		if counter.User == nil {
+			continue
+		}
+
+		prev, known := current[counter.PublicID]
+		if known && (prev.UsageCounter > counter.User.UsageCounter ||
+			(prev.UsageCounter == counter.User.UsageCounter && prev.SessionCounter >= counter.User.SessionCounter)) {
+			stats.present++
+
+			continue
+		}
+
+		if opts.dryRun {
+			fmt.Fprintf(out, "counters of %s would be set to %d/%d\n",
+				counter.PublicID, counter.User.UsageCounter, counter.User.SessionCounter)
+		} else if err = dst.StoreCounter(counter.PublicID, counter.User); err != nil {
+			return stats, fmt.Errorf("cannot store counters of %s: %w", counter.PublicID, err)
+		}
+
+		if known {
+			stats.replaced++
+		} else {
+			stats.added++
+		}
+	}
+
+	return stats, nil
+}
+
+// importClients adds new API clients and replaces different ones with --overwrite, nothing is stored on conflicts.
+func importClients(dst common.ClientAdmin, clients []*common.Client, opts importOptions, out io.Writer) (importStats, error) {
+	var (
+		stats    importStats
+		imported []*common.Client
+	)
+
+	for _, client := range clients {
+		present, err := dst.GetClient(client.ID)
+
+		switch {
+		case errors.Is(err, common.ErrClientNotFound):
+			stats.added++
+		case err != nil:
+			return importStats{}, fmt.Errorf("cannot read API client %d: %w", client.ID, err)
+		case *present == *client:
+			stats.present++
+
+			continue
+		case !opts.overwrite:
+			return importStats{}, fmt.Errorf("client %d: %w", client.ID, ErrClientConflict)
+		default:
+			stats.replaced++
+		}
+
+		imported = append(imported, client)
+	}
+
+	for _, client := range imported {
+		if opts.dryRun {
+			fmt.Fprintf(out, "API client %d would be imported\n", client.ID)
+		} else if err := dst.StoreClient(client); err != nil {
+			return stats, fmt.Errorf("cannot store API client %d: %w", client.ID, err)
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/modules/sqliteclients"
+	"github.com/archaron/go-yubiserv/modules/sqlitecounters"
+	"github.com/archaron/go-yubiserv/sqlitedriver"
+	"github.com/archaron/go-yubiserv/ykimport"
+)
+
+func TestImportKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("dry run", func(t *testing.T) {
+		t.Parallel()
+
+		dst := newMigrateStore(t)
+		out := new(bytes.Buffer)
+
+		stats, err := importKeys(ctx, dst, migrateTestKeys(), importOptions{dryRun: true}, out)
+		require.NoError(t, err)
+		require.Equal(t, importStats{added: 3}, stats)
+		require.Contains(t, out.String(), "key vvcccccccccc would be added\n")
+
+		keys, err := dst.ListKeys()
+		require.NoError(t, err)
+		require.Empty(t, keys)
+	})
+
+	t.Run("import and repeat", func(t *testing.T) {
+		t.Parallel()
+
+		dst := newMigrateStore(t)
+
+		stats, err := importKeys(ctx, dst, migrateTestKeys(), importOptions{}, new(bytes.Buffer))
+		require.NoError(t, err)
+		require.Equal(t, importStats{added: 3}, stats)
+
+		stats, err = importKeys(ctx, dst, migrateTestKeys(), importOptions{}, new(bytes.Buffer))
+		require.NoError(t, err)
+		require.Equal(t, importStats{present: 3}, stats)
+	})
+
+	t.Run("conflict and overwrite", func(t *testing.T) {
+		t.Parallel()
+
+		other := testKey()
+		other.AESKey = "202122232425262728292a2b2c2d2e2f"
+		dst := newMigrateStore(t, other)
+
+		_, err := importKeys(ctx, dst, migrateTestKeys(), importOptions{}, new(bytes.Buffer))
+		require.ErrorIs(t, err, ErrKeyConflict)
+
+		keys, err := dst.ListKeys()
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+
+		stats, err := importKeys(ctx, dst, migrateTestKeys(), importOptions{overwrite: true}, new(bytes.Buffer))
+		require.NoError(t, err)
+		require.Equal(t, importStats{added: 2, replaced: 1}, stats)
+
+		stored, err := dst.GetKey(ctx, other.PublicID)
+		require.NoError(t, err)
+		require.Equal(t, testKey().AESKey, stored.AESKey)
+	})
+
+	t.Run("verification failure", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := importKeys(ctx, rejectingTarget{newMigrateStore(t)}, migrateTestKeys(), importOptions{}, new(bytes.Buffer))
+		require.ErrorIs(t, err, common.ErrStorageDecryptFail)
+	})
+}
+
+func TestImportCounters(t *testing.T) {
+	t.Parallel()
+
+	db, err := sqlitedriver.Open(":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	dst, err := sqlitecounters.TestNewService(zaptest.NewLogger(t), db)
+	require.NoError(t, err)
+
+	ahead := &common.OTPUser{UsageCounter: 20, SessionCounter: 1, Seen: time.Unix(1700000200, 0)}
+	require.NoError(t, dst.StoreCounter("vvdddddddddd", ahead))
+	require.NoError(t, dst.StoreCounter("vveeeeeeeeee", &common.OTPUser{UsageCounter: 5}))
+
+	counters := []ykimport.Counter{
+		{PublicID: "vvcccccccccc", Active: true, User: &common.OTPUser{UsageCounter: 12, SessionCounter: 3}},
+		{PublicID: "vvdddddddddd", Active: true, User: &common.OTPUser{UsageCounter: 19, SessionCounter: 9}},
+		{PublicID: "vveeeeeeeeee", Active: true, User: &common.OTPUser{UsageCounter: 5, SessionCounter: 1}},
+		{PublicID: "vvffffffffff", Active: false},
+	}
+
+	out := new(bytes.Buffer)
+	stats, err := importCounters(dst, counters, importOptions{dryRun: true}, out)
+	require.NoError(t, err)
+	require.Equal(t, importStats{added: 1, replaced: 1, present: 1}, stats)
+	require.Contains(t, out.String(), "counters of vvcccccccccc would be set to 12/3\n")
+
+	stats, err = importCounters(dst, counters, importOptions{}, new(bytes.Buffer))
+	require.NoError(t, err)
+	require.Equal(t, importStats{added: 1, replaced: 1, present: 1}, stats)
+
+	users, err := dst.LoadCounters()
+	require.NoError(t, err)
+	require.Len(t, users, 3)
+	require.Equal(t, uint16(12), users["vvcccccccccc"].UsageCounter)
+	require.Equal(t, uint16(20), users["vvdddddddddd"].UsageCounter)
+	require.Equal(t, uint8(1), users["vveeeeeeeeee"].SessionCounter)
+}
+
+func TestImportClients(t *testing.T) {
+	t.Parallel()
+
+	db, err := sqlitedriver.Open(":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	dst, err := sqliteclients.TestNewService(zaptest.NewLogger(t), db)
+	require.NoError(t, err)
+
+	require.NoError(t, dst.StoreClient(&common.Client{ID: 2, Secret: "b3RoZXI=", Active: true}))
+
+	clients := []*common.Client{
+		{ID: 1, Secret: "c2VjcmV0", Active: true, Description: "intranet"},
+		{ID: 2, Secret: "c2VjcmV0", Active: false},
+	}
+
+	_, err = importClients(dst, clients, importOptions{}, new(bytes.Buffer))
+	require.ErrorIs(t, err, ErrClientConflict)
+
+	stats, err := importClients(dst, clients, importOptions{overwrite: true}, new(bytes.Buffer))
+	require.NoError(t, err)
+	require.Equal(t, importStats{added: 1, replaced: 1}, stats)
+
+	stats, err = importClients(dst, clients, importOptions{}, new(bytes.Buffer))
+	require.NoError(t, err)
+	require.Equal(t, importStats{present: 2}, stats)
+
+	stored, err := dst.ListClients()
+	require.NoError(t, err)
+	require.Equal(t, clients, stored)
+}
+
+func Test_deactivateKeys(t *testing.T) {
+	t.Parallel()
+
+	keys := migrateTestKeys()
+	deactivateKeys(keys, []ykimport.Counter{{PublicID: keys[0].PublicID}, {PublicID: keys[1].PublicID, Active: true}})
+
+	require.False(t, keys[0].Active)
+	require.True(t, keys[1].Active)
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/im-kulikov/helium"
+	"github.com/im-kulikov/helium/module"
+	"github.com/spf13/viper"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/dig"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/misc"
+)
+
+var (
+	ErrUnknownFormat   = errors.New("unknown output format")
+	ErrMissingPublicID = errors.New("public ID argument is required")
+)
+
+// rotateParams are the stores of the rotated key, counters are missing for memory and file counters.
+type rotateParams struct {
+	dig.In
+
+	Keys     common.KeyAdmin
+	Counters common.CounterStorage `optional:"true"`
+}
+
+//nolint:gochecknoglobals
+var formatFlag = &cli.StringFlag{Name: "format", Aliases: []string{"f"}, Value: "table", Usage: "Output format: table, json"}
+
+func keysCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "keys",
+		Usage: "manage keys in the selected key store",
+		Subcommands: cli.Commands{
+			{
+				Name:   "add",
+				Usage:  "add a new key, missing secrets are generated randomly",
+				Action: keysAdd,
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "public-id", Required: true, Usage: "Public ID (12 modhex characters)"},
+					&cli.Uint64Flag{Name: "id", Usage: "Numeric key ID (serial)"},
+					&cli.StringFlag{Name: "private-id", Usage: "Private ID (6 hex-encoded bytes), random if empty"},
+					&cli.StringFlag{Name: "aes-key", Usage: "AES key (16 hex-encoded bytes), random if empty"},
+					&cli.StringFlag{Name: "lock-code", Usage: "Lock code (6 hex-encoded bytes), random if empty"},
+					&cli.BoolFlag{Name: "inactive", Usage: "Add key disabled"},
+					&cli.StringFlag{Name: "owner", Usage: "Key holder (Vault and bolt key stores)"},
+					&cli.StringFlag{Name: "description", Usage: "Free-form note (Vault and bolt key stores)"},
+					formatFlag,
+				},
+			},
+			{
+				Name:   "list",
+				Usage:  "list keys without secrets",
+				Action: keysList,
+				Flags:  []cli.Flag{formatFlag},
+			},
+			{
+				Name:      "show",
+				Usage:     "show key with its secrets",
+				ArgsUsage: "<public-id>",
+				Action:    keysShow,
+				Flags:     []cli.Flag{formatFlag},
+			},
+			{
+				Name:      "enable",
+				Usage:     "enable key",
+				ArgsUsage: "<public-id>",
+				Action:    keysSetActive(true),
+			},
+			{
+				Name:      "disable",
+				Usage:     "disable key, its OTPs are rejected until enabled again",
+				ArgsUsage: "<public-id>",
+				Action:    keysSetActive(false),
+			},
+			{
+				Name:      "delete",
+				Usage:     "delete key",
+				ArgsUsage: "<public-id>",
+				Action:    keysDelete,
+			},
+			{
+				Name:      "rotate",
+				Usage:     "generate new AES key and private ID for the key and reset its sqlite or bolt counters",
+				ArgsUsage: "<public-id>",
+				Action:    keysRotate,
+				Flags:     []cli.Flag{formatFlag},
+			},
+		},
+	}
+}
+
+// withKeyAdmin runs fn against the key store selected by --keystore without starting the service.
+func withKeyAdmin(c *cli.Context, fn func(ka common.KeyAdmin) error) error {
+	store, err := keystoreModule(c)
+	if err != nil {
+		return err
+	}
+
+	return withKeyStore(c, store, func(ka common.KeyAdmin, _ *viper.Viper) error {
+		return fn(ka)
+	})
+}
+
+// withKeyStore connects to the key store of the given module and runs fn against it.
+func withKeyStore(c *cli.Context, store module.Module, fn func(ka common.KeyAdmin, v *viper.Viper) error) error {
+	h, err := newKeyStoreApp(c, store)
+	if err != nil {
+		return err
+	}
+
+	return h.Invoke(func(ka common.KeyAdmin, v *viper.Viper) error {
+		if err := ka.Connect(c.Context); err != nil {
+			return fmt.Errorf("cannot connect to key store: %w", err)
+		}
+
+		defer func() { _ = ka.Close() }()
+
+		return fn(ka, v)
+	})
+}
+
+// newKeyStoreApp creates the application of the key store module without the services.
+func newKeyStoreApp(c *cli.Context, store module.Module) (*helium.Helium, error) {
+	h, err := helium.New(&helium.Settings{
+		File:         c.String("config"),
+		Prefix:       misc.Prefix,
+		Name:         misc.Name,
+		Type:         "yaml",
+		BuildTime:    misc.Version,
+		BuildVersion: misc.Build,
+		Defaults: func(v *viper.Viper) error {
+			return defaults(c, v)
+		},
+	}, generateModules.Append(store))
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize helium: %w", err)
+	}
+
+	return h, nil
+}
+
+func keysAdd(c *cli.Context) error {
+	key := &common.Key{
+		ID:        c.Uint64("id"),
+		PublicID:  c.String("public-id"),
+		Created:   time.Now().UTC().Format(time.RFC3339),
+		PrivateID: c.String("private-id"),
+		AESKey:    c.String("aes-key"),
+		LockCode:  c.String("lock-code"),
+		Active:    !c.Bool("inactive"),
+
+		Owner:       c.String("owner"),
+		Description: c.String("description"),
+	}
+
+	if err := key.GenerateSecrets(); err != nil {
+		return err
+	}
+
+	if err := key.Validate(); err != nil {
+		return err
+	}
+
+	return withKeyAdmin(c, func(ka common.KeyAdmin) error {
+		if err := ka.StoreKeys([]*common.Key{key}); err != nil {
+			return err
+		}
+
+		return printKey(os.Stdout, c.String("format"), key)
+	})
+}
+
+func keysList(c *cli.Context) error {
+	return withKeyAdmin(c, func(ka common.KeyAdmin) error {
+		keys, err := ka.ListKeys()
+		if err != nil {
+			return err
+		}
+
+		return printKeyList(os.Stdout, c.String("format"), keys)
+	})
+}
+
+func keysShow(c *cli.Context) error {
+	publicID, err := publicIDArg(c)
+	if err != nil {
+		return err
+	}
+
+	return withKeyAdmin(c, func(ka common.KeyAdmin) error {
+		key, err := ka.GetKey(c.Context, publicID)
+		if err != nil {
+			return err
+		}
+
+		return printKey(os.Stdout, c.String("format"), key)
+	})
+}
+
+func keysSetActive(active bool) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		publicID, err := publicIDArg(c)
+		if err != nil {
+			return err
+		}
+
+		return withKeyAdmin(c, func(ka common.KeyAdmin) error {
+			key, err := ka.GetKey(c.Context, publicID)
+			if err != nil {
+				return err
+			}
+
+			key.Active = active
+
+			return ka.StoreKey(key)
+		})
+	}
+}
+
+func keysDelete(c *cli.Context) error {
+	publicID, err := publicIDArg(c)
+	if err != nil {
+		return err
+	}
+
+	return withKeyAdmin(c, func(ka common.KeyAdmin) error {
+		return ka.DeleteKey(publicID)
+	})
+}
+
+func keysRotate(c *cli.Context) error {
+	publicID, err := publicIDArg(c)
+	if err != nil {
+		return err
+	}
+
+	store, err := keystoreModule(c)
+	if err != nil {
+		return err
+	}
+
+	counters, err := rotateCountersModule(c)
+	if err != nil {
+		return err
+	}
+
+	h, err := newKeyStoreApp(c, store.Append(counters))
+	if err != nil {
+		return err
+	}
+
+	return h.Invoke(func(p rotateParams) error {
+		if err := p.Keys.Connect(c.Context); err != nil {
+			return fmt.Errorf("cannot connect to key store: %w", err)
+		}
+
+		defer func() { _ = p.Keys.Close() }()
+
+		key, err := rotateKey(c.Context, p.Keys, p.Counters, publicID)
+		if err != nil {
+			return err
+		}
+
+		//nolint:forbidigo
+		fmt.Fprintln(os.Stderr, "# Program the new secrets into the YubiKey, old OTPs will fail to decrypt")
+
+		if p.Counters == nil {
+			//nolint:forbidigo
+			fmt.Fprintf(os.Stderr, "# Reset its counters with DELETE /v1/counters/%s of the admin API\n", publicID)
+		}
+
+		return printKey(os.Stdout, c.String("format"), key)
+	})
+}
+
+// rotateCountersModule returns the counters store module whose counters rotate resets. Counters of the file store
+// are not reset: a running service rewrites the whole file from its memory and would bring them back.
+func rotateCountersModule(c *cli.Context) (module.Module, error) {
+	if c.String("counterstore") == "file" {
+		return module.Module{}, nil
+	}
+
+	return counterstoreModule(c)
+}
+
+// rotateKey generates new secrets of the key and resets its counters, as the reprogrammed YubiKey
+// starts counting anew. Counters are nil for memory and file counters, they are reset through the admin API.
+func rotateKey(ctx context.Context, ka common.KeyAdmin, counters common.CounterStorage, publicID string) (*common.Key, error) {
+	key, err := ka.GetKey(ctx, publicID)
+	if err != nil {
+		return nil, err
+	}
+
+	key.PrivateID, key.AESKey = "", ""
+	if err = key.GenerateSecrets(); err != nil {
+		return nil, err
+	}
+
+	if err = ka.StoreKey(key); err != nil {
+		return nil, err
+	}
+
+	if counters != nil {
+		if err = counters.DeleteCounter(publicID); err != nil {
+			return nil, fmt.Errorf("secrets rotated, but cannot reset counters: %w", err)
+		}
+	}
+
+	return key, nil
+}
+
+func publicIDArg(c *cli.Context) (string, error) {
+	publicID := c.Args().First()
+	if publicID == "" {
+		return "", ErrMissingPublicID
+	}
+
+	if !common.IsValidPublicID(publicID) {
+		return "", fmt.Errorf("%s: %w", publicID, common.ErrKeyInvalidPublicID)
+	}
+
+	return publicID, nil
+}
+
+func printKey(w io.Writer, format string, key *common.Key) error {
+	switch format {
+	case "json":
+		return writeJSON(w, key)
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0) //nolint:mnd
+		fmt.Fprintf(tw, "ID:\t%d\n", key.ID)
+		fmt.Fprintf(tw, "Public ID:\t%s\n", key.PublicID)
+		fmt.Fprintf(tw, "Private ID:\t%s\n", key.PrivateID)
+		fmt.Fprintf(tw, "AES key:\t%s\n", key.AESKey)
+		fmt.Fprintf(tw, "Lock code:\t%s\n", key.LockCode)
+		fmt.Fprintf(tw, "Active:\t%t\n", key.Active)
+		fmt.Fprintf(tw, "Created:\t%s\n", key.Created)
+
+		if key.Owner != "" {
+			fmt.Fprintf(tw, "Owner:\t%s\n", key.Owner)
+		}
+
+		if key.Description != "" {
+			fmt.Fprintf(tw, "Description:\t%s\n", key.Description)
+		}
+
+		return tw.Flush()
+	default:
+		return fmt.Errorf("%s: %w", format, ErrUnknownFormat)
+	}
+}
+
+func printKeyList(w io.Writer, format string, keys []*common.Key) error {
+	infos := make([]common.KeyInfo, 0, len(keys))
+	for _, key := range keys {
+		infos = append(infos, key.Info())
+	}
+
+	switch format {
+	case "json":
+		return writeJSON(w, infos)
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0) //nolint:mnd
+		fmt.Fprintln(tw, "ID\tPUBLIC ID\tACTIVE\tCREATED")
+
+		for _, info := range infos {
+			fmt.Fprintf(tw, "%d\t%s\t%t\t%s\n", info.ID, info.PublicID, info.Active, info.Created)
+		}
+
+		return tw.Flush()
+	default:
+		return fmt.Errorf("%s: %w", format, ErrUnknownFormat)
+	}
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("cannot encode JSON: %w", err)
+	}
+
+	return nil
+}
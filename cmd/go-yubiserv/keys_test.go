@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+func testKey() *common.Key {
+	return &common.Key{
+		ID:        1,
+		PublicID:  "vvcccccccccc",
+		Created:   "2024-01-01T00:00:00Z",
+		PrivateID: "0102030405ab",
+		AESKey:    "0102030405060708090a0b0c0d0e0f10",
+		LockCode:  "010203040506",
+		Active:    true,
+	}
+}
+
+func Test_printKeyList(t *testing.T) {
+	t.Parallel()
+
+	keys := []*common.Key{testKey()}
+
+	t.Run("table", func(t *testing.T) {
+		t.Parallel()
+
+		buf := new(bytes.Buffer)
+		require.NoError(t, printKeyList(buf, "table", keys))
+		require.Equal(t, "ID  PUBLIC ID     ACTIVE  CREATED\n1   vvcccccccccc  true    2024-01-01T00:00:00Z\n", buf.String())
+	})
+
+	t.Run("json hides secrets", func(t *testing.T) {
+		t.Parallel()
+
+		buf := new(bytes.Buffer)
+		require.NoError(t, printKeyList(buf, "json", keys))
+		require.NotContains(t, buf.String(), keys[0].AESKey)
+
+		var infos []common.KeyInfo
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &infos))
+		require.Equal(t, []common.KeyInfo{{ID: 1, PublicID: "vvcccccccccc", Created: "2024-01-01T00:00:00Z", Active: true}}, infos)
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		t.Parallel()
+
+		require.ErrorIs(t, printKeyList(new(bytes.Buffer), "xml", keys), ErrUnknownFormat)
+	})
+}
+
+func Test_printKey(t *testing.T) {
+	t.Parallel()
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, printKey(buf, "json", testKey()))
+
+	var key common.Key
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &key))
+	require.Equal(t, *testKey(), key)
+}
+
+func Test_rotateKey(t *testing.T) {
+	t.Parallel()
+
+	key := testKey()
+	svc := newMigrateBoltStore(t, key)
+	require.NoError(t, svc.StoreCounter(key.PublicID, &common.OTPUser{UsageCounter: 10, SessionCounter: 5}))
+
+	rotated, err := rotateKey(context.Background(), svc, svc, key.PublicID)
+	require.NoError(t, err)
+	require.NotEqual(t, key.AESKey, rotated.AESKey)
+	require.NotEqual(t, key.PrivateID, rotated.PrivateID)
+
+	stored, err := svc.GetKey(context.Background(), key.PublicID)
+	require.NoError(t, err)
+	require.Equal(t, rotated.AESKey, stored.AESKey)
+
+	counters, err := svc.LoadCounters()
+	require.NoError(t, err)
+	require.NotContains(t, counters, key.PublicID)
+
+	t.Run("memory counters", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := rotateKey(context.Background(), newMigrateBoltStore(t, testKey()), nil, key.PublicID)
+		require.NoError(t, err)
+	})
+}
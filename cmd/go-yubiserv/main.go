@@ -1,7 +1,6 @@
 package main
 
 import (
-	"crypto/aes"
 	"errors"
 	"fmt"
 	"os"
@@ -15,11 +14,17 @@ import (
 	"github.com/spf13/viper"
 	"github.com/urfave/cli/v2"
 	"go.uber.org/dig"
-	"go.uber.org/zap"
 
-	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/keycache"
+	"github.com/archaron/go-yubiserv/metrics"
 	"github.com/archaron/go-yubiserv/misc"
+	"github.com/archaron/go-yubiserv/modules/admin"
 	"github.com/archaron/go-yubiserv/modules/api"
+	"github.com/archaron/go-yubiserv/modules/boltstorage"
+	"github.com/archaron/go-yubiserv/modules/filecounters"
+	"github.com/archaron/go-yubiserv/modules/filestorage"
+	"github.com/archaron/go-yubiserv/modules/sqliteclients"
+	"github.com/archaron/go-yubiserv/modules/sqlitecounters"
 	"github.com/archaron/go-yubiserv/modules/sqlitestorage"
 	"github.com/archaron/go-yubiserv/modules/vaultstorage"
 )
@@ -29,6 +34,17 @@ const (
 	defaultLoggerSamplingInitial = 100
 	defaultLoggerSamplingThereafter
 	defaultVaultLoginTimeout = 5 * time.Second
+
+	defaultKeyCacheNegativeTTL = 30 * time.Second
+	defaultKeyCacheStaleTTL    = time.Hour
+	defaultKeyCacheSize        = 10000
+
+	// Same defaults as ykval uses for the phishing test.
+	defaultTSAbsTolerance = 20 * time.Second
+	defaultTSRelTolerance = 0.3
+
+	defaultNonceWindow = 10 * time.Minute
+	defaultNonceLimit  = 100000
 )
 
 func defaults(ctx *cli.Context, v *viper.Viper) error {
@@ -65,6 +81,10 @@ func defaults(ctx *cli.Context, v *viper.Viper) error {
 		return fmt.Errorf("cannot apply api defaults: %w", err)
 	}
 
+	if err := admin.Defaults(ctx, v); err != nil {
+		return fmt.Errorf("cannot apply admin api defaults: %w", err)
+	}
+
 	if err := vaultstorage.Defaults(ctx, v); err != nil {
 		return fmt.Errorf("cannot apply vault defaults: %w", err)
 	}
@@ -73,6 +93,30 @@ func defaults(ctx *cli.Context, v *viper.Viper) error {
 		return fmt.Errorf("cannot apply sqlite defaults: %w", err)
 	}
 
+	if err := boltstorage.Defaults(ctx, v); err != nil {
+		return fmt.Errorf("cannot apply bolt defaults: %w", err)
+	}
+
+	if err := filestorage.Defaults(ctx, v); err != nil {
+		return fmt.Errorf("cannot apply keys file defaults: %w", err)
+	}
+
+	if err := keycache.Defaults(ctx, v); err != nil {
+		return fmt.Errorf("cannot apply key cache defaults: %w", err)
+	}
+
+	if err := filecounters.Defaults(ctx, v); err != nil {
+		return fmt.Errorf("cannot apply file counters defaults: %w", err)
+	}
+
+	if err := sqlitecounters.Defaults(ctx, v); err != nil {
+		return fmt.Errorf("cannot apply sqlite counters defaults: %w", err)
+	}
+
+	if err := sqliteclients.Defaults(ctx, v); err != nil {
+		return fmt.Errorf("cannot apply sqlite clients defaults: %w", err)
+	}
+
 	// err := v.WriteConfigAs("./x.yaml")
 	// if err != nil {
 	//	return err
@@ -87,7 +131,9 @@ var modules = module.Combine(
 	grace.Module,      // grace context
 	settings.Module,   // settings module
 	logger.Module,     // logger module
+	metrics.Module,    // metrics registry
 	api.Module,
+	admin.Module,
 )
 
 //nolint:gochecknoglobals
@@ -96,7 +142,12 @@ var generateModules = module.Combine(
 	logger.Module,   // logger module
 )
 
-var ErrUnknownKeyStore = errors.New("unknown key store specified")
+var (
+	ErrUnknownKeyStore     = errors.New("unknown key store specified")
+	ErrUnknownCounterStore = errors.New("unknown counter store specified")
+	ErrBoltCounters        = errors.New("bolt counters store requires the bolt key store")
+	ErrUnknownClientStore  = errors.New("unknown client store specified")
+)
 
 func main() {
 	c := cli.NewApp()
@@ -146,11 +197,30 @@ func main() {
 				},
 				&cli.BoolFlag{
 					Name:  "save",
-					Usage: "Save generated keys in storage",
+					Usage: "Save generated keys in storage selected by --keystore",
 					Value: false,
 				},
+				&cli.StringFlag{
+					Name:  "program-file",
+					Usage: "Write a script programming generated keys into YubiKeys to this file",
+				},
+				&cli.StringFlag{
+					Name:  "program-tool",
+					Usage: "Programming tool used in the script: ykman, ykpersonalize",
+					Value: "ykman",
+				},
+				&cli.IntFlag{
+					Name:  "slot",
+					Usage: "YubiKey OTP slot to program: 1, 2",
+					Value: 1,
+				},
 			},
 		},
+		keysCommand(),
+		dbCommand(),
+		vaultCommand(),
+		migrateCommand(),
+		importCommand(),
 	}
 
 	c.Flags = []cli.Flag{
@@ -166,36 +236,88 @@ func main() {
 
 		&cli.StringFlag{Name: "api-address", Value: ":8443", Usage: "Validation API bind address"},
 		&cli.StringFlag{Name: "api-timeout", Value: "1s", Usage: "Validation API connect/read timeout"},
-		&cli.StringFlag{Name: "api-secret", Value: "", Usage: "Validation API secret for HMAC signature verification, empty to disable check"},
+		&cli.StringFlag{Name: "api-lookup-timeout", Value: "500ms", Usage: "Key store lookup deadline for a single OTP, 0 to disable"},
+		&cli.StringFlag{Name: "api-secret", Value: "", Usage: "Validation API secret for HMAC signature verification when no api.clients are configured, empty to disable check"},
+
+		&cli.DurationFlag{Name: "api-ts-abs-tolerance", Value: defaultTSAbsTolerance, Usage: "DELAYED_OTP absolute timestamp drift tolerance"},
+		&cli.Float64Flag{Name: "api-ts-rel-tolerance", Value: defaultTSRelTolerance, Usage: "DELAYED_OTP relative timestamp drift tolerance"},
+
+		&cli.DurationFlag{Name: "api-nonce-window", Value: defaultNonceWindow, Usage: "Time window for REPLAYED_REQUEST nonce check, 0 to disable"},
+		&cli.IntFlag{Name: "api-nonce-limit", Value: defaultNonceLimit, Usage: "Maximal number of remembered request nonces"},
 
 		&cli.StringFlag{Name: "api-tls-cert", Value: "", Usage: "Validation API TLS cert file path"},
 		&cli.StringFlag{Name: "api-tls-key", Value: "", Usage: "Validation API TLS private key file path"},
 
-		&cli.StringFlag{Name: "keystore", Value: "vault", Usage: "Key store backend: sqlite, vault"},
+		&cli.StringFlag{Name: "admin-address", Value: "", Usage: "Admin API bind address, empty to disable"},
+
+		&cli.StringFlag{Name: "keystore", Value: "vault", Usage: "Key store backend: sqlite, vault, bolt, file"},
 
 		&cli.StringFlag{Name: "sqlite-dbpath", Value: "yubiserv.db", Usage: "SQLite3 database path"},
+		&cli.StringFlag{Name: "sqlite-kek", Value: "", Usage: "KEK sealing SQLite3 key secrets: file:<path>, env:<name> or transit:<key>, empty for plaintext"},
+
+		&cli.StringFlag{Name: "bolt-path", Value: "yubiserv.bolt", Usage: "bbolt database path of keys and counters"},
+
+		&cli.StringFlag{Name: "keys-file", Value: "keys.ykksm", Usage: "ykksm keys file path, plain or age-encrypted"},
+		&cli.StringFlag{Name: "keys-file-identity", Value: "", Usage: "age identity file decrypting the keys file"},
+
+		&cli.DurationFlag{Name: "keycache-ttl", Value: 0, Usage: "Key cache entry lifetime, 0 to disable the cache"},
+		&cli.DurationFlag{Name: "keycache-negative-ttl", Value: defaultKeyCacheNegativeTTL, Usage: "Lifetime of cached unknown public IDs, 0 to disable"},
+		&cli.DurationFlag{Name: "keycache-stale-ttl", Value: defaultKeyCacheStaleTTL, Usage: "How long expired keys are served while the key store fails"},
+		&cli.IntFlag{Name: "keycache-size", Value: defaultKeyCacheSize, Usage: "Maximal number of cached keys"},
+
+		&cli.StringFlag{Name: "counterstore", Value: "file", Usage: "Replay-protection counters store: file, sqlite, bolt, memory"},
+		&cli.StringFlag{Name: "counters-path", Value: "counters.json", Usage: "Counters file path"},
+		&cli.StringFlag{Name: "counters-dbpath", Value: "yubiserv.db", Usage: "SQLite3 counters database path"},
+
+		&cli.StringFlag{Name: "clientstore", Value: "config", Usage: "API clients registry: config, sqlite"},
+		&cli.StringFlag{Name: "clients-dbpath", Value: "yubiserv.db", Usage: "SQLite3 API clients database path"},
 
 		&cli.StringFlag{Name: "vault-address", Value: "https://127.0.0.1:8200", Usage: "Vault server address"},
-		&cli.StringFlag{Name: "vault-path", Value: "secret/data/yubiserv", Usage: "Vault path to KV secrets store"},
+		&cli.StringFlag{Name: "vault-path", Value: "secret/data/yubiserv", Usage: "Vault path to KV secrets store, with or without KV v2 data/ segment"},
+		&cli.StringFlag{Name: "vault-kv-mount", Value: "", Usage: "KV secrets engine mount path, detected if empty"},
+		&cli.IntFlag{Name: "vault-kv-version", Value: 0, Usage: "KV secrets engine version: 1, 2 or 0 to detect"},
+		&cli.StringFlag{Name: "vault-namespace", Value: "", Usage: "Vault Enterprise namespace, VAULT_NAMESPACE is used if empty"},
 
+		&cli.StringFlag{Name: "vault-auth", Value: "approle", Usage: "Vault auth method: approle, token, kubernetes, cert"},
+		&cli.StringFlag{Name: "vault-auth-mount", Value: "", Usage: "Vault auth method mount path, empty for the method name"},
 		&cli.StringFlag{Name: "vault-role-id", Value: "", Usage: "role_id for Vault auth, overrides role-file"},
 		&cli.StringFlag{Name: "vault-role-file", Value: "role_id", Usage: "Path to file containing role_id for Vault auth"},
 		&cli.StringFlag{Name: "vault-secret-id", Value: "", Usage: "secret_id for Vault auth, overrides secret-file"},
 		&cli.StringFlag{Name: "vault-secret-file", Value: "secret_id", Usage: "Path to file containing secret_id for Vault auth"},
+		&cli.BoolFlag{Name: "vault-secret-id-wrapped", Usage: "secret_id is a response-wrapping token, a fresh one is read from secret-file on every login"},
+		&cli.StringFlag{Name: "vault-token", Value: "", Usage: "Token for Vault token auth, VAULT_TOKEN is used if empty"},
+		&cli.StringFlag{Name: "vault-token-file", Value: "", Usage: "Path to file containing token for Vault token auth, overrides token"},
+		&cli.StringFlag{Name: "vault-k8s-role", Value: "", Usage: "Role for Vault kubernetes auth"},
+		&cli.StringFlag{Name: "vault-k8s-jwt-file", Value: "/var/run/secrets/kubernetes.io/serviceaccount/token", Usage: "Service account token for Vault kubernetes auth"},
+		&cli.StringFlag{Name: "vault-tls-cert", Value: "", Usage: "Client certificate file for Vault cert auth"},
+		&cli.StringFlag{Name: "vault-tls-key", Value: "", Usage: "Client private key file for Vault cert auth"},
+		&cli.StringFlag{Name: "vault-cert-role", Value: "", Usage: "Certificate role for Vault cert auth, empty to match any"},
 		&cli.DurationFlag{Name: "vault-login-timeout", Value: defaultVaultLoginTimeout, Usage: "Vault server login timeout"},
+		&cli.StringFlag{Name: "vault-transit-key", Value: "", Usage: "Vault Transit key wrapping stored AES keys, empty to store them as is"},
+		&cli.StringFlag{Name: "vault-transit-mount", Value: "transit", Usage: "Vault Transit secrets engine mount path"},
 	}
 
 	// Default action
 	c.Action = func(ctx *cli.Context) error {
-		switch ctx.String("keystore") {
-		case "vault":
-			modules = modules.Append(vaultstorage.Module)
-		case "sqlite":
-			modules = modules.Append(sqlitestorage.Module)
-		default:
-			return fmt.Errorf("%s: %w", ctx.String("keystore"), ErrUnknownKeyStore)
+		store, err := keystoreModule(ctx)
+		if err != nil {
+			return err
+		}
+
+		modules = modules.Append(store)
+
+		counters, err := counterstoreModule(ctx)
+		if err != nil {
+			return err
 		}
 
+		clients, err := clientstoreModule(ctx)
+		if err != nil {
+			return err
+		}
+
+		modules = modules.Append(counters, clients)
+
 		h, err := helium.New(&helium.Settings{
 			File:         ctx.String("config"),
 			Prefix:       misc.Prefix,
@@ -219,58 +341,57 @@ func main() {
 	helium.Catch(err)
 }
 
-func generator() cli.ActionFunc {
-	return func(c *cli.Context) error {
-		h, err := helium.New(&helium.Settings{
-			Prefix:       misc.Prefix,
-			Name:         misc.Name,
-			BuildTime:    misc.Version,
-			BuildVersion: misc.Build,
-		}, generateModules)
-		if err != nil {
-			return fmt.Errorf("cannot initialize helium: %w", err)
+// keystoreModule returns key store module selected by --keystore.
+func keystoreModule(ctx *cli.Context) (module.Module, error) {
+	return keystoreByName(ctx.String("keystore"))
+}
+
+// counterstoreModule returns counters store module selected by --counterstore, empty for memory counters.
+func counterstoreModule(ctx *cli.Context) (module.Module, error) {
+	switch ctx.String("counterstore") {
+	case "file":
+		return filecounters.Module, nil
+	case "sqlite":
+		return sqlitecounters.Module, nil
+	case "bolt":
+		if ctx.String("keystore") != "bolt" {
+			return nil, ErrBoltCounters
 		}
 
-		return h.Invoke(func(log *zap.Logger) {
-			start := c.Int("start")
-			count := c.Int("count")
-			progflags := c.String("progflags")
-
-			fmt.Println("# ykksm 1")                                                                  //nolint:forbidigo
-			fmt.Printf("# start %d end %d\n", start, start+count)                                     //nolint:forbidigo
-			fmt.Println("# serialnr,identity,internaluid,aeskey,lockpw,created,accessed[,progflags]") //nolint:forbidigo
-
-			for i := start; i <= count; i++ {
-				ctr := fmt.Sprintf("%012x", i)
-				modhexctr := misc.HexToModHex(ctr)
-
-				internalUID, err := misc.HexRand(common.PrivateIDSize)
-				if err != nil {
-					log.Fatal("error generating random", zap.Error(err))
-				}
-
-				aesKey, err := misc.HexRand(aes.BlockSize)
-				if err != nil {
-					log.Fatal("error generating random aes key", zap.Error(err))
-				}
-
-				lockPW, err := misc.HexRand(common.LockPWSize)
-				if err != nil {
-					log.Fatal("error generating random lockPW", zap.Error(err))
-				}
-
-				fmt.Printf("%d,%s,%s,%s,%s,%s,%s\n", //nolint:forbidigo
-					i,
-					modhexctr,
-					internalUID,
-					aesKey,
-					lockPW,
-					time.Now().Format(time.RFC3339),
-					progflags,
-				)
-
-				fmt.Println("# the end") //nolint:forbidigo
-			}
-		})
+		return boltstorage.CountersModule, nil
+	case "memory":
+		// Counters are kept in memory only and lost on restart
+		return module.Module{}, nil
+	default:
+		return nil, fmt.Errorf("%s: %w", ctx.String("counterstore"), ErrUnknownCounterStore)
+	}
+}
+
+// clientstoreModule returns API clients registry module selected by --clientstore, empty for config clients.
+func clientstoreModule(ctx *cli.Context) (module.Module, error) {
+	switch ctx.String("clientstore") {
+	case "config":
+		// Read-only clients from api.clients config section
+		return module.Module{}, nil
+	case "sqlite":
+		return sqliteclients.Module, nil
+	default:
+		return nil, fmt.Errorf("%s: %w", ctx.String("clientstore"), ErrUnknownClientStore)
+	}
+}
+
+// keystoreByName returns key store module by its name.
+func keystoreByName(name string) (module.Module, error) {
+	switch name {
+	case "vault":
+		return vaultstorage.Module, nil
+	case "sqlite":
+		return sqlitestorage.Module, nil
+	case "bolt":
+		return boltstorage.Module, nil
+	case "file":
+		return filestorage.Module, nil
+	default:
+		return nil, fmt.Errorf("%s: %w", name, ErrUnknownKeyStore)
 	}
 }
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+	"github.com/urfave/cli/v2"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+var (
+	ErrSameKeyStore   = errors.New("source and destination key stores must differ")
+	ErrCannotVerify   = errors.New("destination key store cannot decrypt OTPs")
+	ErrKeyConflict    = errors.New("destination has another key with the same public ID, use --overwrite to replace it")
+	ErrVerifyMismatch = errors.New("migrated key differs from the source")
+	ErrStateMismatch  = errors.New("state file is of another migration, remove it or set another --state")
+)
+
+type (
+	// migrateTarget is the destination key store, it decrypts test OTPs to verify migrated keys.
+	migrateTarget interface {
+		common.KeyAdmin
+		common.StorageInterface
+	}
+
+	migrateOptions struct {
+		// dryRun only reports what would be copied
+		dryRun bool
+		// overwrite replaces different keys with the same public ID in the destination
+		overwrite bool
+		// state is the file of migrated public IDs, so that an interrupted migration is resumed
+		state string
+		// stores names the source and destination, the state file is only resumed by the same migration
+		stores string
+		// metadata compares and copies owner and description, kept by both key stores
+		metadata bool
+	}
+
+	migrateStats struct {
+		copied, present, resumed int
+	}
+)
+
+func migrateCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "migrate",
+		Usage:  "copy all keys from one key store to another, verifying every copied key with a test OTP",
+		Action: migrate,
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "from", Required: true, Usage: "Source key store: sqlite, vault, bolt, file"},
+			&cli.StringFlag{Name: "to", Required: true, Usage: "Destination key store: sqlite, vault, bolt"},
+			&cli.BoolFlag{Name: "dry-run", Usage: "Only report the keys to be copied"},
+			&cli.BoolFlag{Name: "overwrite", Usage: "Replace different keys with the same public ID in the destination"},
+			&cli.StringFlag{Name: "state", Value: "migrate.state", Usage: "File of migrated public IDs to resume from, empty to disable"},
+		},
+	}
+}
+
+func migrate(c *cli.Context) error {
+	if c.String("from") == c.String("to") {
+		return ErrSameKeyStore
+	}
+
+	src, err := keystoreByName(c.String("from"))
+	if err != nil {
+		return err
+	}
+
+	dst, err := keystoreByName(c.String("to"))
+	if err != nil {
+		return err
+	}
+
+	opts := migrateOptions{
+		dryRun:    c.Bool("dry-run"),
+		overwrite: c.Bool("overwrite"),
+		state:     c.String("state"),
+		stores:    c.String("from") + " -> " + c.String("to"),
+	}
+
+	return withKeyStore(c, src, func(from common.KeyAdmin, _ *viper.Viper) error {
+		return withKeyStore(c, dst, func(to common.KeyAdmin, _ *viper.Viper) error {
+			target, ok := to.(migrateTarget)
+			if !ok {
+				return ErrCannotVerify
+			}
+
+			opts.metadata = keepsMetadata(from) && keepsMetadata(to)
+
+			stats, err := migrateKeys(c.Context, from, target, opts, os.Stdout)
+
+			//nolint:forbidigo
+			fmt.Printf("%d keys copied, %d already present, %d done before\n", stats.copied, stats.present, stats.resumed)
+
+			return err
+		})
+	})
+}
+
+// migrateKeys copies all keys of src to dst, every copied key is read back and a test OTP encrypted
+// with its AES key is decrypted by dst. Verified keys are recorded in the state file and skipped on
+// the next run, keys already present in dst are verified only.
+func migrateKeys(ctx context.Context, src common.KeyAdmin, dst migrateTarget, opts migrateOptions, out io.Writer) (migrateStats, error) {
+	var stats migrateStats
+
+	keys, err := src.ListKeys()
+	if err != nil {
+		return stats, fmt.Errorf("cannot list source keys: %w", err)
+	}
+
+	done, err := readMigrateState(opts.state, opts.stores)
+	if err != nil {
+		return stats, err
+	}
+
+	if dropped := withMetadata(keys); dropped > 0 && keepsMetadata(src) && !keepsMetadata(dst) {
+		fmt.Fprintf(out, "warning: owner and description of %d keys are not kept by the destination key store\n", dropped)
+	}
+
+	var state *os.File
+
+	if !opts.dryRun && opts.state != "" {
+		if state, err = openMigrateState(opts.state, opts.stores); err != nil {
+			return stats, err
+		}
+
+		defer func() { _ = state.Close() }()
+	}
+
+	for _, listed := range keys {
+		if done[listed.PublicID] {
+			stats.resumed++
+
+			continue
+		}
+
+		// Listed keys may have secrets as stored, like AES keys wrapped by Vault Transit
+		key, err := src.GetKey(ctx, listed.PublicID)
+		if err != nil {
+			return stats, fmt.Errorf("cannot read source key %s: %w", listed.PublicID, err)
+		}
+
+		present, err := dst.GetKey(ctx, key.PublicID)
+
+		switch {
+		case errors.Is(err, common.ErrStorageNoKey):
+			present = nil
+		case err != nil:
+			return stats, fmt.Errorf("cannot read destination key %s: %w", key.PublicID, err)
+		case !sameKey(present, key, opts.metadata) && !opts.overwrite:
+			return stats, fmt.Errorf("%s: %w", key.PublicID, ErrKeyConflict)
+		}
+
+		copied := present == nil || !sameKey(present, key, opts.metadata)
+
+		if opts.dryRun {
+			if copied {
+				stats.copied++
+
+				fmt.Fprintf(out, "%s would be copied\n", key.PublicID)
+			} else {
+				stats.present++
+			}
+
+			continue
+		}
+
+		if copied {
+			if err = dst.StoreKey(key); err != nil {
+				return stats, fmt.Errorf("cannot store key %s: %w", key.PublicID, err)
+			}
+		}
+
+		if err = verifyKey(ctx, dst, key, opts.metadata); err != nil {
+			return stats, fmt.Errorf("cannot verify key %s: %w", key.PublicID, err)
+		}
+
+		if state != nil {
+			if _, err = fmt.Fprintln(state, key.PublicID); err != nil {
+				return stats, fmt.Errorf("cannot write state file: %w", err)
+			}
+
+			if err = state.Sync(); err != nil {
+				return stats, fmt.Errorf("cannot write state file: %w", err)
+			}
+		}
+
+		if copied {
+			stats.copied++
+
+			fmt.Fprintf(out, "%s copied\n", key.PublicID)
+		} else {
+			stats.present++
+		}
+	}
+
+	return stats, nil
+}
+
+// verifyKey reads the key back and decrypts a test OTP encrypted with its AES key, owner and description are
+// compared with metadata. OTPs of inactive keys are rejected, so they are verified by reading back only.
+func verifyKey(ctx context.Context, dst migrateTarget, key *common.Key, metadata bool) error {
+	stored, err := dst.GetKey(ctx, key.PublicID)
+	if err != nil {
+		return err
+	}
+
+	if !sameKey(stored, key, metadata) {
+		return ErrVerifyMismatch
+	}
+
+	if !key.Active {
+		return nil
+	}
+
+	aesKey, err := hex.DecodeString(key.AESKey)
+	if err != nil {
+		return fmt.Errorf("invalid AES key: %w", err)
+	}
+
+	otp := &common.OTP{UsageCounter: 1, Random: uint16(rand.Uint32())} //nolint:gosec
+
+	if _, err = hex.Decode(otp.PrivateID[:], []byte(key.PrivateID)); err != nil {
+		return fmt.Errorf("invalid private ID: %w", err)
+	}
+
+	token, err := otp.EncryptToModHex(aesKey)
+	if err != nil {
+		return err
+	}
+
+	decrypted, err := dst.DecryptOTP(ctx, key.PublicID, token)
+	if err != nil {
+		return fmt.Errorf("test OTP rejected: %w", err)
+	}
+
+	if decrypted.PrivateID != otp.PrivateID || decrypted.Random != otp.Random {
+		return fmt.Errorf("test OTP: %w", ErrVerifyMismatch)
+	}
+
+	return nil
+}
+
+// sameKey compares the keys as the key stores keep them, owner and description are compared with metadata only,
+// since not every key store keeps them.
+func sameKey(a, b *common.Key, metadata bool) bool {
+	return a.ID == b.ID && a.PublicID == b.PublicID && a.Created == b.Created && a.Active == b.Active &&
+		a.PrivateID == b.PrivateID && a.AESKey == b.AESKey && a.LockCode == b.LockCode &&
+		(!metadata || (a.Owner == b.Owner && a.Description == b.Description))
+}
+
+// keepsMetadata reports whether the key store keeps owner and description of keys.
+func keepsMetadata(store common.KeyAdmin) bool {
+	keeper, ok := store.(common.KeyMetadataKeeper)
+
+	return ok && keeper.KeepsKeyMetadata()
+}
+
+// withMetadata returns the number of keys with owner or description.
+func withMetadata(keys []*common.Key) int {
+	count := 0
+
+	for _, key := range keys {
+		if key.Owner != "" || key.Description != "" {
+			count++
+		}
+	}
+
+	return count
+}
+
+// migrateStateHeader is the first line of the state file, naming the source and destination of the migration.
+func migrateStateHeader(stores string) string {
+	return "# migrate " + stores
+}
+
+// openMigrateState opens the state file for appending, a new file starts with the header.
+func openMigrateState(path, stores string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open state file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err == nil && info.Size() == 0 {
+		_, err = fmt.Fprintln(f, migrateStateHeader(stores))
+	}
+
+	if err != nil {
+		_ = f.Close()
+
+		return nil, fmt.Errorf("cannot write state file: %w", err)
+	}
+
+	return f, nil
+}
+
+// readMigrateState returns the public IDs recorded in the state file, none if it does not exist.
+// A state file of another source or destination is refused with ErrStateMismatch.
+func readMigrateState(path, stores string) (map[string]bool, error) {
+	done := make(map[string]bool)
+
+	if path == "" {
+		return done, nil
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return done, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot open state file: %w", err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for line := 0; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		if line == 0 && text != migrateStateHeader(stores) {
+			return nil, fmt.Errorf("%s is not the state of migration %s: %w", path, stores, ErrStateMismatch)
+		}
+
+		if text != "" && !strings.HasPrefix(text, "#") {
+			done[text] = true
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read state file: %w", err)
+	}
+
+	return done, nil
+}
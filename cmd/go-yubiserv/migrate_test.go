@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/modules/boltstorage"
+	"github.com/archaron/go-yubiserv/modules/sqlitestorage"
+	"github.com/archaron/go-yubiserv/sqlitedriver"
+)
+
+func newMigrateStore(t *testing.T, keys ...*common.Key) *sqlitestorage.Service {
+	t.Helper()
+
+	db, err := sqlitedriver.Open(":memory:")
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	svc := sqlitestorage.TestNewService(zaptest.NewLogger(t), nil, db)
+	require.NoError(t, svc.TestCreateDatabase())
+
+	for _, key := range keys {
+		require.NoError(t, svc.StoreKey(key))
+	}
+
+	return svc
+}
+
+func newMigrateBoltStore(t *testing.T, keys ...*common.Key) *boltstorage.Service {
+	t.Helper()
+
+	svc := boltstorage.TestNewService(zaptest.NewLogger(t), filepath.Join(t.TempDir(), "keys.db"))
+	require.NoError(t, svc.Connect(context.Background()))
+	t.Cleanup(func() { _ = svc.Close() })
+
+	for _, key := range keys {
+		require.NoError(t, svc.StoreKey(key))
+	}
+
+	return svc
+}
+
+func migrateTestKeys() []*common.Key {
+	second := testKey()
+	second.ID, second.PublicID, second.AESKey = 2, "vvdddddddddd", "101112131415161718191a1b1c1d1e1f"
+
+	inactive := testKey()
+	inactive.ID, inactive.PublicID, inactive.Active = 3, "vveeeeeeeeee", false
+
+	return []*common.Key{testKey(), second, inactive}
+}
+
+// rejectingTarget is a destination that stores keys but rejects all OTPs.
+type rejectingTarget struct {
+	*sqlitestorage.Service
+}
+
+func (r rejectingTarget) DecryptOTP(context.Context, string, string) (*common.OTP, error) {
+	return nil, common.ErrStorageDecryptFail
+}
+
+func TestMigrateKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("dry run", func(t *testing.T) {
+		t.Parallel()
+
+		src, dst := newMigrateStore(t, migrateTestKeys()...), newMigrateStore(t, testKey())
+		state := filepath.Join(t.TempDir(), "migrate.state")
+		out := new(bytes.Buffer)
+
+		stats, err := migrateKeys(ctx, src, dst, migrateOptions{dryRun: true, state: state}, out)
+		require.NoError(t, err)
+		require.Equal(t, migrateStats{copied: 2, present: 1}, stats)
+		require.Equal(t, "vvdddddddddd would be copied\nvveeeeeeeeee would be copied\n", out.String())
+
+		keys, err := dst.ListKeys()
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		require.NoFileExists(t, state)
+	})
+
+	t.Run("copy and resume", func(t *testing.T) {
+		t.Parallel()
+
+		src, dst := newMigrateStore(t, migrateTestKeys()...), newMigrateStore(t)
+		state := filepath.Join(t.TempDir(), "migrate.state")
+
+		opts := migrateOptions{state: state, stores: "sqlite -> vault"}
+
+		// Interrupted run has migrated the first key
+		require.NoError(t, dst.StoreKey(testKey()))
+		require.NoError(t, os.WriteFile(state, []byte("# migrate sqlite -> vault\nvvcccccccccc\n"), 0o600))
+
+		stats, err := migrateKeys(ctx, src, dst, opts, new(bytes.Buffer))
+		require.NoError(t, err)
+		require.Equal(t, migrateStats{copied: 2, resumed: 1}, stats)
+
+		for _, key := range migrateTestKeys() {
+			stored, err := dst.GetKey(ctx, key.PublicID)
+			require.NoError(t, err)
+			require.True(t, sameKey(key, stored, false))
+		}
+
+		done, err := readMigrateState(state, opts.stores)
+		require.NoError(t, err)
+		require.Len(t, done, 3)
+
+		stats, err = migrateKeys(ctx, src, dst, opts, new(bytes.Buffer))
+		require.NoError(t, err)
+		require.Equal(t, migrateStats{resumed: 3}, stats)
+
+		// State of this migration does not apply to another destination
+		_, err = migrateKeys(ctx, src, dst, migrateOptions{state: state, stores: "sqlite -> bolt"}, new(bytes.Buffer))
+		require.ErrorIs(t, err, ErrStateMismatch)
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		t.Parallel()
+
+		other := testKey()
+		other.AESKey = "ffffffffffffffffffffffffffffffff"
+
+		src, dst := newMigrateStore(t, testKey()), newMigrateStore(t, other)
+
+		_, err := migrateKeys(ctx, src, dst, migrateOptions{}, new(bytes.Buffer))
+		require.ErrorIs(t, err, ErrKeyConflict)
+
+		stats, err := migrateKeys(ctx, src, dst, migrateOptions{overwrite: true}, new(bytes.Buffer))
+		require.NoError(t, err)
+		require.Equal(t, migrateStats{copied: 1}, stats)
+
+		stored, err := dst.GetKey(ctx, other.PublicID)
+		require.NoError(t, err)
+		require.Equal(t, testKey().AESKey, stored.AESKey)
+	})
+
+	t.Run("verification failure", func(t *testing.T) {
+		t.Parallel()
+
+		src := newMigrateStore(t, testKey())
+		state := filepath.Join(t.TempDir(), "migrate.state")
+
+		opts := migrateOptions{state: state, stores: "sqlite -> vault"}
+
+		_, err := migrateKeys(ctx, src, rejectingTarget{newMigrateStore(t)}, opts, new(bytes.Buffer))
+		require.ErrorIs(t, err, common.ErrStorageDecryptFail)
+
+		done, err := readMigrateState(state, opts.stores)
+		require.NoError(t, err)
+		require.Empty(t, done)
+	})
+}
+
+func TestMigrateMetadata(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	owned := testKey()
+	owned.Owner, owned.Description = "alice", "spare key"
+
+	t.Run("kept by both key stores", func(t *testing.T) {
+		t.Parallel()
+
+		src := newMigrateBoltStore(t, owned)
+		dst := newMigrateBoltStore(t, testKey())
+		require.True(t, keepsMetadata(src) && keepsMetadata(dst))
+
+		opts := migrateOptions{metadata: true}
+
+		_, err := migrateKeys(ctx, src, dst, opts, new(bytes.Buffer))
+		require.ErrorIs(t, err, ErrKeyConflict)
+
+		opts.overwrite = true
+
+		stats, err := migrateKeys(ctx, src, dst, opts, new(bytes.Buffer))
+		require.NoError(t, err)
+		require.Equal(t, migrateStats{copied: 1}, stats)
+
+		stored, err := dst.GetKey(ctx, owned.PublicID)
+		require.NoError(t, err)
+		require.Equal(t, owned, stored)
+	})
+
+	t.Run("dropped by the destination", func(t *testing.T) {
+		t.Parallel()
+
+		src, dst := newMigrateBoltStore(t, owned, migrateTestKeys()[1]), newMigrateStore(t)
+		require.False(t, keepsMetadata(dst))
+
+		out := new(bytes.Buffer)
+
+		stats, err := migrateKeys(ctx, src, dst, migrateOptions{}, out)
+		require.NoError(t, err)
+		require.Equal(t, migrateStats{copied: 2}, stats)
+		require.Contains(t, out.String(), "warning: owner and description of 1 keys are not kept by the destination key store\n")
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/viper"
+	"github.com/urfave/cli/v2"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/modules/vaultstorage"
+)
+
+var ErrNotVaultStore = errors.New("command requires the Vault key store")
+
+func vaultCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "vault",
+		Usage: "maintain the Vault key store",
+		Subcommands: cli.Commands{
+			{
+				Name:   "wrap-keys",
+				Usage:  "wrap plaintext AES keys with the Vault Transit key from --vault-transit-key",
+				Action: vaultWrapKeys,
+			},
+		},
+	}
+}
+
+// withVaultKeys runs fn against the Vault key store regardless of --keystore.
+func withVaultKeys(c *cli.Context, fn func(svc *vaultstorage.Service) error) error {
+	return withKeyStore(c, vaultstorage.Module, func(ka common.KeyAdmin, _ *viper.Viper) error {
+		svc, ok := ka.(*vaultstorage.Service)
+		if !ok {
+			return ErrNotVaultStore
+		}
+
+		return fn(svc)
+	})
+}
+
+func vaultWrapKeys(c *cli.Context) error {
+	return withVaultKeys(c, func(svc *vaultstorage.Service) error {
+		count, err := svc.WrapKeys(c.Context)
+		if err != nil {
+			return err
+		}
+
+		//nolint:forbidigo
+		fmt.Printf("%d AES keys wrapped\n", count)
+
+		return nil
+	})
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+type (
+	// Client represents a validation API client, like a row of the ykval clients table.
+	// Each client signs requests and verifies responses with its own HMAC secret.
+	Client struct {
+		ID          uint64 `db:"id"          json:"id"`
+		Secret      string `db:"secret"      json:"secret"` // Base64-encoded HMAC-SHA1 key, empty disables signatures
+		Active      bool   `db:"active"      json:"active"`
+		Description string `db:"description" json:"description"`
+	}
+
+	// ClientStorage defines the interface for API clients registry implementations.
+	ClientStorage interface {
+		// GetClient returns the client with the given ID or ErrClientNotFound.
+		GetClient(id uint64) (*Client, error)
+	}
+
+	// ClientAdmin defines API clients management operations of writable registries.
+	ClientAdmin interface {
+		ClientStorage
+
+		// ListClients returns all registered clients.
+		ListClients() ([]*Client, error)
+
+		// StoreClient creates or replaces the client.
+		StoreClient(c *Client) error
+
+		// DeleteClient removes the client with the given ID or returns ErrClientNotFound.
+		DeleteClient(id uint64) error
+	}
+)
+
+// ErrClientNotFound indicates that the requested API client ID is not registered.
+var ErrClientNotFound = errors.New("api client not found")
+
+// Key returns decoded client HMAC key, nil when signatures are disabled.
+func (c *Client) Key() ([]byte, error) {
+	if c.Secret == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(c.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode client %d key: %w", c.ID, err)
+	}
+
+	return key, nil
+}
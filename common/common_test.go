@@ -34,3 +34,30 @@ func TestHMACSign(t *testing.T) {
 		require.Equal(t, testHash, common.SignMapToBase64(testMap, apiKey))
 	})
 }
+
+func TestClientKey(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should decode client key", func(t *testing.T) {
+		t.Parallel()
+
+		key, err := (&common.Client{Secret: "mG5be6ZJU1qBGz24yPh/ESM3UdU="}).Key()
+		require.NoError(t, err)
+		require.Len(t, key, 20)
+	})
+
+	t.Run("should return nil key for empty secret", func(t *testing.T) {
+		t.Parallel()
+
+		key, err := (&common.Client{}).Key()
+		require.NoError(t, err)
+		require.Nil(t, key)
+	})
+
+	t.Run("should error on invalid secret", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := (&common.Client{ID: 7, Secret: "!!!"}).Key()
+		require.ErrorContains(t, err, "failed to decode client 7 key")
+	})
+}
@@ -0,0 +1,32 @@
+package common
+
+import "errors"
+
+// CounterStorage defines the interface for persistent replay-protection counters.
+// Implementations must save counters durably, so that OTPs accepted before a restart
+// cannot be replayed after it.
+type CounterStorage interface {
+	// LoadCounters returns all stored counters keyed by the YubiKey public ID.
+	LoadCounters() (OTPUsers, error)
+
+	// StoreCounter durably saves counters for the given public ID.
+	// Must not return before the data is persisted.
+	StoreCounter(publicID string, user *OTPUser) error
+
+	// DeleteCounter durably removes counters for the given public ID,
+	// missing counters are not an error.
+	DeleteCounter(publicID string) error
+}
+
+// CounterAdmin defines administrative access to the counters of the running validation service.
+type CounterAdmin interface {
+	// Counters returns a snapshot of the last accepted counters keyed by the YubiKey public ID.
+	Counters() OTPUsers
+
+	// ResetCounter forgets the last accepted counters of the given public ID,
+	// so the next OTP of a reprogrammed key is accepted. Returns ErrCounterNotFound if there are none.
+	ResetCounter(publicID string) error
+}
+
+// ErrCounterNotFound indicates that no OTP of the given public ID was accepted yet.
+var ErrCounterNotFound = errors.New("counters not found")
@@ -0,0 +1,106 @@
+package common
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/misc"
+)
+
+// KeyLookupFunc returns the key of the public ID.
+type KeyLookupFunc func(ctx context.Context, publicID string) (*Key, error)
+
+// AESKeyFunc returns the raw AES key of an active key, the returned slice is cleared after the decryption.
+type AESKeyFunc func(ctx context.Context, key *Key) ([]byte, error)
+
+// DecryptOTP decrypts the OTP token with the key returned by lookup and checks its private ID, errors are
+// classified as StorageInterface.DecryptOTP describes. Lookup errors other than ErrStorageNoKey are backend
+// failures, unless already classified by lookup. AES key of the key is hex-encoded.
+func DecryptOTP(ctx context.Context, log *zap.Logger, lookup KeyLookupFunc, publicID, token string) (*OTP, error) {
+	return DecryptOTPWithKey(ctx, log, lookup, hexAESKey, publicID, token)
+}
+
+// DecryptOTPWithKey is DecryptOTP with the raw AES key returned by aesKey, e.g. unwrapped by a KEK.
+// Errors of aesKey are classified the same way as lookup errors.
+func DecryptOTPWithKey(
+	ctx context.Context,
+	log *zap.Logger,
+	lookup KeyLookupFunc,
+	aesKey AESKeyFunc,
+	publicID, token string,
+) (*OTP, error) {
+	log = log.With(
+		zap.String("public_id", publicID),
+		zap.String("token", token),
+	)
+
+	key, err := lookup(ctx, publicID)
+
+	switch {
+	case errors.Is(err, ErrStorageNoKey):
+		return nil, ErrStorageNoKey
+	case errors.Is(err, ErrStorageDecryptFail), errors.Is(err, ErrStorageBackend):
+		return nil, err
+	case err != nil:
+		return nil, fmt.Errorf("%w: %w", ErrStorageBackend, err)
+	}
+
+	if !key.Active {
+		return nil, ErrStorageKeyInactive
+	}
+
+	rawKey, err := aesKey(ctx, key)
+
+	switch {
+	case errors.Is(err, ErrStorageDecryptFail), errors.Is(err, ErrStorageBackend):
+		log.Error("cannot get AES key", zap.Error(err))
+
+		return nil, err
+	case err != nil:
+		log.Error("cannot get AES key", zap.Error(err))
+
+		return nil, fmt.Errorf("%w: %w", ErrStorageBackend, err)
+	}
+
+	defer clear(rawKey)
+
+	binToken, err := hex.DecodeString(misc.ModHexToHex(token))
+	if err != nil {
+		log.Error("failed to decode token", zap.Error(err))
+
+		return nil, ErrStorageDecryptFail
+	}
+
+	otp := &OTP{}
+
+	if err = otp.Decrypt(rawKey, binToken); err != nil {
+		log.Error("AES decryption failed", zap.Error(err))
+
+		return nil, fmt.Errorf("%w: %w", ErrStorageDecryptFail, err)
+	}
+
+	if hex.EncodeToString(otp.PrivateID[:]) != key.PrivateID {
+		log.Error("private ID mismatch",
+			zap.String("otp_private_id", hex.EncodeToString(otp.PrivateID[:])),
+			zap.String("key_private_id", key.PrivateID),
+		)
+
+		return nil, ErrStorageDecryptFail
+	}
+
+	return otp, nil
+}
+
+// hexAESKey decodes the hex-encoded AES key of the key.
+func hexAESKey(_ context.Context, key *Key) ([]byte, error) {
+	raw, err := hex.DecodeString(key.AESKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid AES key: %w", ErrStorageDecryptFail, err)
+	}
+
+	return raw, nil
+}
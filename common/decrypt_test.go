@@ -0,0 +1,94 @@
+package common_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+func TestDecryptOTP(t *testing.T) {
+	t.Parallel()
+
+	const token = "dvgtiblfkbgturecfllberrvkinnctnn"
+
+	key := &common.Key{
+		PublicID:  "cccccccccccc",
+		PrivateID: "010203040506",
+		AESKey:    "000102030405060708090a0b0c0d0e0f",
+		Active:    true,
+	}
+
+	lookup := func(k *common.Key, err error) common.KeyLookupFunc {
+		return func(context.Context, string) (*common.Key, error) { return k, err }
+	}
+
+	decrypt := func(t *testing.T, lookup common.KeyLookupFunc) (*common.OTP, error) {
+		t.Helper()
+
+		return common.DecryptOTP(context.Background(), zaptest.NewLogger(t), lookup, key.PublicID, token)
+	}
+
+	otp, err := decrypt(t, lookup(key, nil))
+	require.NoError(t, err)
+	require.Equal(t, common.TestVectors[token].OTP, *otp)
+
+	inactive := *key
+	inactive.Active = false
+	_, err = decrypt(t, lookup(&inactive, nil))
+	require.ErrorIs(t, err, common.ErrStorageKeyInactive)
+
+	other := *key
+	other.PrivateID = "0a0b0c0d0e0f"
+	_, err = decrypt(t, lookup(&other, nil))
+	require.ErrorIs(t, err, common.ErrStorageDecryptFail)
+
+	_, err = decrypt(t, lookup(nil, errors.New("database is locked")))
+	require.ErrorIs(t, err, common.ErrStorageBackend)
+
+	_, err = decrypt(t, lookup(nil, common.ErrStorageNoKey))
+	require.Equal(t, common.ErrStorageNoKey, err)
+
+	// Errors already classified by the lookup are kept
+	_, err = decrypt(t, lookup(nil, common.ErrStorageDecryptFail))
+	require.ErrorIs(t, err, common.ErrStorageDecryptFail)
+	require.NotErrorIs(t, err, common.ErrStorageBackend)
+}
+
+func TestDecryptOTPWithKey(t *testing.T) {
+	t.Parallel()
+
+	const token = "dvgtiblfkbgturecfllberrvkinnctnn"
+
+	key := &common.Key{
+		PublicID:  "cccccccccccc",
+		PrivateID: "010203040506",
+		AESKey:    "vault:v1:wrapped",
+		Active:    true,
+	}
+
+	lookup := func(context.Context, string) (*common.Key, error) { return key, nil }
+
+	var raw []byte
+
+	aesKey := func(context.Context, *common.Key) ([]byte, error) {
+		raw = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+		return raw, nil
+	}
+
+	otp, err := common.DecryptOTPWithKey(context.Background(), zaptest.NewLogger(t), lookup, aesKey, key.PublicID, token)
+	require.NoError(t, err)
+	require.Equal(t, common.TestVectors[token].OTP, *otp)
+
+	// Raw AES key is wiped after the decryption
+	require.Equal(t, make([]byte, 16), raw)
+
+	failing := func(context.Context, *common.Key) ([]byte, error) { return nil, errors.New("transit is down") }
+	_, err = common.DecryptOTPWithKey(context.Background(), zaptest.NewLogger(t), lookup, failing, key.PublicID, token)
+	require.ErrorIs(t, err, common.ErrStorageBackend)
+}
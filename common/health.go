@@ -0,0 +1,19 @@
+package common
+
+import (
+	"context"
+	"errors"
+)
+
+// HealthChecker is implemented by modules, which depend on external resources
+// (databases, Vault) and can report whether they are able to serve requests.
+type HealthChecker interface {
+	// Name of the checked component.
+	Name() string
+
+	// CheckHealth returns nil when the component is healthy, or the reason why it is not.
+	CheckHealth(ctx context.Context) error
+}
+
+// ErrNotConnected indicates that the storage connection is not established yet.
+var ErrNotConnected = errors.New("storage is not connected")
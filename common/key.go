@@ -0,0 +1,121 @@
+package common
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/archaron/go-yubiserv/misc"
+)
+
+var (
+	ErrKeyInvalidPublicID  = errors.New("public ID must be 12 modhex characters")
+	ErrKeyInvalidPrivateID = errors.New("private ID must be 6 hex-encoded bytes")
+	ErrKeyInvalidAESKey    = errors.New("AES key must be 16 hex-encoded bytes")
+	ErrKeyInvalidLockCode  = errors.New("lock code must be 6 hex-encoded bytes")
+)
+
+// Key represents a YubiKey record in the keystore.
+// It contains all necessary fields for OTP validation and key management.
+//
+// The struct tags follow database column naming conventions for ORM mapping.
+type Key struct {
+	ID        uint64 `db:"id"         json:"id"`         // Unique database identifier
+	PublicID  string `db:"public_id"  json:"public_id"`  // Public identity (12-byte modhex string)
+	Created   string `db:"created"    json:"created"`    // Creation timestamp in ISO8601 format
+	PrivateID string `db:"private_id" json:"private_id"` // Private identity (6-byte hex string)
+	AESKey    string `db:"aes_key"    json:"aes_key"`    // AES-128 key (32-byte hex string)
+	LockCode  string `db:"lock_code"  json:"lock_code"`  // Lock/unlock code (optional)
+	Active    bool   `db:"active"     json:"active"`     // Activation status
+
+	Owner       string `db:"-" json:"owner,omitempty"`       // Key holder (Vault and bolt key stores)
+	Description string `db:"-" json:"description,omitempty"` // Free-form note (Vault and bolt key stores)
+}
+
+// KeyInfo is a Key record without secrets, used in key listings.
+type KeyInfo struct {
+	ID          uint64 `json:"id"`
+	PublicID    string `json:"public_id"`
+	Created     string `json:"created"`
+	Active      bool   `json:"active"`
+	Owner       string `json:"owner,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Info returns the key record without secrets.
+func (k *Key) Info() KeyInfo {
+	return KeyInfo{ID: k.ID, PublicID: k.PublicID, Created: k.Created, Active: k.Active, Owner: k.Owner, Description: k.Description}
+}
+
+// String implements fmt.Stringer interface for pretty-printing Key records.
+// The output format is optimized for logging and debugging purposes.
+//
+// Example output:
+// YubiKey[ID:000000000001 Pub:vveirvt... Priv:abc123 AES:0123... Active:true]
+func (k *Key) String() string {
+	return fmt.Sprintf("YubiKey[ID:%012x Pub:%.6s... Priv:%.6s AES:%.6s... Active:%t]",
+		k.ID,
+		k.PublicID,
+		k.PrivateID,
+		k.AESKey,
+		k.Active,
+	)
+}
+
+// IsValidPublicID checks that publicID is a 12 characters modhex string.
+func IsValidPublicID(publicID string) bool {
+	return len(publicID) == PublicIDLength && misc.IsModHex(publicID)
+}
+
+// Validate checks the key identifiers and secrets format.
+func (k *Key) Validate() error {
+	if !IsValidPublicID(k.PublicID) {
+		return fmt.Errorf("%s: %w", k.PublicID, ErrKeyInvalidPublicID)
+	}
+
+	if !isHexBytes(k.PrivateID, PrivateIDSize) {
+		return ErrKeyInvalidPrivateID
+	}
+
+	if !isHexBytes(k.AESKey, aes.BlockSize) {
+		return ErrKeyInvalidAESKey
+	}
+
+	if !isHexBytes(k.LockCode, LockPWSize) {
+		return ErrKeyInvalidLockCode
+	}
+
+	return nil
+}
+
+// GenerateSecrets fills empty private ID, AES key and lock code with random values.
+func (k *Key) GenerateSecrets() error {
+	var err error
+
+	if k.PrivateID == "" {
+		if k.PrivateID, err = misc.HexRand(PrivateIDSize); err != nil {
+			return fmt.Errorf("error generating random private ID: %w", err)
+		}
+	}
+
+	if k.AESKey == "" {
+		if k.AESKey, err = misc.HexRand(aes.BlockSize); err != nil {
+			return fmt.Errorf("error generating random AES key: %w", err)
+		}
+	}
+
+	if k.LockCode == "" {
+		if k.LockCode, err = misc.HexRand(LockPWSize); err != nil {
+			return fmt.Errorf("error generating random lock code: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func isHexBytes(s string, size int) bool {
+	b, err := hex.DecodeString(s)
+
+	return err == nil && len(b) == size
+}
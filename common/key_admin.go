@@ -0,0 +1,37 @@
+package common
+
+import "context"
+
+// KeyAdmin defines key management operations shared by the keystores.
+// It is used by administrative tools, which work with the keystore without starting the service.
+type KeyAdmin interface {
+	// Connect opens the keystore for administrative use.
+	Connect(ctx context.Context) error
+
+	// Close releases keystore resources.
+	Close() error
+
+	// GetKey returns the key with the given public ID.
+	GetKey(ctx context.Context, publicID string) (*Key, error)
+
+	// ListKeys returns all stored keys. Secrets may be returned as stored, e.g. AES keys wrapped
+	// by Vault Transit, GetKey returns them usable.
+	ListKeys() ([]*Key, error)
+
+	// StoreKey creates or replaces the key.
+	StoreKey(k *Key) error
+
+	// StoreKeys adds a batch of new keys in one transaction,
+	// returns ErrStorageKeyExists and stores nothing if any of them is already present.
+	StoreKeys(keys []*Key) error
+
+	// DeleteKey removes the key with the given public ID,
+	// returns ErrStorageNoKey if there is no such key.
+	DeleteKey(publicID string) error
+}
+
+// KeyMetadataKeeper is implemented by keystores, which keep owner and description of keys.
+type KeyMetadataKeeper interface {
+	// KeepsKeyMetadata reports that owner and description are stored with keys.
+	KeepsKeyMetadata() bool
+}
@@ -1,9 +1,11 @@
-package sqlitestorage
+package common_test
 
 import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/archaron/go-yubiserv/common"
 )
 
 func TestKeyStruct(t *testing.T) {
@@ -12,7 +14,7 @@ func TestKeyStruct(t *testing.T) {
 	t.Run("struct fields and tags", func(t *testing.T) {
 		t.Parallel()
 
-		key := Key{
+		key := common.Key{
 			ID:        123456789012,
 			PublicID:  "cccccccccccc",
 			Created:   "2023-01-01T00:00:00Z",
@@ -37,12 +39,12 @@ func TestKeyStruct(t *testing.T) {
 
 		testCases := []struct {
 			name     string
-			key      Key
+			key      common.Key
 			expected string
 		}{
 			{
 				name: "standard key",
-				key: Key{
+				key: common.Key{
 					ID:        1,
 					PublicID:  "cccccccccccc",
 					PrivateID: "112233445566",
@@ -53,7 +55,7 @@ func TestKeyStruct(t *testing.T) {
 			},
 			{
 				name: "inactive key",
-				key: Key{
+				key: common.Key{
 					ID:        2,
 					PublicID:  "dddddddddddd",
 					PrivateID: "aabbccddeeff",
@@ -64,7 +66,7 @@ func TestKeyStruct(t *testing.T) {
 			},
 			{
 				name: "empty values",
-				key: Key{
+				key: common.Key{
 					ID:        0,
 					PublicID:  "",
 					PrivateID: "",
@@ -86,7 +88,7 @@ func TestKeyStruct(t *testing.T) {
 	t.Run("string truncation", func(t *testing.T) {
 		t.Parallel()
 
-		key := Key{
+		key := common.Key{
 			ID:        1,
 			PublicID:  "aabbccddeeff",                     // Exactly 12 chars
 			PrivateID: "112233445566",                     // Exactly 12 chars
@@ -100,3 +102,49 @@ func TestKeyStruct(t *testing.T) {
 		require.Contains(t, output, "AES:001122...") // First 6 chars of AESKey
 	})
 }
+
+func TestKeyValidate(t *testing.T) {
+	t.Parallel()
+
+	validKey := func() *common.Key {
+		return &common.Key{
+			PublicID:  "vvcccccccccc",
+			PrivateID: "0102030405ab",
+			AESKey:    "0102030405060708090a0b0c0d0e0f10",
+			LockCode:  "010203040506",
+		}
+	}
+
+	require.NoError(t, validKey().Validate())
+
+	tests := []struct {
+		name   string
+		modify func(k *common.Key)
+		err    error
+	}{
+		{name: "short public ID", modify: func(k *common.Key) { k.PublicID = "vvcc" }, err: common.ErrKeyInvalidPublicID},
+		{name: "non-modhex public ID", modify: func(k *common.Key) { k.PublicID = "aaaaaaaaaaaa" }, err: common.ErrKeyInvalidPublicID},
+		{name: "bad private ID", modify: func(k *common.Key) { k.PrivateID = "zz" }, err: common.ErrKeyInvalidPrivateID},
+		{name: "short AES key", modify: func(k *common.Key) { k.AESKey = "0102" }, err: common.ErrKeyInvalidAESKey},
+		{name: "bad lock code", modify: func(k *common.Key) { k.LockCode = "01020304050607" }, err: common.ErrKeyInvalidLockCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			key := validKey()
+			tt.modify(key)
+			require.ErrorIs(t, key.Validate(), tt.err)
+		})
+	}
+}
+
+func TestKeyGenerateSecrets(t *testing.T) {
+	t.Parallel()
+
+	key := &common.Key{PublicID: "vvcccccccccc", LockCode: "010203040506"}
+	require.NoError(t, key.GenerateSecrets())
+	require.NoError(t, key.Validate())
+	require.Equal(t, "010203040506", key.LockCode)
+}
@@ -0,0 +1,9 @@
+package common
+
+// NonceCache tracks recently seen request nonces to detect replayed requests.
+// Implementations may be shared by several server instances.
+type NonceCache interface {
+	// Seen records the nonce of the given client and reports whether
+	// it was already seen within the cache time window.
+	Seen(clientID uint64, nonce string) (bool, error)
+}
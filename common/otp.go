@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"time"
 
 	"github.com/howeyc/crc16"
 	"github.com/pkg/errors"
@@ -15,6 +16,9 @@ import (
 // size of OTP record in bytes.
 const size = 16
 
+// TimestampTick is the resolution of the YubiKey timestamp counter, which runs at 8 Hz.
+const TimestampTick = time.Second / 8
+
 var (
 	// ErrInvalidLength indicates that the OTP token has an incorrect length.
 	// Valid YubiKey OTP must be not less than 16 bytes long (remaining bytes are ignored).
@@ -58,6 +62,21 @@ func (o *OTP) String() string {
 		"Random: %04x, CRC: %2x", o.PrivateID, o.UsageCounter, o.SessionCounter, o.TimestampCounter, o.Random, o.CRC)
 }
 
+// Timestamp returns the 24-bit timestamp counter value.
+func (o *OTP) Timestamp() uint32 {
+	return TimestampValue(o.TimestampCounter)
+}
+
+// TimestampValue converts a 3-byte timestamp counter to its numeric value.
+func TimestampValue(ts [3]byte) uint32 {
+	return uint32(ts[0])<<16 | uint32(ts[1])<<8 | uint32(ts[2]) //nolint:mnd
+}
+
+// TimestampBytes converts a numeric timestamp counter value to its 3-byte form.
+func TimestampBytes(v uint32) [3]byte {
+	return [3]byte{byte(v >> 16), byte(v >> 8), byte(v)} //nolint:mnd
+}
+
 // UnmarshalBinary unmarshalls OTP from binary bytes.
 func (o *OTP) UnmarshalBinary(data []byte) error {
 	if len(data) < size {
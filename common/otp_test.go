@@ -85,6 +85,15 @@ func TestOTP(t *testing.T) {
 		require.ErrorIs(t, err, aes.KeySizeError(7))
 	})
 
+	t.Run("timestamp conversion", func(t *testing.T) {
+		t.Parallel()
+
+		otp := &common.OTP{TimestampCounter: [3]byte{0x24, 0x13, 0xa7}}
+		require.Equal(t, uint32(0x2413a7), otp.Timestamp())
+		require.Equal(t, [3]byte{0x24, 0x13, 0xa7}, common.TimestampBytes(0x2413a7))
+		require.Equal(t, [3]byte{0xff, 0xff, 0xff}, common.TimestampBytes(0xffffffff))
+	})
+
 	t.Run("must error on bad OTP size", func(t *testing.T) {
 		t.Parallel()
 
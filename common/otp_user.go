@@ -1,5 +1,7 @@
 package common
 
+import "time"
+
 type (
 	// OTPUsers maintains a registry of YubiKey user sessions and counters.
 	// The map key represents the YubiKey public ID, while the value stores
@@ -14,9 +16,11 @@ type (
 	//   UsageCounter - Increments with each OTP generation (16-bit)
 	//   SessionCounter - Increments per user session (8-bit)
 	//   Timestamp - Last token timestamp (3-byte binary format)
+	//   Seen - Wall-clock time when the last token was accepted
 	OTPUser struct {
-		UsageCounter   uint16
-		SessionCounter uint8
-		Timestamp      [3]byte
+		UsageCounter   uint16    `json:"usage_counter"`
+		SessionCounter uint8     `json:"session_counter"`
+		Timestamp      [3]byte   `json:"timestamp"`
+		Seen           time.Time `json:"seen"`
 	}
 )
@@ -1,6 +1,9 @@
 package common
 
-import "errors"
+import (
+	"context"
+	"errors"
+)
 
 // StorageInterface defines the interface for YubiKey OTP storage implementations.
 // Implementations must provide methods for OTP decryption and key management.
@@ -10,13 +13,36 @@ type StorageInterface interface {
 	// if decryption fails or the public ID is not found.
 	//
 	// Parameters:
+	//   ctx      - Request context, bounds the key lookup in the backend
 	//   publicID - The YubiKey public identifier (first 12 characters of OTP)
 	//   token    - Full OTP token to decrypt
 	//
 	// Returns:
 	//   *OTP - Decrypted OTP structure on success
 	//   error - Decryption error or key not found error
-	DecryptOTP(publicID, token string) (*OTP, error)
+	//
+	// Errors are classified with the ErrStorage* sentinels, so that the caller
+	// could tell a rejected OTP from a failing storage:
+	//   ErrStorageNoKey       - public ID is unknown
+	//   ErrStorageKeyInactive - key is disabled
+	//   ErrStorageDecryptFail - OTP cannot be decrypted with the key, wraps ErrInvalidCRC on CRC mismatch
+	//   ErrStorageBackend     - storage cannot be reached or has failed, the request may be retried
+	DecryptOTP(ctx context.Context, publicID, token string) (*OTP, error)
+}
+
+// KeyCacheStats is implemented by key storages, which cache keys.
+type KeyCacheStats interface {
+	// CachedKeys returns the number of cached keys.
+	CachedKeys() int
+}
+
+// KeyCache is implemented by key lookup caches.
+type KeyCache interface {
+	KeyCacheStats
+
+	// PurgeKeys drops cached entries of the given public IDs, or all entries if none given,
+	// and returns the number of dropped entries.
+	PurgeKeys(publicIDs ...string) int
 }
 
 var (
@@ -24,6 +50,10 @@ var (
 	// was not found in the key storage.
 	ErrStorageNoKey = errors.New("client key not found")
 
+	// ErrStorageKeyExists indicates that a key with the same public ID
+	// is already present in the key storage.
+	ErrStorageKeyExists = errors.New("client key already exists")
+
 	// ErrStorageKeyInactive indicates that the YubiKey exists in storage
 	// but is marked as inactive/disabled for authentication.
 	ErrStorageKeyInactive = errors.New("client key is not active")
@@ -34,4 +64,12 @@ var (
 	// - Corrupted or malformed OTP token
 	// - Cryptographic verification failure
 	ErrStorageDecryptFail = errors.New("otp request decryption failed")
+
+	// ErrStorageBackend indicates a transient failure of the key storage backend,
+	// e.g. database I/O error or Vault being unreachable. Unlike other storage
+	// errors it tells nothing about the OTP itself.
+	ErrStorageBackend = errors.New("key storage backend failure")
+
+	// ErrStorageReadOnly indicates that the key storage does not support changing keys.
+	ErrStorageReadOnly = errors.New("key storage is read-only")
 )
@@ -0,0 +1,91 @@
+// Package envelope implements envelope encryption of key secrets at rest: every record is sealed
+// with its own data encryption key (DEK), and the DEK is stored wrapped by the key encryption key (KEK).
+// Rotating the KEK re-wraps the DEKs only, the sealed secrets are left untouched.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// dekSize is the size of AES-256 data encryption key.
+const dekSize = 32
+
+var (
+	ErrUnknownKEK = errors.New("unknown KEK source, expected file:<path>, env:<name> or transit:<key>")
+	ErrInvalidKEK = errors.New("KEK must be 32 bytes, hex or base64 encoded")
+	ErrOpen       = errors.New("cannot open sealed value")
+)
+
+// NewDEK generates a random data encryption key.
+func NewDEK() ([]byte, error) {
+	dek := make([]byte, dekSize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, fmt.Errorf("cannot generate DEK: %w", err)
+	}
+
+	return dek, nil
+}
+
+// Seal encrypts the value with AES-256-GCM, aad binds the ciphertext to its record and field,
+// so that sealed values cannot be swapped between rows or columns.
+func Seal(key []byte, value, aad string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("cannot generate nonce: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(value), []byte(aad))), nil
+}
+
+// Open decrypts the value sealed with Seal using the same key and aad.
+func Open(key []byte, sealed, aad string) (string, error) {
+	data, err := openBytes(key, sealed, aad)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+func openBytes(key []byte, sealed, aad string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return nil, ErrOpen
+	}
+
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(aad))
+	if err != nil {
+		return nil, ErrOpen
+	}
+
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create GCM: %w", err)
+	}
+
+	return gcm, nil
+}
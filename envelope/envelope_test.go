@@ -0,0 +1,207 @@
+package envelope_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/archaron/go-yubiserv/envelope"
+)
+
+const testKEK = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func TestSeal(t *testing.T) {
+	t.Parallel()
+
+	dek, err := envelope.NewDEK()
+	require.NoError(t, err)
+
+	sealed, err := envelope.Seal(dek, "0102030405060708090a0b0c0d0e0f10", "vvcccccccccc/aes_key")
+	require.NoError(t, err)
+	require.NotContains(t, sealed, "0102030405060708090a0b0c0d0e0f10")
+
+	value, err := envelope.Open(dek, sealed, "vvcccccccccc/aes_key")
+	require.NoError(t, err)
+	require.Equal(t, "0102030405060708090a0b0c0d0e0f10", value)
+
+	t.Run("bound to aad", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := envelope.Open(dek, sealed, "vvcccccccccb/aes_key")
+		require.ErrorIs(t, err, envelope.ErrOpen)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		t.Parallel()
+
+		other, err := envelope.NewDEK()
+		require.NoError(t, err)
+
+		_, err = envelope.Open(other, sealed, "vvcccccccccc/aes_key")
+		require.ErrorIs(t, err, envelope.ErrOpen)
+	})
+
+	t.Run("garbage", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := envelope.Open(dek, "not base64!", "vvcccccccccc/aes_key")
+		require.ErrorIs(t, err, envelope.ErrOpen)
+	})
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	raw, err := hex.DecodeString(testKEK)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+
+	for name, content := range map[string][]byte{
+		"raw": raw,
+		"hex": []byte(testKEK + "\n"),
+		"b64": []byte(base64.StdEncoding.EncodeToString(raw)),
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), content, 0o600))
+	}
+
+	expected, err := envelope.NewLocalKEK(raw)
+	require.NoError(t, err)
+
+	for _, name := range []string{"raw", "hex", "b64"} {
+		t.Run("file "+name, func(t *testing.T) {
+			t.Parallel()
+
+			kek, err := envelope.Load("file:"+filepath.Join(dir, name), envelope.TransitOptions{})
+			require.NoError(t, err)
+			require.Equal(t, expected.ID(), kek.ID())
+		})
+	}
+
+	for _, spec := range []string{"", "file", "plain:key", "transit:"} {
+		t.Run("unknown "+spec, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := envelope.Load(spec, envelope.TransitOptions{})
+			require.ErrorIs(t, err, envelope.ErrUnknownKEK)
+		})
+	}
+
+	t.Run("short key", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "short")
+		require.NoError(t, os.WriteFile(path, []byte("0102"), 0o600))
+
+		_, err := envelope.Load("file:"+path, envelope.TransitOptions{})
+		require.ErrorIs(t, err, envelope.ErrInvalidKEK)
+	})
+}
+
+func TestLoadEnv(t *testing.T) { //nolint:paralleltest
+	t.Setenv("YUBISERV_TEST_KEK", testKEK)
+
+	kek, err := envelope.Load("env:YUBISERV_TEST_KEK", envelope.TransitOptions{})
+	require.NoError(t, err)
+
+	raw, err := hex.DecodeString(testKEK)
+	require.NoError(t, err)
+
+	expected, err := envelope.NewLocalKEK(raw)
+	require.NoError(t, err)
+	require.Equal(t, expected.ID(), kek.ID())
+
+	_, err = envelope.Load("env:YUBISERV_TEST_KEK_UNSET", envelope.TransitOptions{})
+	require.ErrorIs(t, err, envelope.ErrInvalidKEK)
+}
+
+func TestLocalKEK(t *testing.T) {
+	t.Parallel()
+
+	raw, err := hex.DecodeString(testKEK)
+	require.NoError(t, err)
+
+	kek, err := envelope.NewLocalKEK(raw)
+	require.NoError(t, err)
+
+	dek, err := envelope.NewDEK()
+	require.NoError(t, err)
+
+	wrapped, err := kek.Wrap(context.Background(), dek)
+	require.NoError(t, err)
+
+	unwrapped, err := kek.Unwrap(context.Background(), wrapped)
+	require.NoError(t, err)
+	require.Equal(t, dek, unwrapped)
+
+	other, err := envelope.NewLocalKEK(make([]byte, 32))
+	require.NoError(t, err)
+	require.NotEqual(t, kek.ID(), other.ID())
+
+	_, err = other.Unwrap(context.Background(), wrapped)
+	require.ErrorIs(t, err, envelope.ErrOpen)
+}
+
+func TestTransitKEK(t *testing.T) {
+	t.Parallel()
+
+	// Vault Transit stand-in "encrypts" by prefixing the plaintext
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+
+			return
+		}
+
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/v1/transit-kek/encrypt/yubiserv":
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{"ciphertext": "vault:v1:" + req["plaintext"]}})
+		case "/v1/transit-kek/decrypt/yubiserv":
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{"plaintext": strings.TrimPrefix(req["ciphertext"], "vault:v1:")}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	kek, err := envelope.Load("transit:yubiserv", envelope.TransitOptions{Address: srv.URL, Mount: "/transit-kek/", Token: "test-token"})
+	require.NoError(t, err)
+	require.Equal(t, "transit:transit-kek/yubiserv", kek.ID())
+
+	dek, err := envelope.NewDEK()
+	require.NoError(t, err)
+
+	wrapped, err := kek.Wrap(context.Background(), dek)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(wrapped, "vault:v1:"))
+
+	unwrapped, err := kek.Unwrap(context.Background(), wrapped)
+	require.NoError(t, err)
+	require.Equal(t, dek, unwrapped)
+
+	denied, err := envelope.Load("transit:yubiserv", envelope.TransitOptions{Address: srv.URL, Mount: "transit-kek", Token: "wrong"})
+	require.NoError(t, err)
+
+	_, err = denied.Wrap(context.Background(), dek)
+	require.Error(t, err)
+}
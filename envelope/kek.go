@@ -0,0 +1,207 @@
+package envelope
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// dekAAD binds wrapped DEKs to their purpose.
+const dekAAD = "yubiserv-dek"
+
+type (
+	// KEK wraps and unwraps data encryption keys.
+	KEK interface {
+		// ID identifies the KEK, it is stored along with the wrapped DEKs.
+		ID() string
+
+		// Wrap encrypts the DEK.
+		Wrap(ctx context.Context, dek []byte) (string, error)
+
+		// Unwrap decrypts the DEK wrapped by Wrap.
+		Unwrap(ctx context.Context, wrapped string) ([]byte, error)
+	}
+
+	// TransitOptions of Vault Transit KEK.
+	TransitOptions struct {
+		// Address of Vault server, VAULT_ADDR is used if empty.
+		Address string
+		// Mount path of the transit secrets engine.
+		Mount string
+		// Token for Vault, VAULT_TOKEN is used if empty.
+		Token string
+	}
+
+	localKEK struct {
+		id  string
+		key []byte
+	}
+
+	transitKEK struct {
+		client *vault.Client
+		mount  string
+		name   string
+	}
+)
+
+// Load returns KEK by its spec:
+//   - file:<path> - 32 bytes key, raw or hex/base64 encoded, read from the file;
+//   - env:<name> - hex/base64 encoded 32 bytes key from the environment variable;
+//   - transit:<key> - named key of Vault Transit secrets engine, KEK never leaves Vault.
+func Load(spec string, transit TransitOptions) (KEK, error) {
+	source, arg, _ := strings.Cut(spec, ":")
+	if arg == "" {
+		return nil, fmt.Errorf("%q: %w", spec, ErrUnknownKEK)
+	}
+
+	switch source {
+	case "file":
+		raw, err := os.ReadFile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read KEK file: %w", err)
+		}
+
+		if len(raw) == dekSize {
+			return NewLocalKEK(raw)
+		}
+
+		return parseLocalKEK(string(raw))
+	case "env":
+		value, ok := os.LookupEnv(arg)
+		if !ok {
+			return nil, fmt.Errorf("KEK environment variable %s is not set: %w", arg, ErrInvalidKEK)
+		}
+
+		return parseLocalKEK(value)
+	case "transit":
+		return newTransitKEK(transit, arg)
+	default:
+		return nil, fmt.Errorf("%q: %w", spec, ErrUnknownKEK)
+	}
+}
+
+// NewLocalKEK creates KEK from 32 bytes key held in memory.
+func NewLocalKEK(key []byte) (KEK, error) {
+	if len(key) != dekSize {
+		return nil, ErrInvalidKEK
+	}
+
+	fingerprint := sha256.Sum256(key)
+
+	return &localKEK{
+		id:  "local:" + hex.EncodeToString(fingerprint[:4]),
+		key: append([]byte(nil), key...),
+	}, nil
+}
+
+func parseLocalKEK(encoded string) (KEK, error) {
+	encoded = strings.TrimSpace(encoded)
+
+	if key, err := hex.DecodeString(encoded); err == nil {
+		return NewLocalKEK(key)
+	}
+
+	if key, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+		return NewLocalKEK(key)
+	}
+
+	return nil, ErrInvalidKEK
+}
+
+// ID of the local KEK is its fingerprint, so that a wrong key is detected before unwrapping.
+func (k *localKEK) ID() string {
+	return k.id
+}
+
+func (k *localKEK) Wrap(_ context.Context, dek []byte) (string, error) {
+	return Seal(k.key, string(dek), dekAAD)
+}
+
+func (k *localKEK) Unwrap(_ context.Context, wrapped string) ([]byte, error) {
+	return openBytes(k.key, wrapped, dekAAD)
+}
+
+func newTransitKEK(opts TransitOptions, name string) (KEK, error) {
+	config := vault.DefaultConfig()
+	if opts.Address != "" {
+		config.Address = opts.Address
+	}
+
+	client, err := vault.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create vault client: %w", err)
+	}
+
+	if opts.Token != "" {
+		client.SetToken(opts.Token)
+	}
+
+	return NewTransitKEK(client, opts.Mount, name), nil
+}
+
+// NewTransitKEK creates KEK backed by the named key of Vault Transit secrets engine mounted at mount,
+// requests are made with the client, so that it shares the client's token.
+func NewTransitKEK(client *vault.Client, mount, name string) KEK {
+	if mount = strings.Trim(mount, "/"); mount == "" {
+		mount = "transit"
+	}
+
+	return &transitKEK{client: client, mount: mount, name: name}
+}
+
+func (k *transitKEK) ID() string {
+	return "transit:" + k.mount + "/" + k.name
+}
+
+func (k *transitKEK) Wrap(ctx context.Context, dek []byte) (string, error) {
+	secret, err := k.client.Logical().WriteWithContext(ctx, k.mount+"/encrypt/"+k.name, map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(dek),
+	})
+	if err != nil {
+		return "", fmt.Errorf("vault transit encrypt: %w", err)
+	}
+
+	ciphertext, ok := transitField(secret, "ciphertext")
+	if !ok {
+		return "", fmt.Errorf("vault transit encrypt: %w", ErrOpen)
+	}
+
+	return ciphertext, nil
+}
+
+func (k *transitKEK) Unwrap(ctx context.Context, wrapped string) ([]byte, error) {
+	secret, err := k.client.Logical().WriteWithContext(ctx, k.mount+"/decrypt/"+k.name, map[string]interface{}{
+		"ciphertext": wrapped,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("vault transit decrypt: %w", err)
+	}
+
+	plaintext, ok := transitField(secret, "plaintext")
+	if !ok {
+		return nil, fmt.Errorf("vault transit decrypt: %w", ErrOpen)
+	}
+
+	dek, err := base64.StdEncoding.DecodeString(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("vault transit decrypt: %w", ErrOpen)
+	}
+
+	return dek, nil
+}
+
+func transitField(secret *vault.Secret, name string) (string, bool) {
+	if secret == nil {
+		return "", false
+	}
+
+	value, ok := secret.Data[name].(string)
+
+	return value, ok && value != ""
+}
@@ -0,0 +1,314 @@
+// Package keycache implements in-process cache of keys in front of a key storage.
+//
+// Secrets of cached keys are kept in memory locked into RAM, unknown public IDs are cached
+// negatively, and expired keys are still served for a while when the key storage fails.
+package keycache
+
+import (
+	"container/list"
+	"context"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+type (
+	// Getter fetches the key from the key storage.
+	Getter func(ctx context.Context, publicID string) (*common.Key, error)
+
+	// Options of the cache.
+	Options struct {
+		// TTL of a cached key, zero disables the cache.
+		TTL time.Duration
+		// NegativeTTL of an unknown public ID, zero disables negative caching.
+		NegativeTTL time.Duration
+		// StaleTTL is how long after expiration the entry is served when the key storage fails.
+		StaleTTL time.Duration
+		// Size limits the number of cached entries, least recently used ones are evicted first.
+		Size int
+		// Wrapped reports AES keys wrapped by a KEK, they are not secret and are cached as is, outside the locked memory.
+		Wrapped func(aesKey string) bool
+	}
+
+	// Cache of keys in front of a key storage, safe for concurrent use.
+	Cache struct {
+		log  *zap.Logger
+		get  Getter
+		opts Options
+		now  func() time.Time
+
+		mu      sync.Mutex
+		entries map[string]*list.Element
+		lru     *list.List
+		keys    int
+		pool    lockedPool
+	}
+
+	entry struct {
+		publicID string
+		expires  time.Time
+
+		// Key without secrets, nil for an unknown public ID
+		key *common.Key
+		// Secrets of the key in locked memory
+		secret []byte
+	}
+)
+
+// FromConfig reads cache options from the keycache section of the config.
+func FromConfig(v *viper.Viper) Options {
+	return Options{
+		TTL:         v.GetDuration("keycache.ttl"),
+		NegativeTTL: v.GetDuration("keycache.negative_ttl"),
+		StaleTTL:    v.GetDuration("keycache.stale_ttl"),
+		Size:        v.GetInt("keycache.size"),
+	}
+}
+
+// New creates the cache in front of the key getter.
+func New(log *zap.Logger, get Getter, opts Options) *Cache {
+	return &Cache{
+		log:     log,
+		get:     get,
+		opts:    opts,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		pool: lockedPool{
+			onLockFail: func(err error) {
+				log.Warn("cannot lock key cache memory, secrets may be swapped to disk", zap.Error(err))
+			},
+		},
+	}
+}
+
+// GetKey returns the key from the cache or fetches it from the key storage.
+// If the key storage fails, the expired entry is returned until its stale period ends.
+func (c *Cache) GetKey(ctx context.Context, publicID string) (*common.Key, error) {
+	now := c.now()
+
+	c.mu.Lock()
+	if e := c.lookup(publicID); e != nil && now.Before(e.expires) {
+		defer c.mu.Unlock()
+
+		return c.load(e)
+	}
+	c.mu.Unlock()
+
+	key, err := c.get(ctx, publicID)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case err == nil:
+		c.store(publicID, key, now.Add(c.opts.TTL))
+
+		return key, nil
+	case errors.Is(err, common.ErrStorageNoKey):
+		if c.opts.NegativeTTL > 0 {
+			c.store(publicID, nil, now.Add(c.opts.NegativeTTL))
+		}
+
+		return nil, err
+	}
+
+	if e := c.lookup(publicID); e != nil && now.Before(e.expires.Add(c.opts.StaleTTL)) {
+		c.log.Warn("key storage failed, serving stale cached key", zap.String("public_id", publicID), zap.Error(err))
+
+		return c.load(e)
+	}
+
+	return nil, err
+}
+
+// CachedKeys returns the number of cached keys, unknown public IDs are not counted.
+func (c *Cache) CachedKeys() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.keys
+}
+
+// PurgeKeys drops cached entries of the given public IDs, or all entries if none given,
+// and returns the number of dropped entries.
+func (c *Cache) PurgeKeys(publicIDs ...string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(publicIDs) == 0 {
+		purged := c.lru.Len()
+		for c.lru.Len() > 0 {
+			c.remove(c.lru.Front())
+		}
+
+		return purged
+	}
+
+	purged := 0
+
+	for _, publicID := range publicIDs {
+		if elem, ok := c.entries[publicID]; ok {
+			c.remove(elem)
+			purged++
+		}
+	}
+
+	return purged
+}
+
+// Close drops all entries and releases the locked memory.
+func (c *Cache) Close() {
+	c.PurgeKeys()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pool.release()
+}
+
+// lookup returns the entry and marks it as recently used, entries past their stale period are dropped.
+func (c *Cache) lookup(publicID string) *entry {
+	elem, ok := c.entries[publicID]
+	if !ok {
+		return nil
+	}
+
+	e := elem.Value.(*entry) //nolint:forcetypeassert
+	if !c.now().Before(e.expires.Add(c.opts.StaleTTL)) {
+		c.remove(elem)
+
+		return nil
+	}
+
+	c.lru.MoveToFront(elem)
+
+	return e
+}
+
+// load restores the key from the entry, secrets are copied out of the locked memory.
+func (c *Cache) load(e *entry) (*common.Key, error) {
+	if e.key == nil {
+		return nil, common.ErrStorageNoKey
+	}
+
+	key := *e.key
+	secrets := unpackSecrets(e.secret)
+	key.PrivateID, key.LockCode = secrets[1], secrets[2]
+
+	if key.AESKey == "" {
+		key.AESKey = secrets[0]
+	}
+
+	return &key, nil
+}
+
+// store replaces the entry of the public ID, key is nil for an unknown public ID.
+func (c *Cache) store(publicID string, key *common.Key, expires time.Time) {
+	if elem, ok := c.entries[publicID]; ok {
+		c.remove(elem)
+	}
+
+	e := &entry{publicID: publicID, expires: expires}
+
+	if key != nil {
+		slot, err := c.pool.get()
+		if err != nil {
+			c.log.Error("cannot allocate key cache memory", zap.Error(err))
+
+			return
+		}
+
+		stripped := *key
+		stripped.PrivateID, stripped.LockCode = "", ""
+
+		aesKey := key.AESKey
+		if c.opts.Wrapped == nil || !c.opts.Wrapped(aesKey) {
+			stripped.AESKey = ""
+		} else {
+			aesKey = ""
+		}
+
+		if !packSecrets(slot, aesKey, key.PrivateID, key.LockCode) {
+			// Malformed key is not cached, so that it is fetched again after being fixed
+			c.pool.put(slot)
+
+			return
+		}
+
+		e.key, e.secret = &stripped, slot
+		c.keys++
+	}
+
+	c.entries[publicID] = c.lru.PushFront(e)
+
+	for c.opts.Size > 0 && c.lru.Len() > c.opts.Size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops the entry and wipes its secrets.
+func (c *Cache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*entry) //nolint:forcetypeassert
+	delete(c.entries, e.publicID)
+
+	if e.key != nil {
+		c.pool.put(e.secret)
+		c.keys--
+	}
+}
+
+// packSecrets writes hex-encoded secrets into the slot as length-prefixed byte strings.
+// Returns false if any of the secrets is not hex-encoded or they do not fit.
+func packSecrets(slot []byte, secrets ...string) bool {
+	offset := 0
+
+	for _, secret := range secrets {
+		n := hex.DecodedLen(len(secret))
+		if offset+1+n > len(slot) {
+			return false
+		}
+
+		slot[offset] = byte(n)
+
+		if _, err := hex.Decode(slot[offset+1:offset+1+n], []byte(secret)); err != nil {
+			return false
+		}
+
+		offset += 1 + n
+	}
+
+	return true
+}
+
+// unpackSecrets reads hex-encoded secrets written by packSecrets.
+func unpackSecrets(slot []byte) [3]string {
+	var secrets [3]string
+
+	offset := 0
+
+	for i := range secrets {
+		n := int(slot[offset])
+		secrets[i] = hex.EncodeToString(slot[offset+1 : offset+1+n])
+		offset += 1 + n
+	}
+
+	return secrets
+}
+
+// Defaults for the key cache.
+func Defaults(ctx *cli.Context, v *viper.Viper) error {
+	v.SetDefault("keycache.ttl", ctx.Duration("keycache-ttl"))
+	v.SetDefault("keycache.negative_ttl", ctx.Duration("keycache-negative-ttl"))
+	v.SetDefault("keycache.stale_ttl", ctx.Duration("keycache-stale-ttl"))
+	v.SetDefault("keycache.size", ctx.Int("keycache-size"))
+
+	return nil
+}
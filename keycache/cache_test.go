@@ -0,0 +1,304 @@
+package keycache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+var errBackend = errors.New("backend is down")
+
+type testStorage struct {
+	sync.Mutex
+
+	keys  map[string]*common.Key
+	err   error
+	calls int
+}
+
+func (s *testStorage) GetKey(_ context.Context, publicID string) (*common.Key, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.calls++
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	key, ok := s.keys[publicID]
+	if !ok {
+		return nil, common.ErrStorageNoKey
+	}
+
+	k := *key
+
+	return &k, nil
+}
+
+func (s *testStorage) fail(err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.err = err
+}
+
+func testKey() *common.Key {
+	return &common.Key{
+		ID:        1,
+		PublicID:  "vvcccccccccc",
+		Created:   "2024-01-01T00:00:00Z",
+		PrivateID: "0102030405ab",
+		AESKey:    "0102030405060708090a0b0c0d0e0f10",
+		LockCode:  "010203040506",
+		Active:    true,
+	}
+}
+
+type testClock struct {
+	sync.Mutex
+
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func newTestCache(t *testing.T, opts Options) (*Cache, *testStorage, *testClock) {
+	t.Helper()
+
+	storage := &testStorage{keys: map[string]*common.Key{"vvcccccccccc": testKey()}}
+	clock := &testClock{now: time.Now()}
+
+	cache := New(zaptest.NewLogger(t), storage.GetKey, opts)
+	cache.now = clock.Now
+
+	t.Cleanup(cache.Close)
+
+	return cache, storage, clock
+}
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+
+	opts := Options{TTL: time.Minute, NegativeTTL: 10 * time.Second, StaleTTL: time.Hour, Size: 10}
+	ctx := context.Background()
+
+	t.Run("should cache key with its secrets", func(t *testing.T) {
+		t.Parallel()
+
+		cache, storage, _ := newTestCache(t, opts)
+
+		for range 3 {
+			key, err := cache.GetKey(ctx, "vvcccccccccc")
+			require.NoError(t, err)
+			require.Equal(t, testKey(), key)
+		}
+
+		require.Equal(t, 1, storage.calls)
+		require.Equal(t, 1, cache.CachedKeys())
+	})
+
+	t.Run("should refetch expired key", func(t *testing.T) {
+		t.Parallel()
+
+		cache, storage, clock := newTestCache(t, opts)
+
+		_, err := cache.GetKey(ctx, "vvcccccccccc")
+		require.NoError(t, err)
+
+		clock.Add(opts.TTL)
+
+		_, err = cache.GetKey(ctx, "vvcccccccccc")
+		require.NoError(t, err)
+		require.Equal(t, 2, storage.calls)
+	})
+
+	t.Run("should cache unknown public ID", func(t *testing.T) {
+		t.Parallel()
+
+		cache, storage, clock := newTestCache(t, opts)
+
+		for range 2 {
+			_, err := cache.GetKey(ctx, "vvcccccccccb")
+			require.ErrorIs(t, err, common.ErrStorageNoKey)
+		}
+
+		require.Equal(t, 1, storage.calls)
+		require.Zero(t, cache.CachedKeys())
+
+		clock.Add(opts.NegativeTTL)
+
+		_, err := cache.GetKey(ctx, "vvcccccccccb")
+		require.ErrorIs(t, err, common.ErrStorageNoKey)
+		require.Equal(t, 2, storage.calls)
+	})
+
+	t.Run("should serve stale key while storage fails", func(t *testing.T) {
+		t.Parallel()
+
+		cache, storage, clock := newTestCache(t, opts)
+
+		_, err := cache.GetKey(ctx, "vvcccccccccc")
+		require.NoError(t, err)
+
+		storage.fail(errBackend)
+		clock.Add(opts.TTL + opts.StaleTTL/2)
+
+		key, err := cache.GetKey(ctx, "vvcccccccccc")
+		require.NoError(t, err)
+		require.Equal(t, testKey(), key)
+
+		clock.Add(opts.StaleTTL / 2)
+
+		_, err = cache.GetKey(ctx, "vvcccccccccc")
+		require.ErrorIs(t, err, errBackend)
+		require.Zero(t, cache.CachedKeys())
+	})
+
+	t.Run("should not serve stale key when storage works", func(t *testing.T) {
+		t.Parallel()
+
+		cache, storage, clock := newTestCache(t, opts)
+
+		_, err := cache.GetKey(ctx, "vvcccccccccc")
+		require.NoError(t, err)
+
+		storage.Lock()
+		delete(storage.keys, "vvcccccccccc")
+		storage.Unlock()
+
+		clock.Add(opts.TTL)
+
+		_, err = cache.GetKey(ctx, "vvcccccccccc")
+		require.ErrorIs(t, err, common.ErrStorageNoKey)
+	})
+
+	t.Run("should purge entries", func(t *testing.T) {
+		t.Parallel()
+
+		cache, storage, _ := newTestCache(t, opts)
+
+		_, err := cache.GetKey(ctx, "vvcccccccccc")
+		require.NoError(t, err)
+
+		_, err = cache.GetKey(ctx, "vvcccccccccb")
+		require.ErrorIs(t, err, common.ErrStorageNoKey)
+
+		require.Equal(t, 1, cache.PurgeKeys("vvcccccccccc", "vvcccccccccd"))
+		require.Equal(t, 1, cache.PurgeKeys())
+		require.Zero(t, cache.CachedKeys())
+
+		_, err = cache.GetKey(ctx, "vvcccccccccc")
+		require.NoError(t, err)
+		require.Equal(t, 3, storage.calls)
+	})
+
+	t.Run("should evict least recently used entries", func(t *testing.T) {
+		t.Parallel()
+
+		cache, storage, _ := newTestCache(t, Options{TTL: time.Minute, NegativeTTL: time.Minute, Size: 2})
+
+		_, err := cache.GetKey(ctx, "vvcccccccccc")
+		require.NoError(t, err)
+
+		for _, publicID := range []string{"vvcccccccccb", "vvcccccccccd"} {
+			_, err = cache.GetKey(ctx, publicID)
+			require.ErrorIs(t, err, common.ErrStorageNoKey)
+		}
+
+		require.Zero(t, cache.CachedKeys())
+
+		_, err = cache.GetKey(ctx, "vvcccccccccd")
+		require.ErrorIs(t, err, common.ErrStorageNoKey)
+		require.Equal(t, 3, storage.calls)
+	})
+
+	t.Run("should not cache malformed key", func(t *testing.T) {
+		t.Parallel()
+
+		cache, storage, _ := newTestCache(t, opts)
+
+		storage.Lock()
+		storage.keys["vvcccccccccc"].AESKey = "not hex"
+		storage.Unlock()
+
+		for range 2 {
+			key, err := cache.GetKey(ctx, "vvcccccccccc")
+			require.NoError(t, err)
+			require.Equal(t, "not hex", key.AESKey)
+		}
+
+		require.Equal(t, 2, storage.calls)
+		require.Zero(t, cache.CachedKeys())
+	})
+
+	t.Run("should cache wrapped AES key as is", func(t *testing.T) {
+		t.Parallel()
+
+		wrapped := opts
+		wrapped.Wrapped = func(aesKey string) bool { return strings.HasPrefix(aesKey, "vault:") }
+
+		cache, storage, _ := newTestCache(t, wrapped)
+
+		storage.Lock()
+		storage.keys["vvcccccccccc"].AESKey = "vault:v1:wrapped"
+		want := *storage.keys["vvcccccccccc"]
+		storage.Unlock()
+
+		for range 2 {
+			key, err := cache.GetKey(ctx, "vvcccccccccc")
+			require.NoError(t, err)
+			require.Equal(t, want, *key)
+		}
+
+		require.Equal(t, 1, storage.calls)
+		require.Equal(t, 1, cache.CachedKeys())
+	})
+}
+
+func TestLockedPool(t *testing.T) {
+	t.Parallel()
+
+	var pool lockedPool
+
+	slot, err := pool.get()
+	require.NoError(t, err)
+	require.Len(t, slot, slotSize)
+
+	copy(slot, "secret")
+	pool.put(slot)
+	require.Equal(t, make([]byte, slotSize), slot)
+
+	// All slots of the page are handed out before the next page is allocated
+	slots := len(pool.free)
+	for range slots + 1 {
+		_, err = pool.get()
+		require.NoError(t, err)
+	}
+
+	require.Len(t, pool.pages, 2)
+
+	pool.release()
+	require.Empty(t, pool.pages)
+	require.Empty(t, pool.free)
+}
@@ -0,0 +1,87 @@
+package keycache
+
+import (
+	"errors"
+	"os"
+)
+
+// slotSize fits secrets of one key: AES key, private ID and lock code with their lengths.
+const slotSize = 32
+
+// ErrLockUnsupported is reported when memory locking is not available on the platform.
+var ErrLockUnsupported = errors.New("memory locking is not supported on this platform")
+
+type (
+	// lockedPool hands out fixed size slots of memory locked into RAM, so that secrets
+	// never reach the swap. It is not safe for concurrent use.
+	lockedPool struct {
+		pages []page
+		free  [][]byte
+
+		// onLockFail is called once, when memory cannot be locked
+		onLockFail func(err error)
+		lockFailed bool
+	}
+
+	page struct {
+		mem    []byte
+		mapped bool
+	}
+)
+
+// get returns a zeroed slot, allocating a new page when no free slots left.
+func (p *lockedPool) get() ([]byte, error) {
+	if len(p.free) == 0 {
+		if err := p.grow(); err != nil {
+			return nil, err
+		}
+	}
+
+	slot := p.free[len(p.free)-1]
+	p.free = p.free[:len(p.free)-1]
+
+	return slot, nil
+}
+
+// put wipes the slot and returns it to the pool.
+func (p *lockedPool) put(slot []byte) {
+	clear(slot)
+
+	p.free = append(p.free, slot)
+}
+
+// release wipes and unmaps all pages, the pool can be used again afterwards.
+func (p *lockedPool) release() {
+	for _, pg := range p.pages {
+		clear(pg.mem)
+
+		if pg.mapped {
+			freeLocked(pg.mem)
+		}
+	}
+
+	p.pages, p.free = nil, nil
+}
+
+func (p *lockedPool) grow() error {
+	mem, mapped, err := allocLocked(os.Getpagesize())
+	if mem == nil {
+		return err
+	}
+
+	if err != nil && !p.lockFailed {
+		p.lockFailed = true
+
+		if p.onLockFail != nil {
+			p.onLockFail(err)
+		}
+	}
+
+	p.pages = append(p.pages, page{mem: mem, mapped: mapped})
+
+	for offset := 0; offset+slotSize <= len(mem); offset += slotSize {
+		p.free = append(p.free, mem[offset:offset+slotSize:offset+slotSize])
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package keycache
+
+// allocLocked falls back to regular memory, where memory locking is not supported.
+func allocLocked(size int) ([]byte, bool, error) {
+	return make([]byte, size), false, ErrLockUnsupported
+}
+
+// freeLocked is a no-op, memory is released by the garbage collector.
+func freeLocked([]byte) {}
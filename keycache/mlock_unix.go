@@ -0,0 +1,30 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package keycache
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// allocLocked maps anonymous memory and locks it into RAM. The memory is returned
+// even if it cannot be locked (e.g. RLIMIT_MEMLOCK is too low) along with the error,
+// mapped reports whether it must be released with freeLocked.
+func allocLocked(size int) ([]byte, bool, error) {
+	mem, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return make([]byte, size), false, fmt.Errorf("mmap: %w", err)
+	}
+
+	if err = syscall.Mlock(mem); err != nil {
+		return mem, true, fmt.Errorf("mlock: %w", err)
+	}
+
+	return mem, true, nil
+}
+
+// freeLocked unlocks and unmaps memory allocated by allocLocked.
+func freeLocked(mem []byte) {
+	_ = syscall.Munlock(mem)
+	_ = syscall.Munmap(mem)
+}
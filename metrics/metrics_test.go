@@ -0,0 +1,36 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/archaron/go-yubiserv/metrics"
+)
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	reg := metrics.NewRegistry()
+
+	responses := promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "test_responses_total",
+		Help: "Responses by status.",
+	}, []string{"status"})
+	responses.WithLabelValues("OK").Inc()
+	responses.WithLabelValues(`BAD"OTP`).Inc()
+
+	rec := httptest.NewRecorder()
+	metrics.Handler(reg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+	require.Contains(t, rec.Body.String(), "# TYPE test_responses_total counter\n")
+	require.Contains(t, rec.Body.String(), "test_responses_total{status=\"BAD\\\"OTP\"} 1\n")
+	require.Contains(t, rec.Body.String(), "go_goroutines ")
+	require.Contains(t, rec.Body.String(), "promhttp_metric_handler_errors_total")
+}
@@ -0,0 +1,10 @@
+package metrics
+
+import (
+	"github.com/im-kulikov/helium/module"
+)
+
+// Module provides the metrics registry shared by all modules.
+var Module = module.Module{ //nolint:gochecknoglobals
+	{Constructor: NewRegistry},
+}
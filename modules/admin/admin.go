@@ -0,0 +1,197 @@
+// Package admin implements administrative REST API for keys, counters and API clients.
+package admin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	perrors "github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"go.uber.org/dig"
+	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+type (
+	serviceParams struct {
+		dig.In
+
+		Logger   *zap.Logger
+		Config   *viper.Viper
+		Keys     common.KeyAdmin     `optional:"true"`
+		Counters common.CounterAdmin `optional:"true"`
+		Clients  common.ClientAdmin  `optional:"true"`
+		KeyCache common.KeyCache     `optional:"true"`
+	}
+
+	// Service represents admin API service.
+	Service struct {
+		log     *zap.Logger
+		address string
+		timeout time.Duration
+		cert    string
+		key     string
+		tokens  []string
+
+		server  *http.Server
+		started chan struct{}
+
+		keys     common.KeyAdmin
+		counters common.CounterAdmin
+		clients  common.ClientAdmin
+		cache    common.KeyCache
+	}
+
+	errorResponse struct {
+		Error string `json:"error"`
+	}
+)
+
+var (
+	ErrUnauthorized   = errors.New("missing or invalid bearer token")
+	ErrNotImplemented = errors.New("not supported by the selected storage")
+	ErrBadRequest     = errors.New("invalid request")
+)
+
+func (s *Service) newRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Use(s.authenticate)
+
+	r.Route("/v1", func(r chi.Router) {
+		r.Route("/keys", func(r chi.Router) {
+			r.Get("/", s.listKeys)
+			r.Post("/", s.createKey)
+			r.Get("/{publicID}", s.getKey)
+			r.Put("/{publicID}", s.updateKey)
+			r.Delete("/{publicID}", s.deleteKey)
+			r.Post("/{publicID}/enable", s.setKeyActive(true))
+			r.Post("/{publicID}/disable", s.setKeyActive(false))
+		})
+
+		r.Route("/counters", func(r chi.Router) {
+			r.Get("/", s.listCounters)
+			r.Get("/{publicID}", s.getCounter)
+			r.Delete("/{publicID}", s.resetCounter)
+		})
+
+		r.Route("/clients", func(r chi.Router) {
+			r.Get("/", s.listClients)
+			r.Post("/", s.createClient)
+			r.Get("/{id}", s.getClient)
+			r.Put("/{id}", s.updateClient)
+			r.Delete("/{id}", s.deleteClient)
+		})
+
+		r.Route("/cache", func(r chi.Router) {
+			r.Delete("/", s.purgeCache)
+			r.Delete("/{publicID}", s.purgeCache)
+		})
+	})
+
+	return r
+}
+
+// authenticate rejects requests without one of the configured bearer tokens.
+func (s *Service) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !s.validToken(token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="yubiserv-admin"`)
+			s.fail(w, r, http.StatusUnauthorized, ErrUnauthorized)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validToken compares token digests in constant time, so neither the token nor its length leaks.
+func (s *Service) validToken(token string) bool {
+	digest := sha256.Sum256([]byte(token))
+	valid := 0
+
+	for _, t := range s.tokens {
+		expected := sha256.Sum256([]byte(t))
+		valid |= subtle.ConstantTimeCompare(digest[:], expected[:])
+	}
+
+	return valid == 1
+}
+
+// fail writes JSON error response.
+func (s *Service) fail(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		s.log.Error("admin request failed", zap.String("path", r.URL.Path), zap.Error(err))
+	}
+
+	render.Status(r, status)
+	render.JSON(w, r, errorResponse{Error: err.Error()})
+}
+
+// Start admin API service, disabled when no address is configured.
+func (s *Service) Start(ctx context.Context) error {
+	if s.address == "" {
+		s.log.Debug("admin API disabled")
+		close(s.started)
+		<-ctx.Done()
+
+		return nil
+	}
+
+	s.server = &http.Server{
+		Addr:              s.address,
+		Handler:           s.newRouter(),
+		ReadTimeout:       s.timeout,
+		ReadHeaderTimeout: s.timeout,
+		WriteTimeout:      s.timeout,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	close(s.started)
+
+	if s.cert != "" && s.key != "" {
+		s.log.Info("admin API listen in secured TLS HTTPS mode", zap.String("address", s.address))
+
+		return ignoreClosed(s.server.ListenAndServeTLS(s.cert, s.key))
+	}
+
+	s.log.Info("admin API listen in unsecured HTTP mode", zap.String("address", s.address))
+
+	return ignoreClosed(s.server.ListenAndServe())
+}
+
+func ignoreClosed(err error) error {
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return perrors.Wrap(err, "admin serve")
+}
+
+// Stop admin API service.
+func (s *Service) Stop(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-s.started:
+	}
+
+	if s.server != nil {
+		s.log.Info("shutting down admin server", zap.Error(s.server.Shutdown(ctx)))
+	}
+}
+
+// Name of the admin API service.
+func (s *Service) Name() string {
+	return "admin-api"
+}
@@ -0,0 +1,489 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+const testToken = "s3cr3t-t0ken"
+
+type (
+	testKeys struct {
+		sync.Mutex
+
+		keys map[string]*common.Key
+		// readOnly rejects changes like the file key store
+		readOnly bool
+	}
+
+	testCounters struct {
+		users common.OTPUsers
+	}
+
+	testClients struct {
+		clients map[uint64]*common.Client
+	}
+
+	testCache struct {
+		entries map[string]struct{}
+	}
+)
+
+func (k *testKeys) Connect(context.Context) error { return nil }
+
+func (k *testKeys) Close() error { return nil }
+
+func (k *testKeys) GetKey(_ context.Context, publicID string) (*common.Key, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	key, ok := k.keys[publicID]
+	if !ok {
+		return nil, common.ErrStorageNoKey
+	}
+
+	c := *key
+
+	return &c, nil
+}
+
+func (k *testKeys) ListKeys() ([]*common.Key, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	keys := make([]*common.Key, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (k *testKeys) StoreKey(key *common.Key) error {
+	k.Lock()
+	defer k.Unlock()
+
+	if k.readOnly {
+		return common.ErrStorageReadOnly
+	}
+
+	c := *key
+	k.keys[key.PublicID] = &c
+
+	return nil
+}
+
+func (k *testKeys) StoreKeys(keys []*common.Key) error {
+	for _, key := range keys {
+		if _, err := k.GetKey(context.Background(), key.PublicID); err == nil {
+			return common.ErrStorageKeyExists
+		}
+	}
+
+	for _, key := range keys {
+		_ = k.StoreKey(key)
+	}
+
+	return nil
+}
+
+func (k *testKeys) DeleteKey(publicID string) error {
+	k.Lock()
+	defer k.Unlock()
+
+	if k.readOnly {
+		return common.ErrStorageReadOnly
+	}
+
+	if _, ok := k.keys[publicID]; !ok {
+		return common.ErrStorageNoKey
+	}
+
+	delete(k.keys, publicID)
+
+	return nil
+}
+
+func (c *testCounters) Counters() common.OTPUsers { return c.users }
+
+func (c *testCounters) ResetCounter(publicID string) error {
+	if _, ok := c.users[publicID]; !ok {
+		return common.ErrCounterNotFound
+	}
+
+	delete(c.users, publicID)
+
+	return nil
+}
+
+func (c *testClients) GetClient(id uint64) (*common.Client, error) {
+	client, ok := c.clients[id]
+	if !ok {
+		return nil, common.ErrClientNotFound
+	}
+
+	cc := *client
+
+	return &cc, nil
+}
+
+func (c *testClients) ListClients() ([]*common.Client, error) {
+	clients := make([]*common.Client, 0, len(c.clients))
+	for _, client := range c.clients {
+		clients = append(clients, client)
+	}
+
+	return clients, nil
+}
+
+func (c *testClients) StoreClient(client *common.Client) error {
+	cc := *client
+	c.clients[client.ID] = &cc
+
+	return nil
+}
+
+func (c *testClients) DeleteClient(id uint64) error {
+	if _, ok := c.clients[id]; !ok {
+		return common.ErrClientNotFound
+	}
+
+	delete(c.clients, id)
+
+	return nil
+}
+
+func (c *testCache) CachedKeys() int { return len(c.entries) }
+
+func (c *testCache) PurgeKeys(publicIDs ...string) int {
+	if len(publicIDs) == 0 {
+		purged := len(c.entries)
+		c.entries = map[string]struct{}{}
+
+		return purged
+	}
+
+	purged := 0
+
+	for _, publicID := range publicIDs {
+		if _, ok := c.entries[publicID]; ok {
+			delete(c.entries, publicID)
+			purged++
+		}
+	}
+
+	return purged
+}
+
+func createTestService(t *testing.T) *Service {
+	t.Helper()
+
+	return &Service{
+		log:    zaptest.NewLogger(t),
+		tokens: []string{"other-token", testToken},
+		keys: &testKeys{keys: map[string]*common.Key{
+			"vvcccccccccc": {
+				ID:        1,
+				PublicID:  "vvcccccccccc",
+				Created:   "2024-01-01T00:00:00Z",
+				PrivateID: "0102030405ab",
+				AESKey:    "0102030405060708090a0b0c0d0e0f10",
+				LockCode:  "010203040506",
+				Active:    true,
+			},
+		}},
+		counters: &testCounters{users: common.OTPUsers{
+			"vvcccccccccc": {UsageCounter: 3, SessionCounter: 2, Timestamp: [3]byte{0, 1, 0}},
+		}},
+		clients: &testClients{clients: map[uint64]*common.Client{
+			1: {ID: 1, Secret: "ynS/XoXc2gwGDBssYSu2w21Aky4=", Active: true, Description: "VPN"},
+		}},
+	}
+}
+
+func doRequest(t *testing.T, h http.Handler, method, path, body string) (int, string) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec.Code, rec.Body.String()
+}
+
+func Test_authenticate(t *testing.T) {
+	t.Parallel()
+
+	h := createTestService(t).newRouter()
+
+	for name, header := range map[string]string{
+		"no header":   "",
+		"wrong token": "Bearer wrong",
+		"basic auth":  "Basic " + testToken,
+		"token only":  testToken,
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/keys", nil)
+			if header != "" {
+				req.Header.Set("Authorization", header)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			require.Equal(t, http.StatusUnauthorized, rec.Code)
+			require.Contains(t, rec.Header().Get("WWW-Authenticate"), "Bearer")
+			require.JSONEq(t, `{"error":"missing or invalid bearer token"}`, rec.Body.String())
+		})
+	}
+}
+
+func Test_keys(t *testing.T) {
+	t.Parallel()
+
+	svc := createTestService(t)
+	h := svc.newRouter()
+
+	code, body := doRequest(t, h, http.MethodGet, "/v1/keys", "")
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `[{"id":1,"public_id":"vvcccccccccc","created":"2024-01-01T00:00:00Z","active":true}]`, body)
+
+	code, body = doRequest(t, h, http.MethodGet, "/v1/keys/vvcccccccccc", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, `"aes_key":"0102030405060708090a0b0c0d0e0f10"`)
+
+	code, _ = doRequest(t, h, http.MethodGet, "/v1/keys/vvccccccccvv", "")
+	require.Equal(t, http.StatusNotFound, code)
+
+	// Create with generated secrets
+	code, body = doRequest(t, h, http.MethodPost, "/v1/keys", `{"public_id":"vvccccccccvv","id":2}`)
+	require.Equal(t, http.StatusCreated, code)
+
+	var created common.Key
+	require.NoError(t, json.Unmarshal([]byte(body), &created))
+	require.NoError(t, created.Validate())
+	require.Equal(t, uint64(2), created.ID)
+	require.True(t, created.Active)
+
+	code, _ = doRequest(t, h, http.MethodPost, "/v1/keys", `{"public_id":"vvccccccccvv"}`)
+	require.Equal(t, http.StatusConflict, code)
+
+	code, _ = doRequest(t, h, http.MethodPost, "/v1/keys", `{"public_id":"vvcc"}`)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = doRequest(t, h, http.MethodPost, "/v1/keys", `{"public_id":`)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	// Update keeps omitted fields
+	code, body = doRequest(t, h, http.MethodPut, "/v1/keys/vvcccccccccc", `{"aes_key":"ffffffffffffffffffffffffffffffff"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, `"aes_key":"ffffffffffffffffffffffffffffffff"`)
+	require.Contains(t, body, `"private_id":"0102030405ab"`)
+
+	code, body = doRequest(t, h, http.MethodPut, "/v1/keys/vvcccccccccc", `{"owner":"alice","description":"spare key"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, `"owner":"alice","description":"spare key"`)
+	require.Contains(t, body, `"aes_key":"ffffffffffffffffffffffffffffffff"`)
+
+	code, _ = doRequest(t, h, http.MethodPut, "/v1/keys/vvcccccccccc", `{"public_id":"vvccccccccvv"}`)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = doRequest(t, h, http.MethodPut, "/v1/keys/vvcccccccccc", `{"lock_code":"zz"}`)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	// Activation toggling
+	code, body = doRequest(t, h, http.MethodPost, "/v1/keys/vvcccccccccc/disable", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, `"active":false`)
+
+	key, err := svc.keys.GetKey(context.Background(), "vvcccccccccc")
+	require.NoError(t, err)
+	require.False(t, key.Active)
+
+	code, body = doRequest(t, h, http.MethodPost, "/v1/keys/vvcccccccccc/enable", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, `"active":true`)
+
+	// Delete
+	code, _ = doRequest(t, h, http.MethodDelete, "/v1/keys/vvccccccccvv", "")
+	require.Equal(t, http.StatusNoContent, code)
+
+	code, _ = doRequest(t, h, http.MethodDelete, "/v1/keys/vvccccccccvv", "")
+	require.Equal(t, http.StatusNotFound, code)
+}
+
+func Test_readOnlyKeys(t *testing.T) {
+	t.Parallel()
+
+	svc := createTestService(t)
+	svc.keys.(*testKeys).readOnly = true //nolint:forcetypeassert
+	h := svc.newRouter()
+
+	code, _ := doRequest(t, h, http.MethodGet, "/v1/keys/vvcccccccccc", "")
+	require.Equal(t, http.StatusOK, code)
+
+	code, body := doRequest(t, h, http.MethodPut, "/v1/keys/vvcccccccccc", `{"owner":"alice"}`)
+	require.Equal(t, http.StatusMethodNotAllowed, code)
+	require.Contains(t, body, common.ErrStorageReadOnly.Error())
+
+	code, _ = doRequest(t, h, http.MethodPost, "/v1/keys/vvcccccccccc/disable", "")
+	require.Equal(t, http.StatusMethodNotAllowed, code)
+
+	code, _ = doRequest(t, h, http.MethodDelete, "/v1/keys/vvcccccccccc", "")
+	require.Equal(t, http.StatusMethodNotAllowed, code)
+}
+
+func Test_counters(t *testing.T) {
+	t.Parallel()
+
+	h := createTestService(t).newRouter()
+
+	code, body := doRequest(t, h, http.MethodGet, "/v1/counters", "")
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `[{"public_id":"vvcccccccccc","usage_counter":3,"session_counter":2,"timestamp":256,"seen":"0001-01-01T00:00:00Z"}]`, body)
+
+	code, _ = doRequest(t, h, http.MethodGet, "/v1/counters/vvcccccccccc", "")
+	require.Equal(t, http.StatusOK, code)
+
+	code, _ = doRequest(t, h, http.MethodDelete, "/v1/counters/vvcccccccccc", "")
+	require.Equal(t, http.StatusNoContent, code)
+
+	code, _ = doRequest(t, h, http.MethodGet, "/v1/counters/vvcccccccccc", "")
+	require.Equal(t, http.StatusNotFound, code)
+
+	code, _ = doRequest(t, h, http.MethodDelete, "/v1/counters/vvcccccccccc", "")
+	require.Equal(t, http.StatusNotFound, code)
+}
+
+func Test_clients(t *testing.T) {
+	t.Parallel()
+
+	t.Run("read-only registry", func(t *testing.T) {
+		t.Parallel()
+
+		svc := createTestService(t)
+		svc.clients = nil
+
+		code, _ := doRequest(t, svc.newRouter(), http.MethodGet, "/v1/clients", "")
+		require.Equal(t, http.StatusNotImplemented, code)
+	})
+
+	t.Run("manage clients", func(t *testing.T) {
+		t.Parallel()
+
+		h := createTestService(t).newRouter()
+
+		code, body := doRequest(t, h, http.MethodGet, "/v1/clients/1", "")
+		require.Equal(t, http.StatusOK, code)
+		require.JSONEq(t, `{"id":1,"secret":"ynS/XoXc2gwGDBssYSu2w21Aky4=","active":true,"description":"VPN"}`, body)
+
+		code, _ = doRequest(t, h, http.MethodGet, "/v1/clients/abc", "")
+		require.Equal(t, http.StatusBadRequest, code)
+
+		// Create with generated secret
+		code, body = doRequest(t, h, http.MethodPost, "/v1/clients", `{"id":2,"description":"web"}`)
+		require.Equal(t, http.StatusCreated, code)
+
+		var created common.Client
+		require.NoError(t, json.Unmarshal([]byte(body), &created))
+		require.True(t, created.Active)
+
+		key, err := created.Key()
+		require.NoError(t, err)
+		require.Len(t, key, clientSecretSize)
+
+		code, _ = doRequest(t, h, http.MethodPost, "/v1/clients", `{"id":2}`)
+		require.Equal(t, http.StatusConflict, code)
+
+		code, _ = doRequest(t, h, http.MethodPost, "/v1/clients", `{"id":0}`)
+		require.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = doRequest(t, h, http.MethodPost, "/v1/clients", `{"id":3,"secret":"not base64!"}`)
+		require.Equal(t, http.StatusBadRequest, code)
+
+		// Update keeps omitted fields
+		code, body = doRequest(t, h, http.MethodPut, "/v1/clients/2", `{"active":false}`)
+		require.Equal(t, http.StatusOK, code)
+		require.Contains(t, body, `"active":false`)
+		require.Contains(t, body, `"description":"web"`)
+
+		code, _ = doRequest(t, h, http.MethodPut, "/v1/clients/5", `{"active":false}`)
+		require.Equal(t, http.StatusNotFound, code)
+
+		code, _ = doRequest(t, h, http.MethodDelete, "/v1/clients/2", "")
+		require.Equal(t, http.StatusNoContent, code)
+
+		code, _ = doRequest(t, h, http.MethodDelete, "/v1/clients/2", "")
+		require.Equal(t, http.StatusNotFound, code)
+	})
+}
+
+func Test_cache(t *testing.T) {
+	t.Parallel()
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+
+		code, body := doRequest(t, createTestService(t).newRouter(), http.MethodDelete, "/v1/cache", "")
+		require.Equal(t, http.StatusNotImplemented, code)
+		require.JSONEq(t, `{"error":"key cache is disabled"}`, body)
+	})
+
+	svc := createTestService(t)
+	svc.cache = &testCache{entries: map[string]struct{}{"vvcccccccccc": {}, "vvcccccccccb": {}, "vvcccccccccd": {}}}
+	h := svc.newRouter()
+
+	code, body := doRequest(t, h, http.MethodDelete, "/v1/cache/vvcccccccccc", "")
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"purged":1}`, body)
+
+	code, body = doRequest(t, h, http.MethodDelete, "/v1/cache/vvcccccccccc", "")
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"purged":0}`, body)
+
+	code, body = doRequest(t, h, http.MethodDelete, "/v1/cache", "")
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, `{"purged":2}`, body)
+	require.Zero(t, svc.cache.CachedKeys())
+}
+
+func Test_newService(t *testing.T) {
+	t.Parallel()
+
+	v := viper.New()
+	v.Set("admin.address", ":8444")
+
+	_, err := newService(serviceParams{Logger: zaptest.NewLogger(t), Config: v})
+	require.ErrorIs(t, err, ErrNoTokens)
+
+	v.Set("admin.tokens", []string{"", testToken})
+
+	svc, err := newService(serviceParams{Logger: zaptest.NewLogger(t), Config: v})
+	require.NoError(t, err)
+	require.Equal(t, []string{testToken}, svc.(*Service).tokens) //nolint:forcetypeassert
+
+	// Disabled admin API needs no tokens
+	_, err = newService(serviceParams{Logger: zaptest.NewLogger(t), Config: viper.New()})
+	require.NoError(t, err)
+}
@@ -0,0 +1,37 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"go.uber.org/zap"
+)
+
+// ErrCacheDisabled is returned by cache operations when the key cache is not enabled.
+var ErrCacheDisabled = errors.New("key cache is disabled")
+
+// purgeResponse reports the number of dropped cache entries.
+type purgeResponse struct {
+	Purged int `json:"purged"`
+}
+
+func (s *Service) purgeCache(w http.ResponseWriter, r *http.Request) {
+	if s.cache == nil {
+		s.fail(w, r, http.StatusNotImplemented, ErrCacheDisabled)
+
+		return
+	}
+
+	var publicIDs []string
+	if publicID := chi.URLParam(r, "publicID"); publicID != "" {
+		publicIDs = append(publicIDs, publicID)
+	}
+
+	purged := s.cache.PurgeKeys(publicIDs...)
+
+	s.log.Info("key cache purged", zap.Strings("public_ids", publicIDs), zap.Int("purged", purged))
+
+	render.JSON(w, r, purgeResponse{Purged: purged})
+}
@@ -0,0 +1,251 @@
+package admin
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/misc"
+)
+
+// clientSecretSize is the size of generated client HMAC keys, same as ykval uses.
+const clientSecretSize = 20
+
+var (
+	ErrClientExists    = errors.New("api client already exists")
+	ErrInvalidClientID = errors.New("client ID must be a positive integer")
+)
+
+// clientRequest is a client create/update request body, omitted fields are defaulted on create
+// and kept on update.
+type clientRequest struct {
+	ID          uint64  `json:"id"`
+	Secret      *string `json:"secret"`
+	Active      *bool   `json:"active"`
+	Description *string `json:"description"`
+}
+
+// apply copies set request fields to the client and checks the secret encoding.
+func (req *clientRequest) apply(client *common.Client) error {
+	if req.Secret != nil {
+		client.Secret = *req.Secret
+	}
+
+	if req.Active != nil {
+		client.Active = *req.Active
+	}
+
+	if req.Description != nil {
+		client.Description = *req.Description
+	}
+
+	if _, err := client.Key(); err != nil {
+		return fmt.Errorf("%w: %w", ErrBadRequest, err)
+	}
+
+	return nil
+}
+
+// clientStatus maps clients storage errors to HTTP status codes.
+func clientStatus(err error) int {
+	switch {
+	case errors.Is(err, common.ErrClientNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrClientExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrBadRequest), errors.Is(err, ErrInvalidClientID):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func clientID(r *http.Request) (uint64, error) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, ErrInvalidClientID
+	}
+
+	return id, nil
+}
+
+func (s *Service) listClients(w http.ResponseWriter, r *http.Request) {
+	if s.clients == nil {
+		s.fail(w, r, http.StatusNotImplemented, ErrNotImplemented)
+
+		return
+	}
+
+	clients, err := s.clients.ListClients()
+	if err != nil {
+		s.fail(w, r, clientStatus(err), err)
+
+		return
+	}
+
+	render.JSON(w, r, clients)
+}
+
+func (s *Service) createClient(w http.ResponseWriter, r *http.Request) {
+	if s.clients == nil {
+		s.fail(w, r, http.StatusNotImplemented, ErrNotImplemented)
+
+		return
+	}
+
+	var req clientRequest
+
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		s.fail(w, r, http.StatusBadRequest, fmt.Errorf("%w: %w", ErrBadRequest, err))
+
+		return
+	}
+
+	if req.ID == 0 {
+		s.fail(w, r, http.StatusBadRequest, ErrInvalidClientID)
+
+		return
+	}
+
+	if _, err := s.clients.GetClient(req.ID); err == nil {
+		s.fail(w, r, http.StatusConflict, fmt.Errorf("%d: %w", req.ID, ErrClientExists))
+
+		return
+	} else if !errors.Is(err, common.ErrClientNotFound) {
+		s.fail(w, r, clientStatus(err), err)
+
+		return
+	}
+
+	client := &common.Client{ID: req.ID, Active: true}
+
+	// Generate a secret unless given explicitly, an explicit empty one disables signatures
+	if req.Secret == nil {
+		secret, err := misc.Rand(clientSecretSize)
+		if err != nil {
+			s.fail(w, r, http.StatusInternalServerError, err)
+
+			return
+		}
+
+		client.Secret = base64.StdEncoding.EncodeToString(secret)
+	}
+
+	if err := req.apply(client); err != nil {
+		s.fail(w, r, clientStatus(err), err)
+
+		return
+	}
+
+	if err := s.clients.StoreClient(client); err != nil {
+		s.fail(w, r, clientStatus(err), err)
+
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, client)
+}
+
+func (s *Service) getClient(w http.ResponseWriter, r *http.Request) {
+	if s.clients == nil {
+		s.fail(w, r, http.StatusNotImplemented, ErrNotImplemented)
+
+		return
+	}
+
+	id, err := clientID(r)
+	if err != nil {
+		s.fail(w, r, clientStatus(err), err)
+
+		return
+	}
+
+	client, err := s.clients.GetClient(id)
+	if err != nil {
+		s.fail(w, r, clientStatus(err), err)
+
+		return
+	}
+
+	render.JSON(w, r, client)
+}
+
+func (s *Service) updateClient(w http.ResponseWriter, r *http.Request) {
+	if s.clients == nil {
+		s.fail(w, r, http.StatusNotImplemented, ErrNotImplemented)
+
+		return
+	}
+
+	id, err := clientID(r)
+	if err != nil {
+		s.fail(w, r, clientStatus(err), err)
+
+		return
+	}
+
+	var req clientRequest
+
+	if err = render.DecodeJSON(r.Body, &req); err != nil {
+		s.fail(w, r, http.StatusBadRequest, fmt.Errorf("%w: %w", ErrBadRequest, err))
+
+		return
+	}
+
+	if req.ID != 0 && req.ID != id {
+		s.fail(w, r, http.StatusBadRequest, fmt.Errorf("%w: client ID cannot be changed", ErrBadRequest))
+
+		return
+	}
+
+	client, err := s.clients.GetClient(id)
+	if err != nil {
+		s.fail(w, r, clientStatus(err), err)
+
+		return
+	}
+
+	if err = req.apply(client); err != nil {
+		s.fail(w, r, clientStatus(err), err)
+
+		return
+	}
+
+	if err = s.clients.StoreClient(client); err != nil {
+		s.fail(w, r, clientStatus(err), err)
+
+		return
+	}
+
+	render.JSON(w, r, client)
+}
+
+func (s *Service) deleteClient(w http.ResponseWriter, r *http.Request) {
+	if s.clients == nil {
+		s.fail(w, r, http.StatusNotImplemented, ErrNotImplemented)
+
+		return
+	}
+
+	id, err := clientID(r)
+	if err != nil {
+		s.fail(w, r, clientStatus(err), err)
+
+		return
+	}
+
+	if err = s.clients.DeleteClient(id); err != nil {
+		s.fail(w, r, clientStatus(err), err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,91 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+// counterResponse represents the last accepted counters of a key.
+type counterResponse struct {
+	PublicID       string    `json:"public_id"`
+	UsageCounter   uint16    `json:"usage_counter"`
+	SessionCounter uint8     `json:"session_counter"`
+	Timestamp      uint32    `json:"timestamp"`
+	Seen           time.Time `json:"seen"`
+}
+
+func newCounterResponse(publicID string, user *common.OTPUser) counterResponse {
+	return counterResponse{
+		PublicID:       publicID,
+		UsageCounter:   user.UsageCounter,
+		SessionCounter: user.SessionCounter,
+		Timestamp:      common.TimestampValue(user.Timestamp),
+		Seen:           user.Seen,
+	}
+}
+
+func (s *Service) listCounters(w http.ResponseWriter, r *http.Request) {
+	if s.counters == nil {
+		s.fail(w, r, http.StatusNotImplemented, ErrNotImplemented)
+
+		return
+	}
+
+	users := s.counters.Counters()
+	counters := make([]counterResponse, 0, len(users))
+
+	for publicID, user := range users {
+		counters = append(counters, newCounterResponse(publicID, user))
+	}
+
+	sort.Slice(counters, func(i, j int) bool { return counters[i].PublicID < counters[j].PublicID })
+
+	render.JSON(w, r, counters)
+}
+
+func (s *Service) getCounter(w http.ResponseWriter, r *http.Request) {
+	if s.counters == nil {
+		s.fail(w, r, http.StatusNotImplemented, ErrNotImplemented)
+
+		return
+	}
+
+	publicID := chi.URLParam(r, "publicID")
+
+	user, ok := s.counters.Counters()[publicID]
+	if !ok {
+		s.fail(w, r, http.StatusNotFound, common.ErrCounterNotFound)
+
+		return
+	}
+
+	render.JSON(w, r, newCounterResponse(publicID, user))
+}
+
+func (s *Service) resetCounter(w http.ResponseWriter, r *http.Request) {
+	if s.counters == nil {
+		s.fail(w, r, http.StatusNotImplemented, ErrNotImplemented)
+
+		return
+	}
+
+	if err := s.counters.ResetCounter(chi.URLParam(r, "publicID")); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, common.ErrCounterNotFound) {
+			status = http.StatusNotFound
+		}
+
+		s.fail(w, r, status, err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
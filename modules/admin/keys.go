@@ -0,0 +1,214 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+// keyRequest is a key create/update request body, omitted fields are generated on create
+// and kept on update.
+type keyRequest struct {
+	ID        *uint64 `json:"id"`
+	PublicID  string  `json:"public_id"`
+	PrivateID string  `json:"private_id"`
+	AESKey    string  `json:"aes_key"`
+	LockCode  string  `json:"lock_code"`
+	Active    *bool   `json:"active"`
+
+	Owner       *string `json:"owner"`
+	Description *string `json:"description"`
+}
+
+// apply copies set request fields to the key.
+func (req *keyRequest) apply(key *common.Key) {
+	if req.ID != nil {
+		key.ID = *req.ID
+	}
+
+	if req.PrivateID != "" {
+		key.PrivateID = req.PrivateID
+	}
+
+	if req.AESKey != "" {
+		key.AESKey = req.AESKey
+	}
+
+	if req.LockCode != "" {
+		key.LockCode = req.LockCode
+	}
+
+	if req.Active != nil {
+		key.Active = *req.Active
+	}
+
+	if req.Owner != nil {
+		key.Owner = *req.Owner
+	}
+
+	if req.Description != nil {
+		key.Description = *req.Description
+	}
+}
+
+// keyStatus maps key storage errors to HTTP status codes.
+func keyStatus(err error) int {
+	switch {
+	case errors.Is(err, common.ErrStorageNoKey):
+		return http.StatusNotFound
+	case errors.Is(err, common.ErrStorageKeyExists):
+		return http.StatusConflict
+	case errors.Is(err, common.ErrStorageReadOnly):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, common.ErrKeyInvalidPublicID),
+		errors.Is(err, common.ErrKeyInvalidPrivateID),
+		errors.Is(err, common.ErrKeyInvalidAESKey),
+		errors.Is(err, common.ErrKeyInvalidLockCode):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (s *Service) listKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.keys.ListKeys()
+	if err != nil {
+		s.fail(w, r, keyStatus(err), err)
+
+		return
+	}
+
+	infos := make([]common.KeyInfo, 0, len(keys))
+	for _, key := range keys {
+		infos = append(infos, key.Info())
+	}
+
+	render.JSON(w, r, infos)
+}
+
+func (s *Service) createKey(w http.ResponseWriter, r *http.Request) {
+	var req keyRequest
+
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		s.fail(w, r, http.StatusBadRequest, fmt.Errorf("%w: %w", ErrBadRequest, err))
+
+		return
+	}
+
+	key := &common.Key{
+		PublicID: req.PublicID,
+		Created:  time.Now().UTC().Format(time.RFC3339),
+		Active:   true,
+	}
+
+	req.apply(key)
+
+	if err := key.GenerateSecrets(); err != nil {
+		s.fail(w, r, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	if err := key.Validate(); err != nil {
+		s.fail(w, r, keyStatus(err), err)
+
+		return
+	}
+
+	if err := s.keys.StoreKeys([]*common.Key{key}); err != nil {
+		s.fail(w, r, keyStatus(err), err)
+
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, key)
+}
+
+func (s *Service) getKey(w http.ResponseWriter, r *http.Request) {
+	key, err := s.keys.GetKey(r.Context(), chi.URLParam(r, "publicID"))
+	if err != nil {
+		s.fail(w, r, keyStatus(err), err)
+
+		return
+	}
+
+	render.JSON(w, r, key)
+}
+
+func (s *Service) updateKey(w http.ResponseWriter, r *http.Request) {
+	var req keyRequest
+
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		s.fail(w, r, http.StatusBadRequest, fmt.Errorf("%w: %w", ErrBadRequest, err))
+
+		return
+	}
+
+	publicID := chi.URLParam(r, "publicID")
+	if req.PublicID != "" && req.PublicID != publicID {
+		s.fail(w, r, http.StatusBadRequest, fmt.Errorf("%w: public ID cannot be changed", ErrBadRequest))
+
+		return
+	}
+
+	key, err := s.keys.GetKey(r.Context(), publicID)
+	if err != nil {
+		s.fail(w, r, keyStatus(err), err)
+
+		return
+	}
+
+	req.apply(key)
+
+	if err = key.Validate(); err != nil {
+		s.fail(w, r, keyStatus(err), err)
+
+		return
+	}
+
+	if err = s.keys.StoreKey(key); err != nil {
+		s.fail(w, r, keyStatus(err), err)
+
+		return
+	}
+
+	render.JSON(w, r, key)
+}
+
+func (s *Service) deleteKey(w http.ResponseWriter, r *http.Request) {
+	if err := s.keys.DeleteKey(chi.URLParam(r, "publicID")); err != nil {
+		s.fail(w, r, keyStatus(err), err)
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Service) setKeyActive(active bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := s.keys.GetKey(r.Context(), chi.URLParam(r, "publicID"))
+		if err != nil {
+			s.fail(w, r, keyStatus(err), err)
+
+			return
+		}
+
+		key.Active = active
+
+		if err = s.keys.StoreKey(key); err != nil {
+			s.fail(w, r, keyStatus(err), err)
+
+			return
+		}
+
+		render.JSON(w, r, key.Info())
+	}
+}
@@ -0,0 +1,67 @@
+package admin
+
+import (
+	"errors"
+
+	"github.com/im-kulikov/helium/module"
+	"github.com/im-kulikov/helium/service"
+	"github.com/spf13/viper"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/dig"
+)
+
+// Module admin API constructor.
+var Module = module.Module{ //nolint:gochecknoglobals
+	{Constructor: newService, Options: []dig.ProvideOption{dig.Group("services")}},
+}
+
+var (
+	ErrNoTokens  = errors.New("admin API requires at least one token in admin.tokens")
+	ErrTLSParams = errors.New("both tls certificate file and private key file must be set to enable TLS")
+)
+
+func newService(p serviceParams) (service.Service, error) {
+	svc := &Service{
+		log:      p.Logger,
+		address:  p.Config.GetString("admin.address"),
+		timeout:  p.Config.GetDuration("admin.timeout"),
+		cert:     p.Config.GetString("admin.tls_cert"),
+		key:      p.Config.GetString("admin.tls_key"),
+		keys:     p.Keys,
+		counters: p.Counters,
+		clients:  p.Clients,
+		cache:    p.KeyCache,
+		started:  make(chan struct{}),
+	}
+
+	for _, token := range p.Config.GetStringSlice("admin.tokens") {
+		if token != "" {
+			svc.tokens = append(svc.tokens, token)
+		}
+	}
+
+	if svc.address != "" && len(svc.tokens) == 0 {
+		return nil, ErrNoTokens
+	}
+
+	return svc, nil
+}
+
+// Defaults for admin API service.
+func Defaults(ctx *cli.Context, v *viper.Viper) error {
+	v.SetDefault("admin.address", ctx.String("admin-address"))
+	v.SetDefault("admin.timeout", ctx.String("api-timeout"))
+	v.SetDefault("admin.tokens", []string{})
+
+	tlsCert := ctx.String("api-tls-cert")
+	tlsKey := ctx.String("api-tls-key")
+
+	if (tlsCert == "") != (tlsKey == "") {
+		return ErrTLSParams
+	}
+
+	v.SetDefault("admin.tls_cert", tlsCert)
+	v.SetDefault("admin.tls_key", tlsKey)
+
+	return nil
+}
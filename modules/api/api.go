@@ -3,16 +3,19 @@ package api
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/coreos/go-systemd/daemon"
 	"github.com/go-chi/chi/v5"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/im-kulikov/helium/service"
 	"github.com/im-kulikov/helium/settings"
 	"github.com/spf13/viper"
 	"go.uber.org/dig"
@@ -20,6 +23,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/metrics"
 	"github.com/archaron/go-yubiserv/misc"
 )
 
@@ -31,6 +35,19 @@ type (
 		Config   *viper.Viper
 		Settings *settings.Core
 		Storage  common.StorageInterface
+		Counters common.CounterStorage `optional:"true"`
+		Clients  common.ClientStorage  `optional:"true"`
+		Nonces   common.NonceCache     `optional:"true"`
+
+		HealthChecks []common.HealthChecker `group:"health_checks"`
+		Metrics      *prometheus.Registry   `optional:"true"`
+	}
+
+	serviceOutParams struct {
+		dig.Out
+
+		Service  service.Service `group:"services"`
+		Counters common.CounterAdmin
 	}
 
 	// Service represents API service.
@@ -44,15 +61,35 @@ type (
 		settings    *settings.Core
 		gmtLocation *time.Location
 		storage     common.StorageInterface
+		counters    common.CounterStorage
 
 		cancel context.CancelFunc
 
-		apiKey  []byte
+		clients      common.ClientStorage
+		testClientID uint64
+		nonces       common.NonceCache
+		healthChecks []common.HealthChecker
+
+		metrics        *prometheus.Registry
+		responses      *prometheus.CounterVec
+		decryptLatency *prometheus.HistogramVec
+
 		timeout time.Duration
 		cert    string
 		key     string
 
-		Users common.OTPUsers
+		// Deadline of a single key store lookup
+		lookupTimeout time.Duration
+
+		// DELAYED_OTP detection tolerances
+		tsAbsTolerance time.Duration
+		tsRelTolerance float64
+
+		// Users holds the last accepted counters, guarded by usersMu.
+		// Check-and-update of a single key is serialized with locks.
+		Users   common.OTPUsers
+		usersMu sync.RWMutex
+		locks   keyLocks
 	}
 )
 
@@ -61,6 +98,7 @@ func (s *Service) newRouter() http.Handler {
 	r.Get("/version", s.version)
 	r.Get("/health", s.health)
 	r.Get("/readiness", s.readiness)
+	r.Method(http.MethodGet, "/metrics", metrics.Handler(s.metrics))
 
 	r.Get("/wsapi/2.0/verify", s.verifyHandler)
 	r.Get("/", s.testHandler)
@@ -68,19 +106,6 @@ func (s *Service) newRouter() http.Handler {
 	return r
 }
 
-func makeAPIKey(secret string) ([]byte, error) {
-	if secret == "" {
-		return nil, nil
-	}
-
-	key, err := base64.StdEncoding.DecodeString(secret)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode api key: %w", err)
-	}
-
-	return key, nil
-}
-
 // Printf function for HTTP debug log.
 func (s *Service) Printf(format string, args ...interface{}) {
 	if misc.Debug {
@@ -197,9 +222,14 @@ func (s *Service) Watchdog(ctx context.Context) func() error {
 
 				return errors.Wrap(context.Cause(ctx), "watchdog")
 			case <-timer.C:
+				alive, status := s.watchdogStatus(ctx)
+				if alive {
+					status = daemon.SdNotifyWatchdog + "\n" + status
+				} else {
+					s.log.Warn("API server is down, skipping systemd alive notify", zap.String("status", status))
+				}
 
-				_, err = daemon.SdNotify(false, daemon.SdNotifyWatchdog)
-				if err != nil {
+				if _, err = daemon.SdNotify(false, status); err != nil {
 					s.log.Info("error sending systemd alive notify", zap.Error(err))
 				}
 
@@ -208,3 +238,41 @@ func (s *Service) Watchdog(ctx context.Context) func() error {
 		}
 	}
 }
+
+// watchdogStatus returns whether systemd keepalive is to be sent and the status for systemd. Failed health checks,
+// like a Vault outage, only degrade the status, since a restart would not fix them and would drop the cached keys.
+// Keepalive is withheld only when the API server itself does not accept connections.
+func (s *Service) watchdogStatus(ctx context.Context) (bool, string) {
+	if err := s.checkServer(ctx); err != nil {
+		return false, "STATUS=failed: " + err.Error()
+	}
+
+	if _, err := s.checkHealth(ctx); err != nil {
+		s.log.Warn("health check failed", zap.Error(err))
+
+		return true, "STATUS=degraded: " + strings.ReplaceAll(err.Error(), "\n", "; ")
+	}
+
+	return true, "STATUS=ok"
+}
+
+// checkServer checks that the API server accepts connections on its address.
+func (s *Service) checkServer(ctx context.Context) error {
+	host, port, err := net.SplitHostPort(s.address)
+	if err != nil {
+		return fmt.Errorf("invalid API address: %w", err)
+	}
+
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+
+	dialer := net.Dialer{Timeout: s.timeout}
+
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return fmt.Errorf("API server does not accept connections: %w", err)
+	}
+
+	return conn.Close()
+}
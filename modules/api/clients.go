@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/viper"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+type (
+	// clientConfig is an api.clients config section item.
+	clientConfig struct {
+		ID          uint64 `mapstructure:"id"`
+		Secret      string `mapstructure:"secret"`
+		Active      *bool  `mapstructure:"active"` // Active by default
+		Description string `mapstructure:"description"`
+	}
+
+	// configClients is the API clients registry read from the api.clients config section.
+	configClients map[uint64]*common.Client
+
+	// legacyClients is used when no clients are configured: any client ID is accepted
+	// and the single api.secret key is used for all of them.
+	legacyClients struct {
+		secret string
+	}
+)
+
+// ErrDuplicateClient is returned when the same client ID is configured twice.
+var ErrDuplicateClient = errors.New("duplicate api client id")
+
+func newConfigClients(v *viper.Viper) (common.ClientStorage, error) {
+	var items []clientConfig
+
+	if err := v.UnmarshalKey("api.clients", &items); err != nil {
+		return nil, fmt.Errorf("cannot parse api clients: %w", err)
+	}
+
+	if len(items) == 0 {
+		legacy := &legacyClients{secret: v.GetString("api.secret")}
+
+		if _, err := (&common.Client{Secret: legacy.secret}).Key(); err != nil {
+			return nil, fmt.Errorf("cannot get api key: %w", err)
+		}
+
+		return legacy, nil
+	}
+
+	clients := make(configClients, len(items))
+
+	for _, item := range items {
+		if _, ok := clients[item.ID]; ok {
+			return nil, fmt.Errorf("%d: %w", item.ID, ErrDuplicateClient)
+		}
+
+		client := &common.Client{
+			ID:          item.ID,
+			Secret:      item.Secret,
+			Active:      item.Active == nil || *item.Active,
+			Description: item.Description,
+		}
+
+		if _, err := client.Key(); err != nil {
+			return nil, err
+		}
+
+		clients[item.ID] = client
+	}
+
+	return clients, nil
+}
+
+// GetClient returns configured client by ID.
+func (c configClients) GetClient(id uint64) (*common.Client, error) {
+	client, ok := c[id]
+	if !ok {
+		return nil, common.ErrClientNotFound
+	}
+
+	return client, nil
+}
+
+// GetClient returns a client with the global api.secret key for any ID.
+func (c *legacyClients) GetClient(id uint64) (*common.Client, error) {
+	return &common.Client{ID: id, Secret: c.secret, Active: true}, nil
+}
+
+// clientKey finds the API client of the request and returns its ID and HMAC key. When the request
+// must be rejected, a non-empty protocol status is returned along with the key to sign the response.
+func (s *Service) clientKey(rawID string) (uint64, []byte, string, error) {
+	id, err := strconv.ParseUint(rawID, 10, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, nil, ResponseCodeNoSuchClient, common.ErrClientNotFound
+		}
+
+		return 0, nil, ResponseCodeMissingParameter, fmt.Errorf("invalid client id: %w", err)
+	}
+
+	client, err := s.clients.GetClient(id)
+	if err != nil {
+		if errors.Is(err, common.ErrClientNotFound) {
+			return id, nil, ResponseCodeNoSuchClient, err
+		}
+
+		return id, nil, ResponseCodeBackendError, err
+	}
+
+	key, err := client.Key()
+	if err != nil {
+		return id, nil, ResponseCodeBackendError, err
+	}
+
+	if !client.Active {
+		return id, key, ResponseCodeOperationNotAllowed, nil
+	}
+
+	return id, key, "", nil
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+const testSecret2 = "ynS/XoXc2gwGDBssYSu2w21Aky4="
+
+func Test_newConfigClients(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should fall back to global api secret", func(t *testing.T) {
+		t.Parallel()
+
+		v := viper.New()
+		v.Set("api.secret", testSecret)
+
+		clients, err := newConfigClients(v)
+		require.NoError(t, err)
+
+		client, err := clients.GetClient(12345)
+		require.NoError(t, err)
+		require.Equal(t, &common.Client{ID: 12345, Secret: testSecret, Active: true}, client)
+	})
+
+	t.Run("should error on invalid global api secret", func(t *testing.T) {
+		t.Parallel()
+
+		v := viper.New()
+		v.Set("api.secret", "not base64!")
+
+		_, err := newConfigClients(v)
+		require.ErrorContains(t, err, "cannot get api key")
+	})
+
+	t.Run("should read clients registry", func(t *testing.T) {
+		t.Parallel()
+
+		v := viper.New()
+		v.Set("api.secret", testSecret)
+		v.Set("api.clients", []map[string]interface{}{
+			{"id": 1, "secret": testSecret, "description": "first"},
+			{"id": 2, "secret": testSecret2, "active": false},
+			{"id": 3},
+		})
+
+		clients, err := newConfigClients(v)
+		require.NoError(t, err)
+
+		client, err := clients.GetClient(1)
+		require.NoError(t, err)
+		require.Equal(t, &common.Client{ID: 1, Secret: testSecret, Active: true, Description: "first"}, client)
+
+		client, err = clients.GetClient(2)
+		require.NoError(t, err)
+		require.False(t, client.Active)
+
+		client, err = clients.GetClient(3)
+		require.NoError(t, err)
+
+		key, err := client.Key()
+		require.NoError(t, err)
+		require.Nil(t, key)
+
+		_, err = clients.GetClient(4)
+		require.ErrorIs(t, err, common.ErrClientNotFound)
+	})
+
+	t.Run("should error on duplicate client", func(t *testing.T) {
+		t.Parallel()
+
+		v := viper.New()
+		v.Set("api.clients", []map[string]interface{}{{"id": 1}, {"id": 1}})
+
+		_, err := newConfigClients(v)
+		require.ErrorIs(t, err, ErrDuplicateClient)
+	})
+
+	t.Run("should error on invalid client secret", func(t *testing.T) {
+		t.Parallel()
+
+		v := viper.New()
+		v.Set("api.clients", []map[string]interface{}{{"id": 1, "secret": "not base64!"}})
+
+		_, err := newConfigClients(v)
+		require.ErrorContains(t, err, "failed to decode client 1 key")
+	})
+}
+
+func Test_verifyClients(t *testing.T) {
+	t.Parallel()
+
+	newService := func(t *testing.T) *Service {
+		t.Helper()
+
+		svc := createTestService(t, &testStorage{})
+		svc.clients = configClients{
+			1: {ID: 1, Secret: testSecret, Active: true},
+			2: {ID: 2, Secret: testSecret2, Active: true},
+			3: {ID: 3, Secret: testSecret2, Active: false},
+		}
+
+		return svc
+	}
+
+	query := func(id string) url.Values {
+		return url.Values{
+			"id":    []string{id},
+			"otp":   []string{"cccccccccccbiucvrkjiegbhidrcicvlgrcgkgurhjnj"},
+			"nonce": []string{"jrFwbaYFhn0HoxZIsd9LQ6w2ceU"},
+		}
+	}
+
+	t.Run("should verify and sign with client key", func(t *testing.T) {
+		t.Parallel()
+
+		values := decodedRequest(t, signedQueryWith(t, query("2"), testSecret2), newService(t).verifyHandler)
+		require.Equal(t, "OK", values["status"])
+		requireSigned(t, values, testSecret2)
+	})
+
+	t.Run("should reject other client key", func(t *testing.T) {
+		t.Parallel()
+
+		values := decodedRequest(t, signedQueryWith(t, query("2"), testSecret), newService(t).verifyHandler)
+		require.Equal(t, "BAD_SIGNATURE", values["status"])
+		requireSigned(t, values, testSecret2)
+	})
+
+	t.Run("should reject unknown client", func(t *testing.T) {
+		t.Parallel()
+
+		for _, id := range []string{"4", "99999999999999999999999"} {
+			values := decodedRequest(t, signedQuery(t, query(id)), newService(t).verifyHandler)
+			require.Equal(t, "NO_SUCH_CLIENT", values["status"])
+			require.NotContains(t, values, "h")
+		}
+	})
+
+	t.Run("should reject disabled client", func(t *testing.T) {
+		t.Parallel()
+
+		values := decodedRequest(t, signedQueryWith(t, query("3"), testSecret2), newService(t).verifyHandler)
+		require.Equal(t, "OPERATION_NOT_ALLOWED", values["status"])
+		requireSigned(t, values, testSecret2)
+	})
+
+	t.Run("should reject malformed client id", func(t *testing.T) {
+		t.Parallel()
+
+		values := decodedRequest(t, signedQuery(t, query(" 1")), newService(t).verifyHandler)
+		require.Equal(t, "MISSING_PARAMETER", values["status"])
+	})
+}
+
+// requireSigned checks the response signature.
+func requireSigned(t *testing.T, values map[string]string, secret string) {
+	t.Helper()
+
+	require.Contains(t, values, "h")
+
+	key, err := base64.StdEncoding.DecodeString(secret)
+	require.NoError(t, err)
+
+	data := make([]string, 0, len(values))
+
+	for k, v := range values {
+		if k != "h" {
+			data = append(data, k+"="+v)
+		}
+	}
+
+	sort.Strings(data)
+	require.Equal(t, values["h"], common.SignMapToBase64(data, key), strings.Join(data, "&"))
+}
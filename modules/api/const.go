@@ -35,10 +35,4 @@ const (
 	ResponseCodeReplayedRequest = "REPLAYED_REQUEST"
 )
 
-var (
-	_ = ResponseCodeNotEnoughAnswers
-	_ = ResponseCodeReplayedRequest
-	_ = ResponseCodeOperationNotAllowed
-	_ = ResponseCodeDelayedOTP
-	_ = ResponseCodeBackendError
-)
+var _ = ResponseCodeNotEnoughAnswers
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"time"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+// timestampMask limits timestamp counter arithmetic to 24 bits, as the counter wraps around.
+const timestampMask = 0xffffff
+
+// isDelayed implements ykval-style phishing detection. Within a single power-up session
+// (same usage counter) the YubiKey timestamp counter advances at 8 Hz, so the time between
+// two OTPs measured by the key must match the wall-clock time between their verifications.
+// A large drift means the OTP was generated long before it was submitted.
+//
+// OTP is delayed only when the deviation exceeds both the absolute and the relative tolerance.
+// Returns the delay flag and the measured deviation.
+func (s *Service) isDelayed(prev common.OTPUser, otp *common.OTP, now time.Time) (bool, time.Duration) {
+	if prev.Seen.IsZero() || prev.UsageCounter != otp.UsageCounter {
+		return false, 0
+	}
+
+	elapsed := now.Sub(prev.Seen)
+	if elapsed <= 0 {
+		return false, 0
+	}
+
+	ticks := (otp.Timestamp() - common.TimestampValue(prev.Timestamp)) & timestampMask
+	deviation := (elapsed - time.Duration(ticks)*common.TimestampTick).Abs()
+
+	return deviation > s.tsAbsTolerance && float64(deviation)/float64(elapsed) > s.tsRelTolerance, deviation
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+func Test_isDelayed(t *testing.T) {
+	t.Parallel()
+
+	svc := &Service{tsAbsTolerance: 20 * time.Second, tsRelTolerance: 0.3}
+	now := time.Now()
+
+	testCases := []struct {
+		name    string
+		prev    common.OTPUser
+		otp     common.OTP
+		delayed bool
+	}{
+		{
+			name: "first OTP of the key",
+			prev: common.OTPUser{UsageCounter: 1},
+			otp:  common.OTP{UsageCounter: 1, SessionCounter: 1},
+		},
+		{
+			name: "new power-up session",
+			prev: common.OTPUser{UsageCounter: 1, Seen: now.Add(-time.Hour)},
+			otp:  common.OTP{UsageCounter: 2},
+		},
+		{
+			name: "timestamp matches wall clock",
+			prev: common.OTPUser{UsageCounter: 1, Timestamp: common.TimestampBytes(1000), Seen: now.Add(-time.Hour)},
+			otp:  common.OTP{UsageCounter: 1, TimestampCounter: common.TimestampBytes(1000 + 3600*8)},
+		},
+		{
+			name: "small absolute deviation",
+			prev: common.OTPUser{UsageCounter: 1, Timestamp: common.TimestampBytes(1000), Seen: now.Add(-10 * time.Second)},
+			otp:  common.OTP{UsageCounter: 1, TimestampCounter: common.TimestampBytes(1008)},
+		},
+		{
+			name: "small relative deviation",
+			prev: common.OTPUser{UsageCounter: 1, Timestamp: common.TimestampBytes(1000), Seen: now.Add(-time.Hour)},
+			otp:  common.OTP{UsageCounter: 1, TimestampCounter: common.TimestampBytes(1000 + 3000*8)},
+		},
+		{
+			name:    "OTP generated long before submission",
+			prev:    common.OTPUser{UsageCounter: 1, Timestamp: common.TimestampBytes(1000), Seen: now.Add(-time.Hour)},
+			otp:     common.OTP{UsageCounter: 1, TimestampCounter: common.TimestampBytes(1008)},
+			delayed: true,
+		},
+		{
+			name: "timestamp counter wrap",
+			prev: common.OTPUser{UsageCounter: 1, Timestamp: common.TimestampBytes(0xffffff), Seen: now.Add(-time.Minute)},
+			otp:  common.OTP{UsageCounter: 1, TimestampCounter: common.TimestampBytes(60*8 - 1)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			delayed, _ := svc.isDelayed(tc.prev, &tc.otp, now)
+			require.Equal(t, tc.delayed, delayed)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -10,6 +11,8 @@ import (
 )
 
 func (s *Service) responseW(w http.ResponseWriter, status string, apiKey []byte, extra map[string]string) error {
+	s.responses.WithLabelValues(status).Inc()
+
 	// Create ordered pieces
 	ordered := make([]string, 0)
 
@@ -24,6 +27,8 @@ func (s *Service) responseW(w http.ResponseWriter, status string, apiKey []byte,
 		ordered = append([]string{"h=" + common.SignMapToBase64(ordered, apiKey)}, ordered...) // Add signature
 	}
 
+	w.WriteHeader(httpStatus(status))
+
 	_, err := fmt.Fprint(w, strings.Join(ordered, "\r\n")+"\r\n")
 	if err != nil {
 		return fmt.Errorf("error writing response: %w", err)
@@ -31,3 +36,34 @@ func (s *Service) responseW(w http.ResponseWriter, status string, apiKey []byte,
 
 	return nil
 }
+
+// httpStatus returns HTTP status code for the response with given protocol status.
+// Verification outcomes are sent with 200 OK, only a failing backend (worth retrying
+// on another server) and a forbidden operation (worth alerting) differ.
+func httpStatus(status string) int {
+	switch status {
+	case ResponseCodeBackendError:
+		return http.StatusServiceUnavailable
+	case ResponseCodeOperationNotAllowed:
+		return http.StatusForbidden
+	default:
+		return http.StatusOK
+	}
+}
+
+// storageErrorStatus maps the key storage error to the protocol status.
+// Unclassified errors are treated as backend failures, as nothing is known about the OTP.
+func storageErrorStatus(err error) string {
+	switch {
+	case errors.Is(err, common.ErrStorageNoKey):
+		return ResponseCodeNoSuchClient
+	case errors.Is(err, common.ErrStorageKeyInactive):
+		return ResponseCodeOperationNotAllowed
+	case errors.Is(err, common.ErrStorageDecryptFail),
+		errors.Is(err, common.ErrInvalidCRC),
+		errors.Is(err, common.ErrInvalidLength):
+		return ResponseCodeBadOTP
+	default:
+		return ResponseCodeBackendError
+	}
+}
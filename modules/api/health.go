@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+)
+
+type (
+	// componentHealth is a single health check result.
+	componentHealth struct {
+		Status string `json:"status"`
+		Error  string `json:"error,omitempty"`
+	}
+
+	readinessResponse struct {
+		Status     string                     `json:"status"`
+		Components map[string]componentHealth `json:"components,omitempty"`
+	}
+)
+
+// checkHealth runs health checks of all components, returns per-component results
+// and an error joining all failures.
+func (s *Service) checkHealth(ctx context.Context) (map[string]componentHealth, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	var (
+		components = make(map[string]componentHealth, len(s.healthChecks))
+		errs       []error
+	)
+
+	for _, check := range s.healthChecks {
+		if err := check.CheckHealth(ctx); err != nil {
+			components[check.Name()] = componentHealth{Status: "error", Error: err.Error()}
+			errs = append(errs, fmt.Errorf("%s: %w", check.Name(), err))
+
+			continue
+		}
+
+		components[check.Name()] = componentHealth{Status: "ok"}
+	}
+
+	return components, errors.Join(errs...)
+}
+
+func (s *Service) readiness(w http.ResponseWriter, r *http.Request) {
+	components, err := s.checkHealth(r.Context())
+
+	resp := readinessResponse{Status: "ok", Components: components}
+
+	if err != nil {
+		resp.Status = "degraded"
+
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+
+	render.JSON(w, r, resp)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+type testHealthCheck struct {
+	name string
+	err  error
+}
+
+func (c *testHealthCheck) Name() string { return c.name }
+
+func (c *testHealthCheck) CheckHealth(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.err
+}
+
+func Test_readiness(t *testing.T) {
+	t.Parallel()
+
+	readiness := func(t *testing.T, svc *Service) (int, string) {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		svc.readiness(rec, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+
+		return rec.Code, rec.Body.String()
+	}
+
+	t.Run("should be ready when all components are healthy", func(t *testing.T) {
+		t.Parallel()
+
+		svc := createTestService(t, &testStorage{})
+		svc.healthChecks = []common.HealthChecker{
+			&testHealthCheck{name: "sqlite-keys-storage"},
+			&testHealthCheck{name: "sqlite-counters-storage"},
+		}
+
+		code, body := readiness(t, svc)
+		require.Equal(t, http.StatusOK, code)
+		require.JSONEq(t, `{"status":"ok","components":{
+			"sqlite-keys-storage":{"status":"ok"},
+			"sqlite-counters-storage":{"status":"ok"}
+		}}`, body)
+	})
+
+	t.Run("should report degraded components", func(t *testing.T) {
+		t.Parallel()
+
+		svc := createTestService(t, &testStorage{})
+		svc.healthChecks = []common.HealthChecker{
+			&testHealthCheck{name: "sqlite-counters-storage"},
+			&testHealthCheck{name: "vault-keys-storage", err: errors.New("permission denied")},
+		}
+
+		code, body := readiness(t, svc)
+		require.Equal(t, http.StatusServiceUnavailable, code)
+		require.JSONEq(t, `{"status":"degraded","components":{
+			"sqlite-counters-storage":{"status":"ok"},
+			"vault-keys-storage":{"status":"error","error":"permission denied"}
+		}}`, body)
+
+		_, err := svc.checkHealth(context.Background())
+		require.EqualError(t, err, "vault-keys-storage: permission denied")
+	})
+}
+
+func Test_watchdogStatus(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(srv.Close)
+
+	svc := createTestService(t, &testStorage{})
+	svc.address = srv.Listener.Addr().String()
+	svc.healthChecks = []common.HealthChecker{&testHealthCheck{name: "sqlite-counters-storage"}}
+
+	alive, status := svc.watchdogStatus(context.Background())
+	require.True(t, alive)
+	require.Equal(t, "STATUS=ok", status)
+
+	// Remote outage degrades the status, keepalive is still sent to keep the cached keys
+	svc.healthChecks = append(svc.healthChecks, &testHealthCheck{name: "vault-keys-storage", err: errors.New("connection refused")})
+
+	alive, status = svc.watchdogStatus(context.Background())
+	require.True(t, alive)
+	require.Equal(t, "STATUS=degraded: vault-keys-storage: connection refused", status)
+
+	// Server not accepting connections is restarted by systemd
+	srv.Close()
+
+	alive, status = svc.watchdogStatus(context.Background())
+	require.False(t, alive)
+	require.Contains(t, status, "STATUS=failed: API server does not accept connections")
+}
@@ -33,6 +33,21 @@ type verifyReq struct {
 	OTP       string `query:"otp"`
 	Nonce     string `query:"nonce"`
 	Signature string `query:"h"`
+	Timestamp string `query:"timestamp"`
+	SL        string `query:"sl"`
+	Timeout   string `query:"timeout"`
+}
+
+// syncLevel returns numeric sync level percentage, "fast" and "secure" aliases are resolved.
+func (r *verifyReq) syncLevel() string {
+	switch r.SL {
+	case "fast":
+		return "1"
+	case "secure":
+		return "100"
+	default:
+		return r.SL
+	}
 }
 
 //nolint:forcetypeassert
@@ -71,6 +86,17 @@ func newVerifyRequestSchema(args url.Values, key []byte) *zog.StructSchema {
 			Min(common.NonceMinLength, zog.Message(ResponseCodeMissingParameter)).
 			Max(common.NonceMaxLength, zog.Message(ResponseCodeMissingParameter)).
 			Match(regexp.MustCompile(`(?m)^[a-zA-Z0-9]+$`), zog.Message(ResponseCodeMissingParameter)),
+		"Timestamp": zog.String().
+			Trim().
+			OneOf([]string{"0", "1"}, zog.Message(ResponseCodeMissingParameter)),
+		// Sync level: percentage 0-100 or "fast"/"secure"
+		"SL": zog.String().
+			Trim().
+			Match(regexp.MustCompile(`^(fast|secure|100|[1-9]?[0-9])$`), zog.Message(ResponseCodeMissingParameter)),
+		// Timeout in seconds
+		"Timeout": zog.String().
+			Trim().
+			Match(regexp.MustCompile(`^[0-9]{1,6}$`), zog.Message(ResponseCodeMissingParameter)),
 		"Signature": zog.String().
 			Trim().
 			Required(zog.Message(ResponseCodeMissingParameter), func(test internals.TestInterface) {
@@ -124,6 +150,37 @@ func newVerifyRequestSchema(args url.Values, key []byte) *zog.StructSchema {
 	})
 }
 
+// firstIssue picks the issue to report and its response status. Signature of a malformed
+// request cannot be meaningfully verified, so MISSING_PARAMETER takes precedence over BAD_SIGNATURE.
+// Any other issue (e.g. request parsing failure) is reported as MISSING_PARAMETER.
+func firstIssue(errs zog.ZogIssueList) (string, *zog.ZogIssue) {
+	var signature, other *zog.ZogIssue
+
+	for _, iv := range errs {
+		switch iv.Message {
+		case ResponseCodeMissingParameter:
+			return ResponseCodeMissingParameter, iv
+		case ResponseCodeBadSignature:
+			if signature == nil {
+				signature = iv
+			}
+		default:
+			if other == nil {
+				other = iv
+			}
+		}
+	}
+
+	switch {
+	case signature != nil:
+		return ResponseCodeBadSignature, signature
+	case other != nil:
+		return ResponseCodeMissingParameter, other
+	default:
+		return "", nil
+	}
+}
+
 func (s *Service) verifyHandler(w http.ResponseWriter, r *http.Request) {
 	log := s.log.With(zap.String("method", "verify"))
 
@@ -131,23 +188,51 @@ func (s *Service) verifyHandler(w http.ResponseWriter, r *http.Request) {
 
 	extra := make(map[string]string)
 
-	schema := newVerifyRequestSchema(r.URL.Query(), s.apiKey)
+	// Client key is required to check the request signature and to sign the response
+	clientID, apiKey, status, err := s.clientKey(r.URL.Query().Get("id"))
+	if status != "" {
+		log.Debug("api client rejected", zap.String("status", status), zap.Error(err))
+
+		if errResp := s.responseW(w, status, apiKey, extra); errResp != nil {
+			log.Error("could not send response", zap.Error(errResp))
+		}
+
+		return
+	}
+
+	schema := newVerifyRequestSchema(r.URL.Query(), apiKey)
 
 	errs := schema.Parse(zhttp.Request(r), &req)
 
-	if len(errs) != 0 {
-		for _, iv := range errs {
-			if message := iv.Message; message != "" {
-				if errResp := s.responseW(w, message, s.apiKey, extra); errResp != nil {
-					log.Error("error sending backend error response", zap.Error(errResp))
-				}
+	if code, iv := firstIssue(errs); iv != nil {
+		if errResp := s.responseW(w, code, apiKey, extra); errResp != nil {
+			log.Error("error sending backend error response", zap.Error(errResp))
+		}
+
+		log.Debug("message", zap.Strings("field", iv.Path), zap.Error(iv))
 
-				log.Debug("message", zap.Strings("field", iv.Path), zap.Error(iv))
-				return
+		return
+	}
+
+	// Only signed requests are recorded, so that nobody could burn nonces of other clients.
+	if apiKey != nil && s.nonces != nil {
+		seen, errNonce := s.nonces.Seen(clientID, req.Nonce)
+		if errNonce != nil || seen {
+			code := ResponseCodeReplayedRequest
+			if errNonce != nil {
+				code = ResponseCodeBackendError
+
+				log.Error("could not check request nonce", zap.Error(errNonce))
+			} else {
+				log.Warn("replayed request nonce", zap.Uint64("client_id", clientID), zap.String("nonce", req.Nonce))
 			}
 
-		}
+			if errResp := s.responseW(w, code, apiKey, extra); errResp != nil {
+				log.Error("could not send response", zap.Error(errResp))
+			}
 
+			return
+		}
 	}
 
 	// Ok, all checks done, let's try OTP verify
@@ -159,7 +244,7 @@ func (s *Service) verifyHandler(w http.ResponseWriter, r *http.Request) {
 	if len(matches) != 1 || len(matches[0]) != 3 {
 		log.Error("invalid OTP format, cannot extract client ID and hash", zap.String("otp", req.OTP))
 
-		if err := s.responseW(w, ResponseCodeBadOTP, s.apiKey, extra); err != nil {
+		if err := s.responseW(w, ResponseCodeBadOTP, apiKey, extra); err != nil {
 			log.Error("could not send response", zap.Error(err))
 		}
 
@@ -173,58 +258,98 @@ func (s *Service) verifyHandler(w http.ResponseWriter, r *http.Request) {
 
 	log = log.With(zap.String("id", publicID))
 
-	otpData, err := s.storage.DecryptOTP(publicID, matches[0][2])
+	otpData, err := s.decryptOTP(r.Context(), publicID, matches[0][2])
 	if err != nil {
-		log.Error("error decrypting OTP", zap.Error(err))
+		code := storageErrorStatus(err)
+		if code == ResponseCodeBackendError {
+			log.Error("key storage failure", zap.Error(err))
+		} else {
+			log.Warn("OTP rejected", zap.String("status", code), zap.Error(err))
+		}
+
+		if err = s.responseW(w, code, apiKey, extra); err != nil {
+			log.Error("could not send response", zap.Error(err))
+		}
+
+		return
+	}
 
-		if errors.Is(err, common.ErrStorageNoKey) {
+	// Check and update of the counters must be atomic for the key, otherwise
+	// concurrent requests carrying the same OTP could all pass the check.
+	unlock := s.locks.lock(publicID)
+	defer unlock()
 
-			if err = s.responseW(w, ResponseCodeNoSuchClient, s.apiKey, extra); err != nil {
+	prev, known := s.getUser(publicID)
+	if known {
+		log.Debug("existing OTP user", zap.Any("data", prev))
+
+		if (prev.UsageCounter > otpData.UsageCounter) ||
+			(prev.UsageCounter == otpData.UsageCounter && prev.SessionCounter >= otpData.SessionCounter) {
+			log.Warn("saved counters >= OTP decoded counters, rejecting",
+				zap.Uint8("saved_session_counter", prev.SessionCounter),
+				zap.Uint8("otp_session_counter", otpData.SessionCounter),
+				zap.Uint16("saved_usage_counter", prev.UsageCounter),
+				zap.Uint16("otp_usage_counter", otpData.UsageCounter),
+			)
+
+			if err = s.responseW(w, ResponseCodeReplayedOTP, apiKey, extra); err != nil {
 				log.Error("could not send response", zap.Error(err))
 			}
 
 			return
 		}
+	} else {
+		log.Debug("add new OTP user")
+	}
 
-		if err = s.responseW(w, ResponseCodeBadOTP, s.apiKey, extra); err != nil {
-			log.Error("could not send response", zap.Error(err))
-		}
+	now := time.Now()
 
-		return
+	user := &common.OTPUser{
+		UsageCounter:   otpData.UsageCounter,
+		SessionCounter: otpData.SessionCounter,
+		Timestamp:      otpData.TimestampCounter,
+		Seen:           now,
 	}
 
-	if user, ok := s.Users[publicID]; !ok {
-		// If no user found in memory storage, create one
-		s.Users[publicID] = &common.OTPUser{
-			UsageCounter:   otpData.UsageCounter,
-			SessionCounter: otpData.SessionCounter,
-			Timestamp:      otpData.TimestampCounter,
+	// Counters must be saved before answering OK, otherwise the OTP could be replayed after restart
+	if s.counters != nil {
+		if err = s.counters.StoreCounter(publicID, user); err != nil {
+			log.Error("could not save OTP counters", zap.Error(err))
+
+			if err = s.responseW(w, ResponseCodeBackendError, apiKey, extra); err != nil {
+				log.Error("could not send response", zap.Error(err))
+			}
+
+			return
 		}
+	}
 
-		log.Debug("add new OTP user")
-	} else {
-		log.Debug("existing OTP user", zap.Any("data", user))
+	s.setUser(publicID, user)
 
-		if (user.UsageCounter > otpData.UsageCounter) ||
-			(user.UsageCounter == otpData.UsageCounter && user.SessionCounter >= otpData.SessionCounter) {
-			log.Warn("saved counters >= OTP decoded counters, rejecting",
-				zap.Uint8("saved_session_counter", user.SessionCounter),
-				zap.Uint8("otp_session_counter", otpData.SessionCounter),
-				zap.Uint16("saved_usage_counter", user.UsageCounter),
-				zap.Uint16("otp_usage_counter", otpData.UsageCounter),
+	// The OTP is consumed at this point even if it was delayed, so it cannot be retried.
+	if known {
+		if delayed, deviation := s.isDelayed(prev, otpData, now); delayed {
+			log.Warn("OTP timestamp deviates from wall-clock time, rejecting",
+				zap.Duration("deviation", deviation),
+				zap.Time("saved_seen", prev.Seen),
 			)
 
-			if err = s.responseW(w, ResponseCodeReplayedOTP, s.apiKey, extra); err != nil {
+			if err = s.responseW(w, ResponseCodeDelayedOTP, apiKey, extra); err != nil {
 				log.Error("could not send response", zap.Error(err))
 			}
 
 			return
 		}
+	}
 
-		// Save current counter
-		user.UsageCounter = otpData.UsageCounter
-		user.Timestamp = otpData.TimestampCounter
-		user.SessionCounter = otpData.SessionCounter
+	if req.Timestamp == "1" {
+		extra["timestamp"] = strconv.FormatUint(uint64(otpData.Timestamp()), 10)
+		extra["sessioncounter"] = strconv.FormatUint(uint64(otpData.UsageCounter), 10)
+		extra["sessionuse"] = strconv.FormatUint(uint64(otpData.SessionCounter), 10)
+	}
+
+	if req.SL != "" {
+		extra["sl"] = req.syncLevel()
 	}
 
 	log.Debug("otp decoded, access granted",
@@ -232,7 +357,7 @@ func (s *Service) verifyHandler(w http.ResponseWriter, r *http.Request) {
 		zap.String("otp", otpData.String()),
 	)
 
-	if err = s.responseW(w, ResponseCodeOK, s.apiKey, extra); err != nil {
+	if err = s.responseW(w, ResponseCodeOK, apiKey, extra); err != nil {
 		log.Error("could not send response", zap.Error(err))
 	}
 
@@ -252,12 +377,6 @@ func (s *Service) health(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (s *Service) readiness(w http.ResponseWriter, r *http.Request) {
-	render.JSON(w, r, map[string]interface{}{
-		"status": "ok",
-	})
-}
-
 // TestResponseParams used for a report test result.
 type TestResponseParams struct {
 	Result string
@@ -299,14 +418,25 @@ func (s *Service) testVerifyRequest(r *http.Request) (string, error) {
 		return "", fmt.Errorf("could not generate nonce: %w", err)
 	}
 
+	client, err := s.clients.GetClient(s.testClientID)
+	if err != nil {
+		return "", fmt.Errorf("could not get test api client: %w", err)
+	}
+
+	apiKey, err := client.Key()
+	if err != nil {
+		return "", err
+	}
+
 	data := []string{
-		"id=" + strconv.FormatInt(time.Now().Unix(), 10),
+		"id=" + strconv.FormatUint(client.ID, 10),
 		"otp=" + otp,
 		"nonce=" + hex.EncodeToString(buf),
 	}
 
-	sign := common.SignMapToBase64(data, s.apiKey)
-	data = append(data, "h="+url.QueryEscape(sign))
+	if apiKey != nil {
+		data = append(data, "h="+url.QueryEscape(common.SignMapToBase64(data, apiKey)))
+	}
 
 	rec := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodGet, "/?"+strings.Join(data, "&"), nil)
@@ -1,13 +1,16 @@
 package api
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -16,12 +19,15 @@ import (
 	"go.uber.org/zap/zaptest"
 
 	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/metrics"
 	"github.com/archaron/go-yubiserv/misc"
 )
 
+const testSecret = "mG5be6ZJU1qBGz24yPh/ESM3UdU="
+
 type testStorage struct{}
 
-func (s *testStorage) DecryptOTP(publicID, token string) (*common.OTP, error) {
+func (s *testStorage) DecryptOTP(_ context.Context, publicID, token string) (*common.OTP, error) {
 	if publicID != "cccccccccccb" {
 		return nil, common.ErrStorageNoKey
 	}
@@ -46,6 +52,48 @@ func (s *testStorage) DecryptOTP(publicID, token string) (*common.OTP, error) {
 	return otp, nil
 }
 
+type testCounters struct {
+	sync.Mutex
+
+	users common.OTPUsers
+	err   error
+	delay time.Duration
+}
+
+func (c *testCounters) LoadCounters() (common.OTPUsers, error) {
+	return c.users, nil
+}
+
+func (c *testCounters) StoreCounter(publicID string, user *common.OTPUser) error {
+	// Emulate slow durable write
+	time.Sleep(c.delay)
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+
+	u := *user
+	c.users[publicID] = &u
+
+	return nil
+}
+
+func (c *testCounters) DeleteCounter(publicID string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+
+	delete(c.users, publicID)
+
+	return nil
+}
+
 func Test_verify(t *testing.T) {
 	t.Parallel()
 
@@ -146,6 +194,303 @@ func Test_verify(t *testing.T) {
 	})
 }
 
+type failingStorage struct {
+	err error
+}
+
+func (s *failingStorage) DecryptOTP(_ context.Context, _, _ string) (*common.OTP, error) {
+	return nil, s.err
+}
+
+func Test_verifyStorageErrors(t *testing.T) {
+	t.Parallel()
+
+	q := url.Values{
+		"id":    []string{"1"},
+		"otp":   []string{"cccccccccccbiucvrkjiegbhidrcicvlgrcgkgurhjnj"},
+		"nonce": []string{"jrFwbaYFhn0HoxZIsd9LQ6w2ceU"},
+	}
+
+	cases := []struct {
+		name   string
+		err    error
+		status string
+		code   int
+	}{
+		{"no key", common.ErrStorageNoKey, "NO_SUCH_CLIENT", http.StatusOK},
+		{"inactive key", common.ErrStorageKeyInactive, "OPERATION_NOT_ALLOWED", http.StatusForbidden},
+		{"decrypt failure", common.ErrStorageDecryptFail, "BAD_OTP", http.StatusOK},
+		{"crc failure", fmt.Errorf("%w: %w", common.ErrStorageDecryptFail, common.ErrInvalidCRC), "BAD_OTP", http.StatusOK},
+		{"backend failure", fmt.Errorf("%w: connection refused", common.ErrStorageBackend), "BACKEND_ERROR", http.StatusServiceUnavailable},
+		{"unclassified failure", errors.New("unexpected"), "BACKEND_ERROR", http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := createTestService(t, &failingStorage{err: tc.err})
+
+			rec := recordRequest(t, svc.verifyHandler, signedQuery(t, q))
+			require.Equal(t, tc.code, rec.Code)
+			require.Equal(t, tc.status, decodeAnswer(t, rec.Body.String())["status"])
+		})
+	}
+}
+
+type slowStorage struct{}
+
+func (s *slowStorage) DecryptOTP(ctx context.Context, _, _ string) (*common.OTP, error) {
+	<-ctx.Done()
+
+	return nil, fmt.Errorf("%w: %w", common.ErrStorageBackend, ctx.Err())
+}
+
+func Test_verifyLookupTimeout(t *testing.T) {
+	t.Parallel()
+
+	svc := createTestService(t, &slowStorage{})
+	svc.lookupTimeout = 10 * time.Millisecond
+
+	rec := recordRequest(t, svc.verifyHandler, signedQuery(t, url.Values{
+		"id":    []string{"1"},
+		"otp":   []string{"cccccccccccbiucvrkjiegbhidrcicvlgrcgkgurhjnj"},
+		"nonce": []string{"jrFwbaYFhn0HoxZIsd9LQ6w2ceU"},
+	}))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "BACKEND_ERROR", decodeAnswer(t, rec.Body.String())["status"])
+}
+
+func Test_verifyCounters(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should persist counters before OK", func(t *testing.T) {
+		t.Parallel()
+
+		counters := &testCounters{users: make(common.OTPUsers)}
+
+		svc := createTestService(t, &testStorage{})
+		svc.counters = counters
+
+		values := decodedRequest(t, signedQuery(t, url.Values{
+			"id":    []string{"1"},
+			"otp":   []string{"cccccccccccbiucvrkjiegbhidrcicvlgrcgkgurhjnj"},
+			"nonce": []string{"jrFwbaYFhn0HoxZIsd9LQ6w2ceU"},
+		}), svc.verifyHandler)
+		require.Equal(t, "OK", values["status"])
+
+		require.Contains(t, counters.users, "cccccccccccb")
+
+		user := counters.users["cccccccccccb"]
+		require.Equal(t, uint16(1), user.UsageCounter)
+		require.Equal(t, [3]byte{0x24, 0x13, 0xa7}, user.Timestamp)
+		require.WithinDuration(t, time.Now(), user.Seen, time.Minute)
+	})
+
+	t.Run("should reject OTP replayed after restart", func(t *testing.T) {
+		t.Parallel()
+
+		svc := createTestService(t, &testStorage{})
+		svc.Users = common.OTPUsers{
+			"cccccccccccb": {UsageCounter: 1, Timestamp: [3]byte{0x24, 0x13, 0xa7}},
+		}
+
+		values := decodedRequest(t, signedQuery(t, url.Values{
+			"id":    []string{"1"},
+			"otp":   []string{"cccccccccccbiucvrkjiegbhidrcicvlgrcgkgurhjnj"},
+			"nonce": []string{"jrFwbaYFhn0HoxZIsd9LQ6w2ceU"},
+		}), svc.verifyHandler)
+		require.Equal(t, "REPLAYED_OTP", values["status"])
+	})
+
+	t.Run("should return backend error when counters cannot be saved", func(t *testing.T) {
+		t.Parallel()
+
+		svc := createTestService(t, &testStorage{})
+		svc.counters = &testCounters{users: make(common.OTPUsers), err: errors.New("disk full")}
+
+		values := decodedRequest(t, signedQuery(t, url.Values{
+			"id":    []string{"1"},
+			"otp":   []string{"cccccccccccbiucvrkjiegbhidrcicvlgrcgkgurhjnj"},
+			"nonce": []string{"jrFwbaYFhn0HoxZIsd9LQ6w2ceU"},
+		}), svc.verifyHandler)
+		require.Equal(t, "BACKEND_ERROR", values["status"])
+		require.Empty(t, svc.Users)
+	})
+}
+
+func Test_verifyDelayed(t *testing.T) {
+	t.Parallel()
+
+	aesKey, err := hex.DecodeString("c4422890653076cde73d449b191b416a")
+	require.NoError(t, err)
+
+	otp := &common.OTP{
+		PrivateID:        [6]byte{0x33, 0xc6, 0x9e, 0x7f, 0x24, 0x9e},
+		UsageCounter:     1,
+		SessionCounter:   1,
+		TimestampCounter: common.TimestampBytes(0x2413a7 + 8),
+	}
+
+	token, err := otp.EncryptToModHex(aesKey)
+	require.NoError(t, err)
+
+	q := url.Values{
+		"id":    []string{"1"},
+		"otp":   []string{"cccccccccccb" + token},
+		"nonce": []string{"jrFwbaYFhn0HoxZIsd9LQ6w2ceU"},
+	}
+
+	t.Run("should reject OTP with delayed timestamp", func(t *testing.T) {
+		t.Parallel()
+
+		svc := createTestService(t, &testStorage{})
+		svc.Users = common.OTPUsers{
+			"cccccccccccb": {UsageCounter: 1, Timestamp: [3]byte{0x24, 0x13, 0xa7}, Seen: time.Now().Add(-time.Hour)},
+		}
+
+		values := decodedRequest(t, signedQuery(t, q), svc.verifyHandler)
+		require.Equal(t, "DELAYED_OTP", values["status"])
+
+		// OTP is consumed anyway
+		require.Equal(t, uint8(1), svc.Users["cccccccccccb"].SessionCounter)
+	})
+
+	t.Run("should accept OTP with matching timestamp", func(t *testing.T) {
+		t.Parallel()
+
+		svc := createTestService(t, &testStorage{})
+		svc.Users = common.OTPUsers{
+			"cccccccccccb": {UsageCounter: 1, Timestamp: [3]byte{0x24, 0x13, 0xa7}, Seen: time.Now().Add(-time.Second)},
+		}
+
+		values := decodedRequest(t, signedQuery(t, q), svc.verifyHandler)
+		require.Equal(t, "OK", values["status"])
+	})
+}
+
+func Test_verifyConcurrent(t *testing.T) {
+	t.Parallel()
+
+	const submissions = 50
+
+	svc := createTestService(t, &testStorage{})
+	svc.counters = &testCounters{users: make(common.OTPUsers), delay: 10 * time.Millisecond}
+
+	q := signedQuery(t, url.Values{
+		"id":    []string{"1"},
+		"otp":   []string{"cccccccccccbiucvrkjiegbhidrcicvlgrcgkgurhjnj"},
+		"nonce": []string{"jrFwbaYFhn0HoxZIsd9LQ6w2ceU"},
+	})
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses = make(map[string]int)
+	)
+
+	start := make(chan struct{})
+
+	for range submissions {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			<-start
+
+			rec := httptest.NewRecorder()
+			svc.verifyHandler(rec, httptest.NewRequest(http.MethodGet, "http://test/?"+q.Encode(), nil))
+
+			values := decodeAnswer(t, rec.Body.String())
+
+			mu.Lock()
+			statuses[values["status"]]++
+			mu.Unlock()
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+
+	require.Equal(t, map[string]int{"OK": 1, "REPLAYED_OTP": submissions - 1}, statuses)
+}
+
+func Test_verifyProtocolParams(t *testing.T) {
+	t.Parallel()
+
+	query := func(extra url.Values) url.Values {
+		q := url.Values{
+			"id":    []string{"1"},
+			"otp":   []string{"cccccccccccbiucvrkjiegbhidrcicvlgrcgkgurhjnj"},
+			"nonce": []string{"jrFwbaYFhn0HoxZIsd9LQ6w2ceU"},
+		}
+
+		for k, v := range extra {
+			q[k] = v
+		}
+
+		return signedQuery(t, q)
+	}
+
+	t.Run("should return timestamp and session counters", func(t *testing.T) {
+		t.Parallel()
+
+		svc := createTestService(t, &testStorage{})
+
+		values := decodedRequest(t, query(url.Values{"timestamp": []string{"1"}}), svc.verifyHandler)
+		require.Equal(t, "OK", values["status"])
+		require.Equal(t, "2364327", values["timestamp"])
+		require.Equal(t, "1", values["sessioncounter"])
+		require.Equal(t, "0", values["sessionuse"])
+		require.NotContains(t, values, "sl")
+	})
+
+	t.Run("should not return timestamp when not requested", func(t *testing.T) {
+		t.Parallel()
+
+		svc := createTestService(t, &testStorage{})
+
+		values := decodedRequest(t, query(url.Values{"timestamp": []string{"0"}}), svc.verifyHandler)
+		require.Equal(t, "OK", values["status"])
+		require.NotContains(t, values, "timestamp")
+		require.NotContains(t, values, "sessioncounter")
+		require.NotContains(t, values, "sessionuse")
+	})
+
+	for sl, expected := range map[string]string{"0": "0", "25": "25", "100": "100", "fast": "1", "secure": "100"} {
+		t.Run("should echo sync level "+sl, func(t *testing.T) {
+			t.Parallel()
+
+			svc := createTestService(t, &testStorage{})
+
+			values := decodedRequest(t, query(url.Values{"sl": []string{sl}, "timeout": []string{"8"}}), svc.verifyHandler)
+			require.Equal(t, "OK", values["status"])
+			require.Equal(t, expected, values["sl"])
+		})
+	}
+
+	invalid := []url.Values{
+		{"timestamp": []string{"yes"}},
+		{"sl": []string{"101"}},
+		{"sl": []string{"-1"}},
+		{"sl": []string{"slow"}},
+		{"timeout": []string{"-5"}},
+		{"timeout": []string{"1.5"}},
+	}
+
+	for _, params := range invalid {
+		t.Run("should error on invalid "+params.Encode(), func(t *testing.T) {
+			t.Parallel()
+
+			svc := createTestService(t, &testStorage{})
+
+			values := decodedRequest(t, query(params), svc.verifyHandler)
+			require.Equal(t, "MISSING_PARAMETER", values["status"])
+		})
+	}
+}
+
 func Test_verifyNnParams(t *testing.T) {
 	t.Parallel()
 
@@ -257,6 +602,15 @@ func Test_ops(t *testing.T) {
 func simpleRequest(t *testing.T, handler http.HandlerFunc, args ...url.Values) string {
 	t.Helper()
 
+	rec := recordRequest(t, handler, args...)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	return rec.Body.String()
+}
+
+func recordRequest(t *testing.T, handler http.HandlerFunc, args ...url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+
 	q := make(url.Values)
 
 	for arg := range args {
@@ -271,9 +625,7 @@ func simpleRequest(t *testing.T, handler http.HandlerFunc, args ...url.Values) s
 
 	handler(rec, req)
 
-	require.Equal(t, http.StatusOK, rec.Code)
-
-	return rec.Body.String()
+	return rec
 }
 
 func decodeAnswer(t *testing.T, body string) map[string]string {
@@ -281,7 +633,7 @@ func decodeAnswer(t *testing.T, body string) map[string]string {
 
 	values := map[string]string{}
 
-	for _, s := range strings.Split(strings.TrimSpace(body), "\n") {
+	for _, s := range strings.Split(strings.TrimSpace(body), "\r\n") {
 		v := strings.SplitN(s, "=", 2)
 		if len(v) > 1 {
 			values[v[0]] = v[1]
@@ -296,9 +648,6 @@ func decodeAnswer(t *testing.T, body string) map[string]string {
 func createTestService(t *testing.T, storage common.StorageInterface) *Service {
 	t.Helper()
 
-	apiKey, err := base64.StdEncoding.DecodeString("mG5be6ZJU1qBGz24yPh/ESM3UdU=")
-	require.NoError(t, err)
-
 	svc := &Service{
 		log:   zaptest.NewLogger(t),
 		Users: map[string]*common.OTPUser{},
@@ -307,20 +656,59 @@ func createTestService(t *testing.T, storage common.StorageInterface) *Service {
 			BuildVersion: "6660999",
 		},
 		storage: storage,
-		apiKey:  apiKey,
+		clients: &legacyClients{secret: testSecret},
+
+		tsAbsTolerance: 20 * time.Second,
+		tsRelTolerance: 0.3,
 	}
 
+	svc.registerMetrics(metrics.NewRegistry())
+
+	var err error
+
 	svc.gmtLocation, err = time.LoadLocation("GMT")
 	require.NoError(t, err)
 
 	return svc
 }
 
+func signedQuery(t *testing.T, q url.Values) url.Values {
+	t.Helper()
+
+	return signedQueryWith(t, q, testSecret)
+}
+
+func signedQueryWith(t *testing.T, q url.Values, secret string) url.Values {
+	t.Helper()
+
+	apiKey, err := base64.StdEncoding.DecodeString(secret)
+	require.NoError(t, err)
+
+	signed := make(url.Values, len(q)+1)
+	data := make([]string, 0, len(q))
+
+	for k := range q {
+		if k == "h" {
+			continue
+		}
+
+		signed.Set(k, q.Get(k))
+		data = append(data, k+"="+q.Get(k))
+	}
+
+	signed.Set("h", common.SignMapToBase64(data, apiKey))
+
+	return signed
+}
+
 func decodedRequest(t *testing.T, q url.Values, handler http.HandlerFunc) map[string]string {
 	t.Helper()
 
-	values := decodeAnswer(t, simpleRequest(t, handler, q))
+	rec := recordRequest(t, handler, q)
+
+	values := decodeAnswer(t, rec.Body.String())
 	require.Contains(t, values, "status")
+	require.Equal(t, httpStatus(values["status"]), rec.Code)
 
 	return values
 }
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/metrics"
+)
+
+// registerMetrics creates API metrics in the given registry.
+func (s *Service) registerMetrics(reg *prometheus.Registry) {
+	s.metrics = reg
+	factory := promauto.With(reg)
+
+	s.responses = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "yubiserv_verify_responses_total",
+		Help: "Verification responses by status.",
+	}, []string{"status"})
+
+	s.decryptLatency = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "yubiserv_decrypt_otp_duration_seconds",
+		Help:    "OTP decryption latency by key storage backend.",
+		Buckets: metrics.DefBuckets,
+	}, []string{"backend"})
+
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "yubiserv_tracked_users",
+		Help: "Number of keys with saved replay-protection counters.",
+	}, func() float64 {
+		s.usersMu.RLock()
+		defer s.usersMu.RUnlock()
+
+		return float64(len(s.Users))
+	})
+
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "yubiserv_cached_keys",
+		Help: "Number of keys in the key storage cache.",
+	}, func() float64 {
+		if cache, ok := s.storage.(common.KeyCacheStats); ok {
+			return float64(cache.CachedKeys())
+		}
+
+		return 0
+	})
+}
+
+// decryptOTP decrypts OTP with the key storage and records the decryption latency.
+// Key lookup is bounded by the request context and the configured lookup timeout.
+func (s *Service) decryptOTP(ctx context.Context, publicID, token string) (*common.OTP, error) {
+	backend := "unknown"
+	if named, ok := s.storage.(interface{ Name() string }); ok {
+		backend = named.Name()
+	}
+
+	start := time.Now()
+	defer func() {
+		s.decryptLatency.WithLabelValues(backend).Observe(time.Since(start).Seconds())
+	}()
+
+	if s.lookupTimeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, s.lookupTimeout)
+		defer cancel()
+	}
+
+	return s.storage.DecryptOTP(ctx, publicID, token)
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type testCachingStorage struct {
+	testStorage
+}
+
+func (s *testCachingStorage) Name() string { return "test-storage" }
+
+func (s *testCachingStorage) CachedKeys() int { return 7 }
+
+func Test_metrics(t *testing.T) {
+	t.Parallel()
+
+	svc := createTestService(t, &testCachingStorage{})
+
+	q := signedQuery(t, url.Values{
+		"id":    []string{"1"},
+		"otp":   []string{"cccccccccccbiucvrkjiegbhidrcicvlgrcgkgurhjnj"},
+		"nonce": []string{"jrFwbaYFhn0HoxZIsd9LQ6w2ceU"},
+	})
+
+	require.Equal(t, "OK", decodedRequest(t, q, svc.verifyHandler)["status"])
+	require.Equal(t, "REPLAYED_OTP", decodedRequest(t, q, svc.verifyHandler)["status"])
+
+	require.InDelta(t, 1, testutil.ToFloat64(svc.responses.WithLabelValues("OK")), 0)
+	require.InDelta(t, 1, testutil.ToFloat64(svc.responses.WithLabelValues("REPLAYED_OTP")), 0)
+
+	rec := httptest.NewRecorder()
+	svc.newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "yubiserv_verify_responses_total{status=\"OK\"} 1\n")
+	require.Contains(t, rec.Body.String(), "yubiserv_decrypt_otp_duration_seconds_count{backend=\"test-storage\"} 2\n")
+	require.Contains(t, rec.Body.String(), "yubiserv_tracked_users 1\n")
+	require.Contains(t, rec.Body.String(), "yubiserv_cached_keys 7\n")
+}
@@ -5,17 +5,17 @@ import (
 	"fmt"
 
 	"github.com/im-kulikov/helium/module"
-	"github.com/im-kulikov/helium/service"
 	"github.com/spf13/viper"
 	"github.com/urfave/cli/v2"
-	"go.uber.org/dig"
+	"go.uber.org/zap"
 
 	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/metrics"
 )
 
 // Module api constructor.
 var Module = module.Module{ //nolint:gochecknoglobals
-	{Constructor: newAPIService, Options: []dig.ProvideOption{dig.Group("services")}},
+	{Constructor: newAPIService},
 }
 
 var (
@@ -23,34 +23,75 @@ var (
 	ErrNoStorageModule = errors.New("no storage module selected")
 )
 
-func newAPIService(p serviceParams) (service.Service, error) {
+func newAPIService(p serviceParams) (serviceOutParams, error) {
 
 	if p.Storage == nil {
-		return nil, ErrNoStorageModule
+		return serviceOutParams{}, ErrNoStorageModule
 	}
 
-	// Check if the API secret key is specified.
-	apiKey, err := makeAPIKey(p.Config.GetString("api.secret"))
-	if err != nil {
-		return nil, fmt.Errorf("cannot get api key: %w", err)
+	// API clients registry: from the storage module if selected, otherwise from the config.
+	clients := p.Clients
+	if clients == nil {
+		var err error
+
+		if clients, err = newConfigClients(p.Config); err != nil {
+			return serviceOutParams{}, err
+		}
+	}
+
+	// Nonce cache: shared one if provided, otherwise in-process; zero window disables the check.
+	nonces := p.Nonces
+	if window := p.Config.GetDuration("api.nonce_window"); nonces == nil && window > 0 {
+		nonces = newMemoryNonces(window, p.Config.GetInt("api.nonce_limit"))
+	}
+
+	users := make(common.OTPUsers)
+
+	// Restore replay-protection counters, if persistent counters storage is selected.
+	if p.Counters != nil {
+		var err error
+
+		if users, err = p.Counters.LoadCounters(); err != nil {
+			return serviceOutParams{}, fmt.Errorf("cannot load counters: %w", err)
+		}
+
+		p.Logger.Info("counters loaded", zap.Int("count", len(users)))
 	}
 
 	svc := &Service{
-		log:      p.Logger,
-		address:  p.Config.GetString("api.address"),
-		settings: p.Settings,
-		apiKey:   apiKey,
-		timeout:  p.Config.GetDuration("api.timeout"),
-		storage:  p.Storage,
-		cert:     p.Config.GetString("api.tls_cert"),
-		key:      p.Config.GetString("api.tls_key"),
-		Users:    make(common.OTPUsers),
-		started:  make(chan struct{}),
+		log:            p.Logger,
+		address:        p.Config.GetString("api.address"),
+		settings:       p.Settings,
+		clients:        clients,
+		testClientID:   p.Config.GetUint64("api.test_client_id"),
+		nonces:         nonces,
+		healthChecks:   p.HealthChecks,
+		timeout:        p.Config.GetDuration("api.timeout"),
+		lookupTimeout:  p.Config.GetDuration("api.lookup_timeout"),
+		tsAbsTolerance: p.Config.GetDuration("api.ts_abs_tolerance"),
+		tsRelTolerance: p.Config.GetFloat64("api.ts_rel_tolerance"),
+		storage:        p.Storage,
+		counters:       p.Counters,
+		cert:           p.Config.GetString("api.tls_cert"),
+		key:            p.Config.GetString("api.tls_key"),
+		Users:          users,
+		started:        make(chan struct{}),
 	}
 
+	// Metrics are collected even if nobody serves them
+	reg := p.Metrics
+	if reg == nil {
+		reg = metrics.NewRegistry()
+	}
+
+	svc.registerMetrics(reg)
+
 	svc.log.Debug("API created")
 
-	return svc, nil
+	return serviceOutParams{
+		Service:  svc,
+		Counters: svc,
+	}, nil
 }
 
 // Defaults for storage service.
@@ -58,7 +99,13 @@ func Defaults(ctx *cli.Context, v *viper.Viper) error {
 	// api:
 	v.SetDefault("api.address", ctx.String("api-address"))
 	v.SetDefault("api.timeout", ctx.String("api-timeout"))
+	v.SetDefault("api.lookup_timeout", ctx.String("api-lookup-timeout"))
 	v.SetDefault("api.secret", ctx.String("api-secret"))
+	v.SetDefault("api.test_client_id", 1)
+	v.SetDefault("api.nonce_window", ctx.Duration("api-nonce-window"))
+	v.SetDefault("api.nonce_limit", ctx.Int("api-nonce-limit"))
+	v.SetDefault("api.ts_abs_tolerance", ctx.Duration("api-ts-abs-tolerance"))
+	v.SetDefault("api.ts_rel_tolerance", ctx.Float64("api-ts-rel-tolerance"))
 
 	tlsCert := ctx.String("api-tls-cert")
 	tlsKey := ctx.String("api-tls-key")
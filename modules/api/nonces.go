@@ -0,0 +1,78 @@
+package api
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// memoryNonces is the default in-process NonceCache. Nonces are remembered for the
+	// time window, and at most limit of them are kept (0 for no limit): when the limit is reached,
+	// the oldest nonces are forgotten early.
+	memoryNonces struct {
+		window time.Duration
+		limit  int
+
+		mu      sync.Mutex
+		now     func() time.Time
+		entries map[string]time.Time
+		queue   []nonceEntry
+	}
+
+	nonceEntry struct {
+		key     string
+		expires time.Time
+	}
+)
+
+func newMemoryNonces(window time.Duration, limit int) *memoryNonces {
+	return &memoryNonces{
+		window:  window,
+		limit:   limit,
+		now:     time.Now,
+		entries: make(map[string]time.Time),
+	}
+}
+
+// Seen records the nonce and reports whether it was seen within the time window.
+func (c *memoryNonces) Seen(clientID uint64, nonce string) (bool, error) {
+	key := strconv.FormatUint(clientID, 10) + ":" + nonce
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.evict(now)
+
+	if expires, ok := c.entries[key]; ok && now.Before(expires) {
+		return true, nil
+	}
+
+	expires := now.Add(c.window)
+	c.entries[key] = expires
+	c.queue = append(c.queue, nonceEntry{key: key, expires: expires})
+
+	return false, nil
+}
+
+// evict drops expired nonces and the oldest ones above the limit.
+// Entries are queued in expiration order, as the window is the same for all of them.
+func (c *memoryNonces) evict(now time.Time) {
+	var n int
+
+	for ; n < len(c.queue); n++ {
+		entry := c.queue[n]
+
+		if now.Before(entry.expires) && (c.limit <= 0 || len(c.queue)-n < c.limit) {
+			break
+		}
+
+		// The nonce may be re-added after expiration, keep the newer entry
+		if c.entries[entry.key].Equal(entry.expires) {
+			delete(c.entries, entry.key)
+		}
+	}
+
+	c.queue = c.queue[n:]
+}
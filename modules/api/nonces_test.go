@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+type countingStorage struct {
+	testStorage
+
+	calls atomic.Int32
+}
+
+func (s *countingStorage) DecryptOTP(ctx context.Context, publicID, token string) (*common.OTP, error) {
+	s.calls.Add(1)
+
+	return s.testStorage.DecryptOTP(ctx, publicID, token)
+}
+
+func Test_memoryNonces(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should detect repeated nonce per client", func(t *testing.T) {
+		t.Parallel()
+
+		c := newMemoryNonces(time.Minute, 0)
+
+		seen, err := c.Seen(1, "nonce1")
+		require.NoError(t, err)
+		require.False(t, seen)
+
+		seen, err = c.Seen(1, "nonce1")
+		require.NoError(t, err)
+		require.True(t, seen)
+
+		seen, err = c.Seen(2, "nonce1")
+		require.NoError(t, err)
+		require.False(t, seen)
+	})
+
+	t.Run("should forget nonce after window", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Now()
+
+		c := newMemoryNonces(time.Minute, 0)
+		c.now = func() time.Time { return now }
+
+		seen, _ := c.Seen(1, "nonce1")
+		require.False(t, seen)
+
+		now = now.Add(30 * time.Second)
+		seen, _ = c.Seen(1, "nonce2")
+		require.False(t, seen)
+
+		now = now.Add(31 * time.Second)
+		seen, _ = c.Seen(1, "nonce1")
+		require.False(t, seen)
+
+		seen, _ = c.Seen(1, "nonce2")
+		require.True(t, seen)
+
+		require.Len(t, c.entries, 2)
+		require.Len(t, c.queue, 2)
+	})
+
+	t.Run("should keep at most limit nonces", func(t *testing.T) {
+		t.Parallel()
+
+		c := newMemoryNonces(time.Hour, 3)
+
+		for _, nonce := range []string{"n1", "n2", "n3", "n4"} {
+			seen, _ := c.Seen(1, nonce)
+			require.False(t, seen)
+		}
+
+		require.Len(t, c.entries, 3)
+
+		seen, _ := c.Seen(1, "n4")
+		require.True(t, seen)
+
+		// The oldest one is forgotten
+		seen, _ = c.Seen(1, "n1")
+		require.False(t, seen)
+	})
+}
+
+func Test_verifyReplayedRequest(t *testing.T) {
+	t.Parallel()
+
+	storage := &countingStorage{}
+
+	svc := createTestService(t, storage)
+	svc.nonces = newMemoryNonces(time.Minute, 0)
+
+	q := signedQuery(t, url.Values{
+		"id":    []string{"1"},
+		"otp":   []string{"cccccccccccbiucvrkjiegbhidrcicvlgrcgkgurhjnj"},
+		"nonce": []string{"jrFwbaYFhn0HoxZIsd9LQ6w2ceU"},
+	})
+
+	values := decodedRequest(t, q, svc.verifyHandler)
+	require.Equal(t, "OK", values["status"])
+	require.Equal(t, int32(1), storage.calls.Load())
+
+	values = decodedRequest(t, q, svc.verifyHandler)
+	require.Equal(t, "REPLAYED_REQUEST", values["status"])
+	require.Equal(t, int32(1), storage.calls.Load())
+	requireSigned(t, values, testSecret)
+
+	t.Run("should not record nonce of request with bad signature", func(t *testing.T) {
+		q := url.Values{
+			"id":    []string{"1"},
+			"otp":   []string{"cccccccccccbiucvrkjiegbhidrcicvlgrcgkgurhjnj"},
+			"nonce": []string{"anotherNonce0123456789"},
+			"h":     []string{"Fieq5toKf4ts+Lp2nCdibXjeUDI="},
+		}
+
+		values := decodedRequest(t, q, svc.verifyHandler)
+		require.Equal(t, "BAD_SIGNATURE", values["status"])
+
+		values = decodedRequest(t, signedQuery(t, q), svc.verifyHandler)
+		require.Equal(t, "REPLAYED_OTP", values["status"])
+	})
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+type (
+	// keyLocks serializes counter check-and-update per public ID, so requests for
+	// unrelated keys never wait for each other. Entries are reference counted and
+	// dropped as soon as nobody holds or waits for them.
+	keyLocks struct {
+		mu    sync.Mutex
+		locks map[string]*keyLock
+	}
+
+	keyLock struct {
+		sync.Mutex
+		refs int
+	}
+)
+
+// lock acquires the lock for the given public ID and returns its release function.
+func (l *keyLocks) lock(publicID string) func() {
+	l.mu.Lock()
+
+	if l.locks == nil {
+		l.locks = make(map[string]*keyLock)
+	}
+
+	kl, ok := l.locks[publicID]
+	if !ok {
+		kl = &keyLock{}
+		l.locks[publicID] = kl
+	}
+
+	kl.refs++
+	l.mu.Unlock()
+
+	kl.Lock()
+
+	return func() {
+		kl.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if kl.refs--; kl.refs == 0 {
+			delete(l.locks, publicID)
+		}
+	}
+}
+
+// getUser returns a copy of the saved counters for the given public ID.
+func (s *Service) getUser(publicID string) (common.OTPUser, bool) {
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+
+	user, ok := s.Users[publicID]
+	if !ok {
+		return common.OTPUser{}, false
+	}
+
+	return *user, true
+}
+
+// setUser replaces saved counters for the given public ID.
+func (s *Service) setUser(publicID string, user *common.OTPUser) {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	s.Users[publicID] = user
+}
+
+// Counters returns a copy of all saved counters.
+func (s *Service) Counters() common.OTPUsers {
+	s.usersMu.RLock()
+	defer s.usersMu.RUnlock()
+
+	users := make(common.OTPUsers, len(s.Users))
+
+	for publicID, user := range s.Users {
+		u := *user
+		users[publicID] = &u
+	}
+
+	return users
+}
+
+// ResetCounter removes saved counters for the given public ID from the counters storage and memory.
+func (s *Service) ResetCounter(publicID string) error {
+	unlock := s.locks.lock(publicID)
+	defer unlock()
+
+	if _, ok := s.getUser(publicID); !ok {
+		return common.ErrCounterNotFound
+	}
+
+	if s.counters != nil {
+		if err := s.counters.DeleteCounter(publicID); err != nil {
+			return fmt.Errorf("cannot reset counter: %w", err)
+		}
+	}
+
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+
+	delete(s.Users, publicID)
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+func Test_keyLocks(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should release lock entries", func(t *testing.T) {
+		t.Parallel()
+
+		var l keyLocks
+
+		unlock := l.lock("cccccccccccb")
+		require.Len(t, l.locks, 1)
+
+		unlock()
+		require.Empty(t, l.locks)
+	})
+
+	t.Run("should not block other keys", func(t *testing.T) {
+		t.Parallel()
+
+		var l keyLocks
+
+		unlock := l.lock("cccccccccccb")
+		defer unlock()
+
+		done := make(chan struct{})
+
+		go func() {
+			l.lock("cccccccccccd")()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("lock for other key is blocked")
+		}
+	})
+
+	t.Run("should serialize same key", func(t *testing.T) {
+		t.Parallel()
+
+		var (
+			l       keyLocks
+			wg      sync.WaitGroup
+			counter int
+		)
+
+		for range 100 {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				defer l.lock("cccccccccccb")()
+
+				counter++
+			}()
+		}
+
+		wg.Wait()
+
+		require.Equal(t, 100, counter)
+		require.Empty(t, l.locks)
+	})
+}
+
+func Test_resetCounter(t *testing.T) {
+	t.Parallel()
+
+	counters := &testCounters{users: common.OTPUsers{"cccccccccccb": {UsageCounter: 3}}}
+
+	svc := createTestService(t, &testStorage{})
+	svc.counters = counters
+	svc.Users = common.OTPUsers{"cccccccccccb": {UsageCounter: 3}}
+
+	snapshot := svc.Counters()
+	require.Equal(t, common.OTPUsers{"cccccccccccb": {UsageCounter: 3}}, snapshot)
+
+	// Snapshot must be a copy
+	snapshot["cccccccccccb"].UsageCounter = 4
+	require.Equal(t, uint16(3), svc.Users["cccccccccccb"].UsageCounter)
+
+	require.NoError(t, svc.ResetCounter("cccccccccccb"))
+	require.Empty(t, svc.Counters())
+	require.Empty(t, counters.users)
+
+	require.ErrorIs(t, svc.ResetCounter("cccccccccccb"), common.ErrCounterNotFound)
+}
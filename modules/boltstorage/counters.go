@@ -0,0 +1,75 @@
+package boltstorage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+// LoadCounters reads all stored counters from the database.
+func (s *Service) LoadCounters() (common.OTPUsers, error) {
+	db, err := s.database()
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(common.OTPUsers)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(countersBucket).ForEach(func(publicID, data []byte) error {
+			user := &common.OTPUser{}
+			if err := json.Unmarshal(data, user); err != nil {
+				return fmt.Errorf("cannot decode counter %s: %w", publicID, err)
+			}
+
+			users[string(publicID)] = user
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot load counters: %w", err)
+	}
+
+	return users, nil
+}
+
+// StoreCounter saves counters for the given public ID, the transaction is synced to the file on commit.
+func (s *Service) StoreCounter(publicID string, user *common.OTPUser) error {
+	db, err := s.database()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("cannot encode counter: %w", err)
+	}
+
+	if err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(countersBucket).Put([]byte(publicID), data)
+	}); err != nil {
+		return fmt.Errorf("cannot store counter: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteCounter removes counters for the given public ID.
+func (s *Service) DeleteCounter(publicID string) error {
+	db, err := s.database()
+	if err != nil {
+		return err
+	}
+
+	if err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(countersBucket).Delete([]byte(publicID))
+	}); err != nil {
+		return fmt.Errorf("cannot delete counter: %w", err)
+	}
+
+	return nil
+}
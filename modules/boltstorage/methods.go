@@ -0,0 +1,207 @@
+package boltstorage
+
+import (
+	"context"
+	"crypto/aes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+// Key represents a YubiKey record in the bolt database.
+type Key = common.Key
+
+// DecryptOTP Decrypt OTP using stored private AES for specified public identifier.
+func (s *Service) DecryptOTP(ctx context.Context, publicID, token string) (*common.OTP, error) {
+	return common.DecryptOTP(ctx, s.log, common.KeyLookupFunc(s.getKeyFunc), publicID, token)
+}
+
+// GetKey retrieves key with given publicID from storage.
+func (s *Service) GetKey(_ context.Context, publicID string) (*Key, error) {
+	db, err := s.database()
+	if err != nil {
+		return nil, err
+	}
+
+	var key *Key
+
+	err = db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(keysBucket).Get([]byte(publicID))
+		if data == nil {
+			return common.ErrStorageNoKey
+		}
+
+		var decodeErr error
+
+		key, decodeErr = decodeKey(publicID, data)
+
+		return decodeErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot get key: %w", err)
+	}
+
+	return key, nil
+}
+
+// KeepsKeyMetadata reports that owner and description are stored with keys.
+func (s *Service) KeepsKeyMetadata() bool {
+	return true
+}
+
+// ListKeys retrieves all keys from storage ordered by public ID.
+func (s *Service) ListKeys() ([]*Key, error) {
+	db, err := s.database()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*Key, 0)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(keysBucket).ForEach(func(publicID, data []byte) error {
+			key, err := decodeKey(string(publicID), data)
+			if err != nil {
+				return err
+			}
+
+			keys = append(keys, key)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// StoreKey creates or replaces the key.
+func (s *Service) StoreKey(k *Key) error {
+	db, err := s.database()
+	if err != nil {
+		return err
+	}
+
+	data, err := encodeKey(k)
+	if err != nil {
+		return err
+	}
+
+	if err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(keysBucket).Put([]byte(k.PublicID), data)
+	}); err != nil {
+		return fmt.Errorf("cannot store key: %w", err)
+	}
+
+	s.purgeCache(k.PublicID)
+
+	return nil
+}
+
+// StoreKeys adds new keys into the database in one transaction.
+func (s *Service) StoreKeys(keys []*Key) error {
+	db, err := s.database()
+	if err != nil {
+		return err
+	}
+
+	records := make([][]byte, 0, len(keys))
+
+	for _, k := range keys {
+		data, err := encodeKey(k)
+		if err != nil {
+			return err
+		}
+
+		records = append(records, data)
+	}
+
+	if err = db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(keysBucket)
+
+		for i, k := range keys {
+			if bucket.Get([]byte(k.PublicID)) != nil {
+				return fmt.Errorf("%s: %w", k.PublicID, common.ErrStorageKeyExists)
+			}
+
+			if err := bucket.Put([]byte(k.PublicID), records[i]); err != nil {
+				return fmt.Errorf("cannot store key %s: %w", k.PublicID, err)
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// Drop negatively cached public IDs of the new keys
+	for _, k := range keys {
+		s.purgeCache(k.PublicID)
+	}
+
+	return nil
+}
+
+// DeleteKey removes key with given publicID from storage.
+func (s *Service) DeleteKey(publicID string) error {
+	db, err := s.database()
+	if err != nil {
+		return err
+	}
+
+	if err = db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(keysBucket)
+		if bucket.Get([]byte(publicID)) == nil {
+			return common.ErrStorageNoKey
+		}
+
+		return bucket.Delete([]byte(publicID))
+	}); err != nil {
+		if errors.Is(err, common.ErrStorageNoKey) {
+			return common.ErrStorageNoKey
+		}
+
+		return fmt.Errorf("cannot delete key: %w", err)
+	}
+
+	s.purgeCache(publicID)
+
+	return nil
+}
+
+// encodeKey checks the key as the SQLite key store does and encodes it into the JSON record.
+func encodeKey(k *Key) ([]byte, error) {
+	switch {
+	case !common.IsValidPublicID(k.PublicID):
+		return nil, fmt.Errorf("%s: %w", k.PublicID, common.ErrKeyInvalidPublicID)
+	case len(k.PrivateID) != hex.EncodedLen(common.PrivateIDSize):
+		return nil, fmt.Errorf("%s: %w", k.PublicID, common.ErrKeyInvalidPrivateID)
+	case len(k.AESKey) != hex.EncodedLen(aes.BlockSize):
+		return nil, fmt.Errorf("%s: %w", k.PublicID, common.ErrKeyInvalidAESKey)
+	}
+
+	data, err := json.Marshal(k)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode key %s: %w", k.PublicID, err)
+	}
+
+	return data, nil
+}
+
+func decodeKey(publicID string, data []byte) (*Key, error) {
+	key := &Key{}
+	if err := json.Unmarshal(data, key); err != nil {
+		return nil, fmt.Errorf("cannot decode key %s: %w", publicID, err)
+	}
+
+	key.PublicID = publicID
+
+	return key, nil
+}
@@ -0,0 +1,206 @@
+package boltstorage_test
+
+import (
+	"context"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/keycache"
+	"github.com/archaron/go-yubiserv/modules/boltstorage"
+)
+
+func setupTestDB(t *testing.T) (*boltstorage.Service, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "yubiserv.bolt")
+	svc := boltstorage.TestNewService(zaptest.NewLogger(t), path)
+
+	require.NoError(t, svc.Connect(context.Background()))
+	t.Cleanup(func() { _ = svc.Close() })
+
+	return svc, path
+}
+
+func testKey(publicID string) *boltstorage.Key {
+	return &boltstorage.Key{
+		ID:          1,
+		PublicID:    publicID,
+		Created:     "2024-01-01T00:00:00Z",
+		PrivateID:   "0102030405ab",
+		AESKey:      "0102030405060708090a0b0c0d0e0f10",
+		LockCode:    "010203040506",
+		Active:      true,
+		Owner:       "alice",
+		Description: "spare key",
+	}
+}
+
+func TestKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	svc, _ := setupTestDB(t)
+
+	key := testKey("vvcccccccccc")
+	require.NoError(t, svc.StoreKey(key))
+
+	stored, err := svc.GetKey(ctx, key.PublicID)
+	require.NoError(t, err)
+	require.Equal(t, key, stored)
+
+	key.Active = false
+	require.NoError(t, svc.StoreKey(key))
+
+	stored, err = svc.GetKey(ctx, key.PublicID)
+	require.NoError(t, err)
+	require.False(t, stored.Active)
+
+	require.NoError(t, svc.StoreKeys([]*boltstorage.Key{testKey("vvdddddddddd"), testKey("vveeeeeeeeee")}))
+
+	keys, err := svc.ListKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 3)
+	require.Equal(t, "vvcccccccccc", keys[0].PublicID)
+
+	// Nothing is stored if any of the keys exists
+	err = svc.StoreKeys([]*boltstorage.Key{testKey("vvffffffffff"), testKey("vvdddddddddd")})
+	require.ErrorIs(t, err, common.ErrStorageKeyExists)
+
+	_, err = svc.GetKey(ctx, "vvffffffffff")
+	require.ErrorIs(t, err, common.ErrStorageNoKey)
+
+	require.NoError(t, svc.DeleteKey(key.PublicID))
+	require.ErrorIs(t, svc.DeleteKey(key.PublicID), common.ErrStorageNoKey)
+
+	_, err = svc.GetKey(ctx, key.PublicID)
+	require.ErrorIs(t, err, common.ErrStorageNoKey)
+}
+
+func TestStoreInvalidKey(t *testing.T) {
+	t.Parallel()
+
+	svc, _ := setupTestDB(t)
+
+	for name, tc := range map[string]struct {
+		change func(k *boltstorage.Key)
+		err    error
+	}{
+		"public ID":  {change: func(k *boltstorage.Key) { k.PublicID = "short" }, err: common.ErrKeyInvalidPublicID},
+		"private ID": {change: func(k *boltstorage.Key) { k.PrivateID = "0102" }, err: common.ErrKeyInvalidPrivateID},
+		"AES key":    {change: func(k *boltstorage.Key) { k.AESKey = "" }, err: common.ErrKeyInvalidAESKey},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			key := testKey("vvcccccccccc")
+			tc.change(key)
+
+			require.ErrorIs(t, svc.StoreKey(key), tc.err)
+		})
+	}
+}
+
+func TestDecryptOTP(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	svc, _ := setupTestDB(t)
+
+	for otpToken, vector := range common.TestVectors {
+		key := testKey("cccccccccccc")
+		key.PrivateID = hex.EncodeToString(vector.PrivateID[:])
+		key.AESKey = hex.EncodeToString(vector.AESKey)
+		require.NoError(t, svc.StoreKey(key))
+
+		otp, err := svc.DecryptOTP(ctx, "cccccccccccc", otpToken)
+		require.NoError(t, err)
+		require.Equal(t, vector.OTP, *otp)
+
+		// Private ID check
+		key.PrivateID = "ffffffffffff"
+		require.NoError(t, svc.StoreKey(key))
+
+		_, err = svc.DecryptOTP(ctx, "cccccccccccc", otpToken)
+		require.ErrorIs(t, err, common.ErrStorageDecryptFail)
+
+		key.Active = false
+		require.NoError(t, svc.StoreKey(key))
+
+		_, err = svc.DecryptOTP(ctx, "cccccccccccc", otpToken)
+		require.ErrorIs(t, err, common.ErrStorageKeyInactive)
+	}
+
+	_, err := svc.DecryptOTP(ctx, "vvvvvvvvvvvv", "dvgtiblfkbgturecfllberrvkinnctnn")
+	require.ErrorIs(t, err, common.ErrStorageNoKey)
+}
+
+func TestCounters(t *testing.T) {
+	t.Parallel()
+
+	svc, path := setupTestDB(t)
+
+	user := &common.OTPUser{UsageCounter: 3, SessionCounter: 7, Timestamp: [3]byte{1, 2, 3}, Seen: time.Unix(1700000000, 0).UTC()}
+	require.NoError(t, svc.StoreCounter("vvcccccccccc", user))
+	require.NoError(t, svc.StoreCounter("vvdddddddddd", &common.OTPUser{UsageCounter: 1}))
+	require.NoError(t, svc.DeleteCounter("vvdddddddddd"))
+	require.NoError(t, svc.DeleteCounter("vvdddddddddd"))
+
+	// Counters are kept in the file along with the keys
+	require.NoError(t, svc.Close())
+
+	reopened := boltstorage.TestNewService(zaptest.NewLogger(t), path)
+	require.NoError(t, reopened.Connect(context.Background()))
+	t.Cleanup(func() { _ = reopened.Close() })
+
+	users, err := reopened.LoadCounters()
+	require.NoError(t, err)
+	require.Equal(t, common.OTPUsers{"vvcccccccccc": user}, users)
+}
+
+func TestConnect(t *testing.T) {
+	t.Parallel()
+
+	svc, path := setupTestDB(t)
+
+	require.NoError(t, svc.CheckHealth(context.Background()))
+	require.NoError(t, svc.Connect(context.Background()), "connect is idempotent")
+
+	// Database file is locked by the open service
+	other := boltstorage.TestNewService(zaptest.NewLogger(t), path)
+	require.ErrorContains(t, other.Connect(context.Background()), "failed to open database")
+
+	require.NoError(t, svc.Close())
+	require.ErrorIs(t, svc.CheckHealth(context.Background()), common.ErrNotConnected)
+
+	_, err := svc.ListKeys()
+	require.ErrorIs(t, err, common.ErrNotConnected)
+}
+
+func TestKeyCache(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	svc, _ := setupTestDB(t)
+	svc.TestEnableCache(keycache.Options{TTL: time.Minute, NegativeTTL: time.Minute, StaleTTL: time.Hour})
+
+	vector := common.TestVectors["dvgtiblfkbgturecfllberrvkinnctnn"]
+
+	// Unknown public ID is cached negatively until the key is stored
+	_, err := svc.DecryptOTP(ctx, "cccccccccccc", "dvgtiblfkbgturecfllberrvkinnctnn")
+	require.ErrorIs(t, err, common.ErrStorageNoKey)
+
+	key := testKey("cccccccccccc")
+	key.PrivateID = hex.EncodeToString(vector.PrivateID[:])
+	key.AESKey = hex.EncodeToString(vector.AESKey)
+	require.NoError(t, svc.StoreKey(key))
+
+	_, err = svc.DecryptOTP(ctx, "cccccccccccc", "dvgtiblfkbgturecfllberrvkinnctnn")
+	require.NoError(t, err)
+	require.Equal(t, 1, svc.CachedKeys())
+}
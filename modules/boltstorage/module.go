@@ -0,0 +1,75 @@
+// Package boltstorage represents embedded bbolt keys and counters storage in a single file.
+package boltstorage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/im-kulikov/helium/module"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/keycache"
+)
+
+// Module storage constructor.
+var Module = module.Module{ //nolint:gochecknoglobals
+	{Constructor: newService},
+}
+
+// CountersModule keeps replay-protection counters in the database of the key store, requires Module.
+var CountersModule = module.Module{ //nolint:gochecknoglobals
+	{Constructor: newCounters},
+}
+
+// TestNewService creates a new service for testing purposes.
+func TestNewService(log *zap.Logger, path string) *Service {
+	svc := &Service{log: log, path: path, timeout: defaultOpenTimeout}
+	svc.setupCache(keycache.Options{})
+
+	return svc
+}
+
+// TestEnableCache puts the key cache in front of the database for testing purposes.
+func (s *Service) TestEnableCache(opts keycache.Options) {
+	s.setupCache(opts)
+}
+
+// TestDB returns the database for testing purposes.
+func (s *Service) TestDB() *bolt.DB {
+	return s.db
+}
+
+func newService(p serviceParams) (serviceOutParams, error) {
+	svc := &Service{
+		log:     p.Logger,
+		path:    p.Config.GetString("bolt.path"),
+		timeout: p.Config.GetDuration("bolt.open_timeout"),
+	}
+
+	// Default key fetcher, optionally cached
+	svc.setupCache(keycache.FromConfig(p.Config))
+
+	out := serviceOutParams{
+		Service:  svc,
+		Store:    svc,
+		Storage:  svc,
+		KeyAdmin: svc,
+		Health:   svc,
+	}
+
+	if svc.cache != nil {
+		out.KeyCache = svc.cache
+	}
+
+	return out, nil
+}
+
+func newCounters(p countersParams) (countersOutParams, error) {
+	// Counters must be available before the API starts serving, so open the database right away.
+	if err := p.Store.Connect(context.Background()); err != nil {
+		return countersOutParams{}, fmt.Errorf("cannot open counters database: %w", err)
+	}
+
+	return countersOutParams{Counters: p.Store}, nil
+}
@@ -0,0 +1,214 @@
+package boltstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/im-kulikov/helium/service"
+	"github.com/spf13/viper"
+	"github.com/urfave/cli/v2"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/dig"
+	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/keycache"
+)
+
+// defaultOpenTimeout bounds waiting for the lock of the database file held by another process.
+const defaultOpenTimeout = time.Second
+
+// schemaVersion of the database layout, kept in the meta bucket.
+const schemaVersion = "1"
+
+var (
+	keysBucket     = []byte("keys")     //nolint:gochecknoglobals
+	countersBucket = []byte("counters") //nolint:gochecknoglobals
+	metaBucket     = []byte("meta")     //nolint:gochecknoglobals
+	versionKey     = []byte("version")  //nolint:gochecknoglobals
+)
+
+var (
+	ErrSchemaVersion = errors.New("unsupported bolt database version")
+	ErrNoBucket      = errors.New("bolt database bucket is missing")
+)
+
+type (
+	KeyGetterFunc func(ctx context.Context, publicID string) (*Key, error)
+
+	serviceParams struct {
+		dig.In
+
+		Logger *zap.Logger
+		Config *viper.Viper
+	}
+
+	serviceOutParams struct {
+		dig.Out
+		Service  service.Service `group:"services"`
+		Store    *Service
+		Storage  common.StorageInterface
+		KeyAdmin common.KeyAdmin
+		Health   common.HealthChecker `group:"health_checks"`
+		KeyCache common.KeyCache
+	}
+
+	countersParams struct {
+		dig.In
+
+		Store *Service
+	}
+
+	countersOutParams struct {
+		dig.Out
+		Counters common.CounterStorage
+	}
+
+	// Service for bbolt database storage.
+	Service struct {
+		log        *zap.Logger
+		getKeyFunc KeyGetterFunc
+		cache      *keycache.Cache
+
+		// Guards opening and closing of db, shared by keys and counters
+		mu sync.Mutex
+		db *bolt.DB
+
+		path    string
+		timeout time.Duration
+	}
+)
+
+// Start the storage service.
+func (s *Service) Start(ctx context.Context) error {
+	if err := s.Connect(ctx); err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+
+	return nil
+}
+
+// Connect opens the database file, creating it with its buckets if missing. The file is locked
+// while open, so other processes wait for it up to the open timeout.
+func (s *Service) Connect(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db != nil {
+		return nil
+	}
+
+	s.log.Debug("keys storage start", zap.String("path", s.path))
+
+	db, err := bolt.Open(s.path, 0o600, &bolt.Options{Timeout: s.timeout})
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err = db.Update(createBuckets); err != nil {
+		_ = db.Close()
+
+		return fmt.Errorf("could not create database: %w", err)
+	}
+
+	s.db = db
+
+	return nil
+}
+
+// createBuckets of keys, counters and meta data, new database is marked with the current schema version.
+func createBuckets(tx *bolt.Tx) error {
+	for _, name := range [][]byte{keysBucket, countersBucket} {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return fmt.Errorf("cannot create %s bucket: %w", name, err)
+		}
+	}
+
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return fmt.Errorf("cannot create %s bucket: %w", metaBucket, err)
+	}
+
+	switch version := meta.Get(versionKey); {
+	case version == nil:
+		return meta.Put(versionKey, []byte(schemaVersion))
+	case string(version) != schemaVersion:
+		return fmt.Errorf("%s: %w", version, ErrSchemaVersion)
+	}
+
+	return nil
+}
+
+// Stop the storage service.
+func (s *Service) Stop(_ context.Context) {
+	if s.cache != nil {
+		s.cache.Close()
+	}
+
+	_ = s.Close()
+}
+
+// Close the database, releasing the file lock.
+func (s *Service) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db == nil {
+		return nil
+	}
+
+	db := s.db
+	s.db = nil
+
+	if err := db.Close(); err != nil {
+		return fmt.Errorf("cannot close database: %w", err)
+	}
+
+	return nil
+}
+
+// database returns the open database.
+func (s *Service) database() (*bolt.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db == nil {
+		return nil, common.ErrNotConnected
+	}
+
+	return s.db, nil
+}
+
+// CheckHealth checks that the keys bucket is readable.
+func (s *Service) CheckHealth(_ context.Context) error {
+	db, err := s.database()
+	if err != nil {
+		return err
+	}
+
+	return db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(keysBucket) == nil {
+			return fmt.Errorf("database check failed: %s: %w", keysBucket, ErrNoBucket)
+		}
+
+		return nil
+	})
+}
+
+// Name of the service.
+func (s *Service) Name() string {
+	return "bolt-keys-storage"
+}
+
+// Defaults for the bolt storage service.
+func Defaults(ctx *cli.Context, v *viper.Viper) error {
+	v.SetDefault("bolt.path", ctx.String("bolt-path"))
+	v.SetDefault("bolt.open_timeout", defaultOpenTimeout)
+
+	return nil
+}
@@ -0,0 +1,23 @@
+// Package filecounters implements file-backed replay-protection counters storage.
+package filecounters
+
+import (
+	"github.com/im-kulikov/helium/module"
+	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+// Module counters storage constructor.
+var Module = module.Module{ //nolint:gochecknoglobals
+	{Constructor: newStorage},
+}
+
+// NewTestStorage creates a new storage for testing purposes.
+func NewTestStorage(log *zap.Logger, path string) (*Storage, error) {
+	return newFileStorage(log, path)
+}
+
+func newStorage(p storageParams) (common.CounterStorage, error) {
+	return newFileStorage(p.Logger, p.Config.GetString("counters.path"))
+}
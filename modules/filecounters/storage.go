@@ -0,0 +1,182 @@
+package filecounters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/spf13/viper"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/dig"
+	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+type (
+	storageParams struct {
+		dig.In
+
+		Logger *zap.Logger
+		Config *viper.Viper
+	}
+
+	// Storage keeps counters in a JSON file. Every change rewrites the file atomically:
+	// data is written to a temporary file, synced and renamed over the original.
+	// Changes of all keys are serialized and cost the size of the whole file, so it suits small installations only.
+	Storage struct {
+		log  *zap.Logger
+		path string
+
+		users common.OTPUsers
+		sync.Mutex
+	}
+)
+
+func newFileStorage(log *zap.Logger, path string) (*Storage, error) {
+	s := &Storage{
+		log:   log,
+		path:  path,
+		users: make(common.OTPUsers),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Info("counters file not found, starting with empty counters", zap.String("path", path))
+
+			return s, nil
+		}
+
+		return nil, fmt.Errorf("cannot read counters file: %w", err)
+	}
+
+	if err = json.Unmarshal(data, &s.users); err != nil {
+		return nil, fmt.Errorf("cannot parse counters file: %w", err)
+	}
+
+	log.Debug("counters loaded", zap.String("path", path), zap.Int("count", len(s.users)))
+
+	return s, nil
+}
+
+// LoadCounters returns a copy of all stored counters.
+func (s *Storage) LoadCounters() (common.OTPUsers, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	users := make(common.OTPUsers, len(s.users))
+
+	for publicID, user := range s.users {
+		u := *user
+		users[publicID] = &u
+	}
+
+	return users, nil
+}
+
+// StoreCounter saves counters for the given public ID and flushes the file to disk.
+func (s *Storage) StoreCounter(publicID string, user *common.OTPUser) error {
+	s.Lock()
+	defer s.Unlock()
+
+	prev, existed := s.users[publicID]
+
+	u := *user
+	s.users[publicID] = &u
+
+	if err := s.flush(); err != nil {
+		// Keep in-memory state consistent with the file
+		if existed {
+			s.users[publicID] = prev
+		} else {
+			delete(s.users, publicID)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// DeleteCounter removes counters for the given public ID and flushes the file to disk.
+func (s *Storage) DeleteCounter(publicID string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	prev, existed := s.users[publicID]
+	if !existed {
+		return nil
+	}
+
+	delete(s.users, publicID)
+
+	if err := s.flush(); err != nil {
+		s.users[publicID] = prev
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *Storage) flush() error {
+	data, err := json.Marshal(s.users)
+	if err != nil {
+		return fmt.Errorf("cannot marshal counters: %w", err)
+	}
+
+	dir := filepath.Dir(s.path)
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("cannot create temporary counters file: %w", err)
+	}
+
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+
+		return fmt.Errorf("cannot write counters file: %w", err)
+	}
+
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+
+		return fmt.Errorf("cannot sync counters file: %w", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("cannot close counters file: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("cannot replace counters file: %w", err)
+	}
+
+	// Make the rename itself durable
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("cannot open counters directory: %w", err)
+	}
+
+	defer func() { _ = d.Close() }()
+
+	if err = d.Sync(); err != nil {
+		return fmt.Errorf("cannot sync counters directory: %w", err)
+	}
+
+	return nil
+}
+
+// Defaults for the file counters storage.
+func Defaults(ctx *cli.Context, v *viper.Viper) error {
+	v.SetDefault("counters.path", ctx.String("counters-path"))
+
+	return nil
+}
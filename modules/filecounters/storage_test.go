@@ -0,0 +1,101 @@
+package filecounters_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/modules/filecounters"
+)
+
+func TestStorage(t *testing.T) {
+	t.Parallel()
+
+	t.Run("missing file starts empty", func(t *testing.T) {
+		t.Parallel()
+
+		s, err := filecounters.NewTestStorage(zaptest.NewLogger(t), filepath.Join(t.TempDir(), "counters.json"))
+		require.NoError(t, err)
+
+		users, err := s.LoadCounters()
+		require.NoError(t, err)
+		require.Empty(t, users)
+	})
+
+	t.Run("counters survive reopen", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "counters.json")
+
+		s, err := filecounters.NewTestStorage(zaptest.NewLogger(t), path)
+		require.NoError(t, err)
+
+		user := &common.OTPUser{UsageCounter: 10, SessionCounter: 3, Timestamp: [3]byte{1, 2, 3}}
+		require.NoError(t, s.StoreCounter("cccccccccccb", user))
+
+		// Stored value must be copied
+		user.UsageCounter = 11
+
+		reopened, err := filecounters.NewTestStorage(zaptest.NewLogger(t), path)
+		require.NoError(t, err)
+
+		users, err := reopened.LoadCounters()
+		require.NoError(t, err)
+		require.Equal(t, common.OTPUsers{
+			"cccccccccccb": {UsageCounter: 10, SessionCounter: 3, Timestamp: [3]byte{1, 2, 3}},
+		}, users)
+	})
+
+	t.Run("delete counter", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "counters.json")
+
+		s, err := filecounters.NewTestStorage(zaptest.NewLogger(t), path)
+		require.NoError(t, err)
+
+		require.NoError(t, s.StoreCounter("cccccccccccb", &common.OTPUser{UsageCounter: 1}))
+		require.NoError(t, s.StoreCounter("cccccccccccd", &common.OTPUser{UsageCounter: 2}))
+		require.NoError(t, s.DeleteCounter("cccccccccccb"))
+		require.NoError(t, s.DeleteCounter("cccccccccccf"))
+
+		reopened, err := filecounters.NewTestStorage(zaptest.NewLogger(t), path)
+		require.NoError(t, err)
+
+		users, err := reopened.LoadCounters()
+		require.NoError(t, err)
+		require.Equal(t, common.OTPUsers{"cccccccccccd": {UsageCounter: 2}}, users)
+	})
+
+	t.Run("corrupted file", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "counters.json")
+		require.NoError(t, os.WriteFile(path, []byte("{not json"), 0o600))
+
+		_, err := filecounters.NewTestStorage(zaptest.NewLogger(t), path)
+		require.ErrorContains(t, err, "cannot parse counters file")
+	})
+
+	t.Run("write failure keeps previous state", func(t *testing.T) {
+		t.Parallel()
+
+		dir := filepath.Join(t.TempDir(), "sub")
+		require.NoError(t, os.Mkdir(dir, 0o700))
+
+		s, err := filecounters.NewTestStorage(zaptest.NewLogger(t), filepath.Join(dir, "counters.json"))
+		require.NoError(t, err)
+
+		require.NoError(t, os.Remove(dir))
+
+		require.Error(t, s.StoreCounter("cccccccccccb", &common.OTPUser{UsageCounter: 1}))
+
+		users, err := s.LoadCounters()
+		require.NoError(t, err)
+		require.Empty(t, users)
+	})
+}
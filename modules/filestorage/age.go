@@ -0,0 +1,43 @@
+package filestorage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// decryptAge decrypts the binary or armored age file with the identities of the identity file.
+func decryptAge(data []byte, identityFile string) ([]byte, error) {
+	f, err := os.Open(identityFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open age identity: %w", err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse age identity: %w", err)
+	}
+
+	var src io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(data, []byte(armor.Header)) {
+		src = armor.NewReader(src)
+	}
+
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt keys file: %w", err)
+	}
+
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt keys file: %w", err)
+	}
+
+	return plain, nil
+}
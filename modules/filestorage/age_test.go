@@ -0,0 +1,71 @@
+package filestorage_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/modules/filestorage"
+)
+
+func encryptAge(t *testing.T, recipient age.Recipient, armored bool) string {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	out := io.WriteCloser(nopCloser{buf})
+
+	if armored {
+		out = armor.NewWriter(buf)
+	}
+
+	w, err := age.Encrypt(out, recipient)
+	require.NoError(t, err)
+
+	_, err = io.WriteString(w, testKeysFile)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, out.Close())
+
+	return buf.String()
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func TestAgeEncrypted(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	identityFile := filepath.Join(dir, "identity.txt")
+	require.NoError(t, os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0o600))
+
+	for name, armored := range map[string]bool{"binary": false, "armored": true} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "keys.ykksm.age")
+			writeFile(t, path, encryptAge(t, identity.Recipient(), armored))
+
+			svc := filestorage.TestNewService(zaptest.NewLogger(t), path, identityFile)
+			require.NoError(t, svc.Connect(ctx))
+
+			keys, err := svc.ListKeys()
+			require.NoError(t, err)
+			require.Len(t, keys, 2)
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package filestorage
+
+import "bytes"
+
+// Headers of binary and armored age files.
+const (
+	ageHeader      = "age-encryption.org/"
+	ageArmorHeader = "-----BEGIN AGE ENCRYPTED FILE-----"
+)
+
+// isEncrypted reports whether data is an age file.
+func isEncrypted(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+
+	return bytes.HasPrefix(data, []byte(ageHeader)) || bytes.HasPrefix(data, []byte(ageArmorHeader))
+}
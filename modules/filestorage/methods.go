@@ -0,0 +1,65 @@
+package filestorage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+// Key represents a YubiKey record of the keys file.
+type Key = common.Key
+
+// DecryptOTP Decrypt OTP using stored private AES for specified public identifier.
+func (s *Service) DecryptOTP(ctx context.Context, publicID, token string) (*common.OTP, error) {
+	return common.DecryptOTP(ctx, s.log, s.GetKey, publicID, token)
+}
+
+// GetKey returns a copy of the loaded key with given publicID.
+func (s *Service) GetKey(_ context.Context, publicID string) (*Key, error) {
+	set := s.keys.Load()
+	if set == nil {
+		return nil, fmt.Errorf("%w: %w", common.ErrStorageBackend, common.ErrNotConnected)
+	}
+
+	key, ok := set.keys[publicID]
+	if !ok {
+		return nil, common.ErrStorageNoKey
+	}
+
+	k := *key
+
+	return &k, nil
+}
+
+// ListKeys returns copies of all loaded keys in the file order.
+func (s *Service) ListKeys() ([]*Key, error) {
+	set := s.keys.Load()
+	if set == nil {
+		return nil, common.ErrNotConnected
+	}
+
+	keys := make([]*Key, 0, len(set.list))
+
+	for _, key := range set.list {
+		k := *key
+		keys = append(keys, &k)
+	}
+
+	return keys, nil
+}
+
+// StoreKey is not supported, keys are changed in the file.
+func (s *Service) StoreKey(*Key) error {
+	return ErrReadOnly
+}
+
+// StoreKeys is not supported, keys are changed in the file.
+func (s *Service) StoreKeys([]*Key) error {
+	return ErrReadOnly
+}
+
+// DeleteKey is not supported, keys are changed in the file.
+func (s *Service) DeleteKey(string) error {
+	return ErrReadOnly
+}
@@ -0,0 +1,179 @@
+package filestorage_test
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/modules/filestorage"
+)
+
+const testKeyLine = "3,vvccccccccdc,0102030405ad,0102030405060708090a0b0c0d0e0f12,010203040506,2024-01-03T00:00:00Z,\n"
+
+// writeFile replaces the file by rename, as editors and deployment tools do.
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+
+	tmp := path + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, []byte(data), 0o600))
+	require.NoError(t, os.Rename(tmp, path))
+}
+
+func setupTestFile(t *testing.T) (*filestorage.Service, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "keys.ykksm")
+	writeFile(t, path, testKeysFile)
+
+	svc := filestorage.TestNewService(zaptest.NewLogger(t), path, "")
+	require.NoError(t, svc.Connect(context.Background()))
+
+	return svc, path
+}
+
+func TestKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	svc, _ := setupTestFile(t)
+
+	require.NoError(t, svc.CheckHealth(ctx))
+
+	key, err := svc.GetKey(ctx, "vvccccccccdb")
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), key.ID)
+
+	// Returned keys are copies
+	key.Active = false
+	key, err = svc.GetKey(ctx, "vvccccccccdb")
+	require.NoError(t, err)
+	require.True(t, key.Active)
+
+	_, err = svc.GetKey(ctx, "vvcccccccccb")
+	require.ErrorIs(t, err, common.ErrStorageNoKey)
+
+	keys, err := svc.ListKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, "vvcccccccccc", keys[0].PublicID)
+	require.Equal(t, "vvccccccccdb", keys[1].PublicID)
+
+	require.ErrorIs(t, svc.StoreKey(key), filestorage.ErrReadOnly)
+	require.ErrorIs(t, svc.StoreKeys(keys), filestorage.ErrReadOnly)
+	require.ErrorIs(t, svc.DeleteKey(key.PublicID), filestorage.ErrReadOnly)
+}
+
+func TestConnect(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("missing file", func(t *testing.T) {
+		t.Parallel()
+
+		svc := filestorage.TestNewService(zaptest.NewLogger(t), filepath.Join(t.TempDir(), "keys.ykksm"), "")
+		require.ErrorIs(t, svc.Connect(ctx), os.ErrNotExist)
+		require.ErrorIs(t, svc.CheckHealth(ctx), common.ErrNotConnected)
+
+		_, err := svc.GetKey(ctx, "vvcccccccccc")
+		require.ErrorIs(t, err, common.ErrNotConnected)
+	})
+
+	t.Run("encrypted file without identity", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "keys.ykksm.age")
+		writeFile(t, path, "age-encryption.org/v1\n-> X25519 abc\n")
+
+		svc := filestorage.TestNewService(zaptest.NewLogger(t), path, "")
+		require.ErrorIs(t, svc.Connect(ctx), filestorage.ErrNoIdentity)
+	})
+
+	t.Run("broken file keeps loaded keys", func(t *testing.T) {
+		t.Parallel()
+
+		svc, path := setupTestFile(t)
+
+		writeFile(t, path, testKeysFile+"broken line\n")
+		require.ErrorIs(t, svc.Connect(ctx), filestorage.ErrBadLine)
+
+		keys, err := svc.ListKeys()
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+	})
+}
+
+func TestDecryptOTP(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	svc, path := setupTestFile(t)
+
+	key, err := svc.GetKey(ctx, "vvcccccccccc")
+	require.NoError(t, err)
+
+	aesKey, err := hex.DecodeString(key.AESKey)
+	require.NoError(t, err)
+
+	otp := common.OTP{UsageCounter: 1, SessionCounter: 1}
+	_, err = hex.Decode(otp.PrivateID[:], []byte(key.PrivateID))
+	require.NoError(t, err)
+
+	token, err := otp.EncryptToModHex(aesKey)
+	require.NoError(t, err)
+
+	decrypted, err := svc.DecryptOTP(ctx, key.PublicID, token)
+	require.NoError(t, err)
+	require.Equal(t, otp.PrivateID, decrypted.PrivateID)
+
+	// Key of another public ID does not match the private ID
+	writeFile(t, path, "1,vvcccccccccc,0102030405ad,0102030405060708090a0b0c0d0e0f10,010203040506,,\n")
+	require.NoError(t, svc.Connect(ctx))
+
+	_, err = svc.DecryptOTP(ctx, key.PublicID, token)
+	require.ErrorIs(t, err, common.ErrStorageDecryptFail)
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	svc, path := setupTestFile(t)
+
+	done := make(chan error, 1)
+
+	go func() { done <- svc.Start(ctx) }()
+
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	hasKey := func() bool {
+		_, err := svc.GetKey(ctx, "vvccccccccdc")
+
+		return err == nil
+	}
+
+	// Watch starts after the file is loaded, change is repeated until it is noticed
+	require.Eventually(t, func() bool {
+		writeFile(t, path, testKeysFile+testKeyLine)
+
+		return hasKey()
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// Broken file is ignored
+	writeFile(t, path, "broken line\n")
+	time.Sleep(300 * time.Millisecond)
+	require.True(t, hasKey())
+
+	writeFile(t, path, testKeysFile)
+	require.Eventually(t, func() bool { return !hasKey() }, 5*time.Second, 50*time.Millisecond)
+}
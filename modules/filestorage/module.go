@@ -0,0 +1,36 @@
+// Package filestorage represents read-only keys storage loaded from a ykksm export file.
+//
+// The file, plain or age-encrypted, is loaded into memory and watched for changes: a changed file
+// is parsed completely and replaces the served keys at once, a broken one is logged and ignored.
+package filestorage
+
+import (
+	"github.com/im-kulikov/helium/module"
+	"go.uber.org/zap"
+)
+
+// Module storage constructor.
+var Module = module.Module{ //nolint:gochecknoglobals
+	{Constructor: newService},
+}
+
+// TestNewService creates a new service for testing purposes.
+func TestNewService(log *zap.Logger, path, identity string) *Service {
+	return &Service{log: log, path: path, identity: identity, reloadDelay: defaultReloadDelay}
+}
+
+func newService(p serviceParams) (serviceOutParams, error) {
+	svc := &Service{
+		log:         p.Logger,
+		path:        p.Config.GetString("file.path"),
+		identity:    p.Config.GetString("file.age_identity"),
+		reloadDelay: p.Config.GetDuration("file.reload_delay"),
+	}
+
+	return serviceOutParams{
+		Service:  svc,
+		Storage:  svc,
+		KeyAdmin: svc,
+		Health:   svc,
+	}, nil
+}
@@ -0,0 +1,169 @@
+package filestorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/im-kulikov/helium/service"
+	"github.com/spf13/viper"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/dig"
+	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+// defaultReloadDelay collects a burst of file system events into one reload.
+const defaultReloadDelay = 100 * time.Millisecond
+
+var (
+	ErrReadOnly   = fmt.Errorf("file %w, change the keys file instead", common.ErrStorageReadOnly)
+	ErrNoIdentity = errors.New("keys file is age-encrypted, but no age identity is set")
+)
+
+type (
+	serviceParams struct {
+		dig.In
+
+		Logger *zap.Logger
+		Config *viper.Viper
+	}
+
+	serviceOutParams struct {
+		dig.Out
+		Service  service.Service `group:"services"`
+		Storage  common.StorageInterface
+		KeyAdmin common.KeyAdmin
+		Health   common.HealthChecker `group:"health_checks"`
+	}
+
+	// Service for keys file storage.
+	Service struct {
+		log *zap.Logger
+
+		// Keys loaded from the file, replaced as a whole on reload
+		keys atomic.Pointer[keySet]
+
+		path        string
+		identity    string
+		reloadDelay time.Duration
+	}
+
+	// keySet is the loaded content of the keys file.
+	keySet struct {
+		// Checksum of the file, unchanged files are not parsed again
+		sum  [sha256.Size]byte
+		list []*Key
+		keys map[string]*Key
+	}
+)
+
+// Start the storage service, the keys file is reloaded on changes until the service is stopped.
+func (s *Service) Start(ctx context.Context) error {
+	if err := s.Connect(ctx); err != nil {
+		return err
+	}
+
+	return s.watch(ctx)
+}
+
+// Connect loads the keys file.
+func (s *Service) Connect(_ context.Context) error {
+	s.log.Debug("keys storage start", zap.String("path", s.path))
+
+	if _, err := s.load(); err != nil {
+		return fmt.Errorf("cannot load keys file: %w", err)
+	}
+
+	return nil
+}
+
+// load the keys file, unless it is unchanged, and replace the served keys with its keys.
+// Served keys are kept if the file cannot be read, decrypted or parsed.
+func (s *Service) load() (bool, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return false, err
+	}
+
+	sum := sha256.Sum256(data)
+	if current := s.keys.Load(); current != nil && current.sum == sum {
+		return false, nil
+	}
+
+	if isEncrypted(data) {
+		if s.identity == "" {
+			return false, ErrNoIdentity
+		}
+
+		if data, err = decryptAge(data, s.identity); err != nil {
+			return false, err
+		}
+	}
+
+	// Plaintext secrets are not left in memory beyond the parsed keys
+	defer clear(data)
+
+	list, err := ParseKeys(bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+
+	set := &keySet{sum: sum, list: list, keys: make(map[string]*Key, len(list))}
+	for _, key := range list {
+		set.keys[key.PublicID] = key
+	}
+
+	s.keys.Store(set)
+
+	return true, nil
+}
+
+// reload the changed keys file, logging the result.
+func (s *Service) reload() {
+	changed, err := s.load()
+
+	switch {
+	case err != nil:
+		s.log.Error("cannot reload keys file, previous keys are served", zap.String("path", s.path), zap.Error(err))
+	case changed:
+		s.log.Info("keys file reloaded", zap.String("path", s.path), zap.Int("keys", len(s.keys.Load().list)))
+	}
+}
+
+// Stop the storage service.
+func (s *Service) Stop(_ context.Context) {}
+
+// Close the storage, loaded keys are kept.
+func (s *Service) Close() error {
+	return nil
+}
+
+// CheckHealth checks that the keys file is loaded.
+func (s *Service) CheckHealth(_ context.Context) error {
+	if s.keys.Load() == nil {
+		return common.ErrNotConnected
+	}
+
+	return nil
+}
+
+// Name of the service.
+func (s *Service) Name() string {
+	return "file-keys-storage"
+}
+
+// Defaults for the file storage service.
+func Defaults(ctx *cli.Context, v *viper.Viper) error {
+	v.SetDefault("file.path", ctx.String("keys-file"))
+	v.SetDefault("file.age_identity", ctx.String("keys-file-identity"))
+	v.SetDefault("file.reload_delay", defaultReloadDelay)
+
+	return nil
+}
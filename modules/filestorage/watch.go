@@ -0,0 +1,60 @@
+package filestorage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// kubernetesDataLink is swapped by Kubernetes on update of ConfigMap and Secret volumes.
+const kubernetesDataLink = "..data"
+
+// watch the directory of the keys file and reload the file after changes. The directory is watched,
+// since files are usually replaced by rename, and volumes of Kubernetes swap the link to the data.
+func (s *Service) watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("cannot watch keys file: %w", err)
+	}
+
+	defer func() { _ = watcher.Close() }()
+
+	if err = watcher.Add(filepath.Dir(s.path)); err != nil {
+		return fmt.Errorf("cannot watch keys file: %w", err)
+	}
+
+	name := filepath.Base(s.path)
+
+	var reload <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			// Events are collected until the pending reload, so the file is reloaded under constant changes too
+			base := filepath.Base(event.Name)
+			if reload == nil && (base == name || base == kubernetesDataLink) {
+				reload = time.After(s.reloadDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+
+			s.log.Warn("keys file watch failed", zap.Error(err))
+		case <-reload:
+			reload = nil
+
+			s.reload()
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package filestorage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ykksm export fields: serialnr,identity,internaluid,aeskey,lockpw,created,accessed[,progflags].
+const (
+	ykksmFields         = 7
+	ykksmFieldsProgflag = 8
+)
+
+var (
+	ErrBadLine      = errors.New("invalid ykksm line")
+	ErrDuplicateKey = errors.New("duplicate public ID")
+)
+
+// ParseKeys reads keys in ykksm export format, as printed by the generate command. Lines starting
+// with # and empty lines are skipped, all keys are active.
+func ParseKeys(r io.Reader) ([]*Key, error) {
+	keys := make([]*Key, 0)
+	seen := make(map[string]int)
+
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		key, err := parseKey(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if first, ok := seen[key.PublicID]; ok {
+			return nil, fmt.Errorf("line %d: %s first seen on line %d: %w", line, key.PublicID, first, ErrDuplicateKey)
+		}
+
+		seen[key.PublicID] = line
+		keys = append(keys, key)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+func parseKey(text string) (*Key, error) {
+	fields := strings.Split(text, ",")
+	if len(fields) != ykksmFields && len(fields) != ykksmFieldsProgflag {
+		return nil, fmt.Errorf("%d fields: %w", len(fields), ErrBadLine)
+	}
+
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	serial, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("serialnr %q: %w", fields[0], ErrBadLine)
+	}
+
+	key := &Key{
+		ID:        serial,
+		PublicID:  fields[1],
+		PrivateID: strings.ToLower(fields[2]),
+		AESKey:    strings.ToLower(fields[3]),
+		LockCode:  strings.ToLower(fields[4]),
+		Created:   fields[5],
+		Active:    true,
+	}
+
+	if err = key.Validate(); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
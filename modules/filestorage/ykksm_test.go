@@ -0,0 +1,79 @@
+package filestorage_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/modules/filestorage"
+)
+
+const testKeysFile = `# ykksm 1
+# start 1 end 2
+# serialnr,identity,internaluid,aeskey,lockpw,created,accessed[,progflags]
+1,vvcccccccccc,0102030405ab,0102030405060708090a0b0c0d0e0f10,010203040506,2024-01-01T00:00:00Z,,-ofixed=h:0000
+
+2,vvccccccccdb,0102030405AC,0102030405060708090A0B0C0D0E0F11,0102030405AF,2024-01-02T00:00:00Z,
+# the end
+`
+
+func TestParseKeys(t *testing.T) {
+	t.Parallel()
+
+	t.Run("generate output", func(t *testing.T) {
+		t.Parallel()
+
+		keys, err := filestorage.ParseKeys(strings.NewReader(testKeysFile))
+		require.NoError(t, err)
+		require.Equal(t, []*filestorage.Key{
+			{
+				ID:        1,
+				PublicID:  "vvcccccccccc",
+				PrivateID: "0102030405ab",
+				AESKey:    "0102030405060708090a0b0c0d0e0f10",
+				LockCode:  "010203040506",
+				Created:   "2024-01-01T00:00:00Z",
+				Active:    true,
+			},
+			{
+				ID:        2,
+				PublicID:  "vvccccccccdb",
+				PrivateID: "0102030405ac",
+				AESKey:    "0102030405060708090a0b0c0d0e0f11",
+				LockCode:  "0102030405af",
+				Created:   "2024-01-02T00:00:00Z",
+				Active:    true,
+			},
+		}, keys)
+	})
+
+	t.Run("invalid lines", func(t *testing.T) {
+		t.Parallel()
+
+		for line, expected := range map[string]error{
+			"1,vvcccccccccc,0102030405ab":                                         filestorage.ErrBadLine,
+			"x,vvcccccccccc,0102030405ab,0102030405060708090a0b0c0d0e0f10,,,":     filestorage.ErrBadLine,
+			"1,vvccccccccc!,0102030405ab,0102030405060708090a0b0c0d0e0f10,,,":     common.ErrKeyInvalidPublicID,
+			"1,vvcccccccccc,0102030405,0102030405060708090a0b0c0d0e0f10,,,":       common.ErrKeyInvalidPrivateID,
+			"1,vvcccccccccc,0102030405ab,0102030405060708090a0b0c0d0e0fzz,,,":     common.ErrKeyInvalidAESKey,
+			"1,vvcccccccccc,0102030405ab,0102030405060708090a0b0c0d0e0f10,0102,,": common.ErrKeyInvalidLockCode,
+			"1,vvcccccccccc,0102030405ab,0102030405060708090a0b0c0d0e0f10,,,":     common.ErrKeyInvalidLockCode,
+		} {
+			_, err := filestorage.ParseKeys(strings.NewReader(line))
+			require.ErrorIs(t, err, expected, line)
+			require.ErrorContains(t, err, "line 1:")
+		}
+	})
+
+	t.Run("duplicate key", func(t *testing.T) {
+		t.Parallel()
+
+		line := "1,vvcccccccccc,0102030405ab,0102030405060708090a0b0c0d0e0f10,010203040506,,\n"
+
+		_, err := filestorage.ParseKeys(strings.NewReader(line + "# again\n" + line))
+		require.ErrorIs(t, err, filestorage.ErrDuplicateKey)
+		require.ErrorContains(t, err, "line 3: vvcccccccccc first seen on line 1")
+	})
+}
@@ -0,0 +1,68 @@
+package sqliteclients
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+// GetClient returns the client with the given ID.
+func (s *Service) GetClient(id uint64) (*common.Client, error) {
+	client := common.Client{}
+
+	if err := s.db.Get(&client, "SELECT id, secret, active, description FROM Clients WHERE id=?", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, common.ErrClientNotFound
+		}
+
+		return nil, fmt.Errorf("cannot get client: %w", err)
+	}
+
+	return &client, nil
+}
+
+// ListClients returns all clients ordered by ID.
+func (s *Service) ListClients() ([]*common.Client, error) {
+	clients := make([]*common.Client, 0)
+
+	if err := s.db.Select(&clients, "SELECT id, secret, active, description FROM Clients ORDER BY id"); err != nil {
+		return nil, fmt.Errorf("cannot list clients: %w", err)
+	}
+
+	return clients, nil
+}
+
+// StoreClient creates or replaces the client.
+func (s *Service) StoreClient(c *common.Client) error {
+	if _, err := s.db.Exec("REPLACE INTO Clients (id, secret, active, description) VALUES (?,?,?,?)",
+		c.ID,
+		c.Secret,
+		c.Active,
+		c.Description,
+	); err != nil {
+		return fmt.Errorf("cannot store client: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteClient removes the client with the given ID.
+func (s *Service) DeleteClient(id uint64) error {
+	res, err := s.db.Exec("DELETE FROM Clients WHERE id=?", id)
+	if err != nil {
+		return fmt.Errorf("cannot delete client: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("cannot delete client: %w", err)
+	}
+
+	if affected == 0 {
+		return common.ErrClientNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package sqliteclients_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/modules/sqliteclients"
+	"github.com/archaron/go-yubiserv/sqlitedriver"
+)
+
+func TestClients(t *testing.T) {
+	db, err := sqlitedriver.Open(":memory:")
+	require.NoError(t, err)
+
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	svc, err := sqliteclients.TestNewService(zaptest.NewLogger(t), db)
+	require.NoError(t, err)
+
+	t.Run("empty database", func(t *testing.T) {
+		clients, err := svc.ListClients()
+		require.NoError(t, err)
+		require.Empty(t, clients)
+
+		_, err = svc.GetClient(1)
+		require.ErrorIs(t, err, common.ErrClientNotFound)
+	})
+
+	t.Run("store and get", func(t *testing.T) {
+		second := &common.Client{ID: 2, Active: false, Description: "disabled"}
+		first := &common.Client{ID: 1, Secret: "ynS/XoXc2gwGDBssYSu2w21Aky4=", Active: true, Description: "VPN"}
+
+		require.NoError(t, svc.StoreClient(second))
+		require.NoError(t, svc.StoreClient(first))
+
+		client, err := svc.GetClient(1)
+		require.NoError(t, err)
+		require.Equal(t, first, client)
+
+		// Update existing record
+		second.Active = true
+		require.NoError(t, svc.StoreClient(second))
+
+		clients, err := svc.ListClients()
+		require.NoError(t, err)
+		require.Equal(t, []*common.Client{first, second}, clients)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, svc.DeleteClient(2))
+		require.ErrorIs(t, svc.DeleteClient(2), common.ErrClientNotFound)
+
+		_, err := svc.GetClient(2)
+		require.ErrorIs(t, err, common.ErrClientNotFound)
+	})
+
+	t.Run("health", func(t *testing.T) {
+		require.NoError(t, svc.CheckHealth(context.Background()))
+	})
+
+	t.Run("closed database", func(t *testing.T) {
+		require.NoError(t, db.Close())
+
+		require.ErrorContains(t, svc.CheckHealth(context.Background()), "database check failed")
+
+		_, err := svc.GetClient(1)
+		require.ErrorContains(t, err, "cannot get client")
+		require.ErrorContains(t, svc.StoreClient(&common.Client{ID: 3}), "cannot store client")
+	})
+}
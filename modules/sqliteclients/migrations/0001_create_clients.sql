@@ -0,0 +1,7 @@
+-- Validation API clients, like the ykval clients table
+CREATE TABLE IF NOT EXISTS Clients (
+    id          INTEGER      PRIMARY KEY,           -- Client ID
+    secret      VARCHAR(60)  NOT NULL DEFAULT '',   -- Base64-encoded HMAC key, empty disables signatures
+    active      BOOLEAN      NOT NULL DEFAULT TRUE, -- Activation flag
+    description TEXT         NOT NULL DEFAULT ''    -- Client description
+);
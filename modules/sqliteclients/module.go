@@ -0,0 +1,59 @@
+// Package sqliteclients implements SQLite validation API clients registry.
+package sqliteclients
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/im-kulikov/helium/module"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Module clients registry constructor.
+var Module = module.Module{ //nolint:gochecknoglobals
+	{Constructor: newService},
+}
+
+// TestNewService creates a new service for testing purposes.
+func TestNewService(log *zap.Logger, db *sqlx.DB) (*Service, error) {
+	svc := &Service{log: log, db: db}
+
+	if _, err := svc.Migrate(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return svc, nil
+}
+
+// OpenDB opens the clients database at dbPath without changing its schema, e.g. to show or apply its migrations.
+func OpenDB(ctx context.Context, log *zap.Logger, dbPath string) (*Service, error) {
+	svc := &Service{log: log, dbPath: dbPath}
+
+	if err := svc.Open(ctx); err != nil {
+		_ = svc.Close()
+
+		return nil, err
+	}
+
+	return svc, nil
+}
+
+func newService(p serviceParams) (serviceOutParams, error) {
+	svc := &Service{
+		log:    p.Logger,
+		dbPath: p.Config.GetString("clients.dbpath"),
+	}
+
+	// Clients must be available before the API starts serving, so open the database right away.
+	if err := svc.open(); err != nil {
+		return serviceOutParams{}, fmt.Errorf("cannot open clients database: %w", err)
+	}
+
+	return serviceOutParams{
+		Service:     svc,
+		Clients:     svc,
+		ClientAdmin: svc,
+		Health:      svc,
+	}, nil
+}
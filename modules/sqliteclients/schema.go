@@ -0,0 +1,84 @@
+package sqliteclients
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/sqlmigrate"
+)
+
+// migrationsComponent is the name of the clients schema in the schema_migrations table.
+const migrationsComponent = "clients"
+
+// Schema migrations of the clients storage, see migrations/*.sql.
+//
+// The Clients table keeps validation API clients like the ykval clients table.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+func migrations() (*sqlmigrate.Set, error) {
+	files, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("cannot load migrations: %w", err)
+	}
+
+	return sqlmigrate.Load(migrationsComponent, files, baseline)
+}
+
+// baseline detects the schema version of Clients table created before migrations were versioned.
+func baseline(ctx context.Context, tx *sqlx.Tx) (int, error) {
+	var count int
+	if err := tx.GetContext(ctx, &count, "SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='Clients'"); err != nil {
+		return 0, fmt.Errorf("cannot check Clients table: %w", err)
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	return 1, nil
+}
+
+// Migrate applies pending schema migrations, databases of a newer schema are refused.
+func (s *Service) Migrate(ctx context.Context) ([]sqlmigrate.Migration, error) {
+	if s.db == nil {
+		return nil, common.ErrNotConnected
+	}
+
+	set, err := migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := set.Apply(ctx, s.db)
+	if err != nil {
+		return applied, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	for _, m := range applied {
+		s.log.Info("clients database migration applied", zap.Int("version", m.Version), zap.String("name", m.Name))
+	}
+
+	return applied, nil
+}
+
+// MigrationStatus returns all migrations of the clients storage with their applied time and the schema version.
+func (s *Service) MigrationStatus(ctx context.Context) ([]sqlmigrate.Status, int, error) {
+	if s.db == nil {
+		return nil, 0, common.ErrNotConnected
+	}
+
+	set, err := migrations()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return set.Status(ctx, s.db)
+}
@@ -0,0 +1,74 @@
+package sqlitecounters
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+// counter represents a counters record in the SQLite database.
+type counter struct {
+	PublicID       string `db:"public_id"`
+	UsageCounter   uint16 `db:"usage_counter"`
+	SessionCounter uint8  `db:"session_counter"`
+	Timestamp      uint32 `db:"timestamp"`
+	Seen           int64  `db:"seen"`
+}
+
+// LoadCounters reads all stored counters from the database.
+func (s *Service) LoadCounters() (common.OTPUsers, error) {
+	var rows []counter
+
+	if err := s.db.Select(&rows, "SELECT public_id, usage_counter, session_counter, timestamp, seen FROM Counters"); err != nil {
+		return nil, fmt.Errorf("cannot load counters: %w", err)
+	}
+
+	users := make(common.OTPUsers, len(rows))
+
+	for _, row := range rows {
+		user := &common.OTPUser{
+			UsageCounter:   row.UsageCounter,
+			SessionCounter: row.SessionCounter,
+			Timestamp:      common.TimestampBytes(row.Timestamp),
+		}
+
+		if row.Seen != 0 {
+			user.Seen = time.Unix(0, row.Seen)
+		}
+
+		users[row.PublicID] = user
+	}
+
+	return users, nil
+}
+
+// StoreCounter saves counters for the given public ID.
+func (s *Service) StoreCounter(publicID string, user *common.OTPUser) error {
+	var seen int64
+	if !user.Seen.IsZero() {
+		seen = user.Seen.UnixNano()
+	}
+
+	if _, err := s.db.Exec(
+		"REPLACE INTO Counters (public_id, usage_counter, session_counter, timestamp, seen) VALUES (?,?,?,?,?)",
+		publicID,
+		user.UsageCounter,
+		user.SessionCounter,
+		common.TimestampValue(user.Timestamp),
+		seen,
+	); err != nil {
+		return fmt.Errorf("cannot store counter: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteCounter removes counters for the given public ID.
+func (s *Service) DeleteCounter(publicID string) error {
+	if _, err := s.db.Exec("DELETE FROM Counters WHERE public_id=?", publicID); err != nil {
+		return fmt.Errorf("cannot delete counter: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package sqlitecounters_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/modules/sqlitecounters"
+	"github.com/archaron/go-yubiserv/sqlitedriver"
+)
+
+func TestCounters(t *testing.T) {
+	db, err := sqlitedriver.Open(":memory:")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = db.Close() })
+
+	svc, err := sqlitecounters.TestNewService(zaptest.NewLogger(t), db)
+	require.NoError(t, err)
+
+	t.Run("empty database", func(t *testing.T) {
+		users, err := svc.LoadCounters()
+		require.NoError(t, err)
+		require.Empty(t, users)
+	})
+
+	t.Run("store and load", func(t *testing.T) {
+		seen := time.Unix(1700000000, 123456789)
+
+		require.NoError(t, svc.StoreCounter("cccccccccccb", &common.OTPUser{
+			UsageCounter:   0x1234,
+			SessionCounter: 0x56,
+			Timestamp:      [3]byte{0x24, 0x13, 0xa7},
+			Seen:           seen,
+		}))
+
+		require.NoError(t, svc.StoreCounter("cccccccccccd", &common.OTPUser{UsageCounter: 1}))
+
+		// Update existing record
+		require.NoError(t, svc.StoreCounter("cccccccccccd", &common.OTPUser{UsageCounter: 2, SessionCounter: 1}))
+
+		users, err := svc.LoadCounters()
+		require.NoError(t, err)
+		require.Equal(t, common.OTPUsers{
+			"cccccccccccb": {UsageCounter: 0x1234, SessionCounter: 0x56, Timestamp: [3]byte{0x24, 0x13, 0xa7}, Seen: seen},
+			"cccccccccccd": {UsageCounter: 2, SessionCounter: 1},
+		}, users)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, svc.DeleteCounter("cccccccccccd"))
+		require.NoError(t, svc.DeleteCounter("cccccccccccd"))
+
+		users, err := svc.LoadCounters()
+		require.NoError(t, err)
+		require.NotContains(t, users, "cccccccccccd")
+		require.Contains(t, users, "cccccccccccb")
+	})
+
+	t.Run("invalid public id", func(t *testing.T) {
+		require.Error(t, svc.StoreCounter("short", &common.OTPUser{}))
+	})
+
+	t.Run("health", func(t *testing.T) {
+		require.NoError(t, svc.CheckHealth(context.Background()))
+	})
+
+	t.Run("closed database", func(t *testing.T) {
+		require.NoError(t, db.Close())
+
+		require.ErrorContains(t, svc.CheckHealth(context.Background()), "database check failed")
+
+		_, err := svc.LoadCounters()
+		require.ErrorContains(t, err, "cannot load counters")
+		require.ErrorContains(t, svc.StoreCounter("cccccccccccb", &common.OTPUser{}), "cannot store counter")
+		require.ErrorContains(t, svc.DeleteCounter("cccccccccccb"), "cannot delete counter")
+	})
+}
@@ -0,0 +1,8 @@
+-- Last accepted counters of every YubiKey
+CREATE TABLE IF NOT EXISTS Counters (
+    public_id       VARCHAR(16) PRIMARY KEY, -- YubiKey public ID
+    usage_counter   INTEGER     NOT NULL,    -- Last accepted usage counter
+    session_counter INTEGER     NOT NULL,    -- Last accepted session counter
+    timestamp       INTEGER     NOT NULL,    -- Last accepted 24-bit timestamp counter
+    CONSTRAINT chk_public_id CHECK (LENGTH(public_id) = 12)
+);
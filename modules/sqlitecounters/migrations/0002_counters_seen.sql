@@ -0,0 +1,2 @@
+-- Wall-clock time of the last accepted OTP, unix nanoseconds
+ALTER TABLE Counters ADD COLUMN seen INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,89 @@
+package sqlitecounters
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"slices"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/sqlmigrate"
+)
+
+// migrationsComponent is the name of the counters schema in the schema_migrations table.
+const migrationsComponent = "counters"
+
+// Schema migrations of the counters storage, see migrations/*.sql.
+//
+// The Counters table keeps the last accepted usage/session counters, timestamp
+// and acceptance time for every YubiKey public ID.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+func migrations() (*sqlmigrate.Set, error) {
+	files, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("cannot load migrations: %w", err)
+	}
+
+	return sqlmigrate.Load(migrationsComponent, files, baseline)
+}
+
+// baseline detects the schema version of Counters table created before migrations were versioned.
+func baseline(ctx context.Context, tx *sqlx.Tx) (int, error) {
+	columns := make([]string, 0)
+	if err := tx.SelectContext(ctx, &columns, "SELECT name FROM pragma_table_info('Counters')"); err != nil {
+		return 0, fmt.Errorf("cannot check Counters table: %w", err)
+	}
+
+	switch {
+	case len(columns) == 0:
+		return 0, nil
+	case slices.Contains(columns, "seen"):
+		return 2, nil
+	default:
+		return 1, nil
+	}
+}
+
+// Migrate applies pending schema migrations, databases of a newer schema are refused.
+func (s *Service) Migrate(ctx context.Context) ([]sqlmigrate.Migration, error) {
+	if s.db == nil {
+		return nil, common.ErrNotConnected
+	}
+
+	set, err := migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := set.Apply(ctx, s.db)
+	if err != nil {
+		return applied, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	for _, m := range applied {
+		s.log.Info("counters database migration applied", zap.Int("version", m.Version), zap.String("name", m.Name))
+	}
+
+	return applied, nil
+}
+
+// MigrationStatus returns all migrations of the counters storage with their applied time and the schema version.
+func (s *Service) MigrationStatus(ctx context.Context) ([]sqlmigrate.Status, int, error) {
+	if s.db == nil {
+		return nil, 0, common.ErrNotConnected
+	}
+
+	set, err := migrations()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return set.Status(ctx, s.db)
+}
@@ -0,0 +1,77 @@
+package sqlitecounters_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/modules/sqlitecounters"
+	"github.com/archaron/go-yubiserv/sqlitedriver"
+)
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	db, err := sqlitedriver.Open(":memory:")
+	require.NoError(t, err)
+
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	// Counters table created before the seen column and versioned migrations
+	_, err = db.Exec(`
+CREATE TABLE Counters (
+    public_id       VARCHAR(16) PRIMARY KEY,
+    usage_counter   INTEGER     NOT NULL,
+    session_counter INTEGER     NOT NULL,
+    timestamp       INTEGER     NOT NULL,
+    CONSTRAINT chk_public_id CHECK (LENGTH(public_id) = 12)
+);
+INSERT INTO Counters VALUES ('cccccccccccc', 7, 3, 4660);`)
+	require.NoError(t, err)
+
+	svc, err := sqlitecounters.TestNewService(zaptest.NewLogger(t), db)
+	require.NoError(t, err)
+
+	statuses, version, err := svc.MigrationStatus(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, version)
+	require.Len(t, statuses, 2)
+	require.NotEmpty(t, statuses[1].AppliedAt)
+
+	users, err := svc.LoadCounters()
+	require.NoError(t, err)
+	require.Equal(t, uint16(7), users["cccccccccccc"].UsageCounter)
+	require.True(t, users["cccccccccccc"].Seen.IsZero())
+
+	users["cccccccccccc"].Seen = time.Unix(1700000000, 0)
+	require.NoError(t, svc.StoreCounter("cccccccccccc", users["cccccccccccc"]))
+
+	// Migrated database is not migrated again
+	applied, err := svc.Migrate(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, applied)
+}
+
+func TestOpenDB(t *testing.T) {
+	t.Parallel()
+
+	svc, err := sqlitecounters.OpenDB(context.Background(), zaptest.NewLogger(t), filepath.Join(t.TempDir(), "counters.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = svc.Close() })
+
+	// Opened database is not migrated until asked to
+	statuses, version, err := svc.MigrationStatus(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, version)
+	require.Len(t, statuses, 2)
+	require.Empty(t, statuses[0].AppliedAt)
+
+	applied, err := svc.Migrate(context.Background())
+	require.NoError(t, err)
+	require.Len(t, applied, 2)
+}
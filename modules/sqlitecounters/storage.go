@@ -0,0 +1,121 @@
+package sqlitecounters
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/im-kulikov/helium/service"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/dig"
+	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/sqlitedriver"
+)
+
+type (
+	serviceParams struct {
+		dig.In
+
+		Logger *zap.Logger
+		Config *viper.Viper
+	}
+
+	serviceOutParams struct {
+		dig.Out
+		Service  service.Service `group:"services"`
+		Counters common.CounterStorage
+		Health   common.HealthChecker `group:"health_checks"`
+	}
+
+	// Service for SQLite counters storage.
+	Service struct {
+		log *zap.Logger
+		db  *sqlx.DB
+
+		dbPath string
+	}
+)
+
+func (s *Service) open() error {
+	if err := s.Open(context.Background()); err != nil {
+		return err
+	}
+
+	if _, err := s.Migrate(context.Background()); err != nil {
+		return fmt.Errorf("could not create database: %w", err)
+	}
+
+	return nil
+}
+
+// Open the database without changing its schema.
+func (s *Service) Open(ctx context.Context) error {
+	var err error
+
+	s.log.Debug("counters storage open", zap.String("db_path", s.dbPath))
+
+	s.db, err = sqlitedriver.Open(s.dbPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to open database")
+	}
+
+	if err = s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("could not connect to database: %w", err)
+	}
+
+	return nil
+}
+
+// Close the database.
+func (s *Service) Close() error {
+	if s.db == nil {
+		return nil
+	}
+
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("cannot close database: %w", err)
+	}
+
+	return nil
+}
+
+// Start the storage service.
+func (s *Service) Start(ctx context.Context) error {
+	<-ctx.Done()
+
+	return nil
+}
+
+// Stop the storage service.
+func (s *Service) Stop(_ context.Context) {
+	_ = s.Close()
+}
+
+// CheckHealth checks that the Counters table is readable.
+func (s *Service) CheckHealth(ctx context.Context) error {
+	if s.db == nil {
+		return common.ErrNotConnected
+	}
+
+	if _, err := s.db.ExecContext(ctx, "SELECT 1 FROM Counters LIMIT 1"); err != nil {
+		return fmt.Errorf("database check failed: %w", err)
+	}
+
+	return nil
+}
+
+// Name of the service.
+func (s *Service) Name() string {
+	return "sqlite-counters-storage"
+}
+
+// Defaults for the sqlite counters storage service.
+func Defaults(ctx *cli.Context, v *viper.Viper) error {
+	v.SetDefault("counters.dbpath", ctx.String("counters-dbpath")+"?mode=rwc&cache=shared")
+
+	return nil
+}
@@ -1,136 +1,154 @@
 package sqlitestorage
 
 import (
+	"context"
 	"database/sql"
-	"encoding/hex"
 	"fmt"
 
 	"github.com/pkg/errors"
-	"go.uber.org/zap"
 
 	"github.com/archaron/go-yubiserv/common"
-	"github.com/archaron/go-yubiserv/misc"
 )
 
 // DecryptOTP Decrypt OTP using stored private AES for specified public identifier.
-func (s *Service) DecryptOTP(publicID, token string) (*common.OTP, error) {
-	log := s.log.With(
-		zap.String("public_id", publicID),
-		zap.String("token", token),
-	)
-
-	key, err := s.getKeyFunc(publicID)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, common.ErrStorageNoKey
-		}
+func (s *Service) DecryptOTP(ctx context.Context, publicID, token string) (*common.OTP, error) {
+	return common.DecryptOTP(ctx, s.log, s.lookupKey, publicID, token)
+}
 
-		return nil, err
+// lookupKey gets the key for DecryptOTP, a missing row is a missing key.
+func (s *Service) lookupKey(ctx context.Context, publicID string) (*Key, error) {
+	key, err := s.getKeyFunc(ctx, publicID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, common.ErrStorageNoKey
 	}
 
-	if !key.Active {
-		return nil, common.ErrStorageKeyInactive
-	}
+	return key, err
+}
 
-	aesKey, err := hex.DecodeString(key.AESKey)
+// StoreKey stores given key into the database, secrets are sealed if KEK is configured.
+func (s *Service) StoreKey(k *Key) error {
+	row, err := s.sealKey(context.Background(), k)
 	if err != nil {
-		s.log.Error("failed to decode AES key", zap.Error(err))
+		return err
+	}
 
-		return nil, common.ErrStorageDecryptFail
+	if _, err = s.db.NamedExec("REPLACE INTO Keys "+keyColumns+" VALUES "+keyValues, row); err != nil {
+		return fmt.Errorf("cannot store key: %w", err)
 	}
 
-	binToken, err := hex.DecodeString(misc.ModHexToHex(token))
-	if err != nil {
-		s.log.Error("failed to decode token", zap.Error(err))
+	s.purgeCache(k.PublicID)
 
-		return nil, common.ErrStorageDecryptFail
-	}
+	return nil
+}
 
-	otp := &common.OTP{}
+// StoreKeys adds new keys into the database in one transaction.
+func (s *Service) StoreKeys(keys []*Key) error {
+	rows := make([]*keyRow, 0, len(keys))
 
-	if err = otp.Decrypt(aesKey, binToken); err != nil {
-		log.Error("AES decryption failed")
+	for _, k := range keys {
+		row, err := s.sealKey(context.Background(), k)
+		if err != nil {
+			return err
+		}
+
+		rows = append(rows, row)
+	}
 
-		return nil, common.ErrStorageDecryptFail
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("cannot begin transaction: %w", err)
 	}
 
-	if hex.EncodeToString(otp.PrivateID[:]) != key.PrivateID {
-		log.Error("private ID mismatch",
-			zap.String("opt_private_id", hex.EncodeToString(otp.PrivateID[:])),
-			zap.String("key_private_id", key.PrivateID),
-		)
+	defer func() { _ = tx.Rollback() }()
 
-		return nil, common.ErrStorageDecryptFail
+	for _, row := range rows {
+		var count int
+		if err = tx.Get(&count, "SELECT COUNT(*) FROM Keys WHERE public_id=?", row.PublicID); err != nil {
+			return fmt.Errorf("cannot check key %s: %w", row.PublicID, err)
+		}
+
+		if count > 0 {
+			return fmt.Errorf("%s: %w", row.PublicID, common.ErrStorageKeyExists)
+		}
+
+		if _, err = tx.NamedExec("INSERT INTO Keys "+keyColumns+" VALUES "+keyValues, row); err != nil {
+			return fmt.Errorf("cannot store key %s: %w", row.PublicID, err)
+		}
 	}
 
-	return otp, nil
-}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("cannot commit keys: %w", err)
+	}
 
-// StoreKey stores given key into the database.
-func (s *Service) StoreKey(k *Key) error {
-	if _, err := s.db.Exec("REPLACE INTO Keys (id, public_id, created, private_id, lock_code, aes_key, active) VALUES (?,?,?,?,?,?,?)",
-		k.ID,
-		k.PublicID,
-		k.Created,
-		k.PrivateID,
-		k.LockCode,
-		k.AESKey,
-		k.Active,
-	); err != nil {
-		return fmt.Errorf("cannot store key: %w", err)
+	// Drop negatively cached public IDs of the new keys
+	for _, k := range keys {
+		s.purgeCache(k.PublicID)
 	}
 
 	return nil
 }
 
 // GetKey retrieves key with given publicID from storage.
-func (s *Service) GetKey(publicID string) (*Key, error) {
-	key := Key{}
-	row := s.db.QueryRowx("SELECT id, public_id, created, private_id, lock_code, aes_key, active FROM Keys WHERE public_id=?", publicID)
+func (s *Service) GetKey(ctx context.Context, publicID string) (*Key, error) {
+	row := keyRow{}
+
+	if err := s.db.GetContext(ctx, &row, "SELECT "+keySelect+" FROM Keys WHERE public_id=?", publicID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("cannot get key: %w", common.ErrStorageNoKey)
+		}
 
-	if err := row.StructScan(&key); err != nil {
 		return nil, fmt.Errorf("cannot get key: %w", err)
 	}
 
-	return &key, nil
+	return s.openKey(ctx, &row)
 }
 
-// TestCreateDatabase creates a new database for testing.
-func (s *Service) TestCreateDatabase() error {
-	return s.createDatabase()
+// ListKeys retrieves all keys from storage ordered by ID.
+func (s *Service) ListKeys() ([]*Key, error) {
+	rows := make([]*keyRow, 0)
+
+	if err := s.db.Select(&rows, "SELECT "+keySelect+" FROM Keys ORDER BY id, public_id"); err != nil {
+		return nil, fmt.Errorf("cannot list keys: %w", err)
+	}
+
+	keys := make([]*Key, 0, len(rows))
+
+	for _, row := range rows {
+		key, err := s.openKey(context.Background(), row)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
 }
 
-// createDatabase initializes the SQLite database schema required for YubiKey storage.
-// It creates the main Keys table with all necessary columns and constraints.
-//
-// The table structure includes:
-//   - public_id: YubiKey public identifier (modhex, 12 chars + 4 chars reserved)
-//   - id: Unique numeric identifier
-//   - created: ISO-8601 formatted timestamp
-//   - private_id: Private identifier (6-byte hex)
-//   - lock_code: Device lock code (optional)
-//   - aes_key: AES-128 key material (32-byte hex)
-//   - active: Key activation status
-//
-// Returns:
-//   - error if table creation fails, wrapped with context
-func (s *Service) createDatabase() error {
-	const createTableSQL = `
-CREATE TABLE IF NOT EXISTS Keys (
-    public_id  VARCHAR(16)  PRIMARY KEY,  -- YubiKey public ID
-    id         INTEGER      NOT NULL,     -- Sequential ID
-    created    VARCHAR(24)  NOT NULL,     -- ISO8601 timestamp
-    private_id VARCHAR(12)  NOT NULL,     -- Private ID (6 bytes hex)
-    lock_code  VARCHAR(12)  NOT NULL,     -- Lock code
-    aes_key    VARCHAR(32)  NOT NULL,     -- AES-128 key (16 bytes hex)
-    active     BOOLEAN      DEFAULT TRUE, -- Activation flag
-    CONSTRAINT chk_public_id CHECK (LENGTH(public_id) = 12),
-    CONSTRAINT chk_private_id CHECK (LENGTH(private_id) = 12),
-    CONSTRAINT chk_aes_key CHECK (LENGTH(aes_key) = 32)
-)`
-
-	if _, err := s.db.Exec(createTableSQL); err != nil {
-		return fmt.Errorf("failed to create Keys table: %w", err)
+// DeleteKey removes key with given publicID from storage.
+func (s *Service) DeleteKey(publicID string) error {
+	res, err := s.db.Exec("DELETE FROM Keys WHERE public_id=?", publicID)
+	if err != nil {
+		return fmt.Errorf("cannot delete key: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("cannot delete key: %w", err)
 	}
+
+	if affected == 0 {
+		return common.ErrStorageNoKey
+	}
+
+	s.purgeCache(publicID)
+
 	return nil
 }
+
+// TestCreateDatabase creates a new database for testing.
+func (s *Service) TestCreateDatabase() error {
+	_, err := s.Migrate(context.Background())
+
+	return err
+}
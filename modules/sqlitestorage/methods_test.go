@@ -1,6 +1,7 @@
 package sqlitestorage_test
 
 import (
+	"context"
 	"database/sql"
 	"encoding/hex"
 	"errors"
@@ -14,8 +15,10 @@ import (
 	"golang.org/x/exp/rand"
 
 	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/keycache"
 	"github.com/archaron/go-yubiserv/misc"
 	"github.com/archaron/go-yubiserv/modules/sqlitestorage"
+	"github.com/archaron/go-yubiserv/sqlitedriver"
 )
 
 var (
@@ -23,7 +26,7 @@ var (
 )
 
 func setupTestDB(t *testing.T) (*sqlx.DB, *sqlitestorage.Service) {
-	db, err := sqlx.Open("sqlite3", "file:test.db?cache=shared&mode=memory&_foreign_keys=true")
+	db, err := sqlitedriver.Open("file:test.db?cache=shared&mode=memory")
 	require.NoError(t, err, "failed to create in-memory database")
 
 	t.Cleanup(func() { require.NoError(t, db.Close()) })
@@ -70,7 +73,7 @@ func TestDecryptOTP(t *testing.T) {
 
 				svc := sqlitestorage.TestNewService(
 					zaptest.NewLogger(t),
-					func(publicID string) (*sqlitestorage.Key, error) {
+					func(_ context.Context, publicID string) (*sqlitestorage.Key, error) {
 						require.Equal(t, "cccccccccccc", publicID)
 						return &sqlitestorage.Key{
 							PublicID:  "cccccccccccc",
@@ -80,7 +83,7 @@ func TestDecryptOTP(t *testing.T) {
 						}, nil
 					}, nil)
 
-				otp, err := svc.DecryptOTP("cccccccccccc", otpToken)
+				otp, err := svc.DecryptOTP(context.Background(), "cccccccccccc", otpToken)
 				require.NoError(t, err)
 				require.Equal(t, vector.OTP, *otp)
 			})
@@ -121,6 +124,12 @@ func TestDecryptOTP(t *testing.T) {
 				mockError:   errTestError,
 				expectedErr: errTestError,
 			},
+			{
+				name:        "storage error is backend failure",
+				publicID:    "cccccccccccc",
+				mockError:   errTestError,
+				expectedErr: common.ErrStorageBackend,
+			},
 		}
 
 		for _, tc := range testCases {
@@ -129,11 +138,11 @@ func TestDecryptOTP(t *testing.T) {
 
 				svc := sqlitestorage.TestNewService(
 					zaptest.NewLogger(t),
-					func(publicID string) (*sqlitestorage.Key, error) {
+					func(_ context.Context, publicID string) (*sqlitestorage.Key, error) {
 						return tc.mockKey, tc.mockError
 					}, nil)
 
-				_, err := svc.DecryptOTP(tc.publicID, "dummy")
+				_, err := svc.DecryptOTP(context.Background(), tc.publicID, "dummy")
 				require.ErrorIs(t, err, tc.expectedErr)
 			})
 		}
@@ -144,7 +153,7 @@ func TestDecryptOTP(t *testing.T) {
 
 		svc := sqlitestorage.TestNewService(
 			zaptest.NewLogger(t),
-			func(publicID string) (*sqlitestorage.Key, error) {
+			func(_ context.Context, publicID string) (*sqlitestorage.Key, error) {
 				return &sqlitestorage.Key{
 					PublicID:  publicID,
 					PrivateID: hex.EncodeToString(make([]byte, 6)),
@@ -153,7 +162,7 @@ func TestDecryptOTP(t *testing.T) {
 				}, nil
 			}, nil)
 
-		_, err := svc.DecryptOTP("cccccccccccc", "invalid_token")
+		_, err := svc.DecryptOTP(context.Background(), "cccccccccccc", "invalid_token")
 		require.ErrorIs(t, err, common.ErrStorageDecryptFail)
 	})
 
@@ -166,18 +175,42 @@ func TestDecryptOTP(t *testing.T) {
 
 		svc := sqlitestorage.TestNewService(
 			zaptest.NewLogger(t),
-			func(publicID string) (*sqlitestorage.Key, error) {
+			func(_ context.Context, publicID string) (*sqlitestorage.Key, error) {
 				return key, nil
 			}, nil)
 
 		// Use a test vector but with wrong private ID
 		for otpToken := range common.TestVectors {
-			_, err := svc.DecryptOTP("cccccccccccc", otpToken)
+			_, err := svc.DecryptOTP(context.Background(), "cccccccccccc", otpToken)
 			require.ErrorIs(t, err, common.ErrStorageDecryptFail)
 			break // Only need one test case
 		}
 	})
 
+	t.Run("invalid CRC", func(t *testing.T) {
+		t.Parallel()
+
+		svc := sqlitestorage.TestNewService(
+			zaptest.NewLogger(t),
+			func(_ context.Context, publicID string) (*sqlitestorage.Key, error) {
+				return &sqlitestorage.Key{
+					PublicID:  publicID,
+					PrivateID: hex.EncodeToString(make([]byte, 6)),
+					AESKey:    "ffffffffffffffffffffffffffffffff",
+					Active:    true,
+				}, nil
+			}, nil)
+
+		// Test vector encrypted with another key decrypts to garbage
+		for otpToken := range common.TestVectors {
+			_, err := svc.DecryptOTP(context.Background(), "cccccccccccc", otpToken)
+			require.ErrorIs(t, err, common.ErrStorageDecryptFail)
+			require.ErrorIs(t, err, common.ErrInvalidCRC)
+
+			break
+		}
+	})
+
 	t.Run("private ID mismatch 2", func(t *testing.T) {
 		t.Parallel()
 
@@ -203,13 +236,13 @@ func TestDecryptOTP(t *testing.T) {
 
 		svc := sqlitestorage.TestNewService(
 			zaptest.NewLogger(t),
-			func(publicID string) (*sqlitestorage.Key, error) {
+			func(_ context.Context, publicID string) (*sqlitestorage.Key, error) {
 				require.Equal(t, "cccccccccccc", publicID)
 				return testKey, nil
 			}, nil)
 
 		// Should fail with decrypt error due to PrivateID mismatch
-		_, err = svc.DecryptOTP("cccccccccccc", token)
+		_, err = svc.DecryptOTP(context.Background(), "cccccccccccc", token)
 		require.ErrorIs(t, err, common.ErrStorageDecryptFail)
 	})
 }
@@ -252,6 +285,33 @@ func TestStoreKey(t *testing.T) {
 	})
 }
 
+func TestStoreKeys(t *testing.T) {
+	db, svc := setupTestDB(t)
+
+	t.Run("store batch", func(t *testing.T) {
+		keys := []*sqlitestorage.Key{generateTestKey(t), generateTestKey(t)}
+		require.NoError(t, svc.StoreKeys(keys))
+
+		for _, key := range keys {
+			stored, err := svc.GetKey(context.Background(), key.PublicID)
+			require.NoError(t, err)
+			require.Equal(t, key, stored)
+		}
+	})
+
+	t.Run("existing key rolls back batch", func(t *testing.T) {
+		existing, fresh := generateTestKey(t), generateTestKey(t)
+		require.NoError(t, svc.StoreKey(existing))
+
+		err := svc.StoreKeys([]*sqlitestorage.Key{fresh, existing})
+		require.ErrorIs(t, err, common.ErrStorageKeyExists)
+
+		var count int
+		require.NoError(t, db.Get(&count, "SELECT COUNT(*) FROM Keys WHERE public_id=?", fresh.PublicID))
+		require.Zero(t, count)
+	})
+}
+
 func TestGetKey(t *testing.T) {
 	db, svc := setupTestDB(t)
 
@@ -262,13 +322,13 @@ func TestGetKey(t *testing.T) {
 			key)
 		require.NoError(t, err)
 
-		retrieved, err := svc.GetKey(key.PublicID)
+		retrieved, err := svc.GetKey(context.Background(), key.PublicID)
 		require.NoError(t, err)
 		require.Equal(t, key, retrieved)
 	})
 
 	t.Run("key not found", func(t *testing.T) {
-		_, err := svc.GetKey("nonexistent")
+		_, err := svc.GetKey(context.Background(), "nonexistent")
 		require.Error(t, err)
 		require.Contains(t, err.Error(), "cannot get key")
 	})
@@ -277,14 +337,58 @@ func TestGetKey(t *testing.T) {
 		// Force close database to simulate error
 		require.NoError(t, db.Close())
 
-		_, err := svc.GetKey("any")
+		_, err := svc.GetKey(context.Background(), "any")
 		require.Error(t, err)
 	})
 }
 
+func TestListKeys(t *testing.T) {
+	db, err := sqlitedriver.Open("file:list.db?cache=shared&mode=memory")
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, db.Close()) })
+
+	svc := sqlitestorage.TestNewService(zaptest.NewLogger(t), nil, db)
+	require.NoError(t, svc.TestCreateDatabase())
+
+	t.Run("empty storage", func(t *testing.T) {
+		keys, err := svc.ListKeys()
+		require.NoError(t, err)
+		require.Empty(t, keys)
+	})
+
+	t.Run("keys ordered by id", func(t *testing.T) {
+		first, second := generateTestKey(t), generateTestKey(t)
+		first.ID, second.ID = 2, 1
+
+		require.NoError(t, svc.StoreKey(first))
+		require.NoError(t, svc.StoreKey(second))
+
+		keys, err := svc.ListKeys()
+		require.NoError(t, err)
+		require.Equal(t, []*sqlitestorage.Key{second, first}, keys)
+	})
+}
+
+func TestDeleteKey(t *testing.T) {
+	_, svc := setupTestDB(t)
+
+	t.Run("delete existing key", func(t *testing.T) {
+		key := generateTestKey(t)
+		require.NoError(t, svc.StoreKey(key))
+		require.NoError(t, svc.DeleteKey(key.PublicID))
+
+		_, err := svc.GetKey(context.Background(), key.PublicID)
+		require.ErrorIs(t, err, common.ErrStorageNoKey)
+	})
+
+	t.Run("key not found", func(t *testing.T) {
+		require.ErrorIs(t, svc.DeleteKey("cccccccccccc"), common.ErrStorageNoKey)
+	})
+}
+
 func TestCreateDatabase(t *testing.T) {
 	t.Run("successful creation", func(t *testing.T) {
-		db, err := sqlx.Open("sqlite3", ":memory:")
+		db, err := sqlitedriver.Open(":memory:")
 		require.NoError(t, err)
 		t.Cleanup(func() { _ = db.Close() })
 
@@ -299,13 +403,53 @@ func TestCreateDatabase(t *testing.T) {
 	})
 
 	t.Run("creation failure", func(t *testing.T) {
-		db, err := sqlx.Open("sqlite3", ":memory:")
+		db, err := sqlitedriver.Open(":memory:")
 		require.NoError(t, err)
 		_ = db.Close() // Close immediately to force error
 
 		svc := sqlitestorage.TestNewService(zaptest.NewLogger(t), nil, db)
 		err = svc.TestCreateDatabase()
 		require.Error(t, err)
-		require.Contains(t, err.Error(), "failed to create Keys table")
+		require.Contains(t, err.Error(), "failed to migrate database")
+	})
+}
+
+func TestKeyCache(t *testing.T) {
+	_, svc := setupTestDB(t)
+	svc.TestEnableCache(keycache.Options{TTL: time.Minute, NegativeTTL: time.Minute, StaleTTL: time.Hour})
+
+	key := generateTestKey(t)
+	require.NoError(t, svc.StoreKey(key))
+
+	_, err := svc.DecryptOTP(context.Background(), key.PublicID, "dummy")
+	require.ErrorIs(t, err, common.ErrStorageDecryptFail)
+	require.Equal(t, 1, svc.CachedKeys())
+
+	t.Run("changed key is purged", func(t *testing.T) {
+		key.Active = false
+		require.NoError(t, svc.StoreKey(key))
+		require.Zero(t, svc.CachedKeys())
+
+		_, err := svc.DecryptOTP(context.Background(), key.PublicID, "dummy")
+		require.ErrorIs(t, err, common.ErrStorageKeyInactive)
+	})
+
+	t.Run("added key is purged from negative cache", func(t *testing.T) {
+		added := generateTestKey(t)
+
+		_, err := svc.DecryptOTP(context.Background(), added.PublicID, "dummy")
+		require.ErrorIs(t, err, common.ErrStorageNoKey)
+
+		require.NoError(t, svc.StoreKeys([]*sqlitestorage.Key{added}))
+
+		_, err = svc.DecryptOTP(context.Background(), added.PublicID, "dummy")
+		require.ErrorIs(t, err, common.ErrStorageDecryptFail)
+	})
+
+	t.Run("deleted key is purged", func(t *testing.T) {
+		require.NoError(t, svc.DeleteKey(key.PublicID))
+
+		_, err := svc.DecryptOTP(context.Background(), key.PublicID, "dummy")
+		require.ErrorIs(t, err, common.ErrStorageNoKey)
 	})
 }
@@ -0,0 +1,13 @@
+-- Keys with plaintext secrets
+CREATE TABLE IF NOT EXISTS Keys (
+    public_id  VARCHAR(16)  PRIMARY KEY,  -- YubiKey public ID
+    id         INTEGER      NOT NULL,     -- Sequential ID
+    created    VARCHAR(24)  NOT NULL,     -- ISO8601 timestamp
+    private_id VARCHAR(12)  NOT NULL,     -- Private ID (6 bytes hex)
+    lock_code  VARCHAR(12)  NOT NULL,     -- Lock code
+    aes_key    VARCHAR(32)  NOT NULL,     -- AES-128 key (16 bytes hex)
+    active     BOOLEAN      DEFAULT TRUE, -- Activation flag
+    CONSTRAINT chk_public_id CHECK (LENGTH(public_id) = 12),
+    CONSTRAINT chk_private_id CHECK (LENGTH(private_id) = 12),
+    CONSTRAINT chk_aes_key CHECK (LENGTH(aes_key) = 32)
+);
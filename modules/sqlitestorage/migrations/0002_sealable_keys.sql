@@ -0,0 +1,23 @@
+-- Secrets sealed with the row data encryption key. Length checks of plaintext secrets
+-- reject sealed ones, SQLite cannot alter constraints, so the table is rebuilt.
+CREATE TABLE Keys_sealable (
+    public_id  VARCHAR(16)  PRIMARY KEY,  -- YubiKey public ID
+    id         INTEGER      NOT NULL,     -- Sequential ID
+    created    VARCHAR(24)  NOT NULL,     -- ISO8601 timestamp
+    private_id TEXT         NOT NULL,     -- Private ID (6 bytes hex)
+    lock_code  TEXT         NOT NULL,     -- Lock code
+    aes_key    TEXT         NOT NULL,     -- AES-128 key (16 bytes hex)
+    active     BOOLEAN      DEFAULT TRUE, -- Activation flag
+    dek        TEXT,                      -- Wrapped data encryption key
+    kek_id     TEXT,                      -- Key encryption key ID
+    CONSTRAINT chk_public_id CHECK (LENGTH(public_id) = 12),
+    CONSTRAINT chk_private_id CHECK (dek IS NOT NULL OR LENGTH(private_id) = 12),
+    CONSTRAINT chk_aes_key CHECK (dek IS NOT NULL OR LENGTH(aes_key) = 32)
+);
+
+INSERT INTO Keys_sealable (public_id, id, created, private_id, lock_code, aes_key, active)
+SELECT public_id, id, created, private_id, lock_code, aes_key, active FROM Keys;
+
+DROP TABLE Keys;
+
+ALTER TABLE Keys_sealable RENAME TO Keys;
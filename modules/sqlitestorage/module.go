@@ -5,6 +5,9 @@ import (
 	"github.com/im-kulikov/helium/module"
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/envelope"
+	"github.com/archaron/go-yubiserv/keycache"
 )
 
 // Module storage constructor.
@@ -23,17 +26,43 @@ func TestNewService(log *zap.Logger, getterFunc KeyGetterFunc, db *sqlx.DB) *Ser
 	return svc
 }
 
-func newService(p serviceParams) serviceOutParams {
+// TestEnableCache puts the key cache in front of the database for testing purposes.
+func (s *Service) TestEnableCache(opts keycache.Options) {
+	s.setupCache(opts)
+}
+
+// TestSetKEK sets the key encryption key for testing purposes.
+func (s *Service) TestSetKEK(kek envelope.KEK) {
+	s.kek = kek
+}
+
+func newService(p serviceParams) (serviceOutParams, error) {
 	svc := &Service{
 		log:    p.Logger,
 		dbPath: p.Config.GetString("sqlite.dbpath"),
 	}
 
-	// Default key fetcher
-	svc.getKeyFunc = svc.GetKey
+	if spec := p.Config.GetString("sqlite.kek"); spec != "" {
+		var err error
 
-	return serviceOutParams{
-		Service: svc,
-		Storage: svc,
+		if svc.kek, err = LoadKEK(p.Config, spec); err != nil {
+			return serviceOutParams{}, err
+		}
 	}
+
+	// Default key fetcher, optionally cached
+	svc.setupCache(keycache.FromConfig(p.Config))
+
+	out := serviceOutParams{
+		Service:  svc,
+		Storage:  svc,
+		KeyAdmin: svc,
+		Health:   svc,
+	}
+
+	if svc.cache != nil {
+		out.KeyCache = svc.cache
+	}
+
+	return out, nil
 }
@@ -1,6 +1,7 @@
 package sqlitestorage
 
 import (
+	"context"
 	"testing"
 
 	"github.com/jmoiron/sqlx"
@@ -8,6 +9,8 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/envelope"
 )
 
 func TestModule(t *testing.T) {
@@ -30,7 +33,7 @@ func TestTestNewService(t *testing.T) {
 
 		logger := zaptest.NewLogger(t)
 		mockDB := &sqlx.DB{}
-		customGetter := func(string) (*Key, error) { return nil, nil }
+		customGetter := func(context.Context, string) (*Key, error) { return nil, nil }
 
 		svc := TestNewService(logger, customGetter, mockDB)
 
@@ -56,9 +59,21 @@ func TestNewServiceInit(t *testing.T) {
 			Config: v,
 		}
 
-		out := newService(params)
+		out, err := newService(params)
+		require.NoError(t, err)
 
 		require.NotNil(t, out.Service)
 		require.NotNil(t, out.Storage)
 	})
+
+	t.Run("invalid KEK", func(t *testing.T) {
+		t.Parallel()
+
+		v := viper.New()
+		v.Set("sqlite.dbpath", "test.db")
+		v.Set("sqlite.kek", "env:YUBISERV_TEST_MISSING_KEK")
+
+		_, err := newService(serviceParams{Logger: zaptest.NewLogger(t), Config: v})
+		require.ErrorIs(t, err, envelope.ErrInvalidKEK)
+	})
 }
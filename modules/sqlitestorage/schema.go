@@ -0,0 +1,97 @@
+package sqlitestorage
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"slices"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/sqlmigrate"
+)
+
+// migrationsComponent is the name of the key store schema in the schema_migrations table.
+const migrationsComponent = "keys"
+
+// Schema migrations of the key store, see migrations/*.sql.
+//
+// The Keys table includes:
+//   - public_id: YubiKey public identifier (modhex, 12 chars + 4 chars reserved)
+//   - id: Unique numeric identifier
+//   - created: ISO-8601 formatted timestamp
+//   - private_id: Private identifier (6-byte hex), sealed if dek is set
+//   - lock_code: Device lock code (optional), sealed if dek is set
+//   - aes_key: AES-128 key material (32-byte hex), sealed if dek is set
+//   - active: Key activation status
+//   - dek: Data encryption key of the row wrapped with the KEK, NULL for plaintext rows
+//   - kek_id: ID of the KEK wrapping dek
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+func migrations() (*sqlmigrate.Set, error) {
+	files, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("cannot load migrations: %w", err)
+	}
+
+	return sqlmigrate.Load(migrationsComponent, files, baseline)
+}
+
+// baseline detects the schema version of Keys table created before migrations were versioned.
+func baseline(ctx context.Context, tx *sqlx.Tx) (int, error) {
+	columns := make([]string, 0)
+	if err := tx.SelectContext(ctx, &columns, "SELECT name FROM pragma_table_info('Keys')"); err != nil {
+		return 0, fmt.Errorf("cannot check Keys table: %w", err)
+	}
+
+	switch {
+	case len(columns) == 0:
+		return 0, nil
+	case slices.Contains(columns, "dek"):
+		return 2, nil
+	default:
+		return 1, nil
+	}
+}
+
+// Migrate applies pending schema migrations, databases of a newer schema are refused.
+func (s *Service) Migrate(ctx context.Context) ([]sqlmigrate.Migration, error) {
+	if s.db == nil {
+		return nil, common.ErrNotConnected
+	}
+
+	set, err := migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := set.Apply(ctx, s.db)
+	if err != nil {
+		return applied, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	for _, m := range applied {
+		s.log.Info("database migration applied", zap.Int("version", m.Version), zap.String("name", m.Name))
+	}
+
+	return applied, nil
+}
+
+// MigrationStatus returns all migrations of the key store with their applied time and the schema version.
+func (s *Service) MigrationStatus(ctx context.Context) ([]sqlmigrate.Status, int, error) {
+	if s.db == nil {
+		return nil, 0, common.ErrNotConnected
+	}
+
+	set, err := migrations()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return set.Status(ctx, s.db)
+}
@@ -0,0 +1,69 @@
+package sqlitestorage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/modules/sqlitestorage"
+	"github.com/archaron/go-yubiserv/sqlitedriver"
+	"github.com/archaron/go-yubiserv/sqlmigrate"
+)
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("sealable table before versioning", func(t *testing.T) {
+		t.Parallel()
+
+		db, svc := setupSealedDB(t, testKEK(t, 1))
+
+		key := generateTestKey(t)
+		require.NoError(t, svc.StoreKey(key))
+
+		// Drop the record of applied migrations, as in databases created before versioning
+		_, err := db.Exec("DROP TABLE schema_migrations")
+		require.NoError(t, err)
+
+		statuses, version, err := svc.MigrationStatus(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 2, version)
+		require.Len(t, statuses, 2)
+		require.Equal(t, "baseline", statuses[1].AppliedAt)
+
+		applied, err := svc.Migrate(ctx)
+		require.NoError(t, err)
+		require.Empty(t, applied)
+
+		stored, err := svc.GetKey(ctx, key.PublicID)
+		require.NoError(t, err)
+		require.Equal(t, key.AESKey, stored.AESKey)
+	})
+
+	t.Run("newer schema", func(t *testing.T) {
+		t.Parallel()
+
+		db, err := sqlitedriver.Open(":memory:")
+		require.NoError(t, err)
+
+		db.SetMaxOpenConns(1)
+		t.Cleanup(func() { _ = db.Close() })
+
+		svc := sqlitestorage.TestNewService(zaptest.NewLogger(t), nil, db)
+		require.NoError(t, svc.TestCreateDatabase())
+
+		_, err = db.Exec("INSERT INTO schema_migrations (component, version, name, applied_at) VALUES ('keys', 99, 'future', '')")
+		require.NoError(t, err)
+
+		_, err = svc.Migrate(ctx)
+		require.ErrorIs(t, err, sqlmigrate.ErrSchemaTooNew)
+
+		_, version, err := svc.MigrationStatus(ctx)
+		require.NoError(t, err)
+		require.Equal(t, 99, version)
+	})
+}
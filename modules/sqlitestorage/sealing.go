@@ -0,0 +1,224 @@
+package sqlitestorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/envelope"
+)
+
+const (
+	keySelect  = "id, public_id, created, private_id, lock_code, aes_key, active, dek, kek_id"
+	keyColumns = "(" + keySelect + ")"
+	keyValues  = "(:id, :public_id, :created, :private_id, :lock_code, :aes_key, :active, :dek, :kek_id)"
+
+	sealUpdateSQL = "UPDATE Keys SET private_id=:private_id, lock_code=:lock_code, aes_key=:aes_key, " +
+		"dek=:dek, kek_id=:kek_id WHERE public_id=:public_id"
+)
+
+var (
+	ErrKEKRequired = errors.New("key is sealed, but no KEK is configured")
+	ErrKEKMismatch = errors.New("key is sealed with another KEK")
+)
+
+// keyRow is a Keys table row, secrets are sealed with dek if it is set.
+type keyRow struct {
+	Key
+
+	DEK   sql.NullString `db:"dek"`
+	KEKID sql.NullString `db:"kek_id"`
+}
+
+// LoadKEK loads the KEK by its spec, Vault Transit settings are taken from the sqlite.transit config section.
+func LoadKEK(v *viper.Viper, spec string) (envelope.KEK, error) {
+	kek, err := envelope.Load(spec, envelope.TransitOptions{
+		Address: v.GetString("sqlite.transit.address"),
+		Mount:   v.GetString("sqlite.transit.mount"),
+		Token:   v.GetString("sqlite.transit.token"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot load KEK: %w", err)
+	}
+
+	return kek, nil
+}
+
+// sealKey converts the key to the table row, sealing secrets with a new DEK if KEK is configured.
+func (s *Service) sealKey(ctx context.Context, k *Key) (*keyRow, error) {
+	row := &keyRow{Key: *k}
+	if s.kek == nil {
+		return row, nil
+	}
+
+	return row, s.seal(ctx, row, s.kek)
+}
+
+func (s *Service) seal(ctx context.Context, row *keyRow, kek envelope.KEK) error {
+	dek, err := envelope.NewDEK()
+	if err != nil {
+		return err
+	}
+
+	defer clear(dek)
+
+	wrapped, err := kek.Wrap(ctx, dek)
+	if err != nil {
+		return fmt.Errorf("cannot wrap DEK of %s: %w", row.PublicID, err)
+	}
+
+	for column, value := range map[string]*string{
+		"private_id": &row.PrivateID,
+		"aes_key":    &row.AESKey,
+		"lock_code":  &row.LockCode,
+	} {
+		if *value, err = envelope.Seal(dek, *value, row.PublicID+"/"+column); err != nil {
+			return fmt.Errorf("cannot seal %s of %s: %w", column, row.PublicID, err)
+		}
+	}
+
+	row.DEK = sql.NullString{String: wrapped, Valid: true}
+	row.KEKID = sql.NullString{String: kek.ID(), Valid: true}
+
+	return nil
+}
+
+// openKey converts the table row to the key, opening sealed secrets.
+func (s *Service) openKey(ctx context.Context, row *keyRow) (*Key, error) {
+	key := row.Key
+	if !row.DEK.Valid {
+		return &key, nil
+	}
+
+	dek, err := s.unwrap(ctx, row)
+	if err != nil {
+		return nil, err
+	}
+
+	defer clear(dek)
+
+	for column, value := range map[string]*string{
+		"private_id": &key.PrivateID,
+		"aes_key":    &key.AESKey,
+		"lock_code":  &key.LockCode,
+	} {
+		if *value, err = envelope.Open(dek, *value, row.PublicID+"/"+column); err != nil {
+			return nil, fmt.Errorf("cannot open %s of %s: %w", column, row.PublicID, err)
+		}
+	}
+
+	return &key, nil
+}
+
+// unwrap returns DEK of the sealed row, it must be wrapped with the configured KEK.
+func (s *Service) unwrap(ctx context.Context, row *keyRow) ([]byte, error) {
+	if s.kek == nil {
+		return nil, fmt.Errorf("%s: %w", row.PublicID, ErrKEKRequired)
+	}
+
+	if row.KEKID.String != s.kek.ID() {
+		return nil, fmt.Errorf("%s sealed with %s, configured %s: %w", row.PublicID, row.KEKID.String, s.kek.ID(), ErrKEKMismatch)
+	}
+
+	dek, err := s.kek.Unwrap(ctx, row.DEK.String)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unwrap DEK of %s: %w", row.PublicID, err)
+	}
+
+	return dek, nil
+}
+
+// PlaintextKeys returns the number of keys, which secrets are not sealed.
+func (s *Service) PlaintextKeys(ctx context.Context) (int, error) {
+	var count int
+	if err := s.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM Keys WHERE dek IS NULL"); err != nil {
+		return 0, fmt.Errorf("cannot count plaintext keys: %w", err)
+	}
+
+	return count, nil
+}
+
+// EncryptKeys seals secrets of all plaintext keys with the configured KEK in one transaction,
+// returns the number of sealed keys.
+func (s *Service) EncryptKeys(ctx context.Context) (int, error) {
+	if s.kek == nil {
+		return 0, ErrKEKRequired
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+
+	defer func() { _ = tx.Rollback() }()
+
+	rows := make([]*keyRow, 0)
+	if err = tx.SelectContext(ctx, &rows, "SELECT "+keySelect+" FROM Keys WHERE dek IS NULL"); err != nil {
+		return 0, fmt.Errorf("cannot read plaintext keys: %w", err)
+	}
+
+	for _, row := range rows {
+		if err = s.seal(ctx, row, s.kek); err != nil {
+			return 0, err
+		}
+
+		if _, err = tx.NamedExecContext(ctx, sealUpdateSQL, row); err != nil {
+			return 0, fmt.Errorf("cannot update key %s: %w", row.PublicID, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("cannot commit sealed keys: %w", err)
+	}
+
+	s.log.Info("plaintext keys sealed", zap.Int("count", len(rows)), zap.String("kek_id", s.kek.ID()))
+
+	return len(rows), nil
+}
+
+// RewrapKeys re-wraps DEKs of all sealed keys with the new KEK in one transaction,
+// sealed secrets are left intact. Keys already wrapped with the new KEK are skipped,
+// so an interrupted rotation can be repeated. Returns the number of re-wrapped keys.
+func (s *Service) RewrapKeys(ctx context.Context, kek envelope.KEK) (int, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+
+	defer func() { _ = tx.Rollback() }()
+
+	rows := make([]*keyRow, 0)
+	if err = tx.SelectContext(ctx, &rows, "SELECT "+keySelect+" FROM Keys WHERE dek IS NOT NULL AND kek_id<>?", kek.ID()); err != nil {
+		return 0, fmt.Errorf("cannot read sealed keys: %w", err)
+	}
+
+	for _, row := range rows {
+		dek, err := s.unwrap(ctx, row)
+		if err != nil {
+			return 0, err
+		}
+
+		wrapped, err := kek.Wrap(ctx, dek)
+		clear(dek)
+
+		if err != nil {
+			return 0, fmt.Errorf("cannot wrap DEK of %s: %w", row.PublicID, err)
+		}
+
+		if _, err = tx.ExecContext(ctx, "UPDATE Keys SET dek=?, kek_id=? WHERE public_id=?", wrapped, kek.ID(), row.PublicID); err != nil {
+			return 0, fmt.Errorf("cannot update key %s: %w", row.PublicID, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("cannot commit re-wrapped keys: %w", err)
+	}
+
+	s.log.Info("keys re-wrapped", zap.Int("count", len(rows)), zap.String("kek_id", kek.ID()))
+
+	return len(rows), nil
+}
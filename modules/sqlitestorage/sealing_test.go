@@ -0,0 +1,219 @@
+package sqlitestorage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/envelope"
+	"github.com/archaron/go-yubiserv/modules/sqlitestorage"
+	"github.com/archaron/go-yubiserv/sqlitedriver"
+)
+
+func testKEK(t *testing.T, fill byte) envelope.KEK {
+	t.Helper()
+
+	raw := make([]byte, 32)
+	for i := range raw {
+		raw[i] = fill
+	}
+
+	kek, err := envelope.NewLocalKEK(raw)
+	require.NoError(t, err)
+
+	return kek
+}
+
+func setupSealedDB(t *testing.T, kek envelope.KEK) (*sqlx.DB, *sqlitestorage.Service) {
+	t.Helper()
+
+	db, err := sqlitedriver.Open(":memory:")
+	require.NoError(t, err)
+
+	// Every connection to :memory: opens its own database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	svc := sqlitestorage.TestNewService(zaptest.NewLogger(t), nil, db)
+	require.NoError(t, svc.TestCreateDatabase())
+	svc.TestSetKEK(kek)
+
+	return db, svc
+}
+
+func TestSealedKeys(t *testing.T) {
+	t.Parallel()
+
+	kek := testKEK(t, 1)
+	db, svc := setupSealedDB(t, kek)
+
+	key := generateTestKey(t)
+	require.NoError(t, svc.StoreKey(key))
+
+	var raw struct {
+		PrivateID string `db:"private_id"`
+		AESKey    string `db:"aes_key"`
+		LockCode  string `db:"lock_code"`
+		KEKID     string `db:"kek_id"`
+	}
+
+	require.NoError(t, db.Get(&raw, "SELECT private_id, aes_key, lock_code, kek_id FROM Keys WHERE public_id=?", key.PublicID))
+	require.NotEqual(t, key.PrivateID, raw.PrivateID)
+	require.NotEqual(t, key.AESKey, raw.AESKey)
+	require.NotEqual(t, key.LockCode, raw.LockCode)
+	require.Equal(t, kek.ID(), raw.KEKID)
+
+	stored, err := svc.GetKey(context.Background(), key.PublicID)
+	require.NoError(t, err)
+	require.Equal(t, key.AESKey, stored.AESKey)
+	require.Equal(t, key.PrivateID, stored.PrivateID)
+
+	keys, err := svc.ListKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.Equal(t, key.LockCode, keys[0].LockCode)
+
+	t.Run("swapped secrets are rejected", func(t *testing.T) {
+		other := generateTestKey(t)
+		require.NoError(t, svc.StoreKey(other))
+
+		_, err := db.Exec("UPDATE Keys SET aes_key=(SELECT aes_key FROM Keys WHERE public_id=?) WHERE public_id=?",
+			key.PublicID, other.PublicID)
+		require.NoError(t, err)
+
+		_, err = svc.GetKey(context.Background(), other.PublicID)
+		require.ErrorIs(t, err, envelope.ErrOpen)
+	})
+
+	t.Run("another KEK", func(t *testing.T) {
+		svc.TestSetKEK(testKEK(t, 2))
+		t.Cleanup(func() { svc.TestSetKEK(kek) })
+
+		_, err := svc.GetKey(context.Background(), key.PublicID)
+		require.ErrorIs(t, err, sqlitestorage.ErrKEKMismatch)
+	})
+
+	t.Run("no KEK", func(t *testing.T) {
+		svc.TestSetKEK(nil)
+		t.Cleanup(func() { svc.TestSetKEK(kek) })
+
+		_, err := svc.GetKey(context.Background(), key.PublicID)
+		require.ErrorIs(t, err, sqlitestorage.ErrKEKRequired)
+	})
+}
+
+func TestEncryptKeys(t *testing.T) {
+	t.Parallel()
+
+	_, svc := setupSealedDB(t, nil)
+
+	plain := generateTestKey(t)
+	require.NoError(t, svc.StoreKey(plain))
+
+	_, err := svc.EncryptKeys(context.Background())
+	require.ErrorIs(t, err, sqlitestorage.ErrKEKRequired)
+
+	svc.TestSetKEK(testKEK(t, 1))
+
+	// Plaintext and sealed keys are served side by side
+	sealed := generateTestKey(t)
+	require.NoError(t, svc.StoreKey(sealed))
+
+	count, err := svc.PlaintextKeys(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	count, err = svc.EncryptKeys(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	count, err = svc.PlaintextKeys(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	for _, key := range []*sqlitestorage.Key{plain, sealed} {
+		stored, err := svc.GetKey(context.Background(), key.PublicID)
+		require.NoError(t, err)
+		require.Equal(t, key.AESKey, stored.AESKey)
+	}
+}
+
+func TestRewrapKeys(t *testing.T) {
+	t.Parallel()
+
+	oldKEK, newKEK := testKEK(t, 1), testKEK(t, 2)
+	db, svc := setupSealedDB(t, oldKEK)
+
+	key := generateTestKey(t)
+	require.NoError(t, svc.StoreKey(key))
+
+	var before string
+	require.NoError(t, db.Get(&before, "SELECT aes_key FROM Keys WHERE public_id=?", key.PublicID))
+
+	count, err := svc.RewrapKeys(context.Background(), newKEK)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// Sealed secrets are left intact
+	var after string
+	require.NoError(t, db.Get(&after, "SELECT aes_key FROM Keys WHERE public_id=?", key.PublicID))
+	require.Equal(t, before, after)
+
+	_, err = svc.GetKey(context.Background(), key.PublicID)
+	require.ErrorIs(t, err, sqlitestorage.ErrKEKMismatch)
+
+	svc.TestSetKEK(newKEK)
+
+	stored, err := svc.GetKey(context.Background(), key.PublicID)
+	require.NoError(t, err)
+	require.Equal(t, key.AESKey, stored.AESKey)
+
+	count, err = svc.RewrapKeys(context.Background(), newKEK)
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
+
+func TestUpgradeKeysTable(t *testing.T) {
+	t.Parallel()
+
+	db, err := sqlitedriver.Open(":memory:")
+	require.NoError(t, err)
+
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	_, err = db.Exec(`
+CREATE TABLE Keys (
+    public_id  VARCHAR(16)  PRIMARY KEY,
+    id         INTEGER      NOT NULL,
+    created    VARCHAR(24)  NOT NULL,
+    private_id VARCHAR(12)  NOT NULL,
+    lock_code  VARCHAR(12)  NOT NULL,
+    aes_key    VARCHAR(32)  NOT NULL,
+    active     BOOLEAN      DEFAULT TRUE,
+    CONSTRAINT chk_public_id CHECK (LENGTH(public_id) = 12),
+    CONSTRAINT chk_private_id CHECK (LENGTH(private_id) = 12),
+    CONSTRAINT chk_aes_key CHECK (LENGTH(aes_key) = 32)
+)`)
+	require.NoError(t, err)
+
+	key := generateTestKey(t)
+	_, err = db.NamedExec("INSERT INTO Keys (id, public_id, created, private_id, lock_code, aes_key, active) "+
+		"VALUES (:id, :public_id, :created, :private_id, :lock_code, :aes_key, :active)", key)
+	require.NoError(t, err)
+
+	svc := sqlitestorage.TestNewService(zaptest.NewLogger(t), nil, db)
+	require.NoError(t, svc.TestCreateDatabase())
+	svc.TestSetKEK(testKEK(t, 1))
+
+	count, err := svc.EncryptKeys(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	stored, err := svc.GetKey(context.Background(), key.PublicID)
+	require.NoError(t, err)
+	require.Equal(t, key.PrivateID, stored.PrivateID)
+}
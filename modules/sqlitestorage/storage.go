@@ -7,7 +7,6 @@ import (
 
 	"github.com/im-kulikov/helium/service"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3" //goland:noinspection GoLinter
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 	"github.com/urfave/cli/v2"
@@ -15,10 +14,13 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/envelope"
+	"github.com/archaron/go-yubiserv/keycache"
+	"github.com/archaron/go-yubiserv/sqlitedriver"
 )
 
 type (
-	KeyGetterFunc func(publicID string) (*Key, error)
+	KeyGetterFunc func(ctx context.Context, publicID string) (*Key, error)
 
 	serviceParams struct {
 		dig.In
@@ -29,16 +31,23 @@ type (
 
 	serviceOutParams struct {
 		dig.Out
-		Service service.Service `group:"services"`
-		Storage common.StorageInterface
+		Service  service.Service `group:"services"`
+		Storage  common.StorageInterface
+		KeyAdmin common.KeyAdmin
+		Health   common.HealthChecker `group:"health_checks"`
+		KeyCache common.KeyCache
 	}
 
 	// Service for SQLite database storage.
 	Service struct {
 		log        *zap.Logger
 		getKeyFunc KeyGetterFunc
+		cache      *keycache.Cache
 		db         *sqlx.DB
 
+		// Key encryption key sealing secrets at rest, nil keeps them in plaintext
+		kek envelope.KEK
+
 		dbPath string
 		sync.Mutex
 	}
@@ -46,34 +55,91 @@ type (
 
 // Start the storage service.
 func (s *Service) Start(ctx context.Context) error {
+	if err := s.Connect(ctx); err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+
+	return nil
+}
+
+// Connect opens the database and applies pending schema migrations.
+func (s *Service) Connect(ctx context.Context) error {
+	if err := s.Open(ctx); err != nil {
+		return err
+	}
+
+	if _, err := s.Migrate(ctx); err != nil {
+		return err
+	}
+
+	if s.kek != nil {
+		count, err := s.PlaintextKeys(ctx)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			s.log.Warn("keys with plaintext secrets found, seal them with `db encrypt`", zap.Int("count", count))
+		}
+	}
+
+	return nil
+}
+
+// Open the database without changing its schema.
+func (s *Service) Open(ctx context.Context) error {
 	var err error
 
 	s.log.Debug("keys storage start", zap.String("db_path", s.dbPath))
 
-	s.db, err = sqlx.Open("sqlite3", s.dbPath)
+	s.db, err = sqlitedriver.Open(s.dbPath)
 	if err != nil {
 		return errors.Wrap(err, "failed to open database")
 	}
 
 	// Ensure the database is created
-	if err := s.db.Ping(); err != nil {
+	if err := s.db.PingContext(ctx); err != nil {
 		return fmt.Errorf("could not connect to database: %w", err)
 	}
 
-	if err := s.createDatabase(); err != nil {
-		return fmt.Errorf("could not create database: %w", err)
+	return nil
+}
+
+// Stop the storage service.
+func (s *Service) Stop(_ context.Context) {
+	if s.cache != nil {
+		s.cache.Close()
 	}
 
-	<-ctx.Done()
+	_ = s.Close()
+}
+
+// Close the database.
+func (s *Service) Close() error {
+	if s.db == nil {
+		return nil
+	}
+
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("cannot close database: %w", err)
+	}
 
 	return nil
 }
 
-// Stop the storage service.
-func (s *Service) Stop(_ context.Context) {
-	if s.db != nil {
-		_ = s.db.Close()
+// CheckHealth checks that the Keys table is readable.
+func (s *Service) CheckHealth(ctx context.Context) error {
+	if s.db == nil {
+		return common.ErrNotConnected
 	}
+
+	if _, err := s.db.ExecContext(ctx, "SELECT 1 FROM Keys LIMIT 1"); err != nil {
+		return fmt.Errorf("database check failed: %w", err)
+	}
+
+	return nil
 }
 
 // Name of the service.
@@ -84,6 +150,9 @@ func (s *Service) Name() string {
 // Defaults for the sqlite storage service.
 func Defaults(ctx *cli.Context, v *viper.Viper) error {
 	v.SetDefault("sqlite.dbpath", ctx.String("sqlite-dbpath")+"?mode=rwc&cache=shared")
+	v.SetDefault("sqlite.kek", ctx.String("sqlite-kek"))
+	v.SetDefault("sqlite.transit.address", ctx.String("vault-address"))
+	v.SetDefault("sqlite.transit.mount", "transit")
 
 	return nil
 }
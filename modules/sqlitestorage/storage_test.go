@@ -6,6 +6,8 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/common"
 )
 
 func TestServiceStart(t *testing.T) {
@@ -31,3 +33,20 @@ func TestServiceName(t *testing.T) {
 	svc := &Service{}
 	require.Equal(t, "sqlite-keys-storage", svc.Name())
 }
+
+func TestServiceCheckHealth(t *testing.T) {
+	t.Parallel()
+
+	svc := &Service{
+		log:    zaptest.NewLogger(t),
+		dbPath: "file:health.db?mode=memory&cache=shared",
+	}
+
+	require.ErrorIs(t, svc.CheckHealth(context.Background()), common.ErrNotConnected)
+
+	require.NoError(t, svc.Connect(context.Background()))
+	require.NoError(t, svc.CheckHealth(context.Background()))
+
+	require.NoError(t, svc.Close())
+	require.ErrorContains(t, svc.CheckHealth(context.Background()), "database check failed")
+}
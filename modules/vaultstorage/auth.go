@@ -0,0 +1,313 @@
+package vaultstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/api/auth/approle"
+	"github.com/spf13/viper"
+)
+
+// Vault auth methods.
+const (
+	AuthAppRole    = "approle"
+	AuthToken      = "token"
+	AuthKubernetes = "kubernetes"
+	AuthCert       = "cert"
+)
+
+// defaultJWTFile is the service account token mounted into Kubernetes pods.
+const defaultJWTFile = "/var/run/secrets/kubernetes.io/serviceaccount/token" //nolint:gosec
+
+var (
+	ErrUnknownAuth    = errors.New("unknown vault auth method, expected approle, token, kubernetes or cert")
+	ErrNoToken        = errors.New("vault token is not set")
+	ErrNoK8sRole      = errors.New("vault kubernetes auth role is not set")
+	ErrNoClientCert   = errors.New("vault cert auth requires client certificate and key")
+	ErrTokenNotIssued = errors.New("vault token lookup returned no data")
+)
+
+type (
+	// authMethod logs in to Vault and tells how the obtained token is renewed.
+	authMethod interface {
+		vault.AuthMethod
+
+		// name of the method for logs and errors.
+		name() string
+
+		// renewSelf reports whether a renewable token is extended with renew-self rather than
+		// by a new login, for methods unable to log in again at will.
+		renewSelf() bool
+	}
+
+	appRoleAuth struct {
+		*approle.AppRoleAuth
+
+		wrapped bool
+	}
+
+	// tokenAuth uses a token issued elsewhere, re-reading the token file on every login,
+	// so that a token rotated by Vault Agent is picked up.
+	tokenAuth struct {
+		token, file string
+	}
+
+	kubernetesAuth struct {
+		mount, role, jwtFile string
+	}
+
+	// certAuth logs in with the client certificate of the TLS connection.
+	certAuth struct {
+		mount, role       string
+		certFile, keyFile string
+	}
+)
+
+// newAuth creates the auth method configured in the vault section of the config.
+func newAuth(v *viper.Viper) (authMethod, error) {
+	mount := v.GetString("vault.auth_mount")
+
+	switch method := v.GetString("vault.auth"); method {
+	case AuthAppRole, "":
+		return newAppRoleAuth(v, mount)
+	case AuthToken:
+		auth := &tokenAuth{token: v.GetString("vault.token"), file: v.GetString("vault.token_file")}
+		if auth.token == "" && auth.file == "" {
+			auth.token = os.Getenv(vault.EnvVaultToken)
+		}
+
+		if auth.token == "" && auth.file == "" {
+			return nil, ErrNoToken
+		}
+
+		return auth, nil
+	case AuthKubernetes:
+		auth := &kubernetesAuth{mount: mountOr(mount, AuthKubernetes), role: v.GetString("vault.k8s_role"), jwtFile: v.GetString("vault.k8s_jwt_file")}
+		if auth.role == "" {
+			return nil, ErrNoK8sRole
+		}
+
+		if auth.jwtFile == "" {
+			auth.jwtFile = defaultJWTFile
+		}
+
+		return auth, nil
+	case AuthCert:
+		auth := &certAuth{
+			mount:    mountOr(mount, AuthCert),
+			role:     v.GetString("vault.cert_role"),
+			certFile: v.GetString("vault.tls_cert"),
+			keyFile:  v.GetString("vault.tls_key"),
+		}
+		if auth.certFile == "" || auth.keyFile == "" {
+			return nil, ErrNoClientCert
+		}
+
+		return auth, nil
+	default:
+		return nil, fmt.Errorf("%q: %w", method, ErrUnknownAuth)
+	}
+}
+
+func newAppRoleAuth(v *viper.Viper, mount string) (authMethod, error) {
+	roleID := v.GetString("vault.role_id")
+	if roleID == "" {
+		rawRole, err := os.ReadFile(v.GetString("vault.role_file"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read role_id file: %w", err)
+		}
+
+		if len(rawRole) != roleLength {
+			return nil, ErrInvalidRoleLength
+		}
+
+		roleID = string(rawRole)
+	}
+
+	wrapped := v.GetBool("vault.secret_id_wrapped")
+	secretID := &approle.SecretID{FromString: v.GetString("vault.secret_id")}
+
+	switch {
+	case secretID.FromString != "":
+	case wrapped:
+		// Wrapping token is single-use, a fresh one is read from the file on every login
+		secretID.FromFile = v.GetString("vault.secret_file")
+	default:
+		rawSecret, err := os.ReadFile(v.GetString("vault.secret_file"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read secret_id file: %w", err)
+		}
+
+		if len(rawSecret) != secretLength {
+			return nil, ErrInvalidSecretLength
+		}
+
+		secretID.FromString = string(rawSecret)
+	}
+
+	opts := []approle.LoginOption{approle.WithMountPath(mountOr(mount, AuthAppRole))}
+	if wrapped {
+		opts = append(opts, approle.WithWrappingToken())
+	}
+
+	auth, err := approle.NewAppRoleAuth(roleID, secretID, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize AppRole: %w", err)
+	}
+
+	return &appRoleAuth{AppRoleAuth: auth, wrapped: wrapped}, nil
+}
+
+func mountOr(mount, method string) string {
+	if mount = strings.Trim(mount, "/"); mount == "" {
+		return method
+	}
+
+	return mount
+}
+
+func (a *appRoleAuth) name() string {
+	return AuthAppRole
+}
+
+// renewSelf is true for response-wrapped secret IDs, they cannot be used for a new login again.
+func (a *appRoleAuth) renewSelf() bool {
+	return a.wrapped
+}
+
+// Login unwraps a response-wrapped secret ID with a tokenless clone of the client, so that
+// the wrapping token is not sent along with the expiring token.
+func (a *appRoleAuth) Login(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
+	if !a.wrapped {
+		return a.AppRoleAuth.Login(ctx, client)
+	}
+
+	clone, err := client.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("cannot clone vault client: %w", err)
+	}
+
+	clone.ClearToken()
+
+	return a.AppRoleAuth.Login(ctx, clone)
+}
+
+func (a *tokenAuth) name() string {
+	return AuthToken
+}
+
+func (a *tokenAuth) renewSelf() bool {
+	return true
+}
+
+// Login looks the token up, so that its TTL and renewability are known.
+func (a *tokenAuth) Login(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
+	token := a.token
+
+	if a.file != "" {
+		raw, err := os.ReadFile(a.file)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read token file: %w", err)
+		}
+
+		token = strings.TrimSpace(string(raw))
+	}
+
+	if token == "" {
+		return nil, ErrNoToken
+	}
+
+	client.SetToken(token)
+
+	secret, err := client.Auth().Token().LookupSelfWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("vault token lookup: %w", err)
+	}
+
+	if secret == nil || secret.Data == nil {
+		return nil, ErrTokenNotIssued
+	}
+
+	ttl, err := secret.TokenTTL()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get vault token TTL: %w", err)
+	}
+
+	renewable, err := secret.TokenIsRenewable()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get vault token renewability: %w", err)
+	}
+
+	policies, err := secret.TokenPolicies()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get vault token policies: %w", err)
+	}
+
+	return &vault.Secret{Auth: &vault.SecretAuth{
+		ClientToken:   token,
+		Policies:      policies,
+		LeaseDuration: int(ttl.Seconds()),
+		Renewable:     renewable,
+	}}, nil
+}
+
+func (a *kubernetesAuth) name() string {
+	return AuthKubernetes
+}
+
+// renewSelf is false, projected service account tokens are rotated, so a new login is made with the current one.
+func (a *kubernetesAuth) renewSelf() bool {
+	return false
+}
+
+func (a *kubernetesAuth) Login(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
+	jwt, err := os.ReadFile(a.jwtFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read service account token: %w", err)
+	}
+
+	secret, err := client.Logical().WriteWithContext(ctx, "auth/"+a.mount+"/login", map[string]interface{}{
+		"role": a.role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to log in with kubernetes auth: %w", err)
+	}
+
+	return secret, nil
+}
+
+func (a *certAuth) name() string {
+	return AuthCert
+}
+
+func (a *certAuth) renewSelf() bool {
+	return false
+}
+
+// configure sets the client certificate of TLS connections to Vault.
+func (a *certAuth) configure(config *vault.Config) error {
+	if err := config.ConfigureTLS(&vault.TLSConfig{ClientCert: a.certFile, ClientKey: a.keyFile}); err != nil {
+		return fmt.Errorf("cannot load vault client certificate: %w", err)
+	}
+
+	return nil
+}
+
+func (a *certAuth) Login(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
+	data := make(map[string]interface{})
+	if a.role != "" {
+		data["name"] = a.role
+	}
+
+	secret, err := client.Logical().WriteWithContext(ctx, "auth/"+a.mount+"/login", data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to log in with cert auth: %w", err)
+	}
+
+	return secret, nil
+}
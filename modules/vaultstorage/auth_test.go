@@ -0,0 +1,270 @@
+package vaultstorage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+// authVault is a stand-in for auth HTTP API of Vault, it records requests and issues numbered tokens.
+type authVault struct {
+	mu       sync.Mutex
+	requests []string
+	bodies   map[string]map[string]interface{}
+	issued   int
+
+	renewable  bool
+	renewFails bool
+}
+
+func newAuthVault(t *testing.T) (*authVault, *Service) {
+	t.Helper()
+
+	av := &authVault{bodies: make(map[string]map[string]interface{}), renewable: true}
+
+	srv := httptest.NewServer(av)
+	t.Cleanup(srv.Close)
+
+	config := vault.DefaultConfig()
+	config.Address = srv.URL
+	config.MaxRetries = 0
+
+	client, err := vault.NewClient(config)
+	require.NoError(t, err)
+
+	client.ClearToken()
+
+	return av, &Service{log: zaptest.NewLogger(t), vault: client, loginTimeout: time.Second}
+}
+
+func (av *authVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	av.mu.Lock()
+	defer av.mu.Unlock()
+
+	var body map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	av.requests = append(av.requests, path)
+	av.bodies[path] = body
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case path == "sys/wrapping/unwrap":
+		if r.Header.Get("X-Vault-Token") != "wrapping-token" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":["wrapping token is not valid or does not exist"]}`))
+
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"secret_id": "unwrapped-secret"}})
+	case path == "auth/token/lookup-self":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"id": r.Header.Get("X-Vault-Token"), "ttl": 3600, "renewable": av.renewable, "policies": []string{"yubiserv"},
+		}})
+	case path == "auth/token/renew-self" && av.renewFails:
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+	case path == "auth/token/renew-self", strings.HasSuffix(path, "/login"):
+		av.issued++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{
+			"client_token": "token-" + string(rune('0'+av.issued)), "lease_duration": 3600, "renewable": true,
+		}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"errors":[]}`))
+	}
+}
+
+func (av *authVault) setRenewal(renewable, renewFails bool) {
+	av.mu.Lock()
+	defer av.mu.Unlock()
+
+	av.renewable, av.renewFails = renewable, renewFails
+}
+
+func (av *authVault) calls() []string {
+	av.mu.Lock()
+	defer av.mu.Unlock()
+
+	calls := av.requests
+	av.requests = nil
+
+	return calls
+}
+
+func (av *authVault) body(path string) map[string]interface{} {
+	av.mu.Lock()
+	defer av.mu.Unlock()
+
+	return av.bodies[path]
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestNewAuth(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		config map[string]interface{}
+		err    error
+		method string
+	}{
+		"unknown":         {config: map[string]interface{}{"auth": "ldap"}, err: ErrUnknownAuth},
+		"token":           {config: map[string]interface{}{"auth": "token", "token": "s.token"}, method: AuthToken},
+		"token file":      {config: map[string]interface{}{"auth": "token", "token_file": "token"}, method: AuthToken},
+		"k8s":             {config: map[string]interface{}{"auth": "kubernetes", "k8s_role": "yubiserv"}, method: AuthKubernetes},
+		"k8s no role":     {config: map[string]interface{}{"auth": "kubernetes"}, err: ErrNoK8sRole},
+		"cert":            {config: map[string]interface{}{"auth": "cert", "tls_cert": "c.pem", "tls_key": "k.pem"}, method: AuthCert},
+		"cert no key":     {config: map[string]interface{}{"auth": "cert", "tls_cert": "c.pem"}, err: ErrNoClientCert},
+		"approle":         {config: map[string]interface{}{"role_id": "role", "secret_id": "secret"}, method: AuthAppRole},
+		"approle wrapped": {config: map[string]interface{}{"role_id": "role", "secret_id_wrapped": true, "secret_file": "missing"}, method: AuthAppRole},
+		"approle short secret": {
+			config: map[string]interface{}{"role_id": "role", "secret_file": writeFile(t, "secret_id", "short")},
+			err:    ErrInvalidSecretLength,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			v := viper.New()
+			v.Set("vault", tc.config)
+
+			auth, err := newAuth(v)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.method, auth.name())
+		})
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	t.Parallel()
+
+	av, svc := newAuthVault(t)
+	tokenFile := writeFile(t, "token", "agent-token\n")
+	svc.auth = &tokenAuth{file: tokenFile}
+
+	require.NoError(t, svc.login(context.Background()))
+	require.Equal(t, []string{"auth/token/lookup-self"}, av.calls())
+	require.Equal(t, "agent-token", svc.vault.Token())
+
+	ttl, err := svc.vaultToken.TokenTTL()
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, ttl)
+
+	// Renewable token is renewed in place
+	require.NoError(t, svc.renew(context.Background()))
+	require.Equal(t, []string{"auth/token/renew-self"}, av.calls())
+
+	// Token rotated by the agent is picked up when renewal fails
+	require.NoError(t, os.WriteFile(tokenFile, []byte("rotated-token"), 0o600))
+
+	av.setRenewal(true, true)
+
+	require.NoError(t, svc.renew(context.Background()))
+	require.Equal(t, []string{"auth/token/renew-self", "auth/token/lookup-self"}, av.calls())
+	require.Equal(t, "rotated-token", svc.vault.Token())
+
+	// Not renewable token is looked up again
+	av.setRenewal(false, false)
+
+	require.NoError(t, svc.login(context.Background()))
+	av.calls()
+
+	require.NoError(t, svc.renew(context.Background()))
+	require.Equal(t, []string{"auth/token/lookup-self"}, av.calls())
+}
+
+func TestKubernetesAuth(t *testing.T) {
+	t.Parallel()
+
+	av, svc := newAuthVault(t)
+	svc.auth = &kubernetesAuth{mount: "k8s-prod", role: "yubiserv", jwtFile: writeFile(t, "jwt", "sa-jwt\n")}
+
+	require.NoError(t, svc.login(context.Background()))
+	require.Equal(t, []string{"auth/k8s-prod/login"}, av.calls())
+	require.Equal(t, map[string]interface{}{"role": "yubiserv", "jwt": "sa-jwt"}, av.body("auth/k8s-prod/login"))
+	require.Equal(t, "token-1", svc.vault.Token())
+
+	// Service account token may be rotated, so a new login is made
+	require.NoError(t, svc.renew(context.Background()))
+	require.Equal(t, []string{"auth/k8s-prod/login"}, av.calls())
+	require.Equal(t, "token-2", svc.vault.Token())
+}
+
+func TestCertAuth(t *testing.T) {
+	t.Parallel()
+
+	av, svc := newAuthVault(t)
+	auth := &certAuth{mount: "cert", role: "yubiserv", certFile: "missing.pem", keyFile: "missing.key"}
+	svc.auth = auth
+
+	require.ErrorContains(t, auth.configure(vault.DefaultConfig()), "cannot load vault client certificate")
+
+	require.NoError(t, svc.login(context.Background()))
+	require.Equal(t, []string{"auth/cert/login"}, av.calls())
+	require.Equal(t, map[string]interface{}{"name": "yubiserv"}, av.body("auth/cert/login"))
+
+	require.NoError(t, svc.renew(context.Background()))
+	require.Equal(t, []string{"auth/cert/login"}, av.calls())
+}
+
+func TestWrappedAppRoleAuth(t *testing.T) {
+	t.Parallel()
+
+	av, svc := newAuthVault(t)
+	secretFile := writeFile(t, "secret_id", "wrapping-token")
+
+	v := viper.New()
+	v.Set("vault", map[string]interface{}{"role_id": "role", "secret_id_wrapped": true, "secret_file": secretFile})
+
+	auth, err := newAuth(v)
+	require.NoError(t, err)
+
+	svc.auth = auth
+
+	require.NoError(t, svc.login(context.Background()))
+	require.Equal(t, []string{"sys/wrapping/unwrap", "auth/approle/login"}, av.calls())
+	require.Equal(t, map[string]interface{}{"role_id": "role", "secret_id": "unwrapped-secret"}, av.body("auth/approle/login"))
+
+	// Wrapping token is single-use, so the token is renewed instead of a new login
+	require.NoError(t, svc.renew(context.Background()))
+	require.Equal(t, []string{"auth/token/renew-self"}, av.calls())
+
+	// A fresh wrapping token is read when renewal fails
+	av.setRenewal(true, true)
+
+	require.NoError(t, os.WriteFile(secretFile, []byte("stale-token"), 0o600))
+	require.ErrorContains(t, svc.renew(context.Background()), "unable to login with approle auth")
+	require.Equal(t, []string{"auth/token/renew-self", "sys/wrapping/unwrap"}, av.calls())
+
+	require.NoError(t, os.WriteFile(secretFile, []byte("wrapping-token"), 0o600))
+	require.NoError(t, svc.renew(context.Background()))
+}
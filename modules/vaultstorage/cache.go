@@ -0,0 +1,34 @@
+package vaultstorage
+
+import (
+	"github.com/archaron/go-yubiserv/keycache"
+)
+
+// setupCache puts the key cache in front of the key storage, if enabled.
+func (s *Service) setupCache(opts keycache.Options) {
+	s.getKeyFunc = s.readKey
+
+	// AES keys wrapped by Vault Transit are cached wrapped
+	opts.Wrapped = isWrapped
+
+	if opts.TTL > 0 {
+		s.cache = keycache.New(s.log, s.readKey, opts)
+		s.getKeyFunc = s.cache.GetKey
+	}
+}
+
+// CachedKeys returns the number of cached keys, zero when the cache is disabled.
+func (s *Service) CachedKeys() int {
+	if s.cache == nil {
+		return 0
+	}
+
+	return s.cache.CachedKeys()
+}
+
+// purgeCache drops cached entries of the changed keys.
+func (s *Service) purgeCache(publicIDs ...string) {
+	if s.cache != nil && len(publicIDs) > 0 {
+		s.cache.PurgeKeys(publicIDs...)
+	}
+}
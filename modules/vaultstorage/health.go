@@ -0,0 +1,46 @@
+package vaultstorage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+// readDone records the result of a key read.
+func (s *Service) readDone(err error) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	s.readErr = err
+	if err == nil {
+		s.lastRead = time.Now()
+	}
+}
+
+// CheckHealth checks that the Vault token is still valid and the last key read succeeded.
+func (s *Service) CheckHealth(ctx context.Context) error {
+	client := s.client()
+	if client == nil || s.token() == nil {
+		return common.ErrNotConnected
+	}
+
+	if _, err := client.Auth().Token().LookupSelfWithContext(ctx); err != nil {
+		return fmt.Errorf("vault token lookup failed: %w", err)
+	}
+
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	if s.readErr != nil {
+		lastRead := "never"
+		if !s.lastRead.IsZero() {
+			lastRead = s.lastRead.Format(time.RFC3339)
+		}
+
+		return fmt.Errorf("last key read failed, last successful read: %s: %w", lastRead, s.readErr)
+	}
+
+	return nil
+}
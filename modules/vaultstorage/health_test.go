@@ -0,0 +1,123 @@
+package vaultstorage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+func TestCheckHealth(t *testing.T) {
+	t.Parallel()
+
+	var tokenValid, vaultUp atomic.Bool
+
+	tokenValid.Store(true)
+	vaultUp.Store(true)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case !vaultUp.Load():
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"errors":["internal error"]}`))
+		case r.URL.Path == "/v1/auth/token/lookup-self" && tokenValid.Load():
+			_, _ = w.Write([]byte(`{"data":{"ttl":3600}}`))
+		case r.URL.Path == "/v1/auth/token/lookup-self":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+		case r.URL.Path == "/v1/secret/data/yubiserv/cccccccccccb":
+			_, _ = w.Write([]byte(`{"data":{"data":{"aes_key":"0102030405060708090a0b0c0d0e0f10"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	svc := &Service{log: zaptest.NewLogger(t), kv: kvPath{mount: "secret", prefix: "yubiserv", version: 2}}
+	svc.getKeyFunc = svc.GetKey
+
+	require.ErrorIs(t, svc.CheckHealth(context.Background()), common.ErrNotConnected)
+
+	config := vault.DefaultConfig()
+	config.Address = srv.URL
+	config.MaxRetries = 0
+
+	client, err := vault.NewClient(config)
+	require.NoError(t, err)
+
+	svc.vault = client
+	svc.vaultToken = &vault.Secret{}
+
+	require.NoError(t, svc.CheckHealth(context.Background()))
+
+	// Missing key is not a failure
+	_, err = svc.GetKey(context.Background(), "cccccccccccd")
+	require.ErrorIs(t, err, common.ErrStorageNoKey)
+	require.NoError(t, svc.CheckHealth(context.Background()))
+
+	// Failed read degrades health until the next successful one
+	vaultUp.Store(false)
+
+	_, err = svc.GetKey(context.Background(), "cccccccccccb")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, common.ErrStorageNoKey)
+
+	// Canceled request does not reach Vault
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = svc.GetKey(ctx, "cccccccccccb")
+	require.ErrorIs(t, err, context.Canceled)
+
+	// Unreachable Vault is a backend failure, not a bad OTP
+	_, err = svc.DecryptOTP(context.Background(), "cccccccccccb", "dummy")
+	require.ErrorIs(t, err, common.ErrStorageBackend)
+
+	vaultUp.Store(true)
+	require.ErrorContains(t, svc.CheckHealth(context.Background()), "last key read failed")
+
+	_, err = svc.GetKey(context.Background(), "cccccccccccb")
+	require.NoError(t, err)
+	require.NoError(t, svc.CheckHealth(context.Background()))
+
+	// Expired or revoked token
+	tokenValid.Store(false)
+	require.ErrorContains(t, svc.CheckHealth(context.Background()), "vault token lookup failed")
+}
+
+func TestCheckHealthDuringRenewal(t *testing.T) {
+	t.Parallel()
+
+	_, svc := newAuthVault(t)
+	svc.auth = &tokenAuth{file: writeFile(t, "token", "agent-token")}
+
+	require.NoError(t, svc.login(context.Background()))
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for range 20 {
+			_ = svc.CheckHealth(context.Background())
+		}
+	}()
+
+	for range 20 {
+		require.NoError(t, svc.renew(context.Background()))
+	}
+
+	<-done
+
+	require.NoError(t, svc.CheckHealth(context.Background()))
+}
@@ -0,0 +1,150 @@
+package vaultstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+var (
+	ErrKVVersion     = errors.New("unsupported KV secrets engine version, expected 1 or 2")
+	ErrNotKVMount    = errors.New("vault path is not on a KV secrets engine mount")
+	ErrKVUndetected  = errors.New("cannot detect KV secrets engine of vault path, set vault.kv_version")
+	ErrKVPathMissing = errors.New("vault path is not set")
+)
+
+// kvPath addresses keys on a KV secrets engine mount of either version.
+type kvPath struct {
+	// Mount path of the secrets engine, without slashes
+	mount string
+	// Path of the keys inside the mount, without slashes and KV v2 data/ segment
+	prefix string
+	// Version of the KV secrets engine, 1 or 2
+	version int
+}
+
+// newKVPath splits the configured path to the mount and the keys prefix. KV v2 path may be given
+// with the data/ segment, as the API addresses it, or without it, as the CLI does.
+func newKVPath(path, mount string, version int) (kvPath, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return kvPath{}, ErrKVPathMissing
+	}
+
+	if version != 1 && version != 2 {
+		return kvPath{}, fmt.Errorf("%d: %w", version, ErrKVVersion)
+	}
+
+	if mount = strings.Trim(mount, "/"); mount == "" {
+		mount, _, _ = strings.Cut(path, "/")
+	}
+
+	prefix, ok := strings.CutPrefix(path, mount)
+	if !ok || (prefix != "" && prefix[0] != '/') {
+		return kvPath{}, fmt.Errorf("%s is outside of mount %s: %w", path, mount, ErrNotKVMount)
+	}
+
+	prefix = strings.Trim(prefix, "/")
+	if version == 2 {
+		if rest, ok := strings.CutPrefix(prefix, "data"); ok && (rest == "" || rest[0] == '/') {
+			prefix = strings.Trim(rest, "/")
+		}
+	}
+
+	return kvPath{mount: mount, prefix: prefix, version: version}, nil
+}
+
+// detectKV finds the mount of the configured path and its KV version, the same way Vault CLI does.
+func (s *Service) detectKV(ctx context.Context) (kvPath, error) {
+	if s.kvVersion != 0 {
+		return newKVPath(s.vaultPath, s.kvMount, s.kvVersion)
+	}
+
+	secret, err := s.client().Logical().ReadWithContext(ctx, "sys/internal/ui/mounts/"+strings.Trim(s.vaultPath, "/"))
+	if err != nil {
+		return kvPath{}, fmt.Errorf("%w: %w", ErrKVUndetected, err)
+	}
+
+	if secret == nil || secret.Data == nil {
+		return kvPath{}, ErrKVUndetected
+	}
+
+	if kind, _ := secret.Data["type"].(string); kind != "kv" && kind != "generic" {
+		return kvPath{}, fmt.Errorf("%s is %q: %w", s.vaultPath, kind, ErrNotKVMount)
+	}
+
+	mount, _ := secret.Data["path"].(string)
+	version := 1
+
+	if options, ok := secret.Data["options"].(map[string]interface{}); ok && options["version"] == "2" {
+		version = 2
+	}
+
+	return newKVPath(s.vaultPath, mount, version)
+}
+
+// dataPath of the key secret.
+func (p kvPath) dataPath(publicID string) string {
+	if p.version == 2 {
+		return p.join("data", publicID)
+	}
+
+	return p.join("", publicID)
+}
+
+// listPath of the keys.
+func (p kvPath) listPath() string {
+	if p.version == 2 {
+		return p.join("metadata", "")
+	}
+
+	return p.join("", "")
+}
+
+// deletePath of the key secret, KV v2 metadata is deleted with all versions.
+func (p kvPath) deletePath(publicID string) string {
+	if p.version == 2 {
+		return p.join("metadata", publicID)
+	}
+
+	return p.join("", publicID)
+}
+
+func (p kvPath) join(segment, publicID string) string {
+	parts := []string{p.mount}
+
+	for _, part := range []string{segment, p.prefix, publicID} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// body of the write request of the key data.
+func (p kvPath) body(data map[string]interface{}) map[string]interface{} {
+	if p.version == 2 {
+		return map[string]interface{}{"data": data}
+	}
+
+	return data
+}
+
+// data of the read key secret.
+func (p kvPath) data(secret *vault.Secret) (map[string]interface{}, bool) {
+	if p.version == 1 {
+		return secret.Data, secret.Data != nil
+	}
+
+	if secret.Data["data"] == nil {
+		return nil, true
+	}
+
+	data, ok := secret.Data["data"].(map[string]interface{})
+
+	return data, ok
+}
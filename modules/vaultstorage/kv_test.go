@@ -0,0 +1,275 @@
+package vaultstorage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/keycache"
+)
+
+func TestNewKVPath(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		path, mount string
+		version     int
+		expected    kvPath
+		err         error
+	}{
+		"v2 api path":     {path: "secret/data/yubiserv", version: 2, expected: kvPath{mount: "secret", prefix: "yubiserv", version: 2}},
+		"v2 cli path":     {path: "/secret/yubiserv/", version: 2, expected: kvPath{mount: "secret", prefix: "yubiserv", version: 2}},
+		"v2 mount root":   {path: "secret", version: 2, expected: kvPath{mount: "secret", version: 2}},
+		"v2 nested mount": {path: "team/kv/data/otp/keys", mount: "team/kv/", version: 2, expected: kvPath{mount: "team/kv", prefix: "otp/keys", version: 2}},
+		"v2 data prefix":  {path: "secret/database", version: 2, expected: kvPath{mount: "secret", prefix: "database", version: 2}},
+		"v1 data kept":    {path: "kv/data/yubiserv", version: 1, expected: kvPath{mount: "kv", prefix: "data/yubiserv", version: 1}},
+		"outside mount":   {path: "secret/yubiserv", mount: "secretary", version: 2, err: ErrNotKVMount},
+		"bad version":     {path: "secret/yubiserv", version: 3, err: ErrKVVersion},
+		"no path":         {path: "/", version: 2, err: ErrKVPathMissing},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p, err := newKVPath(tc.path, tc.mount, tc.version)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, p)
+		})
+	}
+
+	v2 := kvPath{mount: "secret", prefix: "yubiserv", version: 2}
+	require.Equal(t, "secret/data/yubiserv/cccccccccccc", v2.dataPath("cccccccccccc"))
+	require.Equal(t, "secret/metadata/yubiserv", v2.listPath())
+	require.Equal(t, "secret/metadata/yubiserv/cccccccccccc", v2.deletePath("cccccccccccc"))
+
+	v1 := kvPath{mount: "kv", prefix: "yubiserv", version: 1}
+	require.Equal(t, "kv/yubiserv/cccccccccccc", v1.dataPath("cccccccccccc"))
+	require.Equal(t, "kv/yubiserv", v1.listPath())
+	require.Equal(t, "kv/yubiserv/cccccccccccc", v1.deletePath("cccccccccccc"))
+}
+
+// kvVault is a stand-in for KV v1 and v2 HTTP API of Vault with "kv" v1 and "secret" v2 mounts.
+type kvVault struct {
+	mu         sync.Mutex
+	secrets    map[string]map[string]interface{}
+	namespaces []string
+}
+
+func (kv *kvVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.namespaces = append(kv.namespaces, r.Header.Get("X-Vault-Namespace"))
+
+	var body map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	list := r.Method == "LIST" || r.URL.Query().Get("list") == "true"
+
+	if path == "auth/token/lookup-self" {
+		kv.reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"ttl": 0}})
+
+		return
+	}
+
+	if rest, ok := strings.CutPrefix(path, "sys/internal/ui/mounts/"); ok {
+		switch mount, _, _ := strings.Cut(rest, "/"); mount {
+		case "secret":
+			kv.reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+				"path": "secret/", "type": "kv", "options": map[string]interface{}{"version": "2"},
+			}})
+		case "kv":
+			kv.reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"path": "kv/", "type": "kv", "options": nil}})
+		case "pki":
+			kv.reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"path": "pki/", "type": "pki"}})
+		default:
+			kv.reply(w, http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+		}
+
+		return
+	}
+
+	// KV v2 keeps data and metadata under their own paths
+	key := path
+	if rest, ok := strings.CutPrefix(path, "secret/"); ok {
+		_, key, _ = strings.Cut(rest, "/")
+		key = "secret/" + key
+	}
+
+	switch {
+	case list:
+		keys := make([]string, 0)
+
+		for stored := range kv.secrets {
+			if name, ok := strings.CutPrefix(stored, key+"/"); ok && !strings.Contains(name, "/") {
+				keys = append(keys, name)
+			}
+		}
+
+		if len(keys) == 0 {
+			kv.reply(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+
+			return
+		}
+
+		slices.Sort(keys)
+		kv.reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+	case r.Method == http.MethodGet:
+		data, ok := kv.secrets[key]
+		if !ok {
+			kv.reply(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+
+			return
+		}
+
+		if strings.HasPrefix(path, "secret/data/") {
+			kv.reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"data": data, "metadata": map[string]interface{}{}}})
+
+			return
+		}
+
+		kv.reply(w, http.StatusOK, map[string]interface{}{"data": data})
+	case r.Method == http.MethodDelete:
+		delete(kv.secrets, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		if strings.HasPrefix(path, "secret/data/") {
+			body, _ = body["data"].(map[string]interface{})
+		}
+
+		kv.secrets[key] = body
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (kv *kvVault) reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (kv *kvVault) stored(key string) map[string]interface{} {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	return kv.secrets[key]
+}
+
+func newKVService(t *testing.T, kv *kvVault, path string) *Service {
+	t.Helper()
+
+	srv := httptest.NewServer(kv)
+	t.Cleanup(srv.Close)
+
+	config := vault.DefaultConfig()
+	config.Address = srv.URL
+	config.MaxRetries = 0
+
+	client, err := vault.NewClient(config)
+	require.NoError(t, err)
+
+	svc := &Service{log: zaptest.NewLogger(t), vault: client, address: srv.URL, vaultPath: path, loginTimeout: time.Second}
+	svc.setupCache(keycache.Options{})
+
+	return svc
+}
+
+func TestKVVersions(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		path    string
+		version int
+		stored  string
+	}{
+		"v1":          {path: "kv/yubiserv", stored: "kv/yubiserv/cccccccccccc"},
+		"v2 api path": {path: "secret/data/yubiserv", stored: "secret/yubiserv/cccccccccccc"},
+		"v2 cli path": {path: "secret/yubiserv", stored: "secret/yubiserv/cccccccccccc"},
+		"v1 forced":   {path: "locked/yubiserv", version: 1, stored: "locked/yubiserv/cccccccccccc"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			kv := &kvVault{secrets: make(map[string]map[string]interface{})}
+			svc := newKVService(t, kv, tc.path)
+			svc.kvVersion = tc.version
+
+			var err error
+
+			svc.kv, err = svc.detectKV(context.Background())
+			require.NoError(t, err)
+
+			keys, err := svc.ListKeys()
+			require.NoError(t, err)
+			require.Empty(t, keys)
+
+			key := &Key{PublicID: "cccccccccccc", PrivateID: "010203040506", AESKey: "0102030405060708090a0b0c0d0e0f10", Active: true}
+			require.NoError(t, svc.StoreKey(key))
+			require.Equal(t, key.AESKey, kv.stored(tc.stored)["aes_key"])
+
+			got, err := svc.GetKey(context.Background(), key.PublicID)
+			require.NoError(t, err)
+			require.Equal(t, key.AESKey, got.AESKey)
+			require.Equal(t, key.PrivateID, got.PrivateID)
+
+			keys, err = svc.ListKeys()
+			require.NoError(t, err)
+			require.Len(t, keys, 1)
+
+			require.NoError(t, svc.DeleteKey(key.PublicID))
+
+			_, err = svc.GetKey(context.Background(), key.PublicID)
+			require.ErrorIs(t, err, common.ErrStorageNoKey)
+		})
+	}
+}
+
+func TestDetectKV(t *testing.T) {
+	t.Parallel()
+
+	kv := &kvVault{secrets: make(map[string]map[string]interface{})}
+
+	_, err := newKVService(t, kv, "locked/yubiserv").detectKV(context.Background())
+	require.ErrorIs(t, err, ErrKVUndetected)
+
+	_, err = newKVService(t, kv, "pki/yubiserv").detectKV(context.Background())
+	require.ErrorIs(t, err, ErrNotKVMount)
+}
+
+func TestNamespace(t *testing.T) {
+	t.Parallel()
+
+	kv := &kvVault{secrets: make(map[string]map[string]interface{})}
+	svc := newKVService(t, kv, "secret/yubiserv")
+	svc.namespace = "team-a/"
+	svc.auth = &tokenAuth{token: "test-token"}
+
+	require.NoError(t, svc.Connect(context.Background()))
+	require.Equal(t, kvPath{mount: "secret", prefix: "yubiserv", version: 2}, svc.kv)
+
+	_, err := svc.GetKey(context.Background(), "cccccccccccc")
+	require.ErrorIs(t, err, common.ErrStorageNoKey)
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	// Token lookup, KV detection and key read
+	require.Equal(t, []string{"team-a/", "team-a/", "team-a/"}, kv.namespaces)
+}
@@ -2,131 +2,207 @@
 package vaultstorage
 
 import (
-	"database/sql"
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 
 	"github.com/hashicorp/vault/api"
 	"go.uber.org/zap"
 
 	"github.com/archaron/go-yubiserv/common"
-	"github.com/archaron/go-yubiserv/misc"
 )
 
 // DecryptOTP Decrypt OTP using stored private AES for specified public identifier.
-func (s *Service) DecryptOTP(publicID, token string) (*common.OTP, error) {
-	log := s.log.With(
-		zap.String("public_id", publicID),
-		zap.String("token", token),
-	)
-
-	key, err := s.getKeyFunc(publicID)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, common.ErrStorageNoKey
-		}
+// AES key wrapped by Vault Transit is unwrapped and cleared right after the decryption.
+func (s *Service) DecryptOTP(ctx context.Context, publicID, token string) (*common.OTP, error) {
+	return common.DecryptOTPWithKey(ctx, s.log, common.KeyLookupFunc(s.getKeyFunc), s.aesKey, publicID, token)
+}
 
-		return nil, err
-	}
+// StoreKey in vault storage, AES key is wrapped by Vault Transit if a transit key is configured.
+func (s *Service) StoreKey(k *Key) error {
+	return s.storeKey(context.Background(), k)
+}
 
-	if !key.Active {
-		return nil, common.ErrStorageKeyInactive
-	}
+func (s *Service) storeKey(ctx context.Context, k *Key) error {
+	path := s.kv.dataPath(k.PublicID)
 
-	aesKey, err := hex.DecodeString(key.AESKey)
+	aesKey, err := s.wrapKey(ctx, k.AESKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode AES key: %w", err)
+		return fmt.Errorf("vault store key: %w", err)
 	}
 
-	binToken, err := hex.DecodeString(misc.ModHexToHex(token))
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode token: %w", err)
+	if _, err = s.client().Logical().WriteWithContext(ctx, path, s.kv.body(keyData(k, aesKey))); err != nil {
+		return fmt.Errorf("vault store key: %w", err)
 	}
 
-	otp := &common.OTP{}
+	s.purgeCache(k.PublicID)
 
-	if err = otp.Decrypt(aesKey, binToken); err != nil {
-		log.Error("AES decryption failed")
+	return nil
+}
 
-		return nil, common.ErrStorageDecryptFail
+// StoreKeys adds new keys to vault storage.
+// Vault has no transactions, so already written keys are deleted if the batch fails.
+func (s *Service) StoreKeys(keys []*Key) error {
+	for _, k := range keys {
+		_, err := s.readKey(context.Background(), k.PublicID)
+		if err == nil {
+			return fmt.Errorf("%s: %w", k.PublicID, common.ErrStorageKeyExists)
+		}
+
+		if !errors.Is(err, common.ErrStorageNoKey) {
+			return err
+		}
 	}
 
-	if hex.EncodeToString(otp.PrivateID[:]) != key.PrivateID {
-		log.Error("private ID mismatch",
-			zap.String("opt_private_id", hex.EncodeToString(otp.PrivateID[:])),
-			zap.String("key_private_id", key.PrivateID),
-		)
+	for i, k := range keys {
+		if err := s.StoreKey(k); err != nil {
+			for _, stored := range keys[:i] {
+				if delErr := s.DeleteKey(stored.PublicID); delErr != nil {
+					s.log.Error("cannot roll back stored key", zap.String("public_id", stored.PublicID), zap.Error(delErr))
+				}
+			}
 
-		return nil, common.ErrStorageDecryptFail
+			return err
+		}
 	}
 
-	return otp, nil
+	return nil
 }
 
-// StoreKey in vault storage.
-func (s *Service) StoreKey(k *Key) error {
-	path := fmt.Sprintf("%s/%s", s.vaultPath, k.PublicID)
-
-	data := make(map[string]interface{})
-
-	data["aes_key"] = k.AESKey
-	if k.PrivateID != "" {
-		data["private_id"] = k.PrivateID
+// GetKey gets Key from storage by public id, AES key wrapped by Vault Transit is unwrapped.
+func (s *Service) GetKey(ctx context.Context, publicID string) (*Key, error) {
+	key, err := s.readKey(ctx, publicID)
+	if err != nil || !isWrapped(key.AESKey) {
+		return key, err
 	}
 
-	if _, err := s.vault.Logical().Write(path, data); err != nil {
-		return fmt.Errorf("vault store key: %w", err)
+	aesKey, err := s.aesKey(ctx, key)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	key.AESKey = hex.EncodeToString(aesKey)
+	clear(aesKey)
+
+	return key, nil
 }
 
-// GetKey gets Key from storage by public id.
-func (s *Service) GetKey(publicID string) (*Key, error) {
-	path := fmt.Sprintf("%s/%s", s.vaultPath, publicID)
+// readKey reads Key from storage by public id, AES key is returned as stored.
+func (s *Service) readKey(ctx context.Context, publicID string) (*Key, error) {
+	path := s.kv.dataPath(publicID)
 
-	secret, err := s.vault.Logical().Read(path)
+	secret, err := s.client().Logical().ReadWithContext(ctx, path)
 	if err != nil {
 		var re *api.ResponseError
-		if !errors.As(err, &re) {
+		if !errors.As(err, &re) || re.StatusCode != http.StatusNotFound {
+			// Request abandoned by the client tells nothing about Vault health, unlike a missed deadline
+			if !errors.Is(err, context.Canceled) {
+				s.readDone(err)
+			}
+
 			return nil, fmt.Errorf("vault get key: %w", err)
 		}
 	}
 
+	s.readDone(nil)
+
 	if secret == nil {
 		s.log.Warn("public_id not found in vault storage", zap.String("path", path))
 
 		return nil, common.ErrStorageNoKey
 	}
 
-	data, ok := secret.Data["data"].(map[string]interface{})
+	data, ok := s.kv.data(secret)
+	if ok && data == nil {
+		// KV v2 secret with deleted latest version
+		return nil, common.ErrStorageNoKey
+	}
+
 	if !ok {
 		s.log.Warn("data type assertion failure in vault storage", zap.String("path", path), zap.Any("data", secret.Data))
 
 		return nil, common.ErrStorageDecryptFail
 	}
 
-	var aesKey string
+	key, err := keyFromData(publicID, data)
 
-	if aesKey, ok = data["aes_key"].(string); !ok {
+	switch {
+	case errors.Is(err, common.ErrStorageNoKey):
 		s.log.Warn("aes_key not found in vault storage", zap.String("path", path))
 
-		return nil, common.ErrStorageNoKey
+		return nil, err
+	case err != nil:
+		s.log.Warn("invalid key in vault storage", zap.String("path", path), zap.Error(err))
+
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// ListKeys gets all keys from storage, AES keys wrapped by Vault Transit are returned as stored,
+// so that listing does not unwrap every key. GetKey returns the key with its AES key unwrapped.
+func (s *Service) ListKeys() ([]*Key, error) {
+	publicIDs, err := s.listPublicIDs(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*Key, 0, len(publicIDs))
+
+	for _, publicID := range publicIDs {
+		key, err := s.readKey(context.Background(), publicID)
+		if err != nil {
+			return nil, fmt.Errorf("vault get key %s: %w", publicID, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// listPublicIDs returns public IDs of all keys in storage.
+func (s *Service) listPublicIDs(ctx context.Context) ([]string, error) {
+	secret, err := s.client().Logical().ListWithContext(ctx, s.kv.listPath())
+	if err != nil {
+		return nil, fmt.Errorf("vault list keys: %w", err)
 	}
 
-	var privateID string
-	if privateID, ok = data["private_id"].(string); !ok {
-		privateID = ""
+	publicIDs := make([]string, 0)
+
+	if secret == nil {
+		return publicIDs, nil
+	}
+
+	names, _ := secret.Data["keys"].([]interface{})
+
+	for _, name := range names {
+		publicID, ok := name.(string)
+		if !ok || strings.HasSuffix(publicID, "/") {
+			continue
+		}
+
+		publicIDs = append(publicIDs, publicID)
 	}
 
-	return &Key{
-		ID:        0,
-		PublicID:  publicID,
-		Created:   "",
-		PrivateID: privateID,
-		AESKey:    aesKey,
-		LockCode:  "",
-		Active:    true,
-	}, nil
+	return publicIDs, nil
+}
+
+// DeleteKey removes key with all its versions from storage.
+func (s *Service) DeleteKey(publicID string) error {
+	if _, err := s.readKey(context.Background(), publicID); err != nil {
+		return err
+	}
+
+	if _, err := s.client().Logical().Delete(s.kv.deletePath(publicID)); err != nil {
+		return fmt.Errorf("vault delete key: %w", err)
+	}
+
+	s.purgeCache(publicID)
+
+	return nil
 }
@@ -1,6 +1,7 @@
 package vaultstorage_test
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
 	"testing"
@@ -23,7 +24,7 @@ func TestStorageDecryptor(t *testing.T) {
 		for k, vector := range common.TestVectors {
 			svc, err := vaultstorage.NewTestService(
 				zaptest.NewLogger(t),
-				func(publicID string) (*vaultstorage.Key, error) {
+				func(_ context.Context, publicID string) (*vaultstorage.Key, error) {
 					if publicID != "cccccccccccc" {
 						return nil, ErrWrongTestID
 					}
@@ -41,7 +42,7 @@ func TestStorageDecryptor(t *testing.T) {
 			)
 			require.NoError(t, err)
 
-			otp, err := svc.DecryptOTP("cccccccccccc", k)
+			otp, err := svc.DecryptOTP(context.Background(), "cccccccccccc", k)
 			require.NoError(t, err, "cannot decrypt OTP '%s'", k)
 			require.NotNil(t, otp)
 			require.Equal(t, vector.OTP, *otp)
@@ -2,11 +2,14 @@ package vaultstorage
 
 import (
 	"errors"
-	"fmt"
-	"os"
 
 	"github.com/im-kulikov/helium/module"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/keycache"
+	"github.com/archaron/go-yubiserv/metrics"
 )
 
 const (
@@ -26,52 +29,59 @@ var (
 
 // NewTestService creates a new service for testing purposes.
 func NewTestService(log *zap.Logger, getterFunc KeyGetterFunc) (*Service, error) {
-	return &Service{log: log, getKeyFunc: getterFunc}, nil
+	svc := &Service{log: log, getKeyFunc: getterFunc}
+	svc.registerMetrics(metrics.NewRegistry())
+
+	return svc, nil
 }
 
 func newService(p serviceParams) (serviceOutParams, error) {
 	svc := &Service{
 		log:          p.Logger,
 		address:      p.Config.GetString("vault.address"),
+		namespace:    p.Config.GetString("vault.namespace"),
 		vaultPath:    p.Config.GetString("vault.path"),
+		kvMount:      p.Config.GetString("vault.kv_mount"),
+		kvVersion:    p.Config.GetInt("vault.kv_version"),
 		loginTimeout: p.Config.GetDuration("vault.login_timeout"),
+		transitMount: p.Config.GetString("vault.transit_mount"),
+		transitKey:   p.Config.GetString("vault.transit_key"),
 	}
 
-	// Default Key fetcher
-	svc.getKeyFunc = svc.GetKey
-
-	if svc.roleID = p.Config.GetString("vault.role_id"); svc.roleID == "" {
-		roleFile := p.Config.GetString("vault.role_file")
+	reg := p.Metrics
+	if reg == nil {
+		reg = metrics.NewRegistry()
+	}
 
-		rawRole, err := os.ReadFile(roleFile)
-		if err != nil {
-			return serviceOutParams{}, fmt.Errorf("cannot read role_id file: %w", err)
-		}
+	svc.registerMetrics(reg)
 
-		if len(rawRole) != roleLength {
-			return serviceOutParams{}, ErrInvalidRoleLength
-		}
+	// Default key fetcher, optionally cached. Keys are cached as read from KV, AES keys wrapped by Transit stay wrapped.
+	svc.setupCache(keycache.FromConfig(p.Config))
 
-		svc.roleID = string(rawRole)
+	auth, err := newAuth(p.Config)
+	if err != nil {
+		return serviceOutParams{}, err
 	}
 
-	if svc.secretID = p.Config.GetString("vault.secret_id"); svc.secretID == "" {
-		secretFile := p.Config.GetString("vault.secret_file")
+	svc.auth = auth
 
-		rawSecret, err := os.ReadFile(secretFile)
-		if err != nil {
-			return serviceOutParams{}, fmt.Errorf("cannot read secret_id file: %w", err)
-		}
-
-		if len(rawSecret) != secretLength {
-			return serviceOutParams{}, ErrInvalidSecretLength
-		}
+	out := serviceOutParams{
+		Service:  svc,
+		Storage:  svc,
+		KeyAdmin: svc,
+		Health:   svc,
+	}
 
-		svc.secretID = string(rawSecret)
+	if svc.cache != nil {
+		out.KeyCache = svc.cache
 	}
 
-	return serviceOutParams{
-		Service: svc,
-		Storage: svc,
-	}, nil
+	return out, nil
+}
+
+func (s *Service) registerMetrics(reg *prometheus.Registry) {
+	s.relogins = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "yubiserv_vault_relogins_total",
+		Help: "Vault relogin attempts by result.",
+	}, []string{"result"})
 }
@@ -0,0 +1,130 @@
+package vaultstorage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+// ErrInvalidActive is returned for the active flag of a key secret that is neither a boolean nor "true" or "false".
+var ErrInvalidActive = errors.New("invalid active flag of vault key, expected true or false")
+
+// Fields of the key secret in Vault KV.
+const (
+	fieldAESKey      = "aes_key"
+	fieldPrivateID   = "private_id"
+	fieldActive      = "active"
+	fieldCreated     = "created"
+	fieldSerial      = "serial"
+	fieldLockCode    = "lock_code"
+	fieldOwner       = "owner"
+	fieldDescription = "description"
+)
+
+// KeepsKeyMetadata reports that owner and description are stored with keys.
+func (s *Service) KeepsKeyMetadata() bool {
+	return true
+}
+
+// keyData returns the key secret data with the AES key as it is to be stored, empty optional fields are omitted.
+func keyData(k *Key, aesKey string) map[string]interface{} {
+	data := map[string]interface{}{
+		fieldAESKey: aesKey,
+		fieldActive: k.Active,
+	}
+
+	for field, value := range map[string]string{
+		fieldPrivateID:   k.PrivateID,
+		fieldCreated:     k.Created,
+		fieldLockCode:    k.LockCode,
+		fieldOwner:       k.Owner,
+		fieldDescription: k.Description,
+	} {
+		if value != "" {
+			data[field] = value
+		}
+	}
+
+	if k.ID != 0 {
+		data[fieldSerial] = k.ID
+	}
+
+	return data
+}
+
+// keyFromData restores the key from the secret data, fields missing in secrets written
+// by older versions get their defaults, keys without the active flag are active.
+func keyFromData(publicID string, data map[string]interface{}) (*Key, error) {
+	aesKey, ok := data[fieldAESKey].(string)
+	if !ok {
+		return nil, common.ErrStorageNoKey
+	}
+
+	active, err := activeFlag(data[fieldActive])
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{PublicID: publicID, AESKey: aesKey, Active: active}
+
+	for field, value := range map[string]*string{
+		fieldPrivateID:   &key.PrivateID,
+		fieldCreated:     &key.Created,
+		fieldLockCode:    &key.LockCode,
+		fieldOwner:       &key.Owner,
+		fieldDescription: &key.Description,
+	} {
+		*value, _ = data[field].(string)
+	}
+
+	key.ID = serial(data[fieldSerial])
+
+	return key, nil
+}
+
+// activeFlag parses the active flag written as a JSON boolean, or as a string by hand. A key with a flag
+// of any other value is not served, rather than taken as active.
+func activeFlag(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case nil:
+		return true, nil
+	case bool:
+		return v, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+
+	return false, fmt.Errorf("%w: %v", ErrInvalidActive, value)
+}
+
+// serial parses the serial written as a JSON number, or as a string by hand.
+func serial(value interface{}) uint64 {
+	var raw string
+
+	switch v := value.(type) {
+	case json.Number:
+		raw = v.String()
+	case string:
+		raw = v
+	case float64:
+		return uint64(v)
+	default:
+		return 0
+	}
+
+	id, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return id
+}
@@ -0,0 +1,95 @@
+package vaultstorage
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+func TestKeySchema(t *testing.T) {
+	t.Parallel()
+
+	kv := &kvVault{secrets: make(map[string]map[string]interface{})}
+	svc := newKVService(t, kv, "secret/yubiserv")
+	svc.kv = kvPath{mount: "secret", prefix: "yubiserv", version: 2}
+
+	key := &Key{
+		ID:          4242,
+		PublicID:    "cccccccccccc",
+		Created:     "2026-01-02T03:04:05Z",
+		PrivateID:   "010203040506",
+		AESKey:      "0102030405060708090a0b0c0d0e0f10",
+		LockCode:    "a1a2a3a4a5a6",
+		Active:      true,
+		Owner:       "alice",
+		Description: "spare key",
+	}
+	require.NoError(t, svc.StoreKey(key))
+
+	require.Equal(t, map[string]interface{}{
+		"aes_key":     key.AESKey,
+		"private_id":  key.PrivateID,
+		"active":      true,
+		"created":     key.Created,
+		"serial":      float64(4242),
+		"lock_code":   key.LockCode,
+		"owner":       "alice",
+		"description": "spare key",
+	}, kv.stored("secret/yubiserv/cccccccccccc"))
+
+	got, err := svc.GetKey(context.Background(), key.PublicID)
+	require.NoError(t, err)
+	require.Equal(t, key, got)
+
+	t.Run("deactivated key is rejected", func(t *testing.T) {
+		inactive := *key
+		inactive.PublicID = "cccccccccccd"
+		inactive.Active = false
+		require.NoError(t, svc.StoreKey(&inactive))
+
+		_, err := svc.DecryptOTP(context.Background(), inactive.PublicID, "dvgtiblfkbgturecfllberrvkinnctnn")
+		require.ErrorIs(t, err, common.ErrStorageKeyInactive)
+	})
+
+	t.Run("legacy secret", func(t *testing.T) {
+		kv.mu.Lock()
+		kv.secrets["secret/yubiserv/ccccccccccce"] = map[string]interface{}{"aes_key": key.AESKey, "private_id": key.PrivateID}
+		kv.mu.Unlock()
+
+		got, err := svc.GetKey(context.Background(), "ccccccccccce")
+		require.NoError(t, err)
+		require.Equal(t, &Key{PublicID: "ccccccccccce", PrivateID: key.PrivateID, AESKey: key.AESKey, Active: true}, got)
+	})
+
+	t.Run("active flag written by hand", func(t *testing.T) {
+		kv.mu.Lock()
+		kv.secrets["secret/yubiserv/cccccccccccf"] = map[string]interface{}{"aes_key": key.AESKey, "active": "False"}
+		kv.secrets["secret/yubiserv/cccccccccccg"] = map[string]interface{}{"aes_key": key.AESKey, "active": "no"}
+		kv.secrets["secret/yubiserv/ccccccccccch"] = map[string]interface{}{"aes_key": key.AESKey, "active": json.Number("1")}
+		kv.mu.Unlock()
+
+		got, err := svc.GetKey(context.Background(), "cccccccccccf")
+		require.NoError(t, err)
+		require.False(t, got.Active)
+
+		_, err = svc.GetKey(context.Background(), "cccccccccccg")
+		require.ErrorIs(t, err, ErrInvalidActive)
+
+		_, err = svc.GetKey(context.Background(), "ccccccccccch")
+		require.ErrorIs(t, err, ErrInvalidActive)
+	})
+}
+
+func TestSerial(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, uint64(18446744073709551615), serial(json.Number("18446744073709551615")))
+	require.Equal(t, uint64(7), serial("7"))
+	require.Equal(t, uint64(7), serial(float64(7)))
+	require.Zero(t, serial("seven"))
+	require.Zero(t, serial(nil))
+}
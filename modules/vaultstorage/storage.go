@@ -7,48 +7,70 @@ import (
 	"time"
 
 	vault "github.com/hashicorp/vault/api"
-	"github.com/hashicorp/vault/api/auth/approle"
 	"github.com/im-kulikov/helium/service"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/viper"
 	"github.com/urfave/cli/v2"
 	"go.uber.org/dig"
 	"go.uber.org/zap"
 
 	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/envelope"
+	"github.com/archaron/go-yubiserv/keycache"
 )
 
 type (
-	KeyGetterFunc func(publicID string) (*Key, error)
+	KeyGetterFunc func(ctx context.Context, publicID string) (*Key, error)
 
 	serviceParams struct {
 		dig.In
 
-		Logger *zap.Logger
-		Config *viper.Viper
+		Logger  *zap.Logger
+		Config  *viper.Viper
+		Metrics *prometheus.Registry `optional:"true"`
 	}
 
 	serviceOutParams struct {
 		dig.Out
-		Service service.Service `group:"services"`
-		Storage common.StorageInterface
+		Service  service.Service `group:"services"`
+		Storage  common.StorageInterface
+		KeyAdmin common.KeyAdmin
+		Health   common.HealthChecker `group:"health_checks"`
+		KeyCache common.KeyCache
 	}
 
 	// Service for vault storage.
 	Service struct {
 		log        *zap.Logger
 		getKeyFunc KeyGetterFunc
+		cache      *keycache.Cache
 
+		// Replaced on connect and renewal while requests and health checks use them, guarded by healthMu
 		vault      *vault.Client
 		vaultToken *vault.Secret
 
-		address          string
-		roleID, secretID string
-		vaultPath        string
+		address   string
+		namespace string
+		auth      authMethod
+
+		// Keys location, KV mount and version are detected on connect unless configured
+		vaultPath string
+		kvMount   string
+		kvVersion int
+		kv        kvPath
 
 		loginTimeout time.Duration
+		relogins     *prometheus.CounterVec
+
+		// Vault Transit key wrapping AES keys, nil when they are stored as is
+		transitMount, transitKey string
+		transit                  envelope.KEK
 
-		sync.Mutex
+		// Result of the last key read, reported by CheckHealth
+		healthMu sync.Mutex
+		lastRead time.Time
+		readErr  error
 	}
 )
 
@@ -60,47 +82,38 @@ const (
 
 // Start the storage service.
 func (s *Service) Start(ctx context.Context) error {
-	var err error
-
-	s.log.Debug("vault keys storage start", zap.String("address", s.address))
-
-	config := vault.DefaultConfig()
-	config.Address = s.address
-
-	s.vault, err = vault.NewClient(config)
-	if err != nil {
-		return errors.Wrap(err, "unable to initialize Vault client")
+	if err := s.Connect(ctx); err != nil {
+		return err
 	}
 
-	if err = s.login(ctx); err != nil {
-		return errors.Wrap(err, "unable to login")
-	}
-
-	ttl, err := s.vaultToken.TokenTTL()
+	ttl, err := s.token().TokenTTL()
 	if err != nil {
 		return errors.Wrap(err, "unable to get token TTL")
 	}
 
 	reloginTime := (ttl * reLoginRatioM) / reLoginRatioD
 
-	s.log.Debug("got vault token", zap.Duration("ttl", ttl), zap.Duration("relogin_time", reloginTime))
-	timer := time.NewTimer(reloginTime)
+	s.log.Debug("got vault token", zap.String("auth", s.auth.name()), zap.Duration("ttl", ttl), zap.Duration("relogin_time", reloginTime))
+	timer := s.renewTimer(reloginTime)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return errors.Wrap(context.Cause(ctx), "vault storage context cancelled")
 		case <-timer.C:
-			s.log.Debug("relogin to renew vault access token")
+			s.log.Debug("renew vault access token")
 
-			if err = s.login(ctx); err != nil {
+			if err = s.renew(ctx); err != nil {
+				s.relogins.WithLabelValues("failure").Inc()
 				s.log.Error("cannot relogin to vault, will retry after pause", zap.Duration("pause", retryTimeout), zap.Error(err))
 				timer.Reset(retryTimeout)
 
 				continue
 			}
 
-			ttl, err := s.vaultToken.TokenTTL()
+			s.relogins.WithLabelValues("success").Inc()
+
+			ttl, err := s.token().TokenTTL()
 			if err != nil {
 				return fmt.Errorf("cannot get vault token TTL: %w", err)
 			}
@@ -108,41 +121,147 @@ func (s *Service) Start(ctx context.Context) error {
 			reloginTime = (ttl * reLoginRatioM) / reLoginRatioD
 			s.log.Debug("renewed vault token", zap.Duration("ttl", ttl), zap.Duration("relogin_time", reloginTime))
 
-			timer.Reset(reloginTime)
+			if reloginTime > 0 {
+				timer.Reset(reloginTime)
+			}
 		}
 	}
 }
 
-func (s *Service) login(rootCtx context.Context) error {
-	secretID := &approle.SecretID{FromString: s.secretID}
+// renewTimer fires when the token is to be renewed, never for a token without TTL, like a root token.
+func (s *Service) renewTimer(reloginTime time.Duration) *time.Timer {
+	if reloginTime > 0 {
+		return time.NewTimer(reloginTime)
+	}
+
+	s.log.Debug("vault token does not expire, renewal disabled")
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	return timer
+}
+
+// Connect initializes Vault client and logs in.
+func (s *Service) Connect(ctx context.Context) error {
+	var err error
+
+	s.log.Debug("vault keys storage start", zap.String("address", s.address))
 
-	appRoleAuth, err := approle.NewAppRoleAuth(
-		s.roleID,
-		secretID,
-	)
+	config := vault.DefaultConfig()
+	config.Address = s.address
+
+	if cert, ok := s.auth.(*certAuth); ok {
+		if err = cert.configure(config); err != nil {
+			return err
+		}
+	}
+
+	client, err := vault.NewClient(config)
 	if err != nil {
-		return errors.Wrap(err, "unable to initialize AppRole")
+		return errors.Wrap(err, "unable to initialize Vault client")
+	}
+
+	if s.namespace != "" {
+		client.SetNamespace(s.namespace)
 	}
 
+	if s.transitKey != "" {
+		s.transit = envelope.NewTransitKEK(client, s.transitMount, s.transitKey)
+	}
+
+	s.healthMu.Lock()
+	s.vault = client
+	s.healthMu.Unlock()
+
+	if err = s.login(ctx); err != nil {
+		return errors.Wrap(err, "unable to login")
+	}
+
+	if s.kv, err = s.detectKV(ctx); err != nil {
+		return err
+	}
+
+	s.log.Debug("vault KV secrets engine",
+		zap.String("mount", s.kv.mount),
+		zap.String("prefix", s.kv.prefix),
+		zap.Int("version", s.kv.version))
+
+	return nil
+}
+
+func (s *Service) login(rootCtx context.Context) error {
 	ctx, cancel := context.WithTimeout(rootCtx, s.loginTimeout)
 	defer cancel()
 
-	authInfo, err := s.vault.Auth().Login(ctx, appRoleAuth)
+	authInfo, err := s.client().Auth().Login(ctx, s.auth)
 	if err != nil {
-		return errors.Wrap(err, "unable to login to AppRole")
+		return errors.Wrapf(err, "unable to login with %s auth", s.auth.name())
 	}
 
 	if authInfo == nil {
 		return errors.New("no auth info was returned after login")
 	}
 
-	s.vaultToken = authInfo
+	s.setToken(authInfo)
 
 	return nil
 }
 
+// renew extends access to Vault: a renewable token is extended with renew-self if the auth method
+// cannot log in again at will, otherwise a new login is made. Failed renew-self falls back to a new login.
+func (s *Service) renew(rootCtx context.Context) error {
+	if renewable, _ := s.token().TokenIsRenewable(); renewable && s.auth.renewSelf() {
+		ctx, cancel := context.WithTimeout(rootCtx, s.loginTimeout)
+		secret, err := s.client().Auth().Token().RenewSelfWithContext(ctx, 0)
+
+		cancel()
+
+		if err == nil && secret != nil && secret.Auth != nil {
+			s.setToken(secret)
+
+			return nil
+		}
+
+		s.log.Warn("cannot renew vault token, logging in again", zap.Error(err))
+	}
+
+	return s.login(rootCtx)
+}
+
+// client returns the Vault client, nil before Connect.
+func (s *Service) client() *vault.Client {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	return s.vault
+}
+
+// token returns the secret of the last login or renewal, nil before login.
+func (s *Service) token() *vault.Secret {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	return s.vaultToken
+}
+
+func (s *Service) setToken(secret *vault.Secret) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+
+	s.vaultToken = secret
+}
+
 // Stop the storage service.
 func (s *Service) Stop(_ context.Context) {
+	if s.cache != nil {
+		s.cache.Close()
+	}
+}
+
+// Close does nothing, Vault client has no resources to release.
+func (s *Service) Close() error {
+	return nil
 }
 
 // Name returns name of the service.
@@ -153,12 +272,27 @@ func (s *Service) Name() string {
 // Defaults for the storage service.
 func Defaults(ctx *cli.Context, v *viper.Viper) error {
 	v.SetDefault("vault.path", ctx.String("vault-path"))
+	v.SetDefault("vault.kv_mount", ctx.String("vault-kv-mount"))
+	v.SetDefault("vault.kv_version", ctx.Int("vault-kv-version"))
+	v.SetDefault("vault.namespace", ctx.String("vault-namespace"))
 	v.SetDefault("vault.address", ctx.String("vault-address"))
 	v.SetDefault("vault.role_file", ctx.String("vault-role-file"))
 	v.SetDefault("vault.secret_file", ctx.String("vault-secret-file"))
 	v.SetDefault("vault.role_id", ctx.String("vault-role-id"))
 	v.SetDefault("vault.secret_id", ctx.String("vault-secret-id"))
 	v.SetDefault("vault.login_timeout", ctx.String("vault-login-timeout"))
+	v.SetDefault("vault.auth", ctx.String("vault-auth"))
+	v.SetDefault("vault.auth_mount", ctx.String("vault-auth-mount"))
+	v.SetDefault("vault.secret_id_wrapped", ctx.Bool("vault-secret-id-wrapped"))
+	v.SetDefault("vault.token", ctx.String("vault-token"))
+	v.SetDefault("vault.token_file", ctx.String("vault-token-file"))
+	v.SetDefault("vault.k8s_role", ctx.String("vault-k8s-role"))
+	v.SetDefault("vault.k8s_jwt_file", ctx.String("vault-k8s-jwt-file"))
+	v.SetDefault("vault.tls_cert", ctx.String("vault-tls-cert"))
+	v.SetDefault("vault.tls_key", ctx.String("vault-tls-key"))
+	v.SetDefault("vault.cert_role", ctx.String("vault-cert-role"))
+	v.SetDefault("vault.transit_key", ctx.String("vault-transit-key"))
+	v.SetDefault("vault.transit_mount", ctx.String("vault-transit-mount"))
 
 	return nil
 }
@@ -0,0 +1,116 @@
+package vaultstorage
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"go.uber.org/zap"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+// wrappedPrefix starts every ciphertext of Vault Transit, it never starts a hex-encoded AES key.
+const wrappedPrefix = "vault:"
+
+var ErrTransitRequired = errors.New("AES key is wrapped by Vault Transit, but no transit key is configured")
+
+// isWrapped reports whether the stored AES key is wrapped by Vault Transit.
+func isWrapped(aesKey string) bool {
+	return strings.HasPrefix(aesKey, wrappedPrefix)
+}
+
+// wrapKey returns the AES key to store, wrapped by Vault Transit if a transit key is configured.
+func (s *Service) wrapKey(ctx context.Context, aesKey string) (string, error) {
+	if s.transit == nil || isWrapped(aesKey) {
+		return aesKey, nil
+	}
+
+	raw, err := hex.DecodeString(aesKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid AES key: %w", err)
+	}
+
+	defer clear(raw)
+
+	wrapped, err := s.transit.Wrap(ctx, raw)
+	if err != nil {
+		return "", fmt.Errorf("cannot wrap AES key: %w", err)
+	}
+
+	return wrapped, nil
+}
+
+// aesKey returns the raw AES key of the stored key, unwrapping it inside Vault if it is wrapped.
+// The caller must clear the returned key after use. Errors are classified for DecryptOTP.
+func (s *Service) aesKey(ctx context.Context, key *Key) ([]byte, error) {
+	if !isWrapped(key.AESKey) {
+		raw, err := hex.DecodeString(key.AESKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid AES key: %w", common.ErrStorageDecryptFail, err)
+		}
+
+		return raw, nil
+	}
+
+	if s.transit == nil {
+		return nil, fmt.Errorf("%w: %w", common.ErrStorageBackend, ErrTransitRequired)
+	}
+
+	raw, err := s.transit.Unwrap(ctx, key.AESKey)
+	if err != nil {
+		// Vault rejects a malformed or foreign ciphertext as a bad request
+		var re *api.ResponseError
+		if errors.As(err, &re) && re.StatusCode == http.StatusBadRequest {
+			return nil, fmt.Errorf("%w: %w", common.ErrStorageDecryptFail, err)
+		}
+
+		if !errors.Is(err, context.Canceled) {
+			s.readDone(err)
+		}
+
+		return nil, fmt.Errorf("%w: %w", common.ErrStorageBackend, err)
+	}
+
+	return raw, nil
+}
+
+// WrapKeys wraps the plaintext AES keys with the configured transit key, returns the number of wrapped keys.
+// Vault has no transactions, so an interrupted run is completed by running it again.
+func (s *Service) WrapKeys(ctx context.Context) (int, error) {
+	if s.transit == nil {
+		return 0, ErrTransitRequired
+	}
+
+	publicIDs, err := s.listPublicIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for _, publicID := range publicIDs {
+		key, err := s.readKey(ctx, publicID)
+		if err != nil {
+			return count, fmt.Errorf("vault get key %s: %w", publicID, err)
+		}
+
+		if isWrapped(key.AESKey) {
+			continue
+		}
+
+		if err = s.storeKey(ctx, key); err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	s.log.Info("AES keys wrapped", zap.Int("count", count), zap.String("transit_key", s.transit.ID()))
+
+	return count, nil
+}
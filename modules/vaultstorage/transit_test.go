@@ -0,0 +1,289 @@
+package vaultstorage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/envelope"
+	"github.com/archaron/go-yubiserv/keycache"
+)
+
+// fakeVault is a stand-in for KV v2 and Transit HTTP API of Vault.
+type fakeVault struct {
+	mu      sync.Mutex
+	secrets map[string]map[string]interface{}
+
+	transitUp atomic.Bool
+	reads     atomic.Int32
+	unwraps   atomic.Int32
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *vault.Client) {
+	t.Helper()
+
+	fv := &fakeVault{secrets: make(map[string]map[string]interface{})}
+	fv.transitUp.Store(true)
+
+	srv := httptest.NewServer(fv)
+	t.Cleanup(srv.Close)
+
+	config := vault.DefaultConfig()
+	config.Address = srv.URL
+	config.MaxRetries = 0
+
+	client, err := vault.NewClient(config)
+	require.NoError(t, err)
+
+	return fv, client
+}
+
+func (fv *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var body map[string]interface{}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	publicID, isData := strings.CutPrefix(r.URL.Path, "/v1/secret/data/yubiserv/")
+
+	switch {
+	case isData && r.Method == http.MethodGet:
+		fv.reads.Add(1)
+
+		data, ok := fv.secrets[publicID]
+		if !ok {
+			fv.reply(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+
+			return
+		}
+
+		fv.reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"data": data}})
+	case isData:
+		data, _ := body["data"].(map[string]interface{})
+		fv.secrets[publicID] = data
+		fv.reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"version": 1}})
+	case r.URL.Path == "/v1/secret/metadata/yubiserv":
+		keys := make([]string, 0, len(fv.secrets))
+		for publicID := range fv.secrets {
+			keys = append(keys, publicID)
+		}
+
+		slices.Sort(keys)
+		fv.reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+	case r.URL.Path == "/v1/auth/token/lookup-self":
+		fv.reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"ttl": 3600}})
+	case !fv.transitUp.Load():
+		fv.reply(w, http.StatusInternalServerError, map[string]interface{}{"errors": []string{"internal error"}})
+	case r.URL.Path == "/v1/transit/encrypt/yubiserv":
+		// Plaintext is reversed instead of being encrypted
+		plaintext, _ := body["plaintext"].(string)
+		fv.reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"ciphertext": "vault:v1:" + reverse(plaintext)}})
+	case r.URL.Path == "/v1/transit/decrypt/yubiserv":
+		ciphertext, _ := body["ciphertext"].(string)
+		if !strings.HasPrefix(ciphertext, "vault:v1:") {
+			fv.reply(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid ciphertext"}})
+
+			return
+		}
+
+		fv.unwraps.Add(1)
+		fv.reply(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"plaintext": reverse(strings.TrimPrefix(ciphertext, "vault:v1:")),
+		}})
+	default:
+		fv.reply(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+	}
+}
+
+func (fv *fakeVault) reply(w http.ResponseWriter, status int, body interface{}) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (fv *fakeVault) storedAESKey(publicID string) string {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+
+	aesKey, _ := fv.secrets[publicID]["aes_key"].(string)
+
+	return aesKey
+}
+
+func reverse(s string) string {
+	r := []byte(s)
+	slices.Reverse(r)
+
+	return string(r)
+}
+
+func newTransitService(t *testing.T, client *vault.Client, transitKey string) *Service {
+	t.Helper()
+
+	svc := &Service{
+		log:          zaptest.NewLogger(t),
+		vault:        client,
+		vaultToken:   &vault.Secret{},
+		kv:           kvPath{mount: "secret", prefix: "yubiserv", version: 2},
+		transitMount: "transit",
+		transitKey:   transitKey,
+	}
+	svc.setupCache(keycache.Options{})
+
+	if transitKey != "" {
+		svc.transit = envelope.NewTransitKEK(client, svc.transitMount, transitKey)
+	}
+
+	return svc
+}
+
+func TestTransit(t *testing.T) {
+	t.Parallel()
+
+	fv, client := newFakeVault(t)
+	svc := newTransitService(t, client, "yubiserv")
+
+	for token, vector := range common.TestVectors {
+		key := &Key{
+			PublicID:  "cccccccccccc",
+			PrivateID: hex.EncodeToString(vector.PrivateID[:]),
+			AESKey:    hex.EncodeToString(vector.AESKey),
+			Active:    true,
+		}
+		require.NoError(t, svc.StoreKey(key))
+
+		stored := fv.storedAESKey(key.PublicID)
+		require.True(t, strings.HasPrefix(stored, "vault:v1:"))
+		require.NotContains(t, stored, base64.StdEncoding.EncodeToString(vector.AESKey))
+
+		unwraps := fv.unwraps.Load()
+
+		otp, err := svc.DecryptOTP(context.Background(), key.PublicID, token)
+		require.NoError(t, err, "cannot decrypt OTP '%s'", token)
+		require.Equal(t, vector.OTP, *otp)
+		require.Equal(t, unwraps+1, fv.unwraps.Load())
+
+		got, err := svc.GetKey(context.Background(), key.PublicID)
+		require.NoError(t, err)
+		require.Equal(t, key.AESKey, got.AESKey)
+	}
+
+	t.Run("foreign ciphertext is a bad OTP", func(t *testing.T) {
+		svc := newTransitService(t, client, "yubiserv")
+		require.NoError(t, svc.StoreKey(&Key{PublicID: "cccccccccccd", AESKey: "vault:v2:garbage", Active: true}))
+
+		_, err := svc.DecryptOTP(context.Background(), "cccccccccccd", "dvgtiblfkbgturecfllberrvkinnctnn")
+		require.ErrorIs(t, err, common.ErrStorageDecryptFail)
+	})
+
+	t.Run("transit failure is a backend failure", func(t *testing.T) {
+		fv.transitUp.Store(false)
+		t.Cleanup(func() { fv.transitUp.Store(true) })
+
+		_, err := svc.DecryptOTP(context.Background(), "cccccccccccc", "dvgtiblfkbgturecfllberrvkinnctnn")
+		require.ErrorIs(t, err, common.ErrStorageBackend)
+		require.ErrorContains(t, svc.CheckHealth(context.Background()), "last key read failed")
+	})
+
+	t.Run("wrapped key without transit key", func(t *testing.T) {
+		svc := newTransitService(t, client, "")
+
+		_, err := svc.DecryptOTP(context.Background(), "cccccccccccc", "dvgtiblfkbgturecfllberrvkinnctnn")
+		require.ErrorIs(t, err, common.ErrStorageBackend)
+		require.ErrorIs(t, err, ErrTransitRequired)
+	})
+}
+
+func TestTransitCache(t *testing.T) {
+	t.Parallel()
+
+	fv, client := newFakeVault(t)
+	svc := newTransitService(t, client, "yubiserv")
+	svc.setupCache(keycache.Options{TTL: time.Minute, Size: 10})
+	t.Cleanup(svc.cache.Close)
+
+	token, vector := "dvgtiblfkbgturecfllberrvkinnctnn", common.TestVectors["dvgtiblfkbgturecfllberrvkinnctnn"]
+	require.NoError(t, svc.StoreKey(&Key{
+		PublicID:  "cccccccccccc",
+		PrivateID: hex.EncodeToString(vector.PrivateID[:]),
+		AESKey:    hex.EncodeToString(vector.AESKey),
+		Active:    true,
+	}))
+
+	reads := fv.reads.Load()
+
+	for range 2 {
+		otp, err := svc.DecryptOTP(context.Background(), "cccccccccccc", token)
+		require.NoError(t, err)
+		require.Equal(t, vector.OTP, *otp)
+	}
+
+	// Key is read from KV once and unwrapped for every OTP
+	require.Equal(t, reads+1, fv.reads.Load())
+	require.Equal(t, int32(2), fv.unwraps.Load())
+
+	cached, err := svc.cache.GetKey(context.Background(), "cccccccccccc")
+	require.NoError(t, err)
+	require.True(t, isWrapped(cached.AESKey))
+}
+
+func TestWrapKeys(t *testing.T) {
+	t.Parallel()
+
+	fv, client := newFakeVault(t)
+
+	plain := newTransitService(t, client, "")
+	require.NoError(t, plain.StoreKey(&Key{PublicID: "cccccccccccb", AESKey: "0102030405060708090a0b0c0d0e0f10", Active: true}))
+	require.Equal(t, "0102030405060708090a0b0c0d0e0f10", fv.storedAESKey("cccccccccccb"))
+
+	_, err := plain.WrapKeys(context.Background())
+	require.ErrorIs(t, err, ErrTransitRequired)
+
+	svc := newTransitService(t, client, "yubiserv")
+	require.NoError(t, svc.StoreKey(&Key{PublicID: "cccccccccccc", AESKey: "0f0e0d0c0b0a09080706050403020100", Active: true}))
+
+	count, err := svc.WrapKeys(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	require.True(t, isWrapped(fv.storedAESKey("cccccccccccb")))
+
+	// Listing does not unwrap the keys
+	unwraps := fv.unwraps.Load()
+
+	keys, err := svc.ListKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.True(t, isWrapped(keys[0].AESKey))
+	require.True(t, isWrapped(keys[1].AESKey))
+	require.Equal(t, unwraps, fv.unwraps.Load())
+
+	key, err := svc.GetKey(context.Background(), "cccccccccccb")
+	require.NoError(t, err)
+	require.Equal(t, "0102030405060708090a0b0c0d0e0f10", key.AESKey)
+
+	key, err = svc.GetKey(context.Background(), "cccccccccccc")
+	require.NoError(t, err)
+	require.Equal(t, "0f0e0d0c0b0a09080706050403020100", key.AESKey)
+
+	count, err = svc.WrapKeys(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, count)
+}
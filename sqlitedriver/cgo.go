@@ -0,0 +1,10 @@
+//go:build cgo && !purego
+
+package sqlitedriver
+
+import (
+	_ "github.com/mattn/go-sqlite3" //goland:noinspection GoLinter
+)
+
+// Name of the registered SQLite driver.
+const Name = "sqlite3"
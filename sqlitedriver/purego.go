@@ -0,0 +1,10 @@
+//go:build !cgo || purego
+
+package sqlitedriver
+
+import (
+	_ "modernc.org/sqlite" //goland:noinspection GoLinter
+)
+
+// Name of the registered SQLite driver.
+const Name = "sqlite"
@@ -0,0 +1,15 @@
+// Package sqlitedriver registers the SQLite driver of the build.
+//
+// Builds with cgo use mattn/go-sqlite3, release builds with CGO_ENABLED=0 (or the purego build tag)
+// use the pure-Go modernc.org/sqlite, so that SQLite storages work in the static binary.
+// Both drivers accept the same DSN, including ?mode=rwc&cache=shared of the default database paths.
+package sqlitedriver
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// Open the SQLite database with the driver of the build.
+func Open(dsn string) (*sqlx.DB, error) {
+	return sqlx.Open(Name, dsn)
+}
@@ -0,0 +1,290 @@
+// Package sqlmigrate applies versioned schema migrations embedded into the binary to SQLite databases.
+//
+// Migrations are SQL files named <version>_<name>.sql, applied in version order, each one in its own
+// transaction. Applied versions are recorded in the schema_migrations table per component, so that
+// storages sharing a database file keep their schemas apart.
+package sqlmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrSchemaTooNew       = errors.New("database schema is newer than supported, upgrade yubiserv")
+	ErrBadMigrationName   = errors.New("invalid migration file name, expected <version>_<name>.sql")
+	ErrDuplicateMigration = errors.New("duplicate migration version")
+)
+
+const tableSQL = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    component  TEXT     NOT NULL, -- Storage owning the schema
+    version    INTEGER  NOT NULL, -- Applied migration version
+    name       TEXT     NOT NULL, -- Migration name
+    applied_at TEXT     NOT NULL, -- ISO8601 timestamp
+    PRIMARY KEY (component, version)
+)`
+
+type (
+	// Migration of the schema to its version.
+	Migration struct {
+		Version int
+		Name    string
+		SQL     string
+	}
+
+	// BaselineFunc returns the schema version of a database created before migrations were versioned,
+	// 0 for a database without the schema.
+	BaselineFunc func(ctx context.Context, tx *sqlx.Tx) (int, error)
+
+	// Set of migrations of a component.
+	Set struct {
+		component  string
+		migrations []Migration
+		baseline   BaselineFunc
+	}
+
+	// Status of a migration in the database.
+	Status struct {
+		Migration
+
+		// Applied time, empty for pending migrations
+		AppliedAt string
+	}
+)
+
+// Load migrations of the component from the SQL files in the root of fsys.
+func Load(component string, fsys fs.FS, baseline BaselineFunc) (*Set, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("cannot list migrations: %w", err)
+	}
+
+	set := &Set{component: component, baseline: baseline}
+
+	for _, file := range files {
+		version, name, ok := strings.Cut(strings.TrimSuffix(path.Base(file), ".sql"), "_")
+
+		number, err := strconv.Atoi(version)
+		if !ok || err != nil || number <= 0 || name == "" {
+			return nil, fmt.Errorf("%s: %w", file, ErrBadMigrationName)
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read migration %s: %w", file, err)
+		}
+
+		set.migrations = append(set.migrations, Migration{Version: number, Name: name, SQL: string(data)})
+	}
+
+	slices.SortFunc(set.migrations, func(a, b Migration) int { return a.Version - b.Version })
+
+	for i := 1; i < len(set.migrations); i++ {
+		if set.migrations[i].Version == set.migrations[i-1].Version {
+			return nil, fmt.Errorf("%d: %w", set.migrations[i].Version, ErrDuplicateMigration)
+		}
+	}
+
+	return set, nil
+}
+
+// Latest version of the schema known to the binary.
+func (s *Set) Latest() int {
+	if len(s.migrations) == 0 {
+		return 0
+	}
+
+	return s.migrations[len(s.migrations)-1].Version
+}
+
+// Status returns the known migrations, with their applied time if applied, and the version of the schema.
+// Version of a database created before versioning is reported as is, without recording it.
+func (s *Set) Status(ctx context.Context, db *sqlx.DB) ([]Status, int, error) {
+	applied := make(map[int]string)
+
+	var exists int
+	if err := db.GetContext(ctx, &exists, "SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='schema_migrations'"); err != nil {
+		return nil, 0, fmt.Errorf("cannot check schema_migrations table: %w", err)
+	}
+
+	if exists > 0 {
+		rows := make([]struct {
+			Version   int    `db:"version"`
+			AppliedAt string `db:"applied_at"`
+		}, 0)
+
+		if err := db.SelectContext(ctx, &rows, "SELECT version, applied_at FROM schema_migrations WHERE component=?", s.component); err != nil {
+			return nil, 0, fmt.Errorf("cannot read applied migrations: %w", err)
+		}
+
+		for _, row := range rows {
+			applied[row.Version] = row.AppliedAt
+		}
+	}
+
+	version := 0
+	for v := range applied {
+		version = max(version, v)
+	}
+
+	if version == 0 && s.baseline != nil {
+		tx, err := db.BeginTxx(ctx, nil)
+		if err != nil {
+			return nil, 0, fmt.Errorf("cannot begin transaction: %w", err)
+		}
+
+		version, err = s.baseline(ctx, tx)
+		_ = tx.Rollback()
+
+		if err != nil {
+			return nil, 0, fmt.Errorf("cannot detect schema version: %w", err)
+		}
+	}
+
+	statuses := make([]Status, 0, len(s.migrations))
+
+	for _, m := range s.migrations {
+		status := Status{Migration: m, AppliedAt: applied[m.Version]}
+		if status.AppliedAt == "" && m.Version <= version {
+			status.AppliedAt = "baseline"
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, version, nil
+}
+
+// Apply pending migrations, each in its own transaction. A database created before versioning is
+// recorded at its baseline version first. Databases of a newer schema are refused with ErrSchemaTooNew.
+func (s *Set) Apply(ctx context.Context, db *sqlx.DB) ([]Migration, error) {
+	if _, err := db.ExecContext(ctx, tableSQL); err != nil {
+		return nil, fmt.Errorf("cannot create schema_migrations table: %w", err)
+	}
+
+	version, err := s.version(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	if version == 0 && s.baseline != nil {
+		if version, err = s.adopt(ctx, db); err != nil {
+			return nil, err
+		}
+	}
+
+	if version > s.Latest() {
+		return nil, fmt.Errorf("%s schema version %d, latest known %d: %w", s.component, version, s.Latest(), ErrSchemaTooNew)
+	}
+
+	applied := make([]Migration, 0)
+
+	for _, m := range s.migrations {
+		if m.Version <= version {
+			continue
+		}
+
+		done, err := s.apply(ctx, db, m)
+		if err != nil {
+			return applied, err
+		}
+
+		if done {
+			applied = append(applied, m)
+		}
+	}
+
+	return applied, nil
+}
+
+func (s *Set) version(ctx context.Context, db *sqlx.DB) (int, error) {
+	var version int
+	if err := db.GetContext(ctx, &version, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations WHERE component=?", s.component); err != nil {
+		return 0, fmt.Errorf("cannot read schema version: %w", err)
+	}
+
+	return version, nil
+}
+
+// adopt records migrations up to the baseline version as applied.
+func (s *Set) adopt(ctx context.Context, db *sqlx.DB) (int, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+
+	defer func() { _ = tx.Rollback() }()
+
+	version, err := s.baseline(ctx, tx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot detect schema version: %w", err)
+	}
+
+	for _, m := range s.migrations {
+		if m.Version > version {
+			break
+		}
+
+		if err = s.record(ctx, tx, m); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("cannot record baseline: %w", err)
+	}
+
+	return version, nil
+}
+
+// apply the migration unless another process has applied it meanwhile.
+func (s *Set) apply(ctx context.Context, db *sqlx.DB, m Migration) (bool, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("cannot begin transaction: %w", err)
+	}
+
+	defer func() { _ = tx.Rollback() }()
+
+	var count int
+	if err = tx.GetContext(ctx, &count, "SELECT COUNT(*) FROM schema_migrations WHERE component=? AND version=?", s.component, m.Version); err != nil {
+		return false, fmt.Errorf("cannot check migration %d: %w", m.Version, err)
+	}
+
+	if count > 0 {
+		return false, nil
+	}
+
+	if _, err = tx.ExecContext(ctx, m.SQL); err != nil {
+		return false, fmt.Errorf("migration %d_%s failed: %w", m.Version, m.Name, err)
+	}
+
+	if err = s.record(ctx, tx, m); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("cannot commit migration %d_%s: %w", m.Version, m.Name, err)
+	}
+
+	return true, nil
+}
+
+func (s *Set) record(ctx context.Context, tx *sqlx.Tx, m Migration) error {
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (component, version, name, applied_at) VALUES (?, ?, ?, ?)",
+		s.component, m.Version, m.Name, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("cannot record migration %d_%s: %w", m.Version, m.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package sqlmigrate_test
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+
+	"github.com/archaron/go-yubiserv/sqlitedriver"
+	"github.com/archaron/go-yubiserv/sqlmigrate"
+)
+
+func testDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+
+	db, err := sqlitedriver.Open(":memory:")
+	require.NoError(t, err)
+
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db
+}
+
+func testFiles(files ...string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+
+	for i := 0; i+1 < len(files); i += 2 {
+		fsys[files[i]] = &fstest.MapFile{Data: []byte(files[i+1])}
+	}
+
+	return fsys
+}
+
+var (
+	first  = []string{"0001_create.sql", "CREATE TABLE t (a INTEGER);"}
+	second = []string{"0002_column.sql", "ALTER TABLE t ADD COLUMN b TEXT; INSERT INTO t (a, b) VALUES (1, 'x');"}
+)
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	set, err := sqlmigrate.Load("test", testFiles(append(second, first...)...), nil)
+	require.NoError(t, err)
+	require.Equal(t, 2, set.Latest())
+
+	_, err = sqlmigrate.Load("test", testFiles("create.sql", ""), nil)
+	require.ErrorIs(t, err, sqlmigrate.ErrBadMigrationName)
+
+	_, err = sqlmigrate.Load("test", testFiles("1_create.sql", "", "01_again.sql", ""), nil)
+	require.ErrorIs(t, err, sqlmigrate.ErrDuplicateMigration)
+}
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := testDB(t)
+
+	v1, err := sqlmigrate.Load("test", testFiles(first...), nil)
+	require.NoError(t, err)
+
+	applied, err := v1.Apply(ctx, db)
+	require.NoError(t, err)
+	require.Len(t, applied, 1)
+
+	v2, err := sqlmigrate.Load("test", testFiles(append(first, second...)...), nil)
+	require.NoError(t, err)
+
+	statuses, version, err := v2.Status(ctx, db)
+	require.NoError(t, err)
+	require.Equal(t, 1, version)
+	require.NotEmpty(t, statuses[0].AppliedAt)
+	require.Empty(t, statuses[1].AppliedAt)
+
+	applied, err = v2.Apply(ctx, db)
+	require.NoError(t, err)
+	require.Equal(t, []sqlmigrate.Migration{{Version: 2, Name: "column", SQL: second[1]}}, applied)
+
+	var b string
+	require.NoError(t, db.Get(&b, "SELECT b FROM t WHERE a=1"))
+	require.Equal(t, "x", b)
+
+	applied, err = v2.Apply(ctx, db)
+	require.NoError(t, err)
+	require.Empty(t, applied)
+
+	// Older binary refuses the newer schema
+	_, err = v1.Apply(ctx, db)
+	require.ErrorIs(t, err, sqlmigrate.ErrSchemaTooNew)
+
+	// Schemas of other components are independent
+	other, err := sqlmigrate.Load("other", testFiles("0001_create.sql", "CREATE TABLE o (a INTEGER);"), nil)
+	require.NoError(t, err)
+
+	applied, err = other.Apply(ctx, db)
+	require.NoError(t, err)
+	require.Len(t, applied, 1)
+}
+
+func TestApplyRollback(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := testDB(t)
+
+	set, err := sqlmigrate.Load("test", testFiles(append(first, "0002_broken.sql", "ALTER TABLE t ADD COLUMN b TEXT; SELECT * FROM missing;")...), nil)
+	require.NoError(t, err)
+
+	applied, err := set.Apply(ctx, db)
+	require.ErrorContains(t, err, "migration 2_broken failed")
+	require.Len(t, applied, 1)
+
+	// Failed migration left neither its changes nor its record
+	var columns int
+	require.NoError(t, db.Get(&columns, "SELECT COUNT(*) FROM pragma_table_info('t')"))
+	require.Equal(t, 1, columns)
+
+	_, version, err := set.Status(ctx, db)
+	require.NoError(t, err)
+	require.Equal(t, 1, version)
+}
+
+func TestBaseline(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db := testDB(t)
+
+	// Database created before versioning already has the first migration
+	_, err := db.Exec(first[1])
+	require.NoError(t, err)
+
+	baseline := func(ctx context.Context, tx *sqlx.Tx) (int, error) {
+		var tables int
+		err := tx.GetContext(ctx, &tables, "SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='t'")
+
+		return tables, err
+	}
+
+	set, err := sqlmigrate.Load("test", testFiles(append(first, second...)...), baseline)
+	require.NoError(t, err)
+
+	statuses, version, err := set.Status(ctx, db)
+	require.NoError(t, err)
+	require.Equal(t, 1, version)
+	require.Equal(t, "baseline", statuses[0].AppliedAt)
+
+	applied, err := set.Apply(ctx, db)
+	require.NoError(t, err)
+	require.Len(t, applied, 1)
+	require.Equal(t, 2, applied[0].Version)
+}
@@ -0,0 +1,235 @@
+package ykimport
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/archaron/go-yubiserv/common"
+)
+
+// Counter is the state of a YubiKey in the ykval yubikeys table.
+type Counter struct {
+	PublicID string
+	Active   bool
+	// Last accepted counters, nil if no OTP of the key was accepted
+	User *common.OTPUser
+}
+
+// Keys converts rows of the ykksm yubikeys table, creator and hardware columns are not used.
+func Keys(rows []Row) ([]*common.Key, error) {
+	keys := make([]*common.Key, 0, len(rows))
+	seen := make(map[string]bool, len(rows))
+
+	for i, row := range rows {
+		key, err := newKey(row)
+		if err != nil {
+			return nil, fmt.Errorf("ykksm row %d: %w", i+1, err)
+		}
+
+		if seen[key.PublicID] {
+			return nil, fmt.Errorf("ykksm row %d: %s: %w", i+1, key.PublicID, ErrDuplicateRow)
+		}
+
+		seen[key.PublicID] = true
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func newKey(row Row) (*common.Key, error) {
+	if err := row.require("serialnr", "publicname", "internalname", "aeskey", "lockcode"); err != nil {
+		return nil, err
+	}
+
+	serial, err := row.uint("serialnr", math.MaxUint64)
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := row.bool("active")
+	if err != nil {
+		return nil, err
+	}
+
+	key := &common.Key{
+		ID:        serial,
+		PublicID:  row["publicname"],
+		Created:   row["created"],
+		PrivateID: strings.ToLower(row["internalname"]),
+		AESKey:    strings.ToLower(row["aeskey"]),
+		LockCode:  strings.ToLower(row["lockcode"]),
+		Active:    active,
+	}
+
+	if err = key.Validate(); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Counters converts rows of the ykval yubikeys table. The nonce column is not used: nonces of requests
+// are only checked within the nonce window, which has passed for requests made before the import.
+func Counters(rows []Row) ([]Counter, error) {
+	counters := make([]Counter, 0, len(rows))
+	seen := make(map[string]bool, len(rows))
+
+	for i, row := range rows {
+		counter, err := newCounter(row)
+		if err != nil {
+			return nil, fmt.Errorf("ykval yubikeys row %d: %w", i+1, err)
+		}
+
+		if seen[counter.PublicID] {
+			return nil, fmt.Errorf("ykval yubikeys row %d: %s: %w", i+1, counter.PublicID, ErrDuplicateRow)
+		}
+
+		seen[counter.PublicID] = true
+		counters = append(counters, counter)
+	}
+
+	return counters, nil
+}
+
+func newCounter(row Row) (Counter, error) {
+	if err := row.require("yk_publicname", "yk_counter", "yk_use", "yk_high", "yk_low"); err != nil {
+		return Counter{}, err
+	}
+
+	counter := Counter{PublicID: row["yk_publicname"]}
+	if !common.IsValidPublicID(counter.PublicID) {
+		return Counter{}, fmt.Errorf("%s: %w", counter.PublicID, common.ErrKeyInvalidPublicID)
+	}
+
+	var err error
+
+	if counter.Active, err = row.bool("active"); err != nil {
+		return Counter{}, err
+	}
+
+	// ykval keeps -1 counters for keys without accepted OTPs
+	if strings.HasPrefix(row["yk_counter"], "-") {
+		return counter, nil
+	}
+
+	usage, err := row.uint("yk_counter", math.MaxUint16)
+	if err != nil {
+		return Counter{}, err
+	}
+
+	session, err := row.uint("yk_use", math.MaxUint8)
+	if err != nil {
+		return Counter{}, err
+	}
+
+	high, err := row.uint("yk_high", math.MaxUint8)
+	if err != nil {
+		return Counter{}, err
+	}
+
+	low, err := row.uint("yk_low", math.MaxUint16)
+	if err != nil {
+		return Counter{}, err
+	}
+
+	counter.User = &common.OTPUser{
+		UsageCounter:   uint16(usage),
+		SessionCounter: uint8(session),
+		Timestamp:      common.TimestampBytes(uint32(high<<16 | low)), //nolint:mnd
+	}
+
+	if row["modified"] != "" {
+		modified, err := strconv.ParseInt(row["modified"], 10, 64)
+		if err != nil {
+			return Counter{}, fmt.Errorf("modified %q: %w", row["modified"], ErrBadRow)
+		}
+
+		counter.User.Seen = time.Unix(modified, 0)
+	}
+
+	return counter, nil
+}
+
+// Clients converts rows of the ykval clients table, notes or else email are the description.
+func Clients(rows []Row) ([]*common.Client, error) {
+	clients := make([]*common.Client, 0, len(rows))
+	seen := make(map[uint64]bool, len(rows))
+
+	for i, row := range rows {
+		client, err := newClient(row)
+		if err != nil {
+			return nil, fmt.Errorf("ykval clients row %d: %w", i+1, err)
+		}
+
+		if seen[client.ID] {
+			return nil, fmt.Errorf("ykval clients row %d: client %d: %w", i+1, client.ID, ErrDuplicateRow)
+		}
+
+		seen[client.ID] = true
+		clients = append(clients, client)
+	}
+
+	return clients, nil
+}
+
+func newClient(row Row) (*common.Client, error) {
+	if err := row.require("id", "secret"); err != nil {
+		return nil, err
+	}
+
+	id, err := row.uint("id", math.MaxUint64)
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := row.bool("active")
+	if err != nil {
+		return nil, err
+	}
+
+	client := &common.Client{ID: id, Secret: row["secret"], Active: active, Description: row["notes"]}
+	if client.Description == "" {
+		client.Description = row["email"]
+	}
+
+	if _, err = client.Key(); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func (r Row) require(columns ...string) error {
+	for _, column := range columns {
+		if _, ok := r[column]; !ok {
+			return fmt.Errorf("%s: %w", column, ErrNoColumn)
+		}
+	}
+
+	return nil
+}
+
+func (r Row) uint(column string, limit uint64) (uint64, error) {
+	v, err := strconv.ParseUint(r[column], 10, 64)
+	if err != nil || v > limit {
+		return 0, fmt.Errorf("%s %q: %w", column, r[column], ErrBadRow)
+	}
+
+	return v, nil
+}
+
+// bool parses MySQL and PostgreSQL booleans, missing and NULL values are true as the schema default.
+func (r Row) bool(column string) (bool, error) {
+	switch strings.ToLower(r[column]) {
+	case "", "1", "t", "true":
+		return true, nil
+	case "0", "f", "false":
+		return false, nil
+	default:
+		return false, fmt.Errorf("%s %q: %w", column, r[column], ErrBadRow)
+	}
+}
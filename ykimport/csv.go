@@ -0,0 +1,54 @@
+package ykimport
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// ReadCSV reads rows of the table from CSV. The first record is the header, when all its fields are
+// columns of the table, otherwise records are in the order of the Yubico schema.
+func ReadCSV(r io.Reader, table Table) ([]Row, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	columns := table.Columns
+	rows := make([]Row, 0)
+
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("cannot read CSV: %w", err)
+		}
+
+		if line == 1 && isHeader(record, table) {
+			columns = make([]string, len(record))
+			for i, field := range record {
+				columns[i] = normalizeName(field)
+			}
+
+			continue
+		}
+
+		row, err := newRow(columns, record)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		rows = append(rows, row)
+	}
+}
+
+func isHeader(record []string, table Table) bool {
+	for _, field := range record {
+		if !slices.Contains(table.Columns, normalizeName(field)) {
+			return false
+		}
+	}
+
+	return true
+}
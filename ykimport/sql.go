@@ -0,0 +1,402 @@
+package ykimport
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// sqlParser extracts rows of one table from a dump of mysqldump or pg_dump. Column names are taken from
+// INSERT and COPY statements, then from CREATE TABLE of the dump, then from the Yubico schema.
+type sqlParser struct {
+	data    string
+	pos     int
+	table   Table
+	columns []string
+	rows    []Row
+}
+
+// ReadSQL reads rows of the table from INSERT statements and COPY data of a MySQL or PostgreSQL dump.
+// Other statements and tables are skipped.
+func ReadSQL(r io.Reader, table Table) ([]Row, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read dump: %w", err)
+	}
+
+	p := &sqlParser{data: string(data), table: table, columns: table.Columns, rows: make([]Row, 0)}
+	if err = p.parse(); err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.lineNumber(), err)
+	}
+
+	return p.rows, nil
+}
+
+func (p *sqlParser) parse() error {
+	for p.pos < len(p.data) {
+		line := strings.TrimSpace(p.line())
+		upper := strings.ToUpper(line)
+
+		var err error
+
+		switch {
+		case strings.HasPrefix(upper, "CREATE TABLE"):
+			p.createTable(line)
+		case strings.HasPrefix(upper, "INSERT INTO"):
+			err = p.insert()
+		case strings.HasPrefix(upper, "COPY "):
+			err = p.copy(line)
+		default:
+			p.nextLine()
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// line returns the current line.
+func (p *sqlParser) line() string {
+	if end := strings.IndexByte(p.data[p.pos:], '\n'); end >= 0 {
+		return p.data[p.pos : p.pos+end]
+	}
+
+	return p.data[p.pos:]
+}
+
+func (p *sqlParser) nextLine() {
+	p.pos += len(p.line()) + 1
+}
+
+func (p *sqlParser) lineNumber() int {
+	return strings.Count(p.data[:min(p.pos, len(p.data))], "\n") + 1
+}
+
+func (p *sqlParser) isTable(name string) bool {
+	return normalizeName(name) == p.table.Name
+}
+
+// createTable takes column names of the table, one definition per line as dumps have them.
+func (p *sqlParser) createTable(line string) {
+	p.nextLine()
+
+	name := strings.TrimSpace(line[len("CREATE TABLE"):])
+	if strings.HasPrefix(strings.ToUpper(name), "IF NOT EXISTS") {
+		name = strings.TrimSpace(name[len("IF NOT EXISTS"):])
+	}
+
+	name, _, _ = strings.Cut(name, "(")
+	if !p.isTable(strings.TrimSpace(name)) {
+		return
+	}
+
+	columns := make([]string, 0, len(p.table.Columns))
+
+	for p.pos < len(p.data) {
+		def := strings.TrimSpace(p.line())
+		p.nextLine()
+
+		if strings.HasPrefix(def, ")") {
+			break
+		}
+
+		fields := strings.Fields(def)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "PRIMARY", "KEY", "UNIQUE", "CONSTRAINT", "INDEX", "FOREIGN", "CHECK", "FULLTEXT":
+			continue
+		}
+
+		columns = append(columns, normalizeName(fields[0]))
+	}
+
+	p.columns = columns
+}
+
+// copy reads tab-separated rows of a COPY ... FROM stdin statement of pg_dump.
+func (p *sqlParser) copy(line string) error {
+	p.nextLine()
+
+	rest := strings.TrimSpace(line[len("COPY "):])
+
+	name, rest, ok := strings.Cut(rest, "(")
+	if !ok {
+		return fmt.Errorf("COPY without columns: %w", ErrBadDump)
+	}
+
+	list, _, ok := strings.Cut(rest, ")")
+	if !ok {
+		return fmt.Errorf("COPY without columns: %w", ErrBadDump)
+	}
+
+	columns := strings.Split(list, ",")
+	for i := range columns {
+		columns[i] = normalizeName(columns[i])
+	}
+
+	match := p.isTable(name)
+
+	for p.pos < len(p.data) {
+		data := strings.TrimSuffix(p.line(), "\r")
+		if data == `\.` {
+			p.nextLine()
+
+			return nil
+		}
+
+		if match {
+			values := strings.Split(data, "\t")
+			for i := range values {
+				values[i] = unescapeCopy(values[i])
+			}
+
+			row, err := newRow(columns, values)
+			if err != nil {
+				return err
+			}
+
+			p.rows = append(p.rows, row)
+		}
+
+		p.nextLine()
+	}
+
+	return fmt.Errorf("COPY data is not terminated: %w", ErrBadDump)
+}
+
+func unescapeCopy(value string) string {
+	if value == `\N` {
+		return ""
+	}
+
+	return strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n", `\r`, "\r").Replace(value)
+}
+
+// insert reads rows of an INSERT statement, with or without column names and with one or more rows.
+// Strings of MySQL dumps, with backquoted names, and E'...' strings have backslash escapes.
+func (p *sqlParser) insert() error {
+	p.skipSpace()
+	p.pos += len("INSERT INTO")
+	p.skipSpace()
+
+	name := p.identifier()
+	mysql := strings.HasPrefix(name, "`")
+	columns := p.columns
+
+	p.skipSpace()
+
+	if p.peek() == '(' {
+		columns = make([]string, 0, len(p.table.Columns))
+
+		for {
+			p.pos++
+			p.skipSpace()
+			columns = append(columns, normalizeName(p.identifier()))
+			p.skipSpace()
+
+			if p.peek() != ',' {
+				break
+			}
+		}
+
+		if err := p.expect(")"); err != nil {
+			return err
+		}
+	}
+
+	if err := p.expect("VALUES"); err != nil {
+		return err
+	}
+
+	for {
+		values, err := p.tuple(mysql)
+		if err != nil {
+			return err
+		}
+
+		if p.isTable(name) {
+			row, err := newRow(columns, values)
+			if err != nil {
+				return err
+			}
+
+			p.rows = append(p.rows, row)
+		}
+
+		p.skipSpace()
+
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ';', 0:
+			p.pos++
+
+			return nil
+		default:
+			return fmt.Errorf("unexpected %q after values: %w", p.peek(), ErrBadDump)
+		}
+	}
+}
+
+// tuple reads a parenthesized list of values.
+func (p *sqlParser) tuple(mysql bool) ([]string, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	values := make([]string, 0, len(p.columns))
+
+	for {
+		p.skipSpace()
+
+		value, err := p.value(mysql)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+
+		p.skipSpace()
+
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+
+			return values, nil
+		default:
+			return nil, fmt.Errorf("unexpected %q in values: %w", p.peek(), ErrBadDump)
+		}
+	}
+}
+
+// value reads a string, NULL or a bare literal like a number or a boolean.
+func (p *sqlParser) value(mysql bool) (string, error) {
+	escapes := mysql
+	if c := p.peek(); (c == 'E' || c == 'e') && p.pos+1 < len(p.data) && p.data[p.pos+1] == '\'' {
+		escapes = true
+		p.pos++
+	}
+
+	if p.peek() == '\'' {
+		return p.quoted(escapes)
+	}
+
+	start := p.pos
+	for p.pos < len(p.data) && !strings.ContainsRune(",)", rune(p.data[p.pos])) {
+		p.pos++
+	}
+
+	value := strings.TrimSpace(p.data[start:p.pos])
+	if value == "" {
+		return "", fmt.Errorf("empty value: %w", ErrBadDump)
+	}
+
+	if strings.EqualFold(value, "NULL") {
+		return "", nil
+	}
+
+	return value, nil
+}
+
+func (p *sqlParser) quoted(escapes bool) (string, error) {
+	var b strings.Builder
+
+	for p.pos++; p.pos < len(p.data); p.pos++ {
+		c := p.data[p.pos]
+
+		switch {
+		case c == '\'' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '\'':
+			b.WriteByte('\'')
+			p.pos++
+		case c == '\'':
+			p.pos++
+
+			return b.String(), nil
+		case c == '\\' && escapes && p.pos+1 < len(p.data):
+			p.pos++
+			b.WriteString(unescapeChar(p.data[p.pos]))
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return "", fmt.Errorf("string is not terminated: %w", ErrBadDump)
+}
+
+func unescapeChar(c byte) string {
+	switch c {
+	case '0':
+		return "\x00"
+	case 'n':
+		return "\n"
+	case 'r':
+		return "\r"
+	case 't':
+		return "\t"
+	case 'Z':
+		return "\x1a"
+	default:
+		return string(c)
+	}
+}
+
+// identifier reads a possibly quoted and schema-qualified name.
+func (p *sqlParser) identifier() string {
+	start := p.pos
+
+	for p.pos < len(p.data) {
+		c := rune(p.data[p.pos])
+
+		switch {
+		case c == '`' || c == '"':
+			if end := strings.IndexRune(p.data[p.pos+1:], c); end >= 0 {
+				p.pos += end + 2
+			} else {
+				p.pos = len(p.data)
+			}
+		case c == '_' || c == '.' || c == '$' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			p.pos++
+		default:
+			return p.data[start:p.pos]
+		}
+	}
+
+	return p.data[start:p.pos]
+}
+
+// expect skips spaces and the case-insensitive token.
+func (p *sqlParser) expect(token string) error {
+	p.skipSpace()
+
+	if !strings.HasPrefix(strings.ToUpper(p.data[p.pos:min(p.pos+len(token), len(p.data))]), token) {
+		return fmt.Errorf("expected %s: %w", token, ErrBadDump)
+	}
+
+	p.pos += len(token)
+
+	return nil
+}
+
+func (p *sqlParser) skipSpace() {
+	for p.pos < len(p.data) && unicode.IsSpace(rune(p.data[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek returns the current character, 0 at the end.
+func (p *sqlParser) peek() byte {
+	if p.pos >= len(p.data) {
+		return 0
+	}
+
+	return p.data[p.pos]
+}
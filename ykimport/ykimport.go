@@ -0,0 +1,98 @@
+// Package ykimport reads tables of Yubico ykksm and ykval databases from SQL dumps and CSV files,
+// and converts their rows to keys, replay-protection counters and API clients.
+package ykimport
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// Row is a table row, values are keyed by lower-case column name. NULL values are empty.
+	Row map[string]string
+
+	// Table describes a table of the Yubico databases. Columns are listed in the order of the Yubico
+	// schema, they are used for rows dumped without column names.
+	Table struct {
+		Name    string
+		Columns []string
+	}
+)
+
+var (
+	// KSMKeys is the yubikeys table of ykksm.
+	KSMKeys = Table{ //nolint:gochecknoglobals
+		Name:    "yubikeys",
+		Columns: []string{"serialnr", "publicname", "created", "internalname", "aeskey", "lockcode", "creator", "active", "hardware"},
+	}
+
+	// ValKeys is the yubikeys table of ykval.
+	ValKeys = Table{ //nolint:gochecknoglobals
+		Name:    "yubikeys",
+		Columns: []string{"active", "created", "modified", "yk_publicname", "yk_counter", "yk_use", "yk_low", "yk_high", "nonce", "notes"},
+	}
+
+	// ValClients is the clients table of ykval.
+	ValClients = Table{ //nolint:gochecknoglobals
+		Name:    "clients",
+		Columns: []string{"id", "active", "created", "secret", "email", "notes", "otp"},
+	}
+)
+
+var (
+	ErrBadDump      = errors.New("invalid SQL dump")
+	ErrBadRow       = errors.New("invalid row")
+	ErrNoColumn     = errors.New("missing column")
+	ErrDuplicateRow = errors.New("duplicate row")
+)
+
+// ReadFile reads rows of the table from a CSV file, *.csv, or from a SQL dump of MySQL or PostgreSQL.
+func ReadFile(path string, table Table) ([]Row, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = f.Close() }()
+
+	var rows []Row
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		rows, err = ReadCSV(f, table)
+	} else {
+		rows, err = ReadSQL(f, table)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return rows, nil
+}
+
+// newRow maps values to the columns.
+func newRow(columns, values []string) (Row, error) {
+	if len(values) != len(columns) {
+		return nil, fmt.Errorf("%d values for %d columns: %w", len(values), len(columns), ErrBadRow)
+	}
+
+	row := make(Row, len(columns))
+	for i, column := range columns {
+		row[column] = values[i]
+	}
+
+	return row, nil
+}
+
+// normalizeName returns the unquoted lower-case name without schema.
+func normalizeName(name string) string {
+	name = strings.TrimSpace(name)
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+
+	return strings.ToLower(strings.Trim(name, "`\"[]"))
+}
@@ -0,0 +1,240 @@
+package ykimport_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/archaron/go-yubiserv/common"
+	"github.com/archaron/go-yubiserv/ykimport"
+)
+
+const mysqlKSMDump = "-- MySQL dump 10.13\n" +
+	"DROP TABLE IF EXISTS `yubikeys`;\n" +
+	"CREATE TABLE `yubikeys` (\n" +
+	"  `serialnr` int(11) NOT NULL,\n" +
+	"  `publicname` varchar(16) NOT NULL,\n" +
+	"  `created` varchar(24) NOT NULL,\n" +
+	"  `internalname` varchar(12) NOT NULL,\n" +
+	"  `aeskey` varchar(32) NOT NULL,\n" +
+	"  `lockcode` varchar(12) NOT NULL,\n" +
+	"  `creator` varchar(8) NOT NULL,\n" +
+	"  `active` tinyint(1) DEFAULT '1',\n" +
+	"  `hardware` tinyint(1) DEFAULT '1',\n" +
+	"  PRIMARY KEY (`publicname`)\n" +
+	") ENGINE=InnoDB DEFAULT CHARSET=latin1;\n" +
+	"LOCK TABLES `yubikeys` WRITE;\n" +
+	"INSERT INTO `yubikeys` VALUES (1,'vvcccccccccc','2024-01-01T00:00:00','0102030405ab'," +
+	"'0102030405060708090a0b0c0d0e0f10','010203040506','8B5B9C3A',1,1)," +
+	"(2,'vvdddddddddd','2024-01-02T00:00:00','0102030405AC','101112131415161718191A1B1C1D1E1F','010203040506','8B5B9C3A',0,1);\n" +
+	"UNLOCK TABLES;\n"
+
+const pgValDump = `-- PostgreSQL database dump
+CREATE TABLE public.clients (
+    id integer NOT NULL,
+    active boolean DEFAULT true,
+    created integer NOT NULL,
+    secret character varying(60) DEFAULT ''::character varying NOT NULL,
+    email character varying(255),
+    notes character varying(100) DEFAULT ''::character varying,
+    otp character varying(100) DEFAULT ''::character varying
+);
+
+COPY public.clients (id, active, created, secret, email, notes, otp) FROM stdin;
+1	t	1700000000	c2VjcmV0c2VjcmV0c2VjcmV0	admin@example.com	intranet\tapp	\N
+2	f	1700000001	\N	\N	\N	\N
+\.
+
+COPY public.yubikeys (active, created, modified, yk_publicname, yk_counter, yk_use, yk_low, yk_high, nonce, notes) FROM stdin;
+t	1700000000	1700000100	vvcccccccccc	12	3	4660	86	noncenoncenonce	\N
+f	1700000000	1700000000	vvdddddddddd	-1	-1	-1	-1		
+\.
+`
+
+const pgInsertsDump = `CREATE TABLE public.yubikeys (
+    active boolean DEFAULT true,
+    created integer NOT NULL,
+    modified integer NOT NULL,
+    yk_publicname character varying(16) NOT NULL,
+    yk_counter integer NOT NULL,
+    yk_use integer NOT NULL,
+    yk_low integer NOT NULL,
+    yk_high integer NOT NULL,
+    nonce character varying(40) DEFAULT ''::character varying,
+    notes character varying(100) DEFAULT ''::character varying
+);
+
+INSERT INTO public.clients VALUES (1, true, 1700000000, 'c2VjcmV0', NULL, 'it''s (ours); with \', '');
+INSERT INTO public.yubikeys VALUES (true, 1700000000, 1700000100, 'vvcccccccccc', 12, 3, 4660, 86, 'nonce', NULL);
+INSERT INTO public.yubikeys (yk_publicname, yk_counter, yk_use, yk_low, yk_high) VALUES ('vvdddddddddd', 1, 0, 0, 0);
+`
+
+func TestReadSQL(t *testing.T) {
+	t.Parallel()
+
+	t.Run("mysqldump", func(t *testing.T) {
+		t.Parallel()
+
+		rows, err := ykimport.ReadSQL(strings.NewReader(mysqlKSMDump), ykimport.KSMKeys)
+		require.NoError(t, err)
+		require.Len(t, rows, 2)
+		require.Equal(t, "vvdddddddddd", rows[1]["publicname"])
+		require.Equal(t, "0", rows[1]["active"])
+
+		keys, err := ykimport.Keys(rows)
+		require.NoError(t, err)
+		require.Equal(t, []*common.Key{
+			{
+				ID:        1,
+				PublicID:  "vvcccccccccc",
+				Created:   "2024-01-01T00:00:00",
+				PrivateID: "0102030405ab",
+				AESKey:    "0102030405060708090a0b0c0d0e0f10",
+				LockCode:  "010203040506",
+				Active:    true,
+			},
+			{
+				ID:        2,
+				PublicID:  "vvdddddddddd",
+				Created:   "2024-01-02T00:00:00",
+				PrivateID: "0102030405ac",
+				AESKey:    "101112131415161718191a1b1c1d1e1f",
+				LockCode:  "010203040506",
+				Active:    false,
+			},
+		}, keys)
+	})
+
+	t.Run("pg_dump", func(t *testing.T) {
+		t.Parallel()
+
+		rows, err := ykimport.ReadSQL(strings.NewReader(pgValDump), ykimport.ValClients)
+		require.NoError(t, err)
+
+		clients, err := ykimport.Clients(rows)
+		require.NoError(t, err)
+		require.Equal(t, []*common.Client{
+			{ID: 1, Secret: "c2VjcmV0c2VjcmV0c2VjcmV0", Active: true, Description: "intranet\tapp"},
+			{ID: 2, Active: false},
+		}, clients)
+
+		rows, err = ykimport.ReadSQL(strings.NewReader(pgValDump), ykimport.ValKeys)
+		require.NoError(t, err)
+
+		counters, err := ykimport.Counters(rows)
+		require.NoError(t, err)
+		require.Equal(t, []ykimport.Counter{
+			{
+				PublicID: "vvcccccccccc",
+				Active:   true,
+				User: &common.OTPUser{
+					UsageCounter:   12,
+					SessionCounter: 3,
+					Timestamp:      [3]byte{0x56, 0x12, 0x34},
+					Seen:           time.Unix(1700000100, 0),
+				},
+			},
+			{PublicID: "vvdddddddddd", Active: false},
+		}, counters)
+	})
+
+	t.Run("pg_dump inserts", func(t *testing.T) {
+		t.Parallel()
+
+		rows, err := ykimport.ReadSQL(strings.NewReader(pgInsertsDump), ykimport.ValClients)
+		require.NoError(t, err)
+		require.Equal(t, []ykimport.Row{{
+			"id": "1", "active": "true", "created": "1700000000", "secret": "c2VjcmV0",
+			"email": "", "notes": `it's (ours); with \`, "otp": "",
+		}}, rows)
+
+		rows, err = ykimport.ReadSQL(strings.NewReader(pgInsertsDump), ykimport.ValKeys)
+		require.NoError(t, err)
+
+		counters, err := ykimport.Counters(rows)
+		require.NoError(t, err)
+		require.Len(t, counters, 2)
+		require.Equal(t, uint16(12), counters[0].User.UsageCounter)
+		require.True(t, counters[1].Active)
+		require.Equal(t, uint16(1), counters[1].User.UsageCounter)
+		require.True(t, counters[1].User.Seen.IsZero())
+	})
+
+	t.Run("invalid dumps", func(t *testing.T) {
+		t.Parallel()
+
+		for dump, expected := range map[string]error{
+			"INSERT INTO yubikeys VALUES ('vvcccccccccc', 1);":         ykimport.ErrBadRow,
+			"INSERT INTO yubikeys VALUES ('vvcccccccccc);":             ykimport.ErrBadDump,
+			"INSERT INTO yubikeys SELECT * FROM keys;":                 ykimport.ErrBadDump,
+			"COPY public.yubikeys (active, yk_publicname) FROM stdin;": ykimport.ErrBadDump,
+		} {
+			_, err := ykimport.ReadSQL(strings.NewReader(dump), ykimport.ValKeys)
+			require.ErrorIs(t, err, expected, dump)
+		}
+	})
+}
+
+func TestReadCSV(t *testing.T) {
+	t.Parallel()
+
+	t.Run("header", func(t *testing.T) {
+		t.Parallel()
+
+		rows, err := ykimport.ReadCSV(strings.NewReader("ID,Secret,Active\n7,c2VjcmV0,0\n"), ykimport.ValClients)
+		require.NoError(t, err)
+		require.Equal(t, []ykimport.Row{{"id": "7", "secret": "c2VjcmV0", "active": "0"}}, rows)
+	})
+
+	t.Run("schema order", func(t *testing.T) {
+		t.Parallel()
+
+		rows, err := ykimport.ReadCSV(strings.NewReader(
+			"1,vvcccccccccc,2024-01-01T00:00:00,0102030405ab,0102030405060708090a0b0c0d0e0f10,010203040506,8B5B9C3A,1,1\n",
+		), ykimport.KSMKeys)
+		require.NoError(t, err)
+
+		keys, err := ykimport.Keys(rows)
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		require.Equal(t, "vvcccccccccc", keys[0].PublicID)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		t.Parallel()
+
+		path := filepath.Join(t.TempDir(), "clients.csv")
+		require.NoError(t, os.WriteFile(path, []byte("id,secret\n1,c2VjcmV0\n1,c2VjcmV0\n"), 0o600))
+
+		rows, err := ykimport.ReadFile(path, ykimport.ValClients)
+		require.NoError(t, err)
+
+		_, err = ykimport.Clients(rows)
+		require.ErrorIs(t, err, ykimport.ErrDuplicateRow)
+	})
+}
+
+func TestConvert(t *testing.T) {
+	t.Parallel()
+
+	_, err := ykimport.Keys([]ykimport.Row{{"serialnr": "1", "publicname": "vvcccccccccc"}})
+	require.ErrorIs(t, err, ykimport.ErrNoColumn)
+
+	_, err = ykimport.Keys([]ykimport.Row{{
+		"serialnr": "1", "publicname": "vvcccccccccc", "internalname": "0102030405ab",
+		"aeskey": "0102", "lockcode": "010203040506",
+	}})
+	require.ErrorIs(t, err, common.ErrKeyInvalidAESKey)
+
+	_, err = ykimport.Counters([]ykimport.Row{{
+		"yk_publicname": "vvcccccccccc", "yk_counter": "70000", "yk_use": "0", "yk_low": "0", "yk_high": "0",
+	}})
+	require.ErrorIs(t, err, ykimport.ErrBadRow)
+
+	_, err = ykimport.Clients([]ykimport.Row{{"id": "1", "secret": "not base64!", "active": "yes"}})
+	require.ErrorIs(t, err, ykimport.ErrBadRow)
+}